@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+// shutdownTimeout - сколько ждать завершения текущих запросов при остановке
+const shutdownTimeout = 30 * time.Second
+
+// publicPaths - префиксы путей без авторизации (PROXY_PUBLIC_PATHS)
+var publicPaths []string
+
+// adminRoutes - служебные маршруты (статистика, управление), которые PROXY_PUBLIC_PATHS открыть не может
+var adminRoutes = []string{"/admin", "/stats"}
+
+// initPublicPaths читает PROXY_PUBLIC_PATHS; префикс, открывающий маршруты провайдеров
+// или служебные маршруты, - ошибка
+func initPublicPaths(providerRoutes []string) error {
+	publicPaths = []string{"/health", "/livez"}
+	if os.Getenv("PROXY_PUBLIC_PATHS") != "" {
+		publicPaths = nil
+		for _, p := range envList("PROXY_PUBLIC_PATHS") {
+			publicPaths = append(publicPaths, "/"+strings.Trim(p, "/"))
+		}
+	}
+	for _, p := range publicPaths {
+		for _, route := range providerRoutes {
+			if p == "/" || isPathUnder(route, p) || isPathUnder(p, route) {
+				return fmt.Errorf("PROXY_PUBLIC_PATHS: %s would expose provider route %s/*", p, route)
+			}
+		}
+		for _, route := range adminRoutes {
+			if p == "/" || isPathUnder(route, p) || isPathUnder(p, route) {
+				return fmt.Errorf("PROXY_PUBLIC_PATHS: %s would expose admin route %s", p, route)
+			}
+		}
+	}
+	return nil
+}
+
+// isPathUnder - path совпадает с prefix или лежит под ним (по границе сегмента).
+// Без учёта регистра, как и маршрутизация fiber.
+func isPathUnder(path, prefix string) bool {
+	path, prefix = strings.ToLower(path), strings.ToLower(prefix)
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func isPublicPath(path string) bool {
+	for _, p := range publicPaths {
+		if isPathUnder(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// authMiddleware пускает только запросы с известным X-Proxy-Auth; пути publicPaths - без него
+func authMiddleware(c *fiber.Ctx) error {
+	if isPublicPath(c.Path()) {
+		return c.Next()
+	}
+	token := lookupToken(c.Get("X-Proxy-Auth"))
+	if token == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+	c.Locals("token", token)
+	return c.Next()
+}
+
+// requireAdmin закрывает /admin/* и /stats от токенов клиентов без прав администратора:
+// в /stats видны теги и потоки всех токенов
+func requireAdmin(c *fiber.Ctx) error {
+	if !callerToken(c).isAdmin() {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "admin token required",
+		})
+	}
+	return c.Next()
+}
+
+// newAdminApp - отдельный сервер для служебных эндпоинтов (PROXY_ADMIN_ADDR),
+// чтобы закрыть их файрволом отдельно от публичного трафика
+func newAdminApp() *fiber.App {
+	admin := fiber.New(fiber.Config{
+		ErrorHandler:          errorHandler,
+		DisableStartupMessage: true,
+	})
+	admin.Use(requestid.New())
+	admin.Use(recover.New(recover.Config{
+		EnableStackTrace:  true,
+		StackTraceHandler: logPanic,
+	}))
+	if openAIErrors {
+		admin.Use(errorFormatMiddleware)
+	}
+	admin.Use(authMiddleware)
+	admin.Get("/health", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"status": "ok"})
+	})
+	return admin
+}
+
+// serve запускает основной и (если задан) служебный listener; по SIGINT/SIGTERM
+// оба перестают принимать соединения и дожидаются текущих запросов
+func serve(app *fiber.App, addr string, admin *fiber.App, adminAddr string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 2)
+	go func() { errc <- app.Listen(addr) }()
+	if admin != nil {
+		log.Printf("Admin endpoints listening on %s", adminAddr)
+		go func() { errc <- admin.Listen(adminAddr) }()
+	}
+
+	select {
+	case err := <-errc:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", shutdownTimeout)
+	if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+		log.Printf("ERROR: shutdown: %v", err)
+	}
+	if admin != nil {
+		if err := admin.ShutdownWithTimeout(shutdownTimeout); err != nil {
+			log.Printf("ERROR: admin shutdown: %v", err)
+		}
+	}
+	// Последние запросы могли не попасть в периодическое сохранение квот
+	saveQuotas()
+}
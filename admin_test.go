@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// getStatus - статус GET-запроса к base+path с тестовым токеном
+func getStatus(t *testing.T, base, path string) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, base+path, nil)
+	req.Header.Set("X-Proxy-Auth", testAuthToken)
+	resp, _ := send(t, req)
+	return resp.StatusCode
+}
+
+func TestAdminListener(t *testing.T) {
+	t.Setenv("PROXY_ADMIN_ADDR", "127.0.0.1:0")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+	if p.adminURL == "" {
+		t.Fatal("no admin app with PROXY_ADMIN_ADDR")
+	}
+
+	cases := []struct {
+		path        string
+		main, admin int
+	}{
+		{"/openai/v1/models", http.StatusOK, http.StatusNotFound},
+		{"/whoami", http.StatusOK, http.StatusNotFound},
+		{"/stats", http.StatusNotFound, http.StatusOK},
+		{"/admin/maintenance", http.StatusNotFound, http.StatusOK},
+		// Health отвечает на обоих listener-ах - для проверок балансировщика и мониторинга
+		{"/health", http.StatusOK, http.StatusOK},
+	}
+	for _, c := range cases {
+		if got := getStatus(t, p.url, c.path); got != c.main {
+			t.Errorf("main %s: status %d, want %d", c.path, got, c.main)
+		}
+		if got := getStatus(t, p.adminURL, c.path); got != c.admin {
+			t.Errorf("admin %s: status %d, want %d", c.path, got, c.admin)
+		}
+	}
+	// Служебный сервер требует тот же токен
+	req, _ := http.NewRequest(http.MethodGet, p.adminURL+"/stats", nil)
+	if resp, _ := send(t, req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("admin /stats without a token: status %d", resp.StatusCode)
+	}
+}
+
+func TestAdminRoutesOnMainWithoutAdminAddr(t *testing.T) {
+	p := startProxy(t)
+	if p.adminURL != "" {
+		t.Fatal("admin app started without PROXY_ADMIN_ADDR")
+	}
+	if got := getStatus(t, p.url, "/stats"); got != http.StatusOK {
+		t.Fatalf("/stats on the main listener: status %d", got)
+	}
+}
+
+func TestStatsRequiresAdminToken(t *testing.T) {
+	useTokens(t, `[{"name":"team-a","token":"tok-a","tag":"team-a"},{"name":"ops","token":"tok-ops","admin":true}]`)
+	p := startProxy(t)
+
+	// В /stats - теги и потоки всех токенов, токену клиента они не показываются
+	resp, body := p.do(t, http.MethodGet, "/stats", "", "X-Proxy-Auth", "tok-a")
+	if resp.StatusCode != http.StatusForbidden || body != `{"error":"admin token required"}` {
+		t.Fatalf("client token: status %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := p.do(t, http.MethodGet, "/stats", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PROXY_AUTH_TOKEN: status %d", resp.StatusCode)
+	}
+	if resp, _ := p.do(t, http.MethodGet, "/stats", "", "X-Proxy-Auth", "tok-ops"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("admin token: status %d", resp.StatusCode)
+	}
+}
+
+// anonStatus - статус GET-запроса без X-Proxy-Auth
+func anonStatus(t *testing.T, base, path string) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, base+path, nil)
+	resp, _ := send(t, req)
+	return resp.StatusCode
+}
+
+func TestPublicPathsDefault(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	for path, want := range map[string]int{
+		"/health":           http.StatusOK,
+		"/ready":            http.StatusUnauthorized,
+		"/whoami":           http.StatusUnauthorized,
+		"/stats":            http.StatusUnauthorized,
+		"/openai/v1/models": http.StatusUnauthorized,
+		// Граница сегмента: /healthz - не /health
+		"/healthz": http.StatusUnauthorized,
+	} {
+		if got := anonStatus(t, p.url, path); got != want {
+			t.Errorf("%s without a token: status %d, want %d", path, got, want)
+		}
+	}
+}
+
+func TestPublicPathsConfigured(t *testing.T) {
+	t.Setenv("PROXY_PUBLIC_PATHS", "/ready/, health")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	for path, want := range map[string]int{
+		"/ready":  http.StatusOK,
+		"/READY":  http.StatusOK,
+		"/health": http.StatusOK,
+		// Под открытым префиксом авторизация не нужна, маршрута нет - 404
+		"/health/providers": http.StatusNotFound,
+		// /livez больше не в списке
+		"/livez":            http.StatusUnauthorized,
+		"/whoami":           http.StatusUnauthorized,
+		"/openai/v1/models": http.StatusUnauthorized,
+	} {
+		if got := anonStatus(t, p.url, path); got != want {
+			t.Errorf("%s without a token: status %d, want %d", path, got, want)
+		}
+	}
+}
+
+func TestPublicPathsRejectProtectedRoutes(t *testing.T) {
+	routes := []string{"/v1", "/mock", "/openai"}
+	for _, paths := range []string{"/", "/v1", "/v1/models", "/OpenAI", "/openai/v1/chat", "/admin", "/admin/maintenance", "/stats", "STATS/"} {
+		t.Setenv("PROXY_PUBLIC_PATHS", "/health,"+paths)
+		if err := initPublicPaths(routes); err == nil {
+			t.Errorf("PROXY_PUBLIC_PATHS=%s accepted", paths)
+		}
+	}
+	for _, paths := range []string{"/v", "/openai-status", "/statistics", "/administrator"} {
+		t.Setenv("PROXY_PUBLIC_PATHS", paths)
+		if err := initPublicPaths(routes); err != nil {
+			t.Errorf("PROXY_PUBLIC_PATHS=%s rejected: %v", paths, err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Журнал попыток запроса к провайдеру (PROXY_ATTEMPT_LOG): каждая попытка - повтор,
+// повтор битого JSON, эскалация, часть embeddings - с задержкой, исходом и причиной.
+// retries - только запросы с несколькими попытками, all - все запросы.
+var attemptLogMode string
+
+const (
+	attemptLogRetries = "retries"
+	attemptLogAll     = "all"
+)
+
+func initAttemptLog() {
+	attemptLogMode = strings.ToLower(strings.TrimSpace(os.Getenv("PROXY_ATTEMPT_LOG")))
+	switch attemptLogMode {
+	case "", "off":
+		attemptLogMode = ""
+	case attemptLogRetries, attemptLogAll:
+	default:
+		log.Printf("WARN: unknown PROXY_ATTEMPT_LOG %q, attempt log disabled", attemptLogMode)
+		attemptLogMode = ""
+	}
+}
+
+// upstreamAttempt - одна попытка запроса к провайдеру
+type upstreamAttempt struct {
+	Provider  string    `json:"provider"`
+	Key       string    `json:"key,omitempty"`
+	Start     time.Time `json:"start"`
+	LatencyMs int64     `json:"latency_ms"`
+	Status    int       `json:"status,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	// Reason - почему попытка сделана (у первой пусто)
+	Reason string `json:"reason,omitempty"`
+}
+
+type attemptLog struct {
+	mu       sync.Mutex
+	key      string
+	attempts []upstreamAttempt
+}
+
+type attemptLogCtxKey struct{}
+type attemptReasonCtxKey struct{}
+
+// withAttemptLog привязывает журнал к запросу; клоны запроса (повторы, эскалация) пишут в него же.
+// nil - журнал выключен.
+func withAttemptLog(req *http.Request, keyID string) (*http.Request, *attemptLog) {
+	if attemptLogMode == "" {
+		return req, nil
+	}
+	l := &attemptLog{key: keyID}
+	return req.WithContext(context.WithValue(req.Context(), attemptLogCtxKey{}, l)), l
+}
+
+// withAttemptReason задаёт причину для первой попытки запроса (эскалация, часть батча)
+func withAttemptReason(req *http.Request, reason string) *http.Request {
+	if attemptLogMode == "" {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), attemptReasonCtxKey{}, reason))
+}
+
+// sendAttempt выполняет запрос и записывает попытку в журнал запроса.
+// reason пусто - берётся из контекста (withAttemptReason). В ошибке нет query-строки URL.
+func sendAttempt(client *http.Client, req *http.Request, provider, reason string) (*http.Response, error) {
+	start := time.Now()
+	resp, err := client.Do(req)
+	err = withoutQuery(err)
+	l, _ := req.Context().Value(attemptLogCtxKey{}).(*attemptLog)
+	if l == nil {
+		return resp, err
+	}
+	if reason == "" {
+		reason, _ = req.Context().Value(attemptReasonCtxKey{}).(string)
+	}
+	a := upstreamAttempt{
+		Provider:  provider,
+		Key:       l.key,
+		Start:     start.UTC(),
+		LatencyMs: time.Since(start).Milliseconds(),
+		Reason:    reason,
+	}
+	switch {
+	case err != nil:
+		a.Outcome, a.Error = "network_error", err.Error()
+	case resp.StatusCode >= 400:
+		a.Outcome, a.Status = "http_error", resp.StatusCode
+	default:
+		a.Outcome, a.Status = "success", resp.StatusCode
+	}
+	l.mu.Lock()
+	l.attempts = append(l.attempts, a)
+	l.mu.Unlock()
+	return resp, err
+}
+
+// retryReason - причина повтора по результату предыдущей попытки
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		return "retry after error: " + err.Error()
+	}
+	return "retry after status " + strconv.Itoa(resp.StatusCode)
+}
+
+// emit пишет журнал одной строкой JSON с request ID
+func (l *attemptLog) emit(reqID string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.attempts) == 0 || (attemptLogMode == attemptLogRetries && len(l.attempts) < 2) {
+		return
+	}
+	data, err := json.Marshal(map[string]any{"request_id": reqID, "attempts": l.attempts})
+	if err != nil {
+		return
+	}
+	log.Printf("Upstream attempts: %s", data)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// loggedAttempts - журналы попыток из лога прокси по request ID
+func loggedAttempts(t *testing.T, logs *logBuffer) map[string][]upstreamAttempt {
+	t.Helper()
+	out := map[string][]upstreamAttempt{}
+	for _, line := range strings.Split(logs.String(), "\n") {
+		_, data, ok := strings.Cut(line, "Upstream attempts: ")
+		if !ok {
+			continue
+		}
+		var entry struct {
+			RequestID string `json:"request_id"`
+			Attempts  []upstreamAttempt
+		}
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			t.Fatalf("attempt log line %q: %v", line, err)
+		}
+		out[entry.RequestID] = entry.Attempts
+	}
+	return out
+}
+
+// flakyUpstream отвечает первым fail вызовам ответом bad, остальным - {}
+func flakyUpstream(t *testing.T, fail int32, bad func(w http.ResponseWriter)) {
+	var calls atomic.Int32
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= fail {
+			bad(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	})
+}
+
+func TestAttemptLogRetry(t *testing.T) {
+	t.Setenv("PROXY_ATTEMPT_LOG", "retries")
+	t.Setenv("PROXY_MAX_RETRIES", "2")
+	t.Setenv("PROXY_RETRY_BACKOFF_MS", "1")
+	flakyUpstream(t, 1, func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) })
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{}`, "X-Request-ID", "req-retry", "Idempotency-Key", "req-retry"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	attempts := loggedAttempts(t, logs)["req-retry"]
+	if len(attempts) != 2 {
+		t.Fatalf("attempts = %+v, want 2", attempts)
+	}
+	first, second := attempts[0], attempts[1]
+	if first.Outcome != "http_error" || first.Status != 503 || first.Reason != "" {
+		t.Errorf("first attempt = %+v", first)
+	}
+	if second.Outcome != "success" || second.Status != 200 || second.Reason != "retry after status 503" {
+		t.Errorf("second attempt = %+v", second)
+	}
+	for _, a := range attempts {
+		if a.Provider != "openai" || a.Key != (&apiKeyState{index: 0, value: "sk-openai-test"}).id() || a.Start.IsZero() {
+			t.Errorf("attempt = %+v", a)
+		}
+	}
+	if !second.Start.After(first.Start) {
+		t.Errorf("retry started at %s, before the first attempt at %s", second.Start, first.Start)
+	}
+}
+
+func TestAttemptLogMalformedJSONRetry(t *testing.T) {
+	t.Setenv("PROXY_ATTEMPT_LOG", "retries")
+	t.Setenv("PROXY_RETRY_INVALID_JSON", "true")
+	flakyUpstream(t, 1, func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[`))
+	})
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Request-ID", "req-json")
+	attempts := loggedAttempts(t, logs)["req-json"]
+	if len(attempts) != 2 || attempts[0].Outcome != "success" || attempts[1].Reason != "malformed JSON response" {
+		t.Fatalf("attempts = %+v", attempts)
+	}
+}
+
+func TestAttemptLogModes(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+
+	// retries - запрос с одной попыткой не пишется, all - пишется
+	t.Setenv("PROXY_ATTEMPT_LOG", "retries")
+	p := startProxy(t)
+	logs := captureLog(t)
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Request-ID", "req-single")
+	if got := loggedAttempts(t, logs); len(got) != 0 {
+		t.Fatalf("single attempt logged in retries mode: %v", got)
+	}
+
+	t.Setenv("PROXY_ATTEMPT_LOG", "all")
+	p = startProxy(t)
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Request-ID", "req-single")
+	if got := loggedAttempts(t, logs)["req-single"]; len(got) != 1 || got[0].Outcome != "success" {
+		t.Fatalf("attempts in all mode = %+v", got)
+	}
+}
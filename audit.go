@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Аудит ответов: копия тела каждого ответа провайдера уходит в каталог (файл на request ID)
+// и/или webhook. Запись идёт в фоне через ограниченную очередь: медленный приёмник
+// не задерживает клиента, при переполнении записи отбрасываются с WARN.
+//
+// auditSink собирает newApp; воркер читает только поля своего приёмника, поэтому пересборка
+// приложения (тесты) не трогает настройки, с которыми он ещё пишет.
+type auditSink struct {
+	dir     string
+	webhook string
+	// redact - фрагменты, заменяемые на [REDACTED] перед записью (PROXY_AUDIT_REDACT)
+	redact *regexp.Regexp
+	// maxBytes - предел копии тела; длиннее - обрезается с truncated=true
+	maxBytes int
+	queue    chan *auditRecord
+}
+
+var (
+	// auditor - приёмник аудита; nil - аудит выключен
+	auditor      atomic.Pointer[auditSink]
+	auditDropped atomic.Int64
+)
+
+// currentAudit возвращает действующий приёмник аудита, nil - выключен
+func currentAudit() *auditSink {
+	return auditor.Load()
+}
+
+// auditRecord - копия ответа для аудита
+type auditRecord struct {
+	RequestID string    `json:"request_id"`
+	Time      time.Time `json:"time"`
+	Provider  string    `json:"provider"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Stream    bool      `json:"stream,omitempty"`
+	Truncated bool      `json:"truncated,omitempty"`
+	Body      string    `json:"body"`
+
+	encoding string
+}
+
+func initAudit() error {
+	auditor.Store(nil)
+	s := &auditSink{
+		dir:     strings.TrimSpace(os.Getenv("PROXY_AUDIT_DIR")),
+		webhook: strings.TrimSpace(os.Getenv("PROXY_AUDIT_WEBHOOK")),
+	}
+	if s.dir == "" && s.webhook == "" {
+		return nil
+	}
+	if s.dir != "" {
+		if err := os.MkdirAll(s.dir, 0o755); err != nil {
+			return fmt.Errorf("PROXY_AUDIT_DIR: %w", err)
+		}
+	}
+	if expr := os.Getenv("PROXY_AUDIT_REDACT"); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("PROXY_AUDIT_REDACT: %w", err)
+		}
+		s.redact = re
+	}
+	s.maxBytes = envInt("PROXY_AUDIT_MAX_BYTES", 10*1024*1024)
+	s.queue = make(chan *auditRecord, max(envInt("PROXY_AUDIT_QUEUE", 100), 1))
+	go s.worker()
+	auditor.Store(s)
+	log.Printf("Audit enabled (dir=%q webhook=%t)", s.dir, s.webhook != "")
+	return nil
+}
+
+func (s *auditSink) enabled() bool { return s != nil }
+
+// submit ставит запись в очередь, не блокируясь. Request ID копируется: он может
+// ссылаться на буфер запроса fasthttp, а запись обрабатывается уже после ответа.
+func (s *auditSink) submit(rec *auditRecord) {
+	rec.RequestID = strings.Clone(rec.RequestID)
+	rec.Time = time.Now().UTC()
+	select {
+	case s.queue <- rec:
+	default:
+		n := auditDropped.Add(1)
+		log.Printf("WARN: audit queue full, dropped record %s (%d dropped total)", rec.RequestID, n)
+	}
+}
+
+func (s *auditSink) worker() {
+	for rec := range s.queue {
+		if decoded, err := decodeBody([]byte(rec.Body), rec.encoding); err == nil {
+			rec.Body = string(decoded)
+		}
+		if s.redact != nil {
+			rec.Body = s.redact.ReplaceAllString(rec.Body, "[REDACTED]")
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			log.Printf("WARN: failed to encode audit record %s: %v", rec.RequestID, err)
+			continue
+		}
+		if s.dir != "" {
+			if err := writeRecordFile(s.dir, rec.RequestID, data); err != nil {
+				log.Printf("WARN: failed to write audit record %s: %v", rec.RequestID, err)
+			}
+		}
+		if s.webhook != "" {
+			if err := s.post(data); err != nil {
+				log.Printf("WARN: failed to send audit record %s: %v", rec.RequestID, err)
+			}
+		}
+	}
+}
+
+// auditFileName - имя файла по request ID; ID может прийти от клиента (X-Request-ID), поэтому
+// всё, кроме букв, цифр, "-" и "_", заменяется
+func auditFileName(id string) string {
+	name := auditUnsafeChars.ReplaceAllString(id, "_")
+	if name == "" {
+		name = randomHex(8)
+	}
+	return name + ".json"
+}
+
+var auditUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// writeRecordFile создаёт в dir новый файл записи по request ID. Существующий файл не
+// перезаписывается: клиент может повторить X-Request-ID, тогда к имени добавляется суффикс.
+func writeRecordFile(dir, id string, data []byte) error {
+	name := auditFileName(id)
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if os.IsExist(err) && attempt < 3 {
+			name = strings.TrimSuffix(auditFileName(id), ".json") + "-" + randomHex(4) + ".json"
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+func (s *auditSink) post(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.webhook, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// cappedBuffer копит копию потока до limit байт (аудит, датасет); запись в него не бывает ошибкой,
+// чтобы TeeReader никогда не обрывал поток клиента. Под mutex: после отключения клиента
+// читающая горутина batched-режима ещё может писать в буфер.
+type cappedBuffer struct {
+	mu        sync.Mutex
+	limit     int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+// contents - накопленная копия и признак обрезки
+func (b *cappedBuffer) contents() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String(), b.truncated
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// auditedStream - поток с ключом в тексте: в аудите он заменяется, клиенту уходит как есть
+const auditedStream = "data: {\"choices\":[{\"delta\":{\"content\":\"key sk-secret\"}}]}\n\n" +
+	"data: {\"choices\":[{\"delta\":{\"content\":\" done\"}}]}\n\n" +
+	"data: [DONE]\n\n"
+
+// readAudit ждёт файл аудита запроса id и разбирает его
+func readAudit(t *testing.T, dir, name string) auditRecord {
+	t.Helper()
+	var data []byte
+	waitFor(t, "audit record "+name, func() bool {
+		var err error
+		data, err = os.ReadFile(filepath.Join(dir, name))
+		// Файл пишется не атомарно: ждём, пока он будет дописан
+		return err == nil && json.Valid(data)
+	})
+	var rec auditRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestAuditStream(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROXY_AUDIT_DIR", dir)
+	t.Setenv("PROXY_AUDIT_REDACT", `sk-[a-z]+`)
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range strings.SplitAfter(auditedStream, "\n\n") {
+			w.Write([]byte(event))
+			w.(http.Flusher).Flush()
+		}
+	})
+	p := startProxy(t)
+
+	_, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`,
+		"Accept", "text/event-stream", "X-Request-ID", "stream-1")
+	if body != auditedStream {
+		t.Fatalf("client stream changed:\n%q", body)
+	}
+	rec := readAudit(t, dir, "stream-1.json")
+	if want := strings.ReplaceAll(auditedStream, "sk-secret", "[REDACTED]"); rec.Body != want {
+		t.Fatalf("audit body:\n got %q\nwant %q", rec.Body, want)
+	}
+	if !rec.Stream || rec.Status != http.StatusOK || rec.Provider != "openai" || rec.Path != "v1/chat/completions" || rec.Truncated {
+		t.Fatalf("audit record = %+v", rec)
+	}
+}
+
+func TestAuditBufferedResponse(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROXY_AUDIT_DIR", dir)
+	t.Setenv("PROXY_AUDIT_MAX_BYTES", "16")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion"}`))
+	})
+	p := startProxy(t)
+
+	// Повтор X-Request-ID не затирает первую запись
+	for range 2 {
+		p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{}`, "X-Request-ID", "../same")
+	}
+	rec := readAudit(t, dir, "___same.json")
+	if rec.Stream || rec.Body != `{"id":"chatcmpl-` || !rec.Truncated {
+		t.Fatalf("audit record = %+v", rec)
+	}
+	waitFor(t, "the second audit record", func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "___same*.json"))
+		return len(files) == 2
+	})
+}
+
+func TestAuditSlowSinkDoesNotStallClient(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan string, 10)
+	webhook := upstream(t, "deepseek", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		data, _ := io.ReadAll(r.Body)
+		received <- string(data)
+	})
+	t.Setenv("PROXY_AUDIT_WEBHOOK", webhook.URL)
+	t.Setenv("PROXY_AUDIT_QUEUE", "1")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	start := time.Now()
+	for range 5 {
+		if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d", resp.StatusCode)
+		}
+	}
+	// Воркер держит одну запись, в очереди ещё одна - остальные отброшены
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("5 requests took %s with a stalled audit sink", elapsed)
+	}
+	if !strings.Contains(logs.String(), "WARN: audit queue full") {
+		t.Fatalf("no warning about dropped records:\n%s", logs)
+	}
+	close(release)
+	select {
+	case rec := <-received:
+		if !strings.Contains(rec, `"path":"v1/models"`) {
+			t.Fatalf("webhook got %s", rec)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("webhook got nothing after the sink recovered")
+	}
+}
+
+func TestAuditOffByDefault(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	startProxy(t)
+	if currentAudit().enabled() {
+		t.Fatal("audit enabled without PROXY_AUDIT_DIR and PROXY_AUDIT_WEBHOOK")
+	}
+}
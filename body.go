@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// jsonBody - JSON-объект тела запроса для преобразований перед отправкой провайдеру.
+// Значения хранятся как RawMessage, поэтому нетронутые поля уходят без изменений.
+type jsonBody struct {
+	fields  map[string]json.RawMessage
+	changed bool
+}
+
+// parseJSONBody возвращает nil, если тело не JSON-объект
+func parseJSONBody(b []byte) *jsonBody {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || b[0] != '{' {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil
+	}
+	return &jsonBody{fields: fields}
+}
+
+// getString возвращает строковое поле; false - поля нет или это не строка
+func (b *jsonBody) getString(key string) (string, bool) {
+	raw, ok := b.fields[key]
+	if !ok {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+// remove удаляет поле; false - поля не было
+func (b *jsonBody) remove(key string) bool {
+	if _, ok := b.fields[key]; !ok {
+		return false
+	}
+	delete(b.fields, key)
+	b.changed = true
+	return true
+}
+
+func (b *jsonBody) set(key string, v any) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	b.fields[key] = raw
+	b.changed = true
+}
+
+// bytes сериализует тело обратно
+func (b *jsonBody) bytes() ([]byte, error) {
+	return json.Marshal(b.fields)
+}
+
+// getBool возвращает булево поле; false - поля нет или это не bool
+func (b *jsonBody) getBool(key string) bool {
+	var v bool
+	if raw, ok := b.fields[key]; ok {
+		_ = json.Unmarshal(raw, &v)
+	}
+	return v
+}
+
+// requestInfo - сведения о теле запроса, собранные при преобразовании
+type requestInfo struct {
+	model         string
+	stream        bool
+	usageInjected bool     // stream_options.include_usage добавлен прокси, а не клиентом
+	escalation    []string // оставшиеся модели цепочки эскалации (только для non-streaming)
+}
+
+// transformRequestBody применяет настроенные преобразования к JSON-телу запроса.
+// session - id сессии клиента для prompt_cache_key (пусто - не добавлять),
+// seed - seed для chat-запросов без своего seed (пусто - не добавлять),
+// user - источник хешированного user для chat-запросов без своего user (пусто - не добавлять).
+// Ошибка означает, что запрос нужно отклонить с 400.
+func transformRequestBody(p *provider, body []byte, session, seed, user string) ([]byte, requestInfo, error) {
+	var info requestInfo
+	jb := parseJSONBody(body)
+	if jb == nil {
+		return body, info, nil
+	}
+
+	info.stream = jb.getBool("stream")
+
+	// Параметры OpenAI, которые провайдер отклоняет с 400
+	var stripped []string
+	for _, key := range p.stripParams {
+		if jb.remove(key) {
+			stripped = append(stripped, key)
+		}
+	}
+	if len(stripped) > 0 {
+		log.Printf("Stripped unsupported %s params: %s", p.Name, strings.Join(stripped, ", "))
+	}
+
+	// Алиасы моделей; логическая модель цепочки эскалации начинается с первой цели
+	if model, ok := jb.getString("model"); ok {
+		if normalized := normalizeModel(model); normalized != model {
+			model = normalized
+			jb.set("model", model)
+		}
+		if chain, ok := p.escalationChains[model]; ok {
+			model = chain[0]
+			jb.set("model", model)
+			if !info.stream {
+				info.escalation = chain[1:]
+			}
+		}
+		resolved, err := resolveModel(p, model)
+		if err != nil {
+			return nil, info, err
+		}
+		if resolved != model {
+			jb.set("model", resolved)
+		}
+		info.model = resolved
+	}
+
+	// Usage в streaming-ответах для статистики
+	if info.stream && injectStreamUsage && supportsStreamUsage(p.Name) {
+		info.usageInjected = injectIncludeUsage(jb)
+	}
+
+	// Стабильный ключ кэша промптов для повторных запросов одной сессии
+	if session != "" && supportsPromptCacheKey(p.Name) {
+		injectPromptCacheKey(jb, session)
+	}
+
+	// Воспроизводимость: seed из X-Proxy-Seed или PROXY_DEFAULT_SEED
+	if seed != "" && supportsSeed(p.Name) {
+		injectSeed(jb, seed)
+	}
+
+	// Обезличенный id пользователя для abuse-мониторинга провайдера
+	if user != "" && supportsUserField(p.Name) {
+		injectUserField(jb, user)
+	}
+
+	if !jb.changed {
+		return body, info, nil
+	}
+	out, err := jb.bytes()
+	return out, info, err
+}
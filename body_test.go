@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// rawBodyUpstream запоминает тело последнего запроса к провайдеру как есть
+func rawBodyUpstream(t *testing.T, provider string) *string {
+	got := new(string)
+	upstream(t, provider, func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		*got = string(data)
+		w.Write([]byte(`{}`))
+	})
+	return got
+}
+
+func TestStripParams(t *testing.T) {
+	t.Setenv("DEEPSEEK_STRIP_PARAMS", "logit_bias, response_format")
+	deepseek := rawBodyUpstream(t, "deepseek")
+	openai := rawBodyUpstream(t, "openai")
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	body := `{"model":"m","logit_bias":{"50256":-100},"messages":[],"response_format":{"type":"json_schema"}}`
+	p.do(t, http.MethodPost, "/deepseek/v1/chat/completions", body)
+	if *deepseek != `{"messages":[],"model":"m"}` {
+		t.Fatalf("deepseek body = %s", *deepseek)
+	}
+	if !strings.Contains(logs.String(), "Stripped unsupported deepseek params: logit_bias, response_format") {
+		t.Fatalf("stripped params are not logged:\n%s", logs)
+	}
+
+	// У другого провайдера тело не меняется
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", body)
+	if *openai != body {
+		t.Fatalf("openai body = %s, want it intact", *openai)
+	}
+	// Без настроенных полей в теле оно уходит без перекодирования
+	plain := `{"model": "m", "messages": []}`
+	p.do(t, http.MethodPost, "/deepseek/v1/chat/completions", plain)
+	if *deepseek != plain {
+		t.Fatalf("deepseek body without stripped params = %s", *deepseek)
+	}
+}
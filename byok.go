@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Собственные ключи провайдеров у токенов (BYOK): "provider_keys" в PROXY_TOKENS_FILE.
+// Ключи хранятся зашифрованными AES-256-GCM ключом PROXY_KEYS_ENCRYPTION_KEY (32 байта в base64)
+// в виде "enc:<base64(nonce|ciphertext)>"; значение получают командой `ai_proxy encrypt-key`.
+// Расшифрованные ключи есть только в памяти. Провайдер без своего ключа - общий ключ прокси.
+
+const encryptedKeyPrefix = "enc:"
+
+// keysCipher - AEAD из PROXY_KEYS_ENCRYPTION_KEY
+func keysCipher() (cipher.AEAD, error) {
+	raw := strings.TrimSpace(os.Getenv("PROXY_KEYS_ENCRYPTION_KEY"))
+	if raw == "" {
+		return nil, errors.New("PROXY_KEYS_ENCRYPTION_KEY is not set")
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("PROXY_KEYS_ENCRYPTION_KEY must be 32 bytes encoded in base64")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptProviderKey(aead cipher.AEAD, key string) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(key), nil)
+	return encryptedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptProviderKey(aead cipher.AEAD, value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, encryptedKeyPrefix)
+	if !ok {
+		return "", errors.New("key is not encrypted (expected enc:...)")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted key")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt key: wrong PROXY_KEYS_ENCRYPTION_KEY or corrupted value")
+	}
+	return string(plain), nil
+}
+
+// loadProviderKeys расшифровывает provider_keys токенов в пулы ключей
+func loadProviderKeys(list []*apiToken) error {
+	var aead cipher.AEAD
+	for _, t := range list {
+		if len(t.ProviderKeys) == 0 {
+			continue
+		}
+		if aead == nil {
+			var err error
+			if aead, err = keysCipher(); err != nil {
+				return fmt.Errorf("PROXY_TOKENS_FILE: token %q has provider_keys: %w", t.Name, err)
+			}
+		}
+		t.ownKeys = map[string]*keyPool{}
+		for provider, value := range t.ProviderKeys {
+			if !knownProvider(provider) {
+				return fmt.Errorf("PROXY_TOKENS_FILE: token %q: unknown provider %q in provider_keys", t.Name, provider)
+			}
+			key, err := decryptProviderKey(aead, value)
+			if err != nil {
+				return fmt.Errorf("PROXY_TOKENS_FILE: token %q: %s key: %w", t.Name, provider, err)
+			}
+			t.ownKeys[provider] = newKeyPool(key)
+		}
+	}
+	return nil
+}
+
+func knownProvider(name string) bool {
+	for _, p := range providers {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// keyPoolFor - собственные ключи токена для провайдера, иначе пул прокси
+func (t *apiToken) keyPoolFor(provider string, shared *keyPool) *keyPool {
+	if t != nil {
+		if own := t.ownKeys[provider]; own != nil && len(own.keys) > 0 {
+			return own
+		}
+	}
+	return shared
+}
+
+// runEncryptKey - `ai_proxy encrypt-key`: читает ключ провайдера из stdin и печатает
+// значение для provider_keys, зашифрованное PROXY_KEYS_ENCRYPTION_KEY
+func runEncryptKey() error {
+	aead, err := keysCipher()
+	if err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("read key from stdin: %w", err)
+	}
+	key := strings.TrimSpace(line)
+	if key == "" {
+		return errors.New("empty key")
+	}
+	value, err := encryptProviderKey(aead, key)
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testEncryptionKey - PROXY_KEYS_ENCRYPTION_KEY тестов (32 байта в base64)
+const testEncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+// encryptedKey - значение provider_keys для key под testEncryptionKey
+func encryptedKey(t *testing.T, key string) string {
+	t.Helper()
+	t.Setenv("PROXY_KEYS_ENCRYPTION_KEY", testEncryptionKey)
+	aead, err := keysCipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := encryptProviderKey(aead, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// useBYOKTokens - токен byok со своими ключами OpenAI и обычный токен shared
+func useBYOKTokens(t *testing.T, ownKeys string) {
+	useTokens(t, fmt.Sprintf(`[
+		{"name":"byok","token":"tok-byok","provider_keys":{"openai":%q}},
+		{"name":"shared","token":"tok-shared"}
+	]`, encryptedKey(t, ownKeys)))
+}
+
+// keyRecorder - провайдер, запоминающий ключи запросов; ответ - номер вызова
+func keyRecorder(t *testing.T, delay time.Duration) func() []string {
+	var mu sync.Mutex
+	var used []string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		used = append(used, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		n := len(used)
+		mu.Unlock()
+		time.Sleep(delay)
+		fmt.Fprintf(w, `{"call":%d}`, n)
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), used...)
+	}
+}
+
+func TestBYOKKeySelection(t *testing.T) {
+	useBYOKTokens(t, "sk-own")
+	used := keyRecorder(t, 0)
+	var deepseekKey string
+	upstream(t, "deepseek", func(w http.ResponseWriter, r *http.Request) {
+		deepseekKey = r.Header.Get("Authorization")
+	})
+	p := startProxy(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", "tok-byok")
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", "tok-shared")
+	// Провайдер без своего ключа у токена - общий ключ
+	if p.do(t, http.MethodGet, "/deepseek/v1/models", "", "X-Proxy-Auth", "tok-byok"); deepseekKey != "Bearer sk-deepseek-test" {
+		t.Errorf("deepseek key of the BYOK token = %q", deepseekKey)
+	}
+	if want := "sk-own sk-openai-test"; strings.Join(used(), " ") != want {
+		t.Fatalf("keys used = %v, want %s", used(), want)
+	}
+}
+
+func TestBYOKEscalationUsesOwnKey(t *testing.T) {
+	t.Setenv("OPENAI_KEY_BIG", "sk-big")
+	t.Setenv("OPENAI_MODEL_KEYS", "gpt-4.1=OPENAI_KEY_BIG")
+	t.Setenv("OPENAI_ESCALATION_CHAINS", "smart=gpt-4o-small|gpt-4.1")
+	useBYOKTokens(t, "sk-own")
+	var keys []string
+	var models []string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		tieredHandler(&models)(w, r)
+	})
+	p := startProxy(t)
+
+	// Эскалация идёт с собственным ключом токена, а не с ключом модели прокси
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"smart"}`, "X-Proxy-Auth", "tok-byok")
+	if resp.StatusCode != http.StatusOK || strings.Join(keys, " ") != "sk-own sk-own" {
+		t.Fatalf("status %d %s, keys used %v", resp.StatusCode, body, keys)
+	}
+}
+
+func TestBYOKCacheScopedPerKey(t *testing.T) {
+	useBYOKTokens(t, "sk-own-1,sk-own-2")
+	t.Setenv("PROXY_CACHE_POLICIES_FILE", writeFile(t, "cache.json", `[{"path": "v1/*", "ttl_sec": 60}]`))
+	used := keyRecorder(t, 0)
+	p := startProxy(t)
+
+	// Ответ, полученный одним ключом токена, не отдаётся запросу через другой ключ
+	var bodies []string
+	for range 4 {
+		_, body := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", "tok-byok")
+		bodies = append(bodies, body)
+	}
+	if want := "sk-own-1 sk-own-2"; strings.Join(used(), " ") != want {
+		t.Fatalf("keys used = %v, want %s", used(), want)
+	}
+	if want := `{"call":1} {"call":2} {"call":1} {"call":2}`; strings.Join(bodies, " ") != want {
+		t.Fatalf("responses = %v, want %s", bodies, want)
+	}
+}
+
+func TestBYOKNotCoalesced(t *testing.T) {
+	useBYOKTokens(t, "sk-own")
+	t.Setenv("PROXY_COALESCE", "true")
+	used := keyRecorder(t, 100*time.Millisecond)
+	p := startProxy(t)
+
+	// Одинаковые детерминированные запросы разных токенов идут к провайдеру каждый со своим ключом
+	body := `{"model":"gpt-4o","temperature":0,"messages":[]}`
+	var wg sync.WaitGroup
+	for i, token := range []string{"tok-shared", "tok-byok"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Duration(i) * 30 * time.Millisecond)
+			if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", body, "X-Proxy-Auth", token); resp.Header.Get("X-Proxy-Coalesced") != "" {
+				t.Errorf("%s: request coalesced", token)
+			}
+		}()
+	}
+	wg.Wait()
+	if want := "sk-openai-test sk-own"; strings.Join(used(), " ") != want {
+		t.Fatalf("keys used = %v, want %s", used(), want)
+	}
+}
+
+func TestLoadProviderKeysErrors(t *testing.T) {
+	value := encryptedKey(t, "sk-own")
+	cases := []struct {
+		keys map[string]string
+		want string
+	}{
+		{map[string]string{"openai": "sk-plain"}, "key is not encrypted"},
+		{map[string]string{"openai": "enc:%%%"}, "malformed encrypted key"},
+		{map[string]string{"unknown": value}, `unknown provider "unknown"`},
+	}
+	for _, c := range cases {
+		err := loadProviderKeys([]*apiToken{{Name: "team-a", ProviderKeys: c.keys}})
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%v: error %v, want %q", c.keys, err, c.want)
+		}
+	}
+
+	// Значение, зашифрованное другим ключом
+	t.Setenv("PROXY_KEYS_ENCRYPTION_KEY", "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	err := loadProviderKeys([]*apiToken{{Name: "team-a", ProviderKeys: map[string]string{"openai": value}}})
+	if err == nil || !strings.Contains(err.Error(), "cannot decrypt key") {
+		t.Errorf("wrong encryption key: %v", err)
+	}
+	t.Setenv("PROXY_KEYS_ENCRYPTION_KEY", "")
+	err = loadProviderKeys([]*apiToken{{Name: "team-a", ProviderKeys: map[string]string{"openai": value}}})
+	if err == nil || !strings.Contains(err.Error(), "PROXY_KEYS_ENCRYPTION_KEY is not set") {
+		t.Errorf("no encryption key: %v", err)
+	}
+}
+
+func TestEncryptProviderKeyRoundTrip(t *testing.T) {
+	value := encryptedKey(t, "sk-own")
+	aead, _ := keysCipher()
+	if key, err := decryptProviderKey(aead, value); err != nil || key != "sk-own" {
+		t.Fatalf("decrypted %q, %v", key, err)
+	}
+	// Случайный nonce: одинаковые ключи шифруются по-разному
+	if value == encryptedKey(t, "sk-own") || strings.Contains(value, "sk-own") {
+		t.Fatalf("encrypted value %q", value)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Кэш ответов по политикам путей из PROXY_CACHE_POLICIES_FILE. Без политики путь не кэшируется.
+// Кэшируются только успешные (200) ответы, отдельно для каждого токена; SSE-потоки - только
+// с "stream": true и только завершённые (целиком, после [DONE]).
+//
+//	[{"path": "v1/embeddings", "ttl_sec": 3600, "ignore_fields": ["user"]},
+//	 {"path": "v1/models", "ttl_sec": 60},
+//	 {"path": "v1/chat/*", "enabled": false}]
+
+// cachePolicy - политика кэширования пути; path - путь после префикса провайдера,
+// "*" в конце - префикс
+type cachePolicy struct {
+	Path    string `json:"path"`
+	Enabled *bool  `json:"enabled,omitempty"`
+	TTLSec  int    `json:"ttl_sec"`
+	// IgnoreFields - поля JSON-тела, не влияющие на ключ кэша
+	IgnoreFields []string `json:"ignore_fields,omitempty"`
+	// Stream - кэшировать и streaming-ответы; с PROXY_STREAM_DRAIN_ON_DISCONNECT поток
+	// дочитывается после отключения клиента, чтобы попасть в кэш
+	Stream bool `json:"stream,omitempty"`
+
+	ttl time.Duration
+}
+
+var (
+	cachePolicies []*cachePolicy
+	responseCache = newCacheStore(1000)
+)
+
+func loadCachePolicies(path string) error {
+	cachePolicies = nil
+	responseCache = newCacheStore(envInt("PROXY_CACHE_MAX_ENTRIES", 1000))
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("PROXY_CACHE_POLICIES_FILE: %w", err)
+	}
+	var list []*cachePolicy
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("PROXY_CACHE_POLICIES_FILE: %w", err)
+	}
+	for i, p := range list {
+		p.Path = strings.TrimPrefix(strings.TrimSpace(p.Path), "/")
+		if p.Path == "" {
+			return fmt.Errorf("PROXY_CACHE_POLICIES_FILE: policy #%d: path is required", i)
+		}
+		enabled := p.Enabled == nil || *p.Enabled
+		if enabled && p.TTLSec <= 0 {
+			return fmt.Errorf("PROXY_CACHE_POLICIES_FILE: policy %q: ttl_sec must be positive", p.Path)
+		}
+		if enabled {
+			p.ttl = time.Duration(p.TTLSec) * time.Second
+		}
+	}
+	cachePolicies = list
+	log.Printf("Loaded %d cache policies", len(list))
+	return nil
+}
+
+// cachePolicyFor - политика пути; точное совпадение приоритетнее префикса, из префиксов -
+// самый длинный. nil - путь не кэшируется.
+func cachePolicyFor(path string) *cachePolicy {
+	var match *cachePolicy
+	matchLen := -1
+	for _, p := range cachePolicies {
+		if p.Path == path {
+			match = p
+			break
+		}
+		if prefix, ok := strings.CutSuffix(p.Path, "*"); ok && strings.HasPrefix(path, prefix) && len(prefix) > matchLen {
+			match, matchLen = p, len(prefix)
+		}
+	}
+	if match == nil || match.ttl <= 0 {
+		return nil
+	}
+	return match
+}
+
+// cacheScope - часть ключей кэша и объединения по токену: ответ, полученный для одного токена,
+// другому не отдаётся (у токенов свои ACL, лимиты и учёт). С собственным ключом токена (BYOK) -
+// ещё и по ключу: ответ зависит от аккаунта провайдера, новый ключ начинает с чистого кэша.
+func cacheScope(tok *apiToken, provider string, key *apiKeyState) string {
+	if tok == nil {
+		return ""
+	}
+	scope := "token:" + tok.Name
+	if key != nil && tok.keyPoolFor(provider, nil) != nil {
+		scope += ",key:" + key.id()
+	}
+	return scope
+}
+
+// cacheEntry - сохранённый ответ провайдера; для потока body - сырой поток событий
+type cacheEntry struct {
+	header  http.Header
+	body    []byte
+	stream  bool
+	expires time.Time
+}
+
+type cacheStore struct {
+	// maxEntries - предел числа записей (PROXY_CACHE_MAX_ENTRIES); при заполнении новые не добавляются
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+func newCacheStore(maxEntries int) *cacheStore {
+	return &cacheStore{maxEntries: maxEntries, entries: map[string]*cacheEntry{}}
+}
+
+func (s *cacheStore) get(key string, now time.Time) *cacheEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entries[key]
+	if e == nil {
+		return nil
+	}
+	if !now.Before(e.expires) {
+		delete(s.entries, key)
+		return nil
+	}
+	return e
+}
+
+func (s *cacheStore) put(key string, e *cacheEntry, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.entries) >= s.maxEntries {
+		for k, old := range s.entries {
+			if !now.Before(old.expires) {
+				delete(s.entries, k)
+			}
+		}
+		if len(s.entries) >= s.maxEntries {
+			log.Printf("WARN: response cache is full (%d entries), response not cached", len(s.entries))
+			return
+		}
+	}
+	s.entries[key] = e
+}
+
+// serveCached отдаёт ответ из кэша; фильтр списка моделей по токену и хук преобразования -
+// как у живого ответа
+func serveCached(c *fiber.Ctx, e *cacheEntry, provider string, tok *apiToken, method, path string) error {
+	copyResponseHeaders(c, &http.Response{Header: e.header})
+	c.Set("X-Proxy-Cache", "hit")
+	c.Set("X-Proxy-Upstream-Status", "200")
+	body := e.body
+	if tok != nil && len(tok.Models) > 0 && isModelList(method, path) {
+		if decoded, err := decodeBody(body, e.header.Get("Content-Encoding")); err == nil {
+			if filtered, err := filterModelList(decoded, tok); err == nil {
+				c.Response().Header.Del("Content-Encoding")
+				body = filtered
+			}
+		}
+	}
+	c.Status(fiber.StatusOK)
+	body, ok := transformResponse(c, provider, path, fiber.StatusOK, e.header, body)
+	if !ok {
+		return nil
+	}
+	return c.Send(body)
+}
+
+// serveCachedStream отдаёт сохранённый поток одним телом; нормализация событий и удаление
+// добавленного прокси usage - как у живого потока
+func serveCachedStream(c *fiber.Ctx, e *cacheEntry, provider string, info requestInfo) error {
+	copyResponseHeaders(c, &http.Response{Header: e.header})
+	c.Set("X-Proxy-Cache", "hit")
+	c.Set("X-Proxy-Upstream-Status", "200")
+	c.Set("Cache-Control", "no-cache")
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	out, finish := w, func() {}
+	if p := currentRegistry().get(provider); p != nil && p.normalizeStream {
+		out, finish = newNormalizedStreamWriter(w, provider)
+	}
+	tap := newStreamTap(provider)
+	tap.stripUsage = info.usageInjected && stripInjectedUsage
+	pipeStream(out, bytes.NewReader(e.body), tap)
+	finish()
+	w.Flush()
+
+	c.Status(fiber.StatusOK)
+	return c.Send(buf.Bytes())
+}
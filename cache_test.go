@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const cachePoliciesJSON = `[
+	{"path": "v1/embeddings", "ttl_sec": 3600, "ignore_fields": ["user"]},
+	{"path": "v1/models", "ttl_sec": 60},
+	{"path": "v1/*", "ttl_sec": 60},
+	{"path": "v1/chat/completions", "enabled": false}
+]`
+
+// cachedUpstream считает вызовы по путям; ответ - номер вызова пути
+func cachedUpstream(t *testing.T) map[string]*atomic.Int32 {
+	calls := map[string]*atomic.Int32{}
+	for _, path := range []string{"/v1/embeddings", "/v1/models", "/v1/chat/completions", "/v1/files", "/v1/fail"} {
+		calls[path] = &atomic.Int32{}
+	}
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		n := calls[r.URL.Path].Add(1)
+		if r.URL.Path == "/v1/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"call":%d}`, n)
+	})
+	return calls
+}
+
+func TestCachePolicies(t *testing.T) {
+	t.Setenv("PROXY_CACHE_POLICIES_FILE", writeFile(t, "cache.json", cachePoliciesJSON))
+	calls := cachedUpstream(t)
+	p := startProxy(t)
+
+	send := func(method, path, body string) (cache, out string) {
+		t.Helper()
+		resp, out := p.do(t, method, path, body)
+		return resp.Header.Get("X-Proxy-Cache"), out
+	}
+	for _, tc := range []struct {
+		name, method, path, body string
+		wantCache, wantBody      string
+	}{
+		{"embeddings miss", "POST", "/openai/v1/embeddings", `{"input":"a","user":"alice"}`, "miss", `{"call":1}`},
+		{"embeddings hit", "POST", "/openai/v1/embeddings", `{"user":"bob","input":"a"}`, "hit", `{"call":1}`},
+		{"embeddings other input", "POST", "/openai/v1/embeddings", `{"input":"b"}`, "miss", `{"call":2}`},
+		{"models miss", "GET", "/openai/v1/models", "", "miss", `{"call":1}`},
+		{"models hit", "GET", "/openai/v1/models", "", "hit", `{"call":1}`},
+		// Точная политика с enabled=false сильнее префикса v1/*
+		{"chat not cached", "POST", "/openai/v1/chat/completions", `{}`, "", `{"call":1}`},
+		{"chat not cached again", "POST", "/openai/v1/chat/completions", `{}`, "", `{"call":2}`},
+		{"prefix policy", "GET", "/openai/v1/files", "", "miss", `{"call":1}`},
+		{"prefix policy hit", "GET", "/openai/v1/files", "", "hit", `{"call":1}`},
+		// Ошибки не кэшируются
+		{"error", "GET", "/openai/v1/fail", "", "miss", `{"call":1}`},
+		{"error again", "GET", "/openai/v1/fail", "", "miss", `{"call":2}`},
+	} {
+		if cache, body := send(tc.method, tc.path, tc.body); cache != tc.wantCache || body != tc.wantBody {
+			t.Errorf("%s: X-Proxy-Cache %q, body %s; want %q, %s", tc.name, cache, body, tc.wantCache, tc.wantBody)
+		}
+	}
+	if n := calls["/v1/embeddings"].Load(); n != 2 {
+		t.Fatalf("embeddings upstream calls = %d, want 2", n)
+	}
+}
+
+func TestCacheSkipsStreams(t *testing.T) {
+	t.Setenv("PROXY_CACHE_POLICIES_FILE", writeFile(t, "cache.json", `[{"path": "v1/*", "ttl_sec": 60}]`))
+	calls := cachedUpstream(t)
+	p := startProxy(t)
+
+	for range 2 {
+		p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`)
+	}
+	if n := calls["/v1/chat/completions"].Load(); n != 2 {
+		t.Fatalf("streaming upstream calls = %d, want 2", n)
+	}
+}
+
+// streamCachePolicy - кэш потоков chat completions
+const streamCachePolicy = `[{"path": "v1/chat/completions", "ttl_sec": 60, "stream": true}]`
+
+func TestCacheStreams(t *testing.T) {
+	t.Setenv("PROXY_CACHE_POLICIES_FILE", writeFile(t, "cache.json", streamCachePolicy))
+	var calls atomic.Int32
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":\"%d\"}}]}\n\ndata: [DONE]\n\n", calls.Add(1))
+	})
+	p := startProxy(t)
+
+	var bodies []string
+	for _, want := range []string{"miss", "hit"} {
+		resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`, "Accept", "text/event-stream")
+		if resp.Header.Get("X-Proxy-Cache") != want || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+			t.Fatalf("X-Proxy-Cache %q, Content-Type %q, want %s", resp.Header.Get("X-Proxy-Cache"), resp.Header.Get("Content-Type"), want)
+		}
+		bodies = append(bodies, body)
+	}
+	if calls.Load() != 1 || bodies[0] != bodies[1] {
+		t.Fatalf("upstream calls = %d, bodies %q", calls.Load(), bodies)
+	}
+}
+
+func TestCacheStreamDrainedAfterDisconnect(t *testing.T) {
+	t.Setenv("PROXY_CACHE_POLICIES_FILE", writeFile(t, "cache.json", streamCachePolicy))
+	t.Setenv("PROXY_STREAM_DRAIN_ON_DISCONNECT", "true")
+	clientGone := make(chan struct{})
+	disconnectUpstream(t, clientGone)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	readFirstEventAndDisconnect(t, p)
+	close(clientGone)
+	waitFor(t, "the drained stream", func() bool { return strings.Contains(logs.String(), "Stream completed") })
+
+	// Дочитанный без клиента поток целиком попал в кэш
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","stream":true}`, "Accept", "text/event-stream")
+	data := dataLines(body)
+	if resp.Header.Get("X-Proxy-Cache") != "hit" || len(data) != 22 || data[20] != `{"choices":[{"delta":{"content":"20"}}]}` || data[21] != "[DONE]" {
+		t.Fatalf("X-Proxy-Cache %q, cached stream has %d events: %q", resp.Header.Get("X-Proxy-Cache"), len(data), body)
+	}
+}
+
+func TestCacheStreamNotDrainedByDefault(t *testing.T) {
+	t.Setenv("PROXY_CACHE_POLICIES_FILE", writeFile(t, "cache.json", streamCachePolicy))
+	clientGone := make(chan struct{})
+	disconnectUpstream(t, clientGone)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	readFirstEventAndDisconnect(t, p)
+	close(clientGone)
+
+	// Оборванный поток не кэшируется
+	waitFor(t, "the stream to end", func() bool { return strings.Contains(logs.String(), "Client closed openai stream") })
+	resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","stream":true}`, "Accept", "text/event-stream")
+	if resp.Header.Get("X-Proxy-Cache") != "miss" {
+		t.Fatalf("X-Proxy-Cache = %q after an interrupted stream", resp.Header.Get("X-Proxy-Cache"))
+	}
+}
+
+func TestCacheScopedPerToken(t *testing.T) {
+	useTokens(t, `[{"name":"team-a","token":"tok-a"},{"name":"team-b","token":"tok-b"}]`)
+	t.Setenv("PROXY_CACHE_POLICIES_FILE", writeFile(t, "cache.json", cachePoliciesJSON))
+	calls := cachedUpstream(t)
+	p := startProxy(t)
+
+	for _, token := range []string{"tok-a", "tok-b", "tok-a", "tok-b"} {
+		p.do(t, http.MethodPost, "/openai/v1/embeddings", `{"input":"a"}`, "X-Proxy-Auth", token)
+	}
+	// Ответ для team-a не отдаётся team-b
+	if n := calls["/v1/embeddings"].Load(); n != 2 {
+		t.Fatalf("upstream calls = %d, want one per token", n)
+	}
+	if resp, body := p.do(t, http.MethodPost, "/openai/v1/embeddings", `{"input":"a"}`, "X-Proxy-Auth", "tok-b"); resp.Header.Get("X-Proxy-Cache") != "hit" || body != `{"call":2}` {
+		t.Fatalf("team-b got %s (%s)", body, resp.Header.Get("X-Proxy-Cache"))
+	}
+}
+
+func TestCacheOffByDefault(t *testing.T) {
+	calls := cachedUpstream(t)
+	p := startProxy(t)
+
+	for range 2 {
+		if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.Header.Get("X-Proxy-Cache") != "" {
+			t.Fatalf("X-Proxy-Cache = %q without policies", resp.Header.Get("X-Proxy-Cache"))
+		}
+	}
+	if n := calls["/v1/models"].Load(); n != 2 {
+		t.Fatalf("upstream calls = %d, want 2", n)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	s := newCacheStore(1)
+	now := time.Now()
+
+	s.put("a", &cacheEntry{expires: now.Add(time.Minute)}, now)
+	if s.get("a", now.Add(59*time.Second)) == nil {
+		t.Fatal("entry expired before its TTL")
+	}
+	// Полный кэш не вытесняет живые записи
+	s.put("b", &cacheEntry{expires: now.Add(time.Minute)}, now)
+	if s.get("b", now) != nil {
+		t.Fatal("entry added to a full cache")
+	}
+	if s.get("a", now.Add(time.Minute)) != nil {
+		t.Fatal("entry served after its TTL")
+	}
+	// Просроченные записи освобождают место
+	s.put("a", &cacheEntry{expires: now.Add(time.Minute)}, now)
+	s.put("b", &cacheEntry{expires: now.Add(3 * time.Minute)}, now.Add(2*time.Minute))
+	if s.get("b", now.Add(2*time.Minute)) == nil {
+		t.Fatal("expired entry blocks the full cache")
+	}
+}
+
+func TestLoadCachePoliciesErrors(t *testing.T) {
+	for _, policies := range []string{`[{"ttl_sec": 60}]`, `[{"path": "v1/models"}]`, `{"path": "v1/models"}`} {
+		if err := loadCachePolicies(writeFile(t, "cache.json", policies)); err == nil {
+			t.Errorf("%s accepted", policies)
+		}
+	}
+	if err := loadCachePolicies(writeFile(t, "cache.json", `[{"path": "v1/chat/*", "enabled": false}]`)); err != nil {
+		t.Errorf("disabled policy without ttl rejected: %v", err)
+	}
+	loadCachePolicies("")
+}
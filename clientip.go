@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// clientIPHeader - заголовок запроса к провайдеру с IP клиента (PROXY_CLIENT_IP_HEADER),
+	// пусто - не передавать
+	clientIPHeader string
+	// trustedProxies - сети балансировщиков, чьему X-Forwarded-For можно верить (PROXY_TRUSTED_PROXIES)
+	trustedProxies []netip.Prefix
+)
+
+func initClientIP() error {
+	clientIPHeader = strings.TrimSpace(os.Getenv("PROXY_CLIENT_IP_HEADER"))
+	trustedProxies = nil
+	for _, v := range strings.Split(os.Getenv("PROXY_TRUSTED_PROXIES"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return fmt.Errorf("PROXY_TRUSTED_PROXIES: %w", err)
+			}
+			v = netip.PrefixFrom(addr, addr.BitLen()).String()
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return fmt.Errorf("PROXY_TRUSTED_PROXIES: %w", err)
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+	return nil
+}
+
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP - адрес соединения; если соединение от доверенного прокси, берётся самый правый
+// адрес X-Forwarded-For, не принадлежащий доверенным прокси
+func clientIP(c *fiber.Ctx) string {
+	peer := c.Context().RemoteIP().String()
+	if !isTrustedProxy(peer) {
+		return peer
+	}
+	hops := strings.Split(c.Get(fiber.HeaderXForwardedFor), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" || net.ParseIP(hop) == nil {
+			continue
+		}
+		if !isTrustedProxy(hop) {
+			return hop
+		}
+	}
+	return peer
+}
+
+// forwardedFor - значение X-Forwarded-For для провайдера: входящая цепочка плюс адрес соединения
+func forwardedFor(c *fiber.Ctx) string {
+	peer := c.Context().RemoteIP().String()
+	if prior := strings.TrimSpace(c.Get(fiber.HeaderXForwardedFor)); prior != "" {
+		return prior + ", " + peer
+	}
+	return peer
+}
+
+// requireTLS - отклонять запросы, пришедшие не по HTTPS (PROXY_REQUIRE_TLS)
+var requireTLS bool
+
+// isHTTPS - запрос пришёл по TLS: напрямую или, по X-Forwarded-Proto доверенного прокси, до балансировщика
+func isHTTPS(c *fiber.Ctx) bool {
+	if c.Context().IsTLS() {
+		return true
+	}
+	if !isTrustedProxy(c.Context().RemoteIP().String()) {
+		return false
+	}
+	// В цепочке "https, http" первым стоит протокол клиента
+	proto, _, _ := strings.Cut(c.Get(fiber.HeaderXForwardedProto), ",")
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+// requireTLSMiddleware отклоняет plaintext-запросы; /health остаётся доступен для проверок балансировщика
+func requireTLSMiddleware(c *fiber.Ctx) error {
+	if c.Path() == "/health" || isHTTPS(c) {
+		return c.Next()
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"error": "HTTPS is required",
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// ipUpstream запоминает заголовки IP клиента в запросе к провайдеру
+func ipUpstream(t *testing.T) *http.Header {
+	got := &http.Header{}
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		*got = r.Header.Clone()
+		w.Write([]byte(`{}`))
+	})
+	return got
+}
+
+func TestClientIPDirectConnection(t *testing.T) {
+	t.Setenv("PROXY_CLIENT_IP_HEADER", "X-Client-IP")
+	got := ipUpstream(t)
+	p := startProxy(t)
+
+	// Соединение не от доверенного прокси: X-Forwarded-For клиента не учитывается
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Forwarded-For", "198.51.100.1")
+	if ip := got.Get("X-Client-IP"); ip != "127.0.0.1" {
+		t.Fatalf("X-Client-IP = %q, want the connection address", ip)
+	}
+}
+
+func TestClientIPTrustedForwardedChain(t *testing.T) {
+	t.Setenv("PROXY_CLIENT_IP_HEADER", "X-Client-IP")
+	t.Setenv("PROXY_TRUSTED_PROXIES", "127.0.0.1,10.0.0.0/8")
+	got := ipUpstream(t)
+	p := startProxy(t)
+
+	// Самый правый адрес не из доверенных сетей; левее - то, что клиент мог подделать
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Forwarded-For", "198.51.100.1, 203.0.113.7, 10.0.0.2")
+	if ip := got.Get("X-Client-IP"); ip != "203.0.113.7" {
+		t.Fatalf("X-Client-IP = %q, want 203.0.113.7", ip)
+	}
+}
+
+func TestClientIPAppendedToForwardedFor(t *testing.T) {
+	t.Setenv("PROXY_CLIENT_IP_HEADER", "X-Forwarded-For")
+	got := ipUpstream(t)
+	p := startProxy(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Forwarded-For", "203.0.113.7")
+	if xff := got.Values("X-Forwarded-For"); len(xff) != 1 || xff[0] != "203.0.113.7, 127.0.0.1" {
+		t.Fatalf("X-Forwarded-For = %q, want the chain with the connection address appended", xff)
+	}
+	p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if xff := got.Get("X-Forwarded-For"); xff != "127.0.0.1" {
+		t.Fatalf("X-Forwarded-For without a chain = %q", xff)
+	}
+}
+
+func TestClientIPNotForwardedByDefault(t *testing.T) {
+	got := ipUpstream(t)
+	p := startProxy(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if got.Get("X-Client-IP") != "" || got.Get("X-Forwarded-For") != "" {
+		t.Fatalf("client IP forwarded by default: %v", *got)
+	}
+}
+
+func TestRequireTLS(t *testing.T) {
+	t.Setenv("PROXY_REQUIRE_TLS", "true")
+	t.Setenv("PROXY_TRUSTED_PROXIES", "127.0.0.1")
+	ipUpstream(t)
+	p := startProxy(t)
+
+	for _, c := range []struct {
+		proto string
+		want  int
+	}{
+		{"https", http.StatusOK},
+		{"HTTPS", http.StatusOK},
+		{"https, http", http.StatusOK},
+		{"http", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	} {
+		resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Forwarded-Proto", c.proto)
+		if resp.StatusCode != c.want {
+			t.Errorf("X-Forwarded-Proto %q: status %d, want %d: %s", c.proto, resp.StatusCode, c.want, body)
+		}
+	}
+	// Проверки балансировщика идут по HTTP
+	if resp, _ := p.do(t, http.MethodGet, "/health", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("/health over plaintext: status %d", resp.StatusCode)
+	}
+}
+
+func TestRequireTLSIgnoresUntrustedForwardedProto(t *testing.T) {
+	t.Setenv("PROXY_REQUIRE_TLS", "true")
+	ipUpstream(t)
+	p := startProxy(t)
+
+	// Без PROXY_TRUSTED_PROXIES клиент не может сам объявить HTTPS
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Forwarded-Proto", "https")
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "HTTPS is required") {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestRequireTLSOffByDefault(t *testing.T) {
+	ipUpstream(t)
+	p := startProxy(t)
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Forwarded-Proto", "http"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("plaintext without PROXY_REQUIRE_TLS: status %d", resp.StatusCode)
+	}
+}
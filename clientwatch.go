@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// clientPollInterval - как часто проверяется соединение клиента, пока прокси ждёт провайдера
+const clientPollInterval = 50 * time.Millisecond
+
+// watchClient отменяет контекст запроса к провайдеру, если клиент закрыл соединение, не дождавшись
+// ответа. fasthttp об этом не сообщает, поэтому соединение периодически проверяется (clientClosed).
+// stop прекращает проверку и возвращает true, если клиент ушёл.
+func watchClient(ctx context.Context, conn net.Conn) (watched context.Context, stop func() bool) {
+	watched, cancel := context.WithCancel(ctx)
+	var gone atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(clientPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-watched.Done():
+				return
+			case <-ticker.C:
+				if clientClosed(conn) {
+					gone.Store(true)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return watched, func() bool {
+		once.Do(func() { close(done) })
+		return gone.Load()
+	}
+}
+
+// clientClosedRequest - клиент ушёл до ответа: отправлять уже некому, в статистику и лог
+// запрос попадает со статусом 499, а не как ошибка провайдера
+func clientClosedRequest(c *fiber.Ctx, provider string) error {
+	log.Printf("Client closed %s request before the response (status %d, request_id=%s)", provider, statusClientClosed, requestID(c))
+	c.Status(statusClientClosed)
+	return nil
+}
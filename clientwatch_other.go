@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "net"
+
+// clientClosed: без MSG_PEEK отключение клиента до ответа не определяется
+func clientClosed(net.Conn) bool { return false }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cancelledUpstream ждёт отмены запроса прокси (не дольше 5s) и сообщает о ней в cancelled
+func cancelledUpstream(t *testing.T) chan struct{} {
+	cancelled := make(chan struct{}, 1)
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: {}\n\n"))
+			w.(http.Flusher).Flush()
+		}
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case <-r.Context().Done():
+				cancelled <- struct{}{}
+				return
+			case <-time.After(20 * time.Millisecond):
+				// Поток шлёт комментарии: прокси замечает отключение клиента на записи
+				if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+			case <-timeout:
+				return
+			}
+		}
+	})
+	return cancelled
+}
+
+// abandon отправляет запрос и закрывает соединение через 100ms, не дождавшись ответа целиком
+func (p *testProxy) abandon(t *testing.T, req *http.Request) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err == nil {
+		// Заголовки потока уже пришли - уходим посреди тела
+		<-ctx.Done()
+		resp.Body.Close()
+	}
+}
+
+func TestClientCancelBuffered(t *testing.T) {
+	cancelled := cancelledUpstream(t)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	p.abandon(t, p.newRequest(t, http.MethodPost, "/openai/v1/chat/completions", `{}`))
+	select {
+	case <-cancelled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("upstream request was not cancelled after the client left")
+	}
+	waitFor(t, "the client cancel in stats", func() bool {
+		return p.providerStat(t, "openai", "client_cancels") == float64(1)
+	})
+	if errs := p.providerStat(t, "openai", "errors"); errs != float64(0) {
+		t.Fatalf("client cancel counted as %v errors", errs)
+	}
+	if !strings.Contains(logs.String(), "Client closed openai request before the response (status 499") {
+		t.Fatalf("no client cancel in the log:\n%s", logs)
+	}
+}
+
+func TestClientCancelStream(t *testing.T) {
+	cancelledUpstream(t)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	p.abandon(t, p.newRequest(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`, "Accept", "text/event-stream"))
+	waitFor(t, "the client cancel in stats", func() bool {
+		return p.providerStat(t, "openai", "client_cancels") == float64(1)
+	})
+	if errs := p.providerStat(t, "openai", "errors"); errs != float64(0) {
+		t.Fatalf("client cancel counted as %v errors", errs)
+	}
+	if p.providerStat(t, "openai", "streams_incomplete") != float64(0) {
+		t.Fatal("abandoned stream counted as incomplete")
+	}
+	if !strings.Contains(logs.String(), "Client closed openai stream after") {
+		t.Fatalf("no client cancel in the log:\n%s", logs)
+	}
+}
+
+func TestClientWatchKeepsPipelinedRequests(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		// Дольше интервала проверки соединения клиента
+		time.Sleep(3 * clientPollInterval)
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(p.url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Второй запрос лежит в сокете, пока обрабатывается первый: проверка не должна его съесть
+	var raw strings.Builder
+	for range 2 {
+		raw.WriteString("GET /openai/v1/models HTTP/1.1\r\nHost: proxy\r\nX-Proxy-Auth: " + testAuthToken + "\r\n\r\n")
+	}
+	if _, err := conn.Write([]byte(raw.String())); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	r := bufio.NewReader(conn)
+	for i := range 2 {
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatalf("response %d: %v", i+1, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("response %d: status %d", i+1, resp.StatusCode)
+		}
+	}
+	if c := p.providerStat(t, "openai", "client_cancels"); c != float64(0) {
+		t.Fatalf("client_cancels = %v for a connection that stayed open", c)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"syscall"
+)
+
+// clientClosed проверяет, закрыл ли клиент соединение. Чтение с MSG_PEEK не забирает данные
+// из сокета: следующий запрос keep-alive остаётся fasthttp.
+func clientClosed(conn net.Conn) bool {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	closed := false
+	buf := make([]byte, 1)
+	raw.Read(func(fd uintptr) bool {
+		n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		// 0 байт без ошибки - FIN от клиента
+		closed = (err == nil && n == 0) || err == syscall.ECONNRESET
+		return true
+	})
+	return closed
+}
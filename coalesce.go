@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Объединение одинаковых детерминированных запросов (temperature 0, без потока):
+// пока первый запрос в работе, такие же ждут его ответ вместо своего вызова провайдера.
+// Ключ считается по телу без полей из PROXY_COALESCE_IGNORE_FIELDS (user и т.п.),
+// поэтому запросы, отличающиеся только ими, тоже объединяются.
+var (
+	coalesceEnabled bool
+	// coalesceWindow - сколько после завершения отдавать успешный ответ новым таким же запросам
+	// (PROXY_COALESCE_WINDOW_MS, 0 - только пока первый запрос в работе)
+	coalesceWindow time.Duration
+	coalesceIgnore []string
+	coalescer      = &coalesceGroup{calls: map[string]*coalescedCall{}}
+)
+
+func initCoalescing() {
+	coalesceEnabled = envBool("PROXY_COALESCE", false)
+	coalescer = &coalesceGroup{calls: map[string]*coalescedCall{}}
+	coalesceWindow = time.Duration(envInt("PROXY_COALESCE_WINDOW_MS", 0)) * time.Millisecond
+	coalesceIgnore = envList("PROXY_COALESCE_IGNORE_FIELDS")
+	if coalesceIgnore == nil {
+		coalesceIgnore = []string{"user"}
+	}
+	if coalesceEnabled {
+		log.Printf("Request coalescing enabled (window %s, ignored fields %v)", coalesceWindow, coalesceIgnore)
+	}
+}
+
+// coalesceKey - ключ объединения; "" - запрос недетерминирован и объединять его нельзя.
+// scope (cacheScope) - объединяются только запросы одного токена и ключа провайдера.
+func coalesceKey(provider string, req *http.Request, body []byte, scope string) string {
+	jb := parseJSONBody(body)
+	if jb == nil || jb.getBool("stream") {
+		return ""
+	}
+	var temperature float64
+	raw, ok := jb.fields["temperature"]
+	if !ok || json.Unmarshal(raw, &temperature) != nil || temperature != 0 {
+		return ""
+	}
+	return requestHash(provider, req.Method, req.URL.RequestURI(), append(varyValues(req.Header.Get), scope), body, coalesceIgnore)
+}
+
+// coalescedResponse - прочитанный целиком ответ провайдера, общий для объединённых запросов
+type coalescedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// response - отдельная копия ответа для каждого ожидавшего запроса
+func (r *coalescedResponse) response() *http.Response {
+	return &http.Response{
+		StatusCode:    r.status,
+		Header:        r.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(r.body)),
+		ContentLength: int64(len(r.body)),
+	}
+}
+
+type coalescedCall struct {
+	done chan struct{}
+	resp *coalescedResponse
+	err  error
+}
+
+type coalesceGroup struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// do выполняет запрос или присоединяется к такому же, уже выполняющемуся.
+// shared - ответ получен от чужого вызова.
+func (g *coalesceGroup) do(key string, send func() (*http.Response, error)) (resp *http.Response, shared bool, err error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		if call.err != nil {
+			return nil, true, call.err
+		}
+		return call.resp.response(), true, nil
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.resp, call.err = readCoalesced(send)
+
+	g.mu.Lock()
+	if call.err == nil && coalesceWindow > 0 && call.resp.status < 300 {
+		time.AfterFunc(coalesceWindow, func() { g.forget(key, call) })
+	} else {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	close(call.done)
+
+	if call.err != nil {
+		return nil, false, call.err
+	}
+	return call.resp.response(), false, nil
+}
+
+func (g *coalesceGroup) forget(key string, call *coalescedCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+func readCoalesced(send func() (*http.Response, error)) (*coalescedResponse, error) {
+	resp, err := send()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &coalescedResponse{status: resp.StatusCode, header: resp.Header, body: body}, nil
+}
+
+// coalesceRequestKey - ключ объединения для запроса прокси; "" - объединение выключено или неприменимо
+func coalesceRequestKey(provider string, req *http.Request, body []byte, scope string, streaming, upload bool) string {
+	if !coalesceEnabled || streaming || upload {
+		return ""
+	}
+	return coalesceKey(provider, req, body, scope)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowCountingUpstream отвечает через 100ms и считает вызовы; в ответе - номер вызова
+func slowCountingUpstream(t *testing.T) *atomic.Int32 {
+	calls := &atomic.Int32{}
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprintf(w, `{"call":%d}`, n)
+	})
+	return calls
+}
+
+// concurrently отправляет тела одновременно и возвращает ответы и X-Proxy-Coalesced по порядку
+func (p *testProxy) concurrently(t *testing.T, bodies ...string) (responses, coalesced []string) {
+	responses, coalesced = make([]string, len(bodies)), make([]string, len(bodies))
+	var wg sync.WaitGroup
+	for i, body := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Первый запрос успевает дойти до провайдера раньше остальных
+			time.Sleep(time.Duration(min(i, 1)) * 30 * time.Millisecond)
+			resp, out := p.do(t, http.MethodPost, "/openai/v1/chat/completions", body)
+			responses[i], coalesced[i] = out, resp.Header.Get("X-Proxy-Coalesced")
+		}()
+	}
+	wg.Wait()
+	return responses, coalesced
+}
+
+func TestCoalesceIgnoredFields(t *testing.T) {
+	t.Setenv("PROXY_COALESCE", "true")
+	calls := slowCountingUpstream(t)
+	p := startProxy(t)
+
+	responses, coalesced := p.concurrently(t,
+		`{"model":"gpt-4o","temperature":0,"user":"alice","messages":[]}`,
+		`{"messages":[],"user":"bob","temperature":0,"model":"gpt-4o"}`,
+		`{"model":"gpt-4o","temperature":0,"messages":[]}`)
+	if calls.Load() != 1 {
+		t.Fatalf("upstream calls = %d, want 1", calls.Load())
+	}
+	for i, body := range responses {
+		if body != `{"call":1}` {
+			t.Errorf("request %d got %s", i, body)
+		}
+	}
+	if coalesced[0] != "" || coalesced[1] != "true" || coalesced[2] != "true" {
+		t.Fatalf("X-Proxy-Coalesced = %q", coalesced)
+	}
+
+	// Без окна новый запрос после завершения идёт к провайдеру
+	if _, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","temperature":0,"messages":[]}`); body != `{"call":2}` {
+		t.Fatalf("request after completion got %s", body)
+	}
+}
+
+func TestCoalesceConfiguredIgnoreFields(t *testing.T) {
+	t.Setenv("PROXY_COALESCE", "true")
+	t.Setenv("PROXY_COALESCE_IGNORE_FIELDS", "metadata")
+	calls := slowCountingUpstream(t)
+	p := startProxy(t)
+
+	p.concurrently(t,
+		`{"model":"gpt-4o","temperature":0,"metadata":{"run":1}}`,
+		`{"model":"gpt-4o","temperature":0,"metadata":{"run":2}}`)
+	if calls.Load() != 1 {
+		t.Fatalf("requests differing in metadata: upstream calls = %d, want 1", calls.Load())
+	}
+	// user больше не в списке игнорируемых
+	p.concurrently(t,
+		`{"model":"gpt-4o","temperature":0,"user":"alice"}`,
+		`{"model":"gpt-4o","temperature":0,"user":"bob"}`)
+	if calls.Load() != 3 {
+		t.Fatalf("requests differing in user: upstream calls = %d, want 3", calls.Load())
+	}
+}
+
+func TestCoalesceOnlyDeterministic(t *testing.T) {
+	t.Setenv("PROXY_COALESCE", "true")
+	calls := slowCountingUpstream(t)
+	p := startProxy(t)
+
+	for _, body := range []string{
+		`{"model":"gpt-4o","temperature":0.7}`,
+		`{"model":"gpt-4o"}`,
+		`{"model":"gpt-4o","temperature":0,"stream":true}`,
+	} {
+		before := calls.Load()
+		p.concurrently(t, body, body)
+		if calls.Load()-before != 2 {
+			t.Errorf("%s: upstream calls = %d, want 2", body, calls.Load()-before)
+		}
+	}
+	// Отличие в значимом поле - разные запросы
+	before := calls.Load()
+	p.concurrently(t, `{"model":"gpt-4o","temperature":0,"messages":["a"]}`, `{"model":"gpt-4o","temperature":0,"messages":["b"]}`)
+	if calls.Load()-before != 2 {
+		t.Fatalf("different messages: upstream calls = %d, want 2", calls.Load()-before)
+	}
+}
+
+func TestCoalesceWindow(t *testing.T) {
+	t.Setenv("PROXY_COALESCE", "true")
+	t.Setenv("PROXY_COALESCE_WINDOW_MS", "300")
+	calls := slowCountingUpstream(t)
+	p := startProxy(t)
+
+	body := `{"model":"gpt-4o","temperature":0}`
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", body)
+	// В окне после завершения ответ переиспользуется, после окна - нет
+	if resp, out := p.do(t, http.MethodPost, "/openai/v1/chat/completions", body); out != `{"call":1}` || resp.Header.Get("X-Proxy-Coalesced") != "true" {
+		t.Fatalf("request within the window got %s", out)
+	}
+	time.Sleep(350 * time.Millisecond)
+	if _, out := p.do(t, http.MethodPost, "/openai/v1/chat/completions", body); out != `{"call":2}` || calls.Load() != 2 {
+		t.Fatalf("request after the window got %s, upstream calls %d", out, calls.Load())
+	}
+}
+
+func TestCoalesceOffByDefault(t *testing.T) {
+	calls := slowCountingUpstream(t)
+	p := startProxy(t)
+
+	body := `{"model":"gpt-4o","temperature":0}`
+	p.concurrently(t, body, body)
+	if calls.Load() != 2 {
+		t.Fatalf("upstream calls = %d without PROXY_COALESCE, want 2", calls.Load())
+	}
+}
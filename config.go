@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envInt читает целое число из переменной окружения, при отсутствии или ошибке возвращает def
+func envInt(key string, def int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// envBool читает булево значение из переменной окружения, при отсутствии или ошибке возвращает def
+func envBool(key string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// envMap читает список пар "k=v,k2=v2" из переменной окружения
+func envMap(key string) map[string]string {
+	result := map[string]string{}
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" {
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// envList читает список "a,b,c" из переменной окружения, пустые элементы пропускаются
+func envList(key string) []string {
+	var result []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// envPrefix - префикс переменных окружения провайдера, например OPENAI_
+func envPrefix(provider string) string {
+	return strings.ToUpper(provider) + "_"
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Повтор с моделью с большим контекстом, если провайдер отклонил non-streaming запрос
+// из-за превышения контекстного окна:
+//
+//	<PROVIDER>_CONTEXT_FALLBACKS     - модель=запасная модель,... (модель - как в теле после алиасов;
+//	                                   у запасной может быть своя запасная)
+//	<PROVIDER>_CONTEXT_ERROR_PATTERN - regexp по телу 4xx-ответа вместо шаблона по умолчанию
+//
+// Клиент получает ответ запасной модели и заголовок X-Proxy-Context-Fallback с её именем.
+
+// defaultContextErrorPattern - коды и сообщения OpenAI, DeepSeek, Nebius и Anthropic о превышении контекста
+var defaultContextErrorPattern = regexp.MustCompile(`(?i)context_length_exceeded|maximum context length|context window|prompt is too long|input is too long|too many tokens`)
+
+// maxContextFallbacks - предел переходов по цепочке запасных моделей
+const maxContextFallbacks = 3
+
+// parseContextErrorPattern - шаблон ошибки превышения контекста провайдера
+func parseContextErrorPattern(prefix string) (*regexp.Regexp, error) {
+	v := strings.TrimSpace(os.Getenv(prefix + "CONTEXT_ERROR_PATTERN"))
+	if v == "" {
+		return defaultContextErrorPattern, nil
+	}
+	re, err := regexp.Compile(v)
+	if err != nil {
+		return nil, fmt.Errorf("%sCONTEXT_ERROR_PATTERN: %w", prefix, err)
+	}
+	return re, nil
+}
+
+// isContextLengthError - 4xx-ответ о превышении контекстного окна
+func (p *provider) isContextLengthError(resp *http.Response, body []byte) bool {
+	if resp.StatusCode < 400 || resp.StatusCode >= 500 {
+		return false
+	}
+	decoded, err := decodeBody(body, resp.Header.Get("Content-Encoding"))
+	return err == nil && p.contextErrorPattern.Match(decoded)
+}
+
+// contextFallback повторяет запрос с запасными моделями, пока ответ - ошибка превышения контекста.
+// Каждый переход проверяется по списку моделей токена и идёт со своим ключом (<PROVIDER>_MODEL_KEYS, BYOK);
+// запрещённая токену модель пропускается, дальше проверяется её запасная.
+// Возвращает последний ответ (тело прочитано), модель и ключ, которые его дали ("" и nil - повтора не было).
+func contextFallback(p *provider, tok *apiToken, req *http.Request, body []byte, model string, resp *http.Response, respBody []byte) (*http.Response, []byte, string, *apiKeyState) {
+	used := ""
+	var usedKey *apiKeyState
+	for range maxContextFallbacks {
+		target, ok := p.contextFallbacks[model]
+		if !ok || !p.isContextLengthError(resp, respBody) {
+			break
+		}
+		next, err := resolveModel(p, target)
+		if err != nil {
+			log.Printf("WARN: context fallback %s skipped: %v", target, err)
+			break
+		}
+		if !tok.allowsModel(next) {
+			log.Printf("WARN: context fallback %s skipped: model not allowed for token %s", next, tok.Name)
+			model = next
+			continue
+		}
+		key := tok.keyPoolFor(p.Name, p.keysFor(next)).pick()
+		if key == nil {
+			log.Printf("WARN: context fallback %s skipped: no API key", next)
+			break
+		}
+		jb := parseJSONBody(body)
+		if jb == nil {
+			break
+		}
+		jb.set("model", next)
+		nextBody, err := jb.bytes()
+		if err != nil {
+			break
+		}
+
+		log.Printf("Context length exceeded for %s model %s, retrying with %s (key=%s)", p.Name, model, next, key.id())
+		recordContextFallback(p.Name)
+		nextReq := cloneWithBody(req, nextBody)
+		setProviderAuth(nextReq, p.Name, key.value)
+		nextResp, err := doUpstream(p.client, withAttemptReason(nextReq, "context length fallback to model "+next), p.Name)
+		if err != nil {
+			log.Printf("ERROR: context fallback to %s failed: %v", next, err)
+			break
+		}
+		key.observe(nextResp.Header)
+		nextRespBody, err := io.ReadAll(nextResp.Body)
+		nextResp.Body.Close()
+		if err != nil {
+			log.Printf("ERROR: context fallback to %s: failed to read response: %v", next, err)
+			break
+		}
+		resp, respBody, body, model, used, usedKey = nextResp, nextRespBody, nextBody, next, next, key
+	}
+	return resp, respBody, used, usedKey
+}
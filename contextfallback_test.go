@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const contextLengthError = `{"error":{"message":"This model's maximum context length is 128000 tokens.","type":"invalid_request_error","code":"context_length_exceeded"}}`
+
+// contextCall - запрос, дошедший до провайдера: модель, ключ и тело
+type contextCall struct {
+	model, key, body string
+}
+
+// contextUpstream отвечает ошибкой превышения контекста моделям из small,
+// моделям из errors - их телом ошибки, остальным - 200 с именем модели
+func contextUpstream(t *testing.T, small []string, errors map[string]string) func() []contextCall {
+	var mu sync.Mutex
+	var calls []contextCall
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var req struct{ Model string }
+		json.Unmarshal(data, &req)
+		mu.Lock()
+		calls = append(calls, contextCall{req.Model, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), string(data)})
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		for _, m := range small {
+			if m == req.Model {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(contextLengthError))
+				return
+			}
+		}
+		if body, ok := errors[req.Model]; ok {
+			status, body, _ := strings.Cut(body, " ")
+			code := 0
+			fmt.Sscan(status, &code)
+			w.WriteHeader(code)
+			w.Write([]byte(body))
+			return
+		}
+		fmt.Fprintf(w, `{"model":%q,"choices":[{"message":{"content":"ok"}}]}`, req.Model)
+	})
+	return func() []contextCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]contextCall(nil), calls...)
+	}
+}
+
+// calledModels - модели запросов к провайдеру по порядку
+func calledModels(calls []contextCall) string {
+	models := make([]string, len(calls))
+	for i, c := range calls {
+		models[i] = c.model
+	}
+	return strings.Join(models, " ")
+}
+
+func TestContextFallback(t *testing.T) {
+	t.Setenv("OPENAI_CONTEXT_FALLBACKS", "gpt-4o-mini=gpt-4.1")
+	calls := contextUpstream(t, []string{"gpt-4o-mini"}, nil)
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions",
+		`{"model":"gpt-4o-mini","messages":[{"role":"user","content":"long document"}],"temperature":0}`)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Proxy-Context-Fallback") != "gpt-4.1" || !strings.Contains(body, `"model":"gpt-4.1"`) {
+		t.Fatalf("status %d, X-Proxy-Context-Fallback %q: %s", resp.StatusCode, resp.Header.Get("X-Proxy-Context-Fallback"), body)
+	}
+	got := calls()
+	if calledModels(got) != "gpt-4o-mini gpt-4.1" {
+		t.Fatalf("models called = %s", calledModels(got))
+	}
+	// Повтор - то же тело с другой моделью
+	golden(t, "context_fallback_body", []byte(got[1].body+"\n"))
+	if p.providerStat(t, "openai", "context_fallbacks") != float64(1) {
+		t.Fatalf("context_fallbacks = %v", p.providerStat(t, "openai", "context_fallbacks"))
+	}
+}
+
+func TestContextFallbackChain(t *testing.T) {
+	t.Setenv("OPENAI_CONTEXT_FALLBACKS", "small=medium,medium=large,large=small")
+	calls := contextUpstream(t, []string{"small", "medium"}, nil)
+	p := startProxy(t)
+
+	resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"small"}`)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Proxy-Context-Fallback") != "large" || calledModels(calls()) != "small medium large" {
+		t.Fatalf("status %d, fallback %q, models %s", resp.StatusCode, resp.Header.Get("X-Proxy-Context-Fallback"), calledModels(calls()))
+	}
+}
+
+func TestContextFallbackChainLimit(t *testing.T) {
+	// Цикл в цепочке обрывается после maxContextFallbacks повторов
+	t.Setenv("OPENAI_CONTEXT_FALLBACKS", "a=b,b=a")
+	calls := contextUpstream(t, []string{"a", "b"}, nil)
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"a"}`)
+	if resp.StatusCode != http.StatusBadRequest || body != contextLengthError || len(calls()) != 1+maxContextFallbacks {
+		t.Fatalf("status %d, models %s: %s", resp.StatusCode, calledModels(calls()), body)
+	}
+}
+
+func TestContextFallbackOnlyOnContextErrors(t *testing.T) {
+	t.Setenv("OPENAI_CONTEXT_FALLBACKS", "gpt-4o-mini=gpt-4.1,bad=gpt-4.1,broken=gpt-4.1,ok=gpt-4.1")
+	calls := contextUpstream(t, []string{"gpt-4o-mini"}, map[string]string{
+		"bad":    `400 {"error":{"message":"Invalid value for temperature","type":"invalid_request_error"}}`,
+		"broken": `500 {"error":{"message":"maximum context length exceeded by an internal bug"}}`,
+	})
+	p := startProxy(t)
+
+	for _, c := range []struct {
+		model  string
+		status int
+	}{{"bad", 400}, {"broken", 500}, {"ok", 200}, {"o3", 200}} {
+		resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"`+c.model+`"}`)
+		if resp.StatusCode != c.status || resp.Header.Get("X-Proxy-Context-Fallback") != "" {
+			t.Errorf("%s: status %d, fallback %q", c.model, resp.StatusCode, resp.Header.Get("X-Proxy-Context-Fallback"))
+		}
+	}
+	if want := "bad broken ok o3"; calledModels(calls()) != want {
+		t.Fatalf("models called = %s, want %s", calledModels(calls()), want)
+	}
+	if p.providerStat(t, "openai", "context_fallbacks") != float64(0) {
+		t.Fatalf("context_fallbacks = %v", p.providerStat(t, "openai", "context_fallbacks"))
+	}
+}
+
+func TestContextFallbackCustomPattern(t *testing.T) {
+	t.Setenv("OPENAI_CONTEXT_FALLBACKS", "gpt-4o-mini=gpt-4.1,tiny=gpt-4.1")
+	t.Setenv("OPENAI_CONTEXT_ERROR_PATTERN", `"code":"input_too_big"`)
+	calls := contextUpstream(t, []string{"gpt-4o-mini"}, map[string]string{
+		"tiny": `413 {"error":{"message":"request rejected","code":"input_too_big"}}`,
+	})
+	p := startProxy(t)
+
+	// Шаблон заменяет умолчание: стандартная ошибка OpenAI больше не распознаётся
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o-mini"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("default error with a custom pattern: status %d", resp.StatusCode)
+	}
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"tiny"}`); resp.Header.Get("X-Proxy-Context-Fallback") != "gpt-4.1" {
+		t.Fatalf("custom error: status %d, no fallback", resp.StatusCode)
+	}
+	if want := "gpt-4o-mini tiny gpt-4.1"; calledModels(calls()) != want {
+		t.Fatalf("models called = %s, want %s", calledModels(calls()), want)
+	}
+}
+
+func TestContextFallbackRespectsTokenModels(t *testing.T) {
+	useTokens(t, `[
+		{"name":"team-a","token":"tok-a","models":["gpt-4o-mini","gpt-4.1-long"]},
+		{"name":"team-b","token":"tok-b","models":["gpt-4o-mini"]}
+	]`)
+	t.Setenv("OPENAI_CONTEXT_FALLBACKS", "gpt-4o-mini=gpt-4.1,gpt-4.1=gpt-4.1-long")
+	calls := contextUpstream(t, []string{"gpt-4o-mini"}, nil)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	// gpt-4.1 токену запрещена - сразу её запасная
+	resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o-mini"}`, "X-Proxy-Auth", "tok-a")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Proxy-Context-Fallback") != "gpt-4.1-long" {
+		t.Fatalf("team-a: status %d, fallback %q", resp.StatusCode, resp.Header.Get("X-Proxy-Context-Fallback"))
+	}
+	// Без разрешённых запасных моделей клиент получает исходную ошибку
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o-mini"}`, "X-Proxy-Auth", "tok-b")
+	if resp.StatusCode != http.StatusBadRequest || body != contextLengthError {
+		t.Fatalf("team-b: status %d: %s", resp.StatusCode, body)
+	}
+	if want := "gpt-4o-mini gpt-4.1-long gpt-4o-mini"; calledModels(calls()) != want {
+		t.Fatalf("models called = %s, want %s", calledModels(calls()), want)
+	}
+	if !strings.Contains(logs.String(), "WARN: context fallback gpt-4.1 skipped: model not allowed for token team-b") {
+		t.Fatalf("no warning for the skipped model:\n%s", logs)
+	}
+}
+
+func TestContextFallbackUsesModelKey(t *testing.T) {
+	t.Setenv("PROXY_SERVED_BY_HEADER", "true")
+	t.Setenv("OPENAI_API_KEY", "sk-default")
+	t.Setenv("OPENAI_KEY_BIG", "sk-big")
+	t.Setenv("OPENAI_MODEL_KEYS", "gpt-4.1=OPENAI_KEY_BIG")
+	t.Setenv("OPENAI_CONTEXT_FALLBACKS", "gpt-4o-mini=gpt-4.1")
+	calls := contextUpstream(t, []string{"gpt-4o-mini"}, nil)
+	p := startProxy(t)
+
+	resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o-mini"}`)
+	got := calls()
+	if len(got) != 2 || got[0].key != "sk-default" || got[1].key != "sk-big" {
+		t.Fatalf("calls = %+v", got)
+	}
+	if want := servedBy("openai", 0, "sk-big"); resp.Header.Get("X-Proxy-Served-By") != want {
+		t.Fatalf("X-Proxy-Served-By = %q, want %q", resp.Header.Get("X-Proxy-Served-By"), want)
+	}
+}
+
+func TestContextFallbackUsesOwnKey(t *testing.T) {
+	useBYOKTokens(t, "sk-own")
+	t.Setenv("OPENAI_KEY_BIG", "sk-big")
+	t.Setenv("OPENAI_MODEL_KEYS", "gpt-4.1=OPENAI_KEY_BIG")
+	t.Setenv("OPENAI_CONTEXT_FALLBACKS", "gpt-4o-mini=gpt-4.1")
+	calls := contextUpstream(t, []string{"gpt-4o-mini"}, nil)
+	p := startProxy(t)
+
+	// Собственный ключ токена приоритетнее ключа модели и на повторе
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o-mini"}`, "X-Proxy-Auth", "tok-byok")
+	if got := calls(); len(got) != 2 || got[0].key != "sk-own" || got[1].key != "sk-own" {
+		t.Fatalf("calls = %+v", got)
+	}
+}
+
+func TestParseContextErrorPatternError(t *testing.T) {
+	t.Setenv("OPENAI_CONTEXT_ERROR_PATTERN", "(unclosed")
+	if _, err := parseContextErrorPattern("OPENAI_"); err == nil || !strings.Contains(err.Error(), "OPENAI_CONTEXT_ERROR_PATTERN") {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Датасет запросов для офлайн-прогона на других моделях: доля запросов (PROXY_DATASET_SAMPLE_RATE)
+// с телом запроса и, по желанию, ответа пишется в каталог, файл на request ID.
+// В отличие от аудита пишется исходный запрос клиента; запись идёт в фоне, при переполнении
+// очереди записи отбрасываются.
+//
+// datasetSink собирает newApp; запись хранит ссылку на свой приёмник, и воркер читает только
+// его поля, поэтому пересборка приложения (тесты) не трогает уже идущую запись.
+type datasetSink struct {
+	dir string
+	// rate - доля сохраняемых запросов, 0..1
+	rate float64
+	// responses - сохранять и ответ (PROXY_DATASET_RESPONSES)
+	responses bool
+	redact    *regexp.Regexp
+	// maxBytes - запрос длиннее не сохраняется, ответ длиннее обрезается
+	maxBytes int
+	queue    chan *datasetRecord
+}
+
+// dataset - приёмник датасета; nil - выборка выключена
+var dataset atomic.Pointer[datasetSink]
+
+// currentDataset возвращает действующий приёмник датасета, nil - выключен
+func currentDataset() *datasetSink {
+	return dataset.Load()
+}
+
+// datasetRecord - сохранённый запрос; Request/Response - JSON как есть, если тело -
+// валидный JSON после редактирования, иначе строка
+type datasetRecord struct {
+	RequestID string          `json:"request_id"`
+	Time      time.Time       `json:"time"`
+	Provider  string          `json:"provider"`
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	Query     string          `json:"query,omitempty"`
+	Model     string          `json:"model,omitempty"`
+	Request   json.RawMessage `json:"request"`
+	Status    int             `json:"status,omitempty"`
+	Stream    bool            `json:"stream,omitempty"`
+	Response  json.RawMessage `json:"response,omitempty"`
+	Truncated bool            `json:"truncated,omitempty"`
+
+	request  []byte
+	response string
+	encoding string
+	sink     *datasetSink
+}
+
+func initDataset() error {
+	dataset.Store(nil)
+	s := &datasetSink{dir: strings.TrimSpace(os.Getenv("PROXY_DATASET_DIR")), rate: 0.01}
+	if s.dir == "" {
+		return nil
+	}
+	if v := strings.TrimSpace(os.Getenv("PROXY_DATASET_SAMPLE_RATE")); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return fmt.Errorf("PROXY_DATASET_SAMPLE_RATE: must be between 0 and 1, got %q", v)
+		}
+		s.rate = rate
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("PROXY_DATASET_DIR: %w", err)
+	}
+	if expr := os.Getenv("PROXY_DATASET_REDACT"); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("PROXY_DATASET_REDACT: %w", err)
+		}
+		s.redact = re
+	}
+	s.responses = envBool("PROXY_DATASET_RESPONSES", false)
+	s.maxBytes = envInt("PROXY_DATASET_MAX_BYTES", 1024*1024)
+	s.queue = make(chan *datasetRecord, 100)
+	go s.worker()
+	dataset.Store(s)
+	log.Printf("Dataset capture enabled (dir=%s rate=%g responses=%t)", s.dir, s.rate, s.responses)
+	return nil
+}
+
+// sample решает, попадает ли запрос в датасет; nil - не попадает (в том числе при выключенном датасете).
+// body копируется: буфер запроса fasthttp переиспользуется.
+func (s *datasetSink) sample(reqID, provider, method, path, query, model string, body []byte) *datasetRecord {
+	if s == nil || len(body) > s.maxBytes || rand.Float64() >= s.rate {
+		return nil
+	}
+	return &datasetRecord{
+		RequestID: strings.Clone(reqID), Provider: provider, Method: method, Path: path, Query: query, Model: model,
+		request: append([]byte(nil), body...), sink: s,
+	}
+}
+
+// submit ставит запись с ответом в очередь, не блокируясь
+func (r *datasetRecord) submit(status int, stream bool, response string, truncated bool, encoding string) {
+	if r == nil {
+		return
+	}
+	r.Time = time.Now().UTC()
+	r.Status, r.Stream = status, stream
+	if r.sink.responses {
+		r.response, r.Truncated, r.encoding = response, truncated, encoding
+	}
+	select {
+	case r.sink.queue <- r:
+	default:
+		log.Printf("WARN: dataset queue full, dropped request %s", r.RequestID)
+	}
+}
+
+// body - тело после редактирования: JSON как есть, иначе строкой
+func (s *datasetSink) body(body string) json.RawMessage {
+	if s.redact != nil {
+		body = s.redact.ReplaceAllString(body, "[REDACTED]")
+	}
+	if json.Valid([]byte(body)) {
+		return json.RawMessage(body)
+	}
+	quoted, _ := json.Marshal(body)
+	return quoted
+}
+
+func (s *datasetSink) worker() {
+	for rec := range s.queue {
+		rec.Request = s.body(string(rec.request))
+		if rec.response != "" {
+			if decoded, err := decodeBody([]byte(rec.response), rec.encoding); err == nil {
+				rec.response = string(decoded)
+			}
+			rec.Response = s.body(rec.response)
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			log.Printf("WARN: failed to encode dataset record %s: %v", rec.RequestID, err)
+			continue
+		}
+		if err := writeRecordFile(s.dir, rec.RequestID, data); err != nil {
+			log.Printf("WARN: failed to write dataset record %s: %v", rec.RequestID, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readDataset ждёт запись датасета name и разбирает её
+func readDataset(t *testing.T, dir, name string) datasetRecord {
+	t.Helper()
+	var data []byte
+	waitFor(t, "dataset record "+name, func() bool {
+		var err error
+		data, err = os.ReadFile(filepath.Join(dir, name))
+		// Файл пишется не атомарно: ждём, пока он будет дописан
+		return err == nil && json.Valid(data)
+	})
+	var rec datasetRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+// datasetUpstream отвечает JSON с ключом в тексте
+func datasetUpstream(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"content":"use sk-reply"}}]}`))
+	})
+}
+
+func TestDatasetCapture(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROXY_DATASET_DIR", dir)
+	t.Setenv("PROXY_DATASET_SAMPLE_RATE", "1")
+	t.Setenv("PROXY_DATASET_RESPONSES", "true")
+	t.Setenv("PROXY_DATASET_REDACT", `sk-[a-z]+`)
+	datasetUpstream(t)
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions?x=1",
+		`{"model":"gpt-4o","messages":[{"role":"user","content":"my key sk-secret"}]}`, "X-Request-ID", "ds-1")
+	rec := readDataset(t, dir, "ds-1.json")
+	if string(rec.Request) != `{"model":"gpt-4o","messages":[{"role":"user","content":"my key [REDACTED]"}]}` {
+		t.Fatalf("request = %s", rec.Request)
+	}
+	if string(rec.Response) != `{"choices":[{"message":{"content":"use [REDACTED]"}}]}` {
+		t.Fatalf("response = %s", rec.Response)
+	}
+	if rec.Provider != "openai" || rec.Model != "gpt-4o" || rec.Query != "x=1" || rec.Status != http.StatusOK || rec.Stream {
+		t.Fatalf("dataset record = %+v", rec)
+	}
+}
+
+func TestDatasetRequestsOnlyByDefault(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROXY_DATASET_DIR", dir)
+	t.Setenv("PROXY_DATASET_SAMPLE_RATE", "1")
+	datasetUpstream(t)
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `not json`, "X-Request-ID", "ds-1")
+	rec := readDataset(t, dir, "ds-1.json")
+	// Не-JSON тело сохраняется строкой
+	if string(rec.Request) != `"not json"` || rec.Response != nil {
+		t.Fatalf("request = %s, response = %s", rec.Request, rec.Response)
+	}
+}
+
+func TestDatasetSampling(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROXY_DATASET_DIR", dir)
+	t.Setenv("PROXY_DATASET_SAMPLE_RATE", "0")
+	datasetUpstream(t)
+	p := startProxy(t)
+
+	for range 20 {
+		p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	}
+	// При доле 0 запрос отсеивается ещё до очереди записи
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("%d records written with sample rate 0", len(entries))
+	}
+}
+
+func TestDatasetSizeCap(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROXY_DATASET_DIR", dir)
+	t.Setenv("PROXY_DATASET_SAMPLE_RATE", "1")
+	t.Setenv("PROXY_DATASET_MAX_BYTES", "40")
+	datasetUpstream(t)
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","input":"`+strings.Repeat("x", 40)+`"}`, "X-Request-ID", "big")
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`, "X-Request-ID", "small")
+	readDataset(t, dir, "small.json")
+	if _, err := os.Stat(filepath.Join(dir, "big.json")); err == nil {
+		t.Fatal("request over PROXY_DATASET_MAX_BYTES was saved")
+	}
+}
+
+func TestDatasetRepeatedRequestID(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROXY_DATASET_DIR", dir)
+	t.Setenv("PROXY_DATASET_SAMPLE_RATE", "1")
+	datasetUpstream(t)
+	p := startProxy(t)
+
+	// ID клиента не перезаписывает ранее сохранённые записи и не выходит за каталог
+	for range 2 {
+		p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`, "X-Request-ID", "../same")
+	}
+	waitFor(t, "both dataset records", func() bool {
+		matches, _ := filepath.Glob(filepath.Join(dir, "___same*.json"))
+		return len(matches) == 2
+	})
+}
+
+func TestInitDatasetErrors(t *testing.T) {
+	t.Setenv("PROXY_DATASET_DIR", t.TempDir())
+	for _, rate := range []string{"2", "-0.1", "abc"} {
+		t.Setenv("PROXY_DATASET_SAMPLE_RATE", rate)
+		if err := initDataset(); err == nil {
+			t.Errorf("sample rate %q accepted", rate)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// requestTimeout - дедлайн non-streaming запроса к провайдеру (PROXY_REQUEST_TIMEOUT_MS), 0 - без дедлайна
+var requestTimeout time.Duration
+
+const (
+	// deadlineHeader - момент дедлайна (RFC 3339, UTC): провайдеру в запросе, клиенту в ответе по таймауту
+	deadlineHeader = "X-Proxy-Deadline"
+	// timeoutHeader отличает таймаут прокси от 504 провайдера
+	timeoutHeader = "X-Proxy-Timeout"
+)
+
+// withRequestDeadline ограничивает запрос дедлайном и сообщает его провайдеру
+func withRequestDeadline(req *http.Request) (*http.Request, time.Time, context.CancelFunc) {
+	deadline := time.Now().Add(requestTimeout)
+	ctx, cancel := context.WithDeadline(req.Context(), deadline)
+	req = req.WithContext(ctx)
+	req.Header.Set(deadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+	return req, deadline, cancel
+}
+
+// isProxyTimeout - запрос прерван по дедлайну прокси, а не ошибкой провайдера
+func isProxyTimeout(err error, deadline time.Time) bool {
+	return !deadline.IsZero() && errors.Is(err, context.DeadlineExceeded)
+}
+
+// proxyTimeout отвечает 504 с пометкой, что таймаут наложен прокси
+func proxyTimeout(c *fiber.Ctx, deadline time.Time) error {
+	c.Set(timeoutHeader, "proxy")
+	c.Set(deadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+	return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{
+		"error": fiber.Map{
+			"type":    "proxy_timeout",
+			"message": "Request exceeded proxy timeout of " + requestTimeout.String(),
+		},
+	})
+}
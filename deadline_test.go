@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProxyTimeout(t *testing.T) {
+	t.Setenv("PROXY_REQUEST_TIMEOUT_MS", "100")
+	var upstreamDeadline atomic.Value
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		upstreamDeadline.Store(r.Header.Get(deadlineHeader))
+		if r.URL.Path == "/v1/slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/slow", "")
+	if resp.StatusCode != http.StatusGatewayTimeout || resp.Header.Get(timeoutHeader) != "proxy" {
+		t.Fatalf("status %d, %s = %q", resp.StatusCode, timeoutHeader, resp.Header.Get(timeoutHeader))
+	}
+	var out struct{ Error struct{ Type string } }
+	json.Unmarshal([]byte(body), &out)
+	if out.Error.Type != "proxy_timeout" || resp.Header.Get("X-Proxy-Upstream-Status") != "" {
+		t.Fatalf("proxy timeout response: %s, upstream status %q", body, resp.Header.Get("X-Proxy-Upstream-Status"))
+	}
+	// Провайдер получил тот же дедлайн, что и клиент
+	gotDeadline, _ := upstreamDeadline.Load().(string)
+	deadline, err := time.Parse(time.RFC3339Nano, gotDeadline)
+	if err != nil || gotDeadline != resp.Header.Get(deadlineHeader) {
+		t.Fatalf("upstream %s = %q, client %q", deadlineHeader, gotDeadline, resp.Header.Get(deadlineHeader))
+	}
+	if d := time.Until(deadline); d > 0 || d < -time.Second {
+		t.Fatalf("deadline %s is not ~100ms after the request", deadline)
+	}
+
+	// Быстрый запрос укладывается в дедлайн
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("fast request: status %d", resp.StatusCode)
+	}
+}
+
+func TestRetryBackoffStopsAtDeadline(t *testing.T) {
+	t.Setenv("PROXY_REQUEST_TIMEOUT_MS", "100")
+	t.Setenv("PROXY_MAX_RETRIES", "1")
+	t.Setenv("PROXY_RETRY_BACKOFF_MS", "5000")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	p := startProxy(t)
+
+	// Пауза перед повтором длиннее дедлайна: ответ по дедлайну, а не после паузы
+	start := time.Now()
+	resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if resp.StatusCode != http.StatusGatewayTimeout || resp.Header.Get(timeoutHeader) != "proxy" {
+		t.Fatalf("status %d, %s = %q", resp.StatusCode, timeoutHeader, resp.Header.Get(timeoutHeader))
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("response after %s, the retry backoff ignored the deadline", d)
+	}
+}
+
+func TestUpstreamGatewayTimeoutIsNotProxyTimeout(t *testing.T) {
+	t.Setenv("PROXY_REQUEST_TIMEOUT_MS", "5000")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		w.Write([]byte(`upstream request timeout`))
+	})
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if resp.StatusCode != http.StatusGatewayTimeout || body != "upstream request timeout" {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get(timeoutHeader) != "" || resp.Header.Get("X-Proxy-Upstream-Status") != "504" {
+		t.Fatalf("%s = %q, X-Proxy-Upstream-Status = %q", timeoutHeader, resp.Header.Get(timeoutHeader), resp.Header.Get("X-Proxy-Upstream-Status"))
+	}
+}
+
+func TestProxyTimeoutKeepsPartialContentBody(t *testing.T) {
+	t.Setenv("PROXY_REQUEST_TIMEOUT_MS", "5000")
+	part := strings.Repeat("a", 64*1024)
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-131071/1000000")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(part))
+		w.(http.Flusher).Flush()
+		// Вторая половина приходит уже после возврата из хендлера прокси
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(part))
+	})
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/files/file-1/content", "", "Range", "bytes=0-131071")
+	if resp.StatusCode != http.StatusPartialContent || len(body) != 2*len(part) {
+		t.Fatalf("status %d, body %d bytes, want %d", resp.StatusCode, len(body), 2*len(part))
+	}
+}
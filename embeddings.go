@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// embeddingBatchSize - больший массив input в embeddings делится на запросы такого размера
+// (PROXY_EMBEDDING_BATCH_SIZE), 0 - не делить
+var embeddingBatchSize int
+
+// embeddingInputs возвращает тело и элементы input, если запрос к embeddings нужно разделить.
+// Массив чисел - это один токенизированный вход, он не делится.
+func embeddingInputs(path string, body []byte) (*jsonBody, []json.RawMessage) {
+	if embeddingBatchSize <= 0 || !strings.HasSuffix(strings.TrimRight(path, "/"), "embeddings") {
+		return nil, nil
+	}
+	jb := parseJSONBody(body)
+	if jb == nil {
+		return nil, nil
+	}
+	var inputs []json.RawMessage
+	if err := json.Unmarshal(jb.fields["input"], &inputs); err != nil || len(inputs) <= embeddingBatchSize {
+		return nil, nil
+	}
+	if first := bytes.TrimSpace(inputs[0]); len(first) > 0 && (first[0] == '-' || (first[0] >= '0' && first[0] <= '9')) {
+		return nil, nil
+	}
+	return jb, inputs
+}
+
+// embeddingsResponse - поля ответа embeddings, нужные для склейки; остальные берутся из первого ответа
+type embeddingsResponse struct {
+	Data  []map[string]json.RawMessage `json:"data"`
+	Usage struct {
+		PromptTokens int64 `json:"prompt_tokens"`
+		TotalTokens  int64 `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// splitEmbeddings отправляет input частями последовательно (в пределах уже занятого слота
+// конкурентности) и склеивает ответы: index сдвигается на смещение части, usage суммируется.
+// Неуспешный ответ любой части возвращается клиенту как есть, остальные части не отправляются.
+func splitEmbeddings(p *provider, req *http.Request, jb *jsonBody, inputs []json.RawMessage) (*http.Response, error) {
+	// Ответы частей нужны несжатыми для склейки
+	req.Header.Del("Accept-Encoding")
+
+	var merged *http.Response
+	var first map[string]json.RawMessage
+	var all embeddingsResponse
+
+	for offset := 0; offset < len(inputs); offset += embeddingBatchSize {
+		batch := inputs[offset:min(offset+embeddingBatchSize, len(inputs))]
+		jb.set("input", batch)
+		body, err := jb.bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := doUpstream(p.client, withAttemptReason(cloneWithBody(req, body), "embeddings batch at offset "+strconv.Itoa(offset)), p.Name)
+		if err != nil {
+			return nil, err
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			log.Printf("WARN: embeddings batch at offset %d failed with status %d", offset, resp.StatusCode)
+			return bufferedResponse(resp, respBody), nil
+		}
+
+		var part embeddingsResponse
+		if err := json.Unmarshal(respBody, &part); err != nil {
+			return nil, fmt.Errorf("embeddings batch at offset %d: %w", offset, err)
+		}
+		for _, item := range part.Data {
+			var idx int
+			_ = json.Unmarshal(item["index"], &idx)
+			item["index"] = json.RawMessage(strconv.Itoa(idx + offset))
+			all.Data = append(all.Data, item)
+		}
+		all.Usage.PromptTokens += part.Usage.PromptTokens
+		all.Usage.TotalTokens += part.Usage.TotalTokens
+
+		if first == nil {
+			_ = json.Unmarshal(respBody, &first)
+		}
+		merged = resp
+	}
+	log.Printf("Embeddings request split into %d batches of up to %d inputs", (len(inputs)+embeddingBatchSize-1)/embeddingBatchSize, embeddingBatchSize)
+
+	data, _ := json.Marshal(all.Data)
+	usage, _ := json.Marshal(all.Usage)
+	first["data"], first["usage"] = data, usage
+	out, err := json.Marshal(first)
+	if err != nil {
+		return nil, err
+	}
+	return bufferedResponse(merged, out), nil
+}
+
+// bufferedResponse подменяет тело ответа уже прочитанным
+func bufferedResponse(resp *http.Response, body []byte) *http.Response {
+	resp.Header.Del("Content-Length")
+	resp.Header.Del("Content-Encoding")
+	resp.ContentLength = int64(len(body))
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp
+}
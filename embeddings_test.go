@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// embeddingsUpstream отвечает вектором [номер входа в алфавите] на каждый input;
+// input "bad" - ошибка 400, как у провайдера на недопустимый вход.
+// Возвращает функцию, отдающую полученные пачки по порядку.
+func embeddingsUpstream(t *testing.T) func() []string {
+	var mu sync.Mutex
+	var batches []string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var req struct {
+			Input []string `json:"input"`
+		}
+		json.Unmarshal(b, &req)
+		mu.Lock()
+		batches = append(batches, strings.Join(req.Input, "+"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		var data []string
+		for i, in := range req.Input {
+			if in == "bad" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":{"message":"invalid input"}}`))
+				return
+			}
+			data = append(data, fmt.Sprintf(`{"object":"embedding","index":%d,"embedding":[%d]}`, i, in[0]-'a'))
+		}
+		fmt.Fprintf(w, `{"object":"list","model":"text-embedding-3-small","data":[%s],"usage":{"prompt_tokens":%d,"total_tokens":%d}}`,
+			strings.Join(data, ","), len(req.Input), len(req.Input))
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), batches...)
+	}
+}
+
+func TestEmbeddingsBatchSplit(t *testing.T) {
+	t.Setenv("PROXY_EMBEDDING_BATCH_SIZE", "2")
+	received := embeddingsUpstream(t)
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/embeddings",
+		`{"model":"text-embedding-3-small","input":["a","b","c","d","e"]}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if batches, want := received(), "a+b,c+d,e"; strings.Join(batches, ",") != want {
+		t.Fatalf("upstream batches = %v, want %s", batches, want)
+	}
+	// Порядок и index сквозные, usage суммирован
+	golden(t, "embeddings_split", []byte(body))
+}
+
+func TestEmbeddingsBatchPartialFailure(t *testing.T) {
+	t.Setenv("PROXY_EMBEDDING_BATCH_SIZE", "2")
+	received := embeddingsUpstream(t)
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/embeddings",
+		`{"model":"text-embedding-3-small","input":["a","b","bad","d","e"]}`)
+	if resp.StatusCode != http.StatusBadRequest || body != `{"error":{"message":"invalid input"}}` {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if batches, want := received(), "a+b,bad+d"; strings.Join(batches, ",") != want {
+		t.Fatalf("upstream batches = %v, want the rest not sent", batches)
+	}
+}
+
+func TestEmbeddingsWithinBatchSize(t *testing.T) {
+	t.Setenv("PROXY_EMBEDDING_BATCH_SIZE", "2")
+	received := embeddingsUpstream(t)
+	p := startProxy(t)
+
+	const body = `{"model":"text-embedding-3-small","input":["a","b"]}`
+	p.do(t, http.MethodPost, "/openai/v1/embeddings", body)
+	// Токенизированный вход - один массив чисел, не делится
+	p.do(t, http.MethodPost, "/openai/v1/embeddings", `{"model":"text-embedding-3-small","input":[1,2,3,4,5]}`)
+	if batches := received(); len(batches) != 2 || batches[0] != "a+b" {
+		t.Fatalf("upstream batches = %q", batches)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// injectUser - добавлять хешированный user в chat-запросы без своего user (PROXY_INJECT_USER)
+	injectUser bool
+	// userHeader - заголовок с id конечного пользователя (PROXY_USER_HEADER); без него - имя токена
+	userHeader string
+	// userSalt - соль хеша (PROXY_USER_SALT), чтобы id нельзя было подобрать по имени токена
+	userSalt string
+)
+
+// supportsUserField - провайдеры, принимающие user в теле chat-запроса
+func supportsUserField(provider string) bool {
+	return provider == "openai" || provider == "nebius"
+}
+
+// endUserSource - из чего выводится user: заголовок PROXY_USER_HEADER, иначе имя токена
+func endUserSource(c *fiber.Ctx, tok *apiToken) string {
+	if userHeader != "" {
+		if v := strings.TrimSpace(c.Get(userHeader)); v != "" {
+			return "header:" + v
+		}
+	}
+	if tok != nil {
+		return "token:" + tok.Name
+	}
+	return ""
+}
+
+// deriveUserID - стабильный обезличенный id: один источник - один id, сам источник провайдеру не уходит
+func deriveUserID(source string) string {
+	sum := sha256.Sum256([]byte(userSalt + "\x00" + source))
+	return "user-" + hex.EncodeToString(sum[:16])
+}
+
+// injectUserField добавляет user в chat-запрос, если клиент не передал свой
+func injectUserField(jb *jsonBody, source string) bool {
+	if _, ok := jb.fields["messages"]; !ok {
+		return false
+	}
+	if _, ok := jb.fields["user"]; ok {
+		return false
+	}
+	jb.set("user", deriveUserID(source))
+	return true
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+// userEcho - провайдер, запоминающий user из тела и заголовок X-End-User
+type userEcho struct {
+	user, header string
+}
+
+func (u *userEcho) serve(t *testing.T, provider string) {
+	upstream(t, provider, func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ User string }
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		u.user, u.header = body.User, r.Header.Get("X-End-User")
+		w.Write([]byte(`{}`))
+	})
+}
+
+const userChat = `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+
+func TestInjectUserFromToken(t *testing.T) {
+	useTokens(t, `[{"name":"team-a","token":"tok-a"},{"name":"team-b","token":"tok-b"}]`)
+	t.Setenv("PROXY_INJECT_USER", "true")
+	var got userEcho
+	got.serve(t, "openai")
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", userChat, "X-Proxy-Auth", "tok-a")
+	first := got.user
+	if !regexp.MustCompile(`^user-[0-9a-f]{32}$`).MatchString(first) || first != deriveUserID("token:team-a") {
+		t.Fatalf("injected user = %q", first)
+	}
+	// Стабилен для токена и различается между токенами
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", userChat, "X-Proxy-Auth", "tok-a")
+	if got.user != first {
+		t.Fatalf("user changed between requests: %q, %q", first, got.user)
+	}
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", userChat, "X-Proxy-Auth", "tok-b")
+	if got.user == first || got.user == "" {
+		t.Fatalf("user of another token = %q", got.user)
+	}
+
+	// Свой user клиента сохраняется
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions",
+		`{"model":"gpt-4o","messages":[],"user":"client-42"}`, "X-Proxy-Auth", "tok-a")
+	if got.user != "client-42" {
+		t.Fatalf("client user replaced: %q", got.user)
+	}
+}
+
+func TestInjectUserFromHeader(t *testing.T) {
+	t.Setenv("PROXY_INJECT_USER", "true")
+	t.Setenv("PROXY_USER_HEADER", "X-End-User")
+	var got userEcho
+	got.serve(t, "nebius")
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/nebius/v1/chat/completions", userChat, "X-End-User", "alice@example.com")
+	if got.user != deriveUserID("header:alice@example.com") {
+		t.Fatalf("user from header = %q", got.user)
+	}
+	// Сам идентификатор провайдеру не уходит
+	if got.header != "" {
+		t.Fatalf("X-End-User forwarded: %q", got.header)
+	}
+	// Без заголовка - имя токена
+	p.do(t, http.MethodPost, "/nebius/v1/chat/completions", userChat)
+	if got.user != deriveUserID("token:default") {
+		t.Fatalf("user without the header = %q", got.user)
+	}
+}
+
+func TestInjectUserSalt(t *testing.T) {
+	prev := userSalt
+	t.Cleanup(func() { userSalt = prev })
+	userSalt = ""
+	unsalted := deriveUserID("token:team-a")
+	userSalt = "pepper"
+	if salted := deriveUserID("token:team-a"); salted == unsalted || salted != deriveUserID("token:team-a") {
+		t.Fatalf("salted id %q, unsalted %q", salted, unsalted)
+	}
+}
+
+func TestInjectUserSkipsOtherRequests(t *testing.T) {
+	t.Setenv("PROXY_INJECT_USER", "true")
+	var openai, deepseek userEcho
+	openai.serve(t, "openai")
+	deepseek.serve(t, "deepseek")
+	p := startProxy(t)
+
+	// Провайдер без поля user и запросы не chat completions не меняются
+	p.do(t, http.MethodPost, "/deepseek/v1/chat/completions", userChat)
+	p.do(t, http.MethodPost, "/openai/v1/embeddings", `{"model":"text-embedding-3-small","input":"hi"}`)
+	if deepseek.user != "" || openai.user != "" {
+		t.Fatalf("user injected: deepseek %q, embeddings %q", deepseek.user, openai.user)
+	}
+}
+
+func TestInjectUserOffByDefault(t *testing.T) {
+	var got userEcho
+	got.serve(t, "openai")
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", userChat)
+	if got.user != "" {
+		t.Fatalf("user injected without PROXY_INJECT_USER: %q", got.user)
+	}
+}
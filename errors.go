@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// openAIErrors - собственные ошибки прокси в формате OpenAI
+// {"error":{"message","type","code"}} (PROXY_ERROR_FORMAT=openai) вместо {"error":"..."}
+var openAIErrors bool
+
+// errorType - тип ошибки OpenAI по статусу ответа
+func errorType(status int) string {
+	switch {
+	case status == fiber.StatusUnauthorized:
+		return "authentication_error"
+	case status == fiber.StatusForbidden:
+		return "permission_error"
+	case status == fiber.StatusNotFound:
+		return "not_found_error"
+	case status == fiber.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+// toOpenAIError перестраивает JSON-ошибку прокси в схему OpenAI. Строковый "error" становится
+// message, остальные поля верхнего уровня (limit, retry_after, ...) переносятся в объект ошибки.
+// false - тело не похоже на ошибку прокси и остаётся как есть.
+func toOpenAIError(status int, body []byte) ([]byte, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields["error"] == nil || string(fields["error"]) == "null" {
+		return nil, false
+	}
+
+	obj := map[string]any{}
+	var message string
+	if json.Unmarshal(fields["error"], &message) != nil {
+		var nested map[string]any
+		if json.Unmarshal(fields["error"], &nested) != nil || nested == nil {
+			return nil, false
+		}
+		obj = nested
+	} else {
+		obj["message"] = message
+	}
+	for k, v := range fields {
+		if _, ok := obj[k]; k != "error" && !ok {
+			obj[k] = v
+		}
+	}
+	if _, ok := obj["type"]; !ok {
+		obj["type"] = errorType(status)
+	}
+	if _, ok := obj["code"]; !ok {
+		obj["code"] = nil
+	}
+	if _, ok := obj["message"]; !ok {
+		obj["message"] = ""
+	}
+
+	out, err := json.Marshal(map[string]any{"error": obj})
+	return out, err == nil
+}
+
+// errorFormatMiddleware приводит ошибки, сгенерированные самим прокси, к схеме OpenAI.
+// Ответы провайдеров (X-Proxy-Upstream-Status) и потоки не трогаются.
+func errorFormatMiddleware(c *fiber.Ctx) error {
+	if err := c.Next(); err != nil {
+		return err
+	}
+	resp := c.Response()
+	if resp.StatusCode() < 400 || resp.IsBodyStream() || len(resp.Header.Peek("X-Proxy-Upstream-Status")) > 0 ||
+		!strings.HasPrefix(string(resp.Header.ContentType()), fiber.MIMEApplicationJSON) {
+		return nil
+	}
+	if body, ok := toOpenAIError(resp.StatusCode(), resp.Body()); ok {
+		resp.SetBodyRaw(body)
+	}
+	return nil
+}
+
+// openAIErrorResponse - ошибка из errorHandler (паника, 404 маршрута и т.п.) в схеме OpenAI
+func openAIErrorResponse(c *fiber.Ctx, err error, panicked bool) error {
+	status, message := fiber.StatusInternalServerError, "Internal server error"
+	var fe *fiber.Error
+	if !panicked && errors.As(err, &fe) {
+		status, message = fe.Code, fe.Message
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": fiber.Map{
+			"message":    message,
+			"type":       errorType(status),
+			"code":       nil,
+			"request_id": requestID(c),
+		},
+	})
+}
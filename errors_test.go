@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// openAIError - тело ошибки в схеме OpenAI; code и message обязаны присутствовать
+func openAIError(t *testing.T, body string) map[string]any {
+	t.Helper()
+	var out struct {
+		Error map[string]any
+	}
+	if err := json.Unmarshal([]byte(body), &out); err != nil || out.Error == nil {
+		t.Fatalf("not an OpenAI error object: %s", body)
+	}
+	if _, ok := out.Error["message"].(string); !ok {
+		t.Fatalf("error.message is not a string: %s", body)
+	}
+	if _, ok := out.Error["type"].(string); !ok {
+		t.Fatalf("error.type is not a string: %s", body)
+	}
+	if _, ok := out.Error["code"]; !ok {
+		t.Fatalf("no error.code: %s", body)
+	}
+	return out.Error
+}
+
+func TestOpenAIErrorFormat(t *testing.T) {
+	t.Setenv("PROXY_ERROR_FORMAT", "openai")
+	t.Setenv("PROXY_MAX_HEADER_COUNT", "30")
+	t.Setenv("PROXY_MAX_MESSAGES", "1")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	tooManyHeaders := []string{}
+	for i := range 40 {
+		tooManyHeaders = append(tooManyHeaders, "X-Extra-"+strings.Repeat("a", i+1), "1")
+	}
+	cases := []struct {
+		name, wantType string
+		status         int
+		req            *http.Request
+	}{
+		{"unauthorized", "authentication_error", http.StatusUnauthorized,
+			p.newRequest(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", "wrong")},
+		{"unknown route", "not_found_error", http.StatusNotFound,
+			p.newRequest(t, http.MethodGet, "/nope", "")},
+		{"unknown model", "invalid_request_error", http.StatusBadRequest,
+			p.newRequest(t, http.MethodPost, "/v1/chat/completions", `{"model":"my-finetune"}`)},
+		{"too many messages", "invalid_request_error", http.StatusBadRequest,
+			p.newRequest(t, http.MethodPost, "/openai/v1/chat/completions", chatWithMessages(2))},
+		{"header limits", "invalid_request_error", http.StatusRequestHeaderFieldsTooLarge,
+			p.newRequest(t, http.MethodGet, "/openai/v1/models", "", tooManyHeaders...)},
+	}
+	for _, tc := range cases {
+		resp, body := send(t, tc.req)
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, resp.StatusCode, tc.status, body)
+			continue
+		}
+		if e := openAIError(t, body); e["type"] != tc.wantType || e["message"] == "" {
+			t.Errorf("%s: error = %v", tc.name, e)
+		}
+	}
+
+	// Дополнительные поля ошибки переносятся внутрь объекта
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", chatWithMessages(2))
+	golden(t, "openai_error_max_messages", []byte(body))
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestOpenAIErrorFormatKeepsUpstreamErrors(t *testing.T) {
+	t.Setenv("PROXY_ERROR_FORMAT", "openai")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"provider says no"}`))
+	})
+	p := startProxy(t)
+
+	if _, body := p.do(t, http.MethodGet, "/openai/v1/models", ""); body != `{"error":"provider says no"}` {
+		t.Fatalf("provider error rewritten: %s", body)
+	}
+}
+
+func TestSimpleErrorFormatByDefault(t *testing.T) {
+	p := startProxy(t)
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", "wrong")
+	if resp.StatusCode != http.StatusUnauthorized || body != `{"error":"Unauthorized"}` {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestToOpenAIError(t *testing.T) {
+	for _, tc := range []struct {
+		status     int
+		body, want string
+	}{
+		{429, `{"error":"token concurrent stream limit exceeded","limit":2}`,
+			`{"error":{"code":null,"limit":2,"message":"token concurrent stream limit exceeded","type":"rate_limit_error"}}`},
+		// Уже вложенная ошибка дополняется недостающими полями, type сохраняется
+		{502, `{"error":{"type":"upstream_unreachable","message":"dial tcp"}}`,
+			`{"error":{"code":null,"message":"dial tcp","type":"upstream_unreachable"}}`},
+		{403, `{"error":"Forbidden"}`, `{"error":{"code":null,"message":"Forbidden","type":"permission_error"}}`},
+	} {
+		got, ok := toOpenAIError(tc.status, []byte(tc.body))
+		if !ok || string(got) != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.body, got, tc.want)
+		}
+	}
+	for _, body := range []string{`not json`, `{"data":[]}`, `{"error":null}`, `{"error":5}`} {
+		if _, ok := toOpenAIError(400, []byte(body)); ok {
+			t.Errorf("%s rewritten", body)
+		}
+	}
+}
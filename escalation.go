@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// escalationPattern - ответ, совпавший с шаблоном (например отказ модели), считается неудачным
+// (PROXY_ESCALATION_PATTERN)
+var escalationPattern *regexp.Regexp
+
+// escalationStatuses - статусы неудачного ответа: коды и классы вида 5xx
+// (PROXY_ESCALATION_STATUSES). Остальные 4xx - ошибка самого запроса, другая модель её не исправит.
+var escalationStatuses = []string{"429", "5xx"}
+
+func initEscalation() error {
+	escalationPattern = nil
+	escalationStatuses = []string{"429", "5xx"}
+	if list := envList("PROXY_ESCALATION_STATUSES"); len(list) > 0 {
+		for i, s := range list {
+			s = strings.ToLower(s)
+			if _, err := strconv.Atoi(strings.TrimSuffix(s, "xx")); err != nil || len(s) != 3 {
+				return fmt.Errorf("PROXY_ESCALATION_STATUSES: invalid status %q", list[i])
+			}
+			list[i] = s
+		}
+		escalationStatuses = list
+	}
+	v := strings.TrimSpace(os.Getenv("PROXY_ESCALATION_PATTERN"))
+	if v == "" {
+		return nil
+	}
+	re, err := regexp.Compile(v)
+	if err != nil {
+		return fmt.Errorf("PROXY_ESCALATION_PATTERN: %w", err)
+	}
+	escalationPattern = re
+	return nil
+}
+
+// isEscalationStatus - статус входит в escalationStatuses
+func isEscalationStatus(status int) bool {
+	code := strconv.Itoa(status)
+	for _, s := range escalationStatuses {
+		if s == code || strings.HasSuffix(s, "xx") && s[0] == code[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEscalationChains разбирает <PROVIDER>_ESCALATION_CHAINS: "логическая модель=модель1|модель2,..."
+func parseEscalationChains(raw map[string]string) map[string][]string {
+	chains := map[string][]string{}
+	for name, targets := range raw {
+		var chain []string
+		for _, t := range strings.Split(targets, "|") {
+			if t = strings.TrimSpace(t); t != "" {
+				chain = append(chain, t)
+			}
+		}
+		if len(chain) > 0 {
+			chains[name] = chain
+		}
+	}
+	return chains
+}
+
+// needsEscalation - ответ неудачен и запрос стоит повторить со следующей моделью цепочки
+func needsEscalation(resp *http.Response, body []byte) bool {
+	if isEscalationStatus(resp.StatusCode) {
+		return true
+	}
+	if escalationPattern == nil {
+		return false
+	}
+	decoded, err := decodeBody(body, resp.Header.Get("Content-Encoding"))
+	return err == nil && escalationPattern.Match(decoded)
+}
+
+// escalate повторяет запрос со следующими моделями цепочки, пока ответ неудачен.
+// Каждый переход проверяется по списку моделей токена (запрещённая модель пропускается)
+// и идёт с ключом своей модели (<PROVIDER>_MODEL_KEYS, BYOK).
+// Возвращает последний полученный ответ (тело уже прочитано) и ключ, который его дал
+// (nil - эскалации не было); токены отброшенных ответов учитываются.
+func escalate(p *provider, tag string, tok *apiToken, req *http.Request, body []byte, chain []string, resp *http.Response, respBody []byte) (*http.Response, []byte, *apiKeyState) {
+	var usedKey *apiKeyState
+	for _, target := range chain {
+		if !needsEscalation(resp, respBody) {
+			break
+		}
+		model, err := resolveModel(p, target)
+		if err != nil {
+			log.Printf("WARN: escalation target %s skipped: %v", target, err)
+			continue
+		}
+		if !tok.allowsModel(model) {
+			log.Printf("WARN: escalation target %s skipped: model not allowed for token %s", model, tok.Name)
+			continue
+		}
+		key := tok.keyPoolFor(p.Name, p.keysFor(model)).pick()
+		if key == nil {
+			log.Printf("WARN: escalation target %s skipped: no API key", model)
+			continue
+		}
+		jb := parseJSONBody(body)
+		if jb == nil {
+			break
+		}
+		jb.set("model", model)
+		nextBody, err := jb.bytes()
+		if err != nil {
+			break
+		}
+
+		log.Printf("Escalating %s request to model %s (previous status %d, key=%s)", p.Name, model, resp.StatusCode, key.id())
+		recordEscalation(p.Name)
+
+		nextReq := cloneWithBody(req, nextBody)
+		setProviderAuth(nextReq, p.Name, key.value)
+		nextResp, err := doUpstream(p.client, withAttemptReason(nextReq, "escalation to model "+model), p.Name)
+		if err != nil {
+			log.Printf("ERROR: escalation to %s failed: %v", model, err)
+			continue
+		}
+		key.observe(nextResp.Header)
+		nextRespBody, err := io.ReadAll(nextResp.Body)
+		nextResp.Body.Close()
+		if err != nil {
+			log.Printf("ERROR: escalation to %s: failed to read response: %v", model, err)
+			continue
+		}
+		if u, ok := extractUsage(p.Name, respBody, resp.Header.Get("Content-Encoding")); ok {
+			recordUsage(p.Name, tag, u)
+			tok.charge(u)
+		}
+		resp, respBody, usedKey = nextResp, nextRespBody, key
+	}
+	return resp, respBody, usedKey
+}
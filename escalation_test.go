@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// tieredUpstream отвечает по модели: mini отказывает текстом, small падает с 500,
+// strict отклоняет запрос с 400, остальные отвечают
+func tieredUpstream(t *testing.T, models *[]string) {
+	upstream(t, "openai", tieredHandler(models))
+}
+
+func tieredHandler(models *[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.Unmarshal(b, &req)
+		*models = append(*models, req.Model)
+		w.Header().Set("Content-Type", "application/json")
+		switch req.Model {
+		case "gpt-4o-mini":
+			w.Write([]byte(`{"model":"gpt-4o-mini","choices":[{"message":{"content":"I'm sorry, I can't help with that."}}],"usage":{"prompt_tokens":5,"completion_tokens":3}}`))
+		case "gpt-4o-small":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"message":"overloaded"}}`))
+		case "gpt-4o-strict":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"invalid messages"}}`))
+		default:
+			w.Write([]byte(`{"model":"` + req.Model + `","choices":[{"message":{"content":"Sure."}}],"usage":{"prompt_tokens":5,"completion_tokens":2}}`))
+		}
+	}
+}
+
+func TestEscalationOnRefusal(t *testing.T) {
+	t.Setenv("PROXY_ESCALATION_PATTERN", `(?i)I'm sorry, I can't`)
+	t.Setenv("OPENAI_ESCALATION_CHAINS", "smart=gpt-4o-mini|gpt-4o-small|gpt-4o")
+	var models []string
+	tieredUpstream(t, &models)
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"smart","messages":[]}`)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `"Sure."`) {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	// Отказ по шаблону и 500 - обе причины эскалации
+	if want := "gpt-4o-mini gpt-4o-small gpt-4o"; strings.Join(models, " ") != want {
+		t.Fatalf("models tried = %v, want %s", models, want)
+	}
+	if got := p.providerStat(t, "openai", "escalations"); got != float64(2) {
+		t.Fatalf("escalations = %v, want 2", got)
+	}
+	// Токены отброшенного ответа тоже учтены
+	if got := p.providerStat(t, "openai", "usage", "prompt_tokens"); got != float64(10) {
+		t.Fatalf("prompt tokens = %v, want both successful calls counted", got)
+	}
+}
+
+func TestEscalationStopsOnSuccess(t *testing.T) {
+	t.Setenv("OPENAI_ESCALATION_CHAINS", "smart=gpt-4o-mini|gpt-4o")
+	var models []string
+	tieredUpstream(t, &models)
+	p := startProxy(t)
+
+	// Без шаблона отказ - обычный успешный ответ
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"smart"}`)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "I'm sorry") || len(models) != 1 {
+		t.Fatalf("status %d %s, models tried %v", resp.StatusCode, body, models)
+	}
+}
+
+func TestEscalationSkipsStreaming(t *testing.T) {
+	t.Setenv("OPENAI_ESCALATION_CHAINS", "smart=gpt-4o-small|gpt-4o")
+	var models []string
+	tieredUpstream(t, &models)
+	p := startProxy(t)
+
+	resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"smart","stream":true}`)
+	if resp.StatusCode != http.StatusInternalServerError || strings.Join(models, " ") != "gpt-4o-small" {
+		t.Fatalf("streaming request: status %d, models tried %v", resp.StatusCode, models)
+	}
+}
+
+func TestEscalationStatuses(t *testing.T) {
+	t.Setenv("OPENAI_ESCALATION_CHAINS", "smart=gpt-4o-strict|gpt-4o")
+	var models []string
+	tieredUpstream(t, &models)
+	p := startProxy(t)
+
+	// 400 - ошибка самого запроса: по умолчанию (429, 5xx) эскалации нет
+	resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"smart"}`)
+	if resp.StatusCode != http.StatusBadRequest || strings.Join(models, " ") != "gpt-4o-strict" {
+		t.Fatalf("status %d, models tried %v", resp.StatusCode, models)
+	}
+
+	t.Setenv("PROXY_ESCALATION_STATUSES", "400,5XX")
+	models = nil
+	p = startProxy(t)
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"smart"}`); resp.StatusCode != http.StatusOK || strings.Join(models, " ") != "gpt-4o-strict gpt-4o" {
+		t.Fatalf("with 400 listed: status %d, models tried %v", resp.StatusCode, models)
+	}
+
+	for _, v := range []string{"40", "4x", "abc"} {
+		t.Setenv("PROXY_ESCALATION_STATUSES", v)
+		if err := initEscalation(); err == nil {
+			t.Errorf("PROXY_ESCALATION_STATUSES=%s accepted", v)
+		}
+	}
+}
+
+func TestEscalationUsesModelKey(t *testing.T) {
+	t.Setenv("PROXY_SERVED_BY_HEADER", "true")
+	t.Setenv("OPENAI_API_KEY", "sk-default")
+	t.Setenv("OPENAI_KEY_BIG", "sk-big")
+	t.Setenv("OPENAI_MODEL_KEYS", "gpt-4.1=OPENAI_KEY_BIG")
+	t.Setenv("OPENAI_ESCALATION_CHAINS", "smart=gpt-4o-small|gpt-4.1")
+	var keys []string
+	var models []string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		tieredHandler(&models)(w, r)
+	})
+	p := startProxy(t)
+
+	// Модель, до которой дошла эскалация, вызывается своим ключом, и ответ приписан ему
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"smart"}`)
+	if resp.StatusCode != http.StatusOK || strings.Join(keys, " ") != "sk-default sk-big" {
+		t.Fatalf("status %d %s, keys used %v", resp.StatusCode, body, keys)
+	}
+	if want := servedBy("openai", 0, "sk-big"); resp.Header.Get("X-Proxy-Served-By") != want {
+		t.Fatalf("X-Proxy-Served-By = %q, want %q", resp.Header.Get("X-Proxy-Served-By"), want)
+	}
+}
+
+func TestEscalationRespectsTokenModels(t *testing.T) {
+	useTokens(t, `[{"name":"team-a","token":"tok-a","models":["smart","gpt-4o-small","gpt-4.1"]}]`)
+	t.Setenv("OPENAI_ESCALATION_CHAINS", "smart=gpt-4o-small|gpt-4o|gpt-4.1")
+	var models []string
+	tieredUpstream(t, &models)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	// gpt-4o токену запрещена - эскалация идёт сразу к следующей модели цепочки
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"smart"}`, "X-Proxy-Auth", "tok-a")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `"model":"gpt-4.1"`) {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if want := "gpt-4o-small gpt-4.1"; strings.Join(models, " ") != want {
+		t.Fatalf("models tried = %v, want %s", models, want)
+	}
+	if !strings.Contains(logs.String(), "WARN: escalation target gpt-4o skipped: model not allowed for token team-a") {
+		t.Fatalf("no warning for the skipped model:\n%s", logs)
+	}
+}
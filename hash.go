@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// varyHeaders - заголовки запроса, от которых зависит ответ провайдера (учитываются
+// при объединении и кэшировании ответов)
+var varyHeaders = []string{"Accept", "Accept-Encoding", "Anthropic-Version", "Anthropic-Beta", "OpenAI-Beta", lastEventIDHeader}
+
+// varyValues - значения varyHeaders; get - c.Get или http.Header.Get
+func varyValues(get func(string) string) []string {
+	values := make([]string, len(varyHeaders))
+	for i, name := range varyHeaders {
+		values[i] = get(name)
+	}
+	return values
+}
+
+// requestHash - стабильный хеш запроса, общий для записи/воспроизведения, объединения запросов
+// и кэша: провайдер, метод, путь с query, значения заголовков и тело. JSON-тело приводится
+// к каноническому виду (ключи по порядку, без пробелов), поля ignore верхнего уровня отбрасываются,
+// поэтому тела, отличающиеся только порядком ключей, дают одинаковый хеш.
+func requestHash(provider, method, pathWithQuery string, headers []string, body []byte, ignore []string) string {
+	h := sha256.New()
+	for _, part := range []string{provider, method, pathWithQuery} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	for _, v := range headers {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	h.Write(canonicalJSON(body, ignore))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// canonicalJSON - тело в каноническом виде; не JSON возвращается как есть
+func canonicalJSON(body []byte, ignore []string) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return body
+	}
+	if obj, ok := v.(map[string]any); ok {
+		for _, field := range ignore {
+			delete(obj, field)
+		}
+	}
+	// encoding/json пишет ключи map по порядку
+	out, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return out
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRequestHashCanonicalJSON(t *testing.T) {
+	hash := func(body string, ignore ...string) string {
+		return requestHash("openai", "POST", "/v1/chat/completions", []string{"application/json"}, []byte(body), ignore)
+	}
+	base := hash(`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`)
+	if !regexp.MustCompile(`^[0-9a-f]{64}$`).MatchString(base) {
+		t.Fatalf("hash %q is not a hex sha256", base)
+	}
+	// Эталон: хеш одного и того же запроса не меняется между версиями (ключи записей)
+	golden(t, "request_hash", []byte(base+"\n"))
+
+	for _, body := range []string{
+		`{"messages":[{"content":"hi","role":"user"}],"temperature":0,"model":"gpt-4o"}`,
+		"{\n  \"model\": \"gpt-4o\",\n  \"temperature\": 0,\n  \"messages\": [{\"role\": \"user\", \"content\": \"hi\"}]\n}",
+	} {
+		if got := hash(body); got != base {
+			t.Errorf("reordered/reformatted body %s hashes differently", body)
+		}
+	}
+	for _, body := range []string{
+		`{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}],"user":"alice"}`,
+		`{"model":"gpt-4o","temperature":0,"messages":[{"content":"hi","role":"user"},{"role":"user","content":"hi"}]}`,
+	} {
+		if hash(body) == base {
+			t.Errorf("different body %s hashes equally", body)
+		}
+	}
+}
+
+func TestRequestHashIgnoredFields(t *testing.T) {
+	hash := func(body string, ignore ...string) string {
+		return requestHash("openai", "POST", "/v1/chat/completions", nil, []byte(body), ignore)
+	}
+	a := hash(`{"model":"gpt-4o","user":"alice","metadata":{"run":1}}`, "user", "metadata")
+	if b := hash(`{"metadata":{"run":2},"model":"gpt-4o","user":"bob"}`, "user", "metadata"); a != b {
+		t.Fatal("bodies differing only in ignored fields hash differently")
+	}
+	if b := hash(`{"model":"gpt-4o"}`, "user", "metadata"); a != b {
+		t.Fatal("ignored fields are not dropped")
+	}
+	// Игнорируются только поля верхнего уровня
+	if hash(`{"model":"gpt-4o","extra":{"user":"alice"}}`, "user") == hash(`{"model":"gpt-4o","extra":{"user":"bob"}}`, "user") {
+		t.Fatal("nested field with an ignored name dropped")
+	}
+	if hash(`{"model":"gpt-4o","user":"alice"}`) == hash(`{"model":"gpt-4o","user":"bob"}`) {
+		t.Fatal("user ignored without being configured")
+	}
+}
+
+func TestRequestHashRequestParts(t *testing.T) {
+	body := []byte(`{"model":"gpt-4o"}`)
+	base := requestHash("openai", "POST", "/v1/chat/completions", []string{"a"}, body, nil)
+	for name, got := range map[string]string{
+		"provider": requestHash("deepseek", "POST", "/v1/chat/completions", []string{"a"}, body, nil),
+		"method":   requestHash("openai", "PUT", "/v1/chat/completions", []string{"a"}, body, nil),
+		"path":     requestHash("openai", "POST", "/v1/chat/completions?x=1", []string{"a"}, body, nil),
+		"headers":  requestHash("openai", "POST", "/v1/chat/completions", []string{"b"}, body, nil),
+		// Части разделены: склейка полей не даёт совпадений
+		"boundary": requestHash("openaiPOST", "", "/v1/chat/completions", []string{"a"}, body, nil),
+	} {
+		if got == base {
+			t.Errorf("%s does not affect the hash", name)
+		}
+	}
+	// Не-JSON тело хешируется как есть
+	if requestHash("openai", "POST", "/", nil, []byte("a b"), nil) == requestHash("openai", "POST", "/", nil, []byte("a  b"), nil) {
+		t.Error("non-JSON bodies normalized")
+	}
+}
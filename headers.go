@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// hopByHopHeaders относятся к соединению с провайдером и клиенту не передаются
+var hopByHopHeaders = []string{
+	"connection", "keep-alive", "proxy-authenticate", "proxy-authorization",
+	"te", "trailer", "transfer-encoding", "upgrade",
+}
+
+// providerInternalHeaders - служебные заголовки провайдеров (PROXY_STRIP_PROVIDER_HEADERS)
+var providerInternalHeaders = []string{
+	"openai-organization", "openai-project", "openai-processing-ms", "openai-version",
+	"anthropic-organization-id", "cf-ray", "cf-cache-status", "server", "via", "alt-svc",
+	"set-cookie",
+}
+
+// preservedResponseHeaders передаются всегда, даже если не попали в allowlist
+var preservedResponseHeaders = []string{"content-type", "x-ratelimit-*", "anthropic-ratelimit-*", "retry-after"}
+
+// headerFilter - allowlist/denylist заголовков ответа; поддерживает префиксы вида "x-foo-*"
+type headerFilter struct {
+	allow []string
+	deny  []string
+}
+
+var responseHeaders = &headerFilter{deny: hopByHopHeaders}
+
+// initResponseHeaderFilter читает PROXY_RESPONSE_HEADERS_ALLOW, PROXY_RESPONSE_HEADERS_DENY
+// и PROXY_STRIP_PROVIDER_HEADERS
+func initResponseHeaderFilter() {
+	f := &headerFilter{deny: append([]string{}, hopByHopHeaders...)}
+	if envBool("PROXY_STRIP_PROVIDER_HEADERS", false) {
+		f.deny = append(f.deny, providerInternalHeaders...)
+	}
+	f.deny = append(f.deny, parseHeaderList(os.Getenv("PROXY_RESPONSE_HEADERS_DENY"))...)
+	if allow := parseHeaderList(os.Getenv("PROXY_RESPONSE_HEADERS_ALLOW")); len(allow) > 0 {
+		f.allow = append(allow, preservedResponseHeaders...)
+	}
+	responseHeaders = f
+}
+
+func parseHeaderList(s string) []string {
+	var result []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func matchHeader(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed - передавать ли заголовок клиенту; denylist приоритетнее allowlist
+func (f *headerFilter) allowed(name string) bool {
+	name = strings.ToLower(name)
+	if matchHeader(f.deny, name) {
+		return false
+	}
+	return len(f.allow) == 0 || matchHeader(f.allow, name)
+}
+
+// copyResponseHeaders переносит заголовки ответа провайдера в ответ клиенту
+func copyResponseHeaders(c *fiber.Ctx, resp *http.Response) {
+	for k, v := range resp.Header {
+		if !responseHeaders.allowed(k) {
+			continue
+		}
+		for _, val := range v {
+			c.Response().Header.Add(k, val)
+		}
+	}
+}
+
+var (
+	// maxHeaderCount - предел числа заголовков запроса (PROXY_MAX_HEADER_COUNT), 0 - без ограничения
+	maxHeaderCount int
+	// maxHeaderBytes - предел суммарного размера имён и значений заголовков (PROXY_MAX_HEADER_BYTES), 0 - без ограничения
+	maxHeaderBytes int
+)
+
+// headersWithinLimits - число и суммарный размер заголовков укладываются в пределы
+func headersWithinLimits(count, size int) bool {
+	return (maxHeaderCount <= 0 || count <= maxHeaderCount) && (maxHeaderBytes <= 0 || size <= maxHeaderBytes)
+}
+
+// headerLimitsMiddleware отклоняет запросы со слишком многими или слишком большими
+// заголовками с 431 до проксирования
+func headerLimitsMiddleware(c *fiber.Ctx) error {
+	count, size := 0, 0
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		count++
+		size += len(key) + len(value)
+	})
+	if !headersWithinLimits(count, size) {
+		return c.Status(fiber.StatusRequestHeaderFieldsTooLarge).JSON(fiber.Map{
+			"error": "request headers too large",
+			"count": count,
+			"bytes": size,
+		})
+	}
+	return c.Next()
+}
+
+// upstreamHeadersWithinLimits проверяет те же пределы для запроса к провайдеру: к заголовкам
+// клиента добавляются заголовки хука преобразования, трассировка и учётные данные
+func upstreamHeadersWithinLimits(h http.Header) (count, size int, ok bool) {
+	for k, v := range h {
+		for _, val := range v {
+			count++
+			size += len(k) + len(val)
+		}
+	}
+	return count, size, headersWithinLimits(count, size)
+}
+
+// secretHeaders в логах всегда маскируются: токен прокси, ключи провайдеров, куки
+var secretHeaders = []string{"authorization", "x-proxy-auth", "x-api-key", "proxy-authorization", "cookie"}
+
+// logHeaders - отладочный лог заголовков запроса клиента и запроса к провайдеру (PROXY_LOG_HEADERS)
+var logHeaders bool
+
+// maskedHeaders - заголовки одной строкой для лога, значения secretHeaders заменены на ***
+func maskedHeaders(h map[string][]string) string {
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, k := range names {
+		value := strings.Join(h[k], ", ")
+		if matchHeader(secretHeaders, strings.ToLower(k)) {
+			value = "***"
+		}
+		parts = append(parts, k+": "+value)
+	}
+	return strings.Join(parts, "; ")
+}
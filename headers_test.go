@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// headeredUpstream отвечает заголовками всех категорий: служебные соединения,
+// внутренние провайдера, лимиты и произвольные
+func headeredUpstream(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Type", "application/json")
+		h.Set("Keep-Alive", "timeout=5")
+		h.Set("Proxy-Authenticate", "Basic")
+		h.Set("Openai-Organization", "org-internal")
+		h.Set("Openai-Processing-Ms", "42")
+		h.Set("Set-Cookie", "__cf_bm=abc")
+		h.Set("X-Ratelimit-Remaining-Requests", "99")
+		h.Set("Retry-After", "1")
+		h.Set("X-Request-Id", "req_upstream")
+		h.Set("X-Custom", "1")
+		w.Write([]byte(`{}`))
+	})
+}
+
+// checkHeaders проверяет наличие (true) или отсутствие (false) заголовков в ответе
+func checkHeaders(t *testing.T, resp *http.Response, want map[string]bool) {
+	t.Helper()
+	for name, present := range want {
+		if got := resp.Header.Get(name) != ""; got != present {
+			t.Errorf("%s present = %v, want %v (value %q)", name, got, present, resp.Header.Get(name))
+		}
+	}
+}
+
+func TestResponseHeadersDefault(t *testing.T) {
+	headeredUpstream(t)
+	p := startProxy(t)
+
+	resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	// По умолчанию убираются только hop-by-hop
+	checkHeaders(t, resp, map[string]bool{
+		"Keep-Alive": false, "Proxy-Authenticate": false,
+		"Content-Type": true, "Openai-Organization": true, "Set-Cookie": true,
+		"X-Ratelimit-Remaining-Requests": true, "Retry-After": true, "X-Custom": true,
+	})
+}
+
+func TestResponseHeadersStripProviderInternal(t *testing.T) {
+	t.Setenv("PROXY_STRIP_PROVIDER_HEADERS", "true")
+	t.Setenv("PROXY_RESPONSE_HEADERS_DENY", "x-custom")
+	headeredUpstream(t)
+	p := startProxy(t)
+
+	resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	checkHeaders(t, resp, map[string]bool{
+		"Openai-Organization": false, "Openai-Processing-Ms": false, "Set-Cookie": false, "X-Custom": false,
+		"Content-Type": true, "X-Ratelimit-Remaining-Requests": true, "X-Request-Id": true,
+	})
+}
+
+func TestResponseHeadersAllowlist(t *testing.T) {
+	t.Setenv("PROXY_RESPONSE_HEADERS_ALLOW", "x-custom, openai-*")
+	t.Setenv("PROXY_RESPONSE_HEADERS_DENY", "openai-organization")
+	headeredUpstream(t)
+	p := startProxy(t)
+
+	resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	// Content-Type, лимиты и Retry-After передаются и вне allowlist; deny приоритетнее allow
+	checkHeaders(t, resp, map[string]bool{
+		"X-Custom": true, "Openai-Processing-Ms": true, "Openai-Organization": false,
+		"Content-Type": true, "X-Ratelimit-Remaining-Requests": true, "Retry-After": true,
+		"Set-Cookie": false, "Keep-Alive": false,
+	})
+}
+
+func TestDefaultAccept(t *testing.T) {
+	t.Setenv("OPENAI_DEFAULT_ACCEPT", "application/json")
+	var gotAccept []string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = append(gotAccept, r.Header.Get("Accept"))
+		w.Write([]byte(`{}`))
+	})
+	upstream(t, "deepseek", func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = append(gotAccept, r.Header.Get("Accept"))
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "")
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "Accept", "application/xml")
+	// У провайдера без DEFAULT_ACCEPT заголовок не появляется
+	p.do(t, http.MethodGet, "/deepseek/v1/models", "")
+	if len(gotAccept) != 3 || gotAccept[0] != "application/json" || gotAccept[1] != "application/xml" || gotAccept[2] != "" {
+		t.Fatalf("upstream Accept = %q", gotAccept)
+	}
+}
+
+func TestHeaderLimits(t *testing.T) {
+	t.Setenv("PROXY_MAX_HEADER_COUNT", "12")
+	t.Setenv("PROXY_MAX_HEADER_BYTES", "2048")
+	calls := 0
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Custom-1", "1"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("headers within limits: status %d", resp.StatusCode)
+	}
+	var many []string
+	for i := range 12 {
+		many = append(many, fmt.Sprintf("X-Custom-%d", i), "1")
+	}
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "", many...)
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge || !strings.Contains(body, "request headers too large") {
+		t.Fatalf("over count: status %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Big", strings.Repeat("a", 2048)); resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Fatalf("over size: status %d", resp.StatusCode)
+	}
+	if calls != 1 {
+		t.Fatalf("upstream calls = %d, want rejected requests not proxied", calls)
+	}
+}
+
+func TestHeaderLimitsApplyUpstreamCredentials(t *testing.T) {
+	t.Setenv("PROXY_MAX_HEADER_BYTES", "1024")
+	// Заголовки клиента в пределах, ключ провайдера - нет
+	t.Setenv("OPENAI_API_KEY", "sk-"+strings.Repeat("k", 2048))
+	calls := 0
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge || !strings.Contains(body, "upstream request headers too large") || calls != 0 {
+		t.Fatalf("status %d: %s, upstream calls %d", resp.StatusCode, body, calls)
+	}
+}
+
+func TestHeaderLimitsApplyUpstream(t *testing.T) {
+	t.Setenv("PROXY_MAX_HEADER_COUNT", "12")
+	// Хук дописывает заголовки к запросу провайдеру сверх предела
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := map[string][]string{}
+		for i := range 10 {
+			headers[fmt.Sprintf("X-Hook-%d", i)] = []string{"1"}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"headers": headers})
+	}))
+	t.Cleanup(hook.Close)
+	t.Setenv("PROXY_TRANSFORM_REQUEST_URL", hook.URL)
+	calls := 0
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge || !strings.Contains(body, "upstream request headers too large") || calls != 0 {
+		t.Fatalf("status %d: %s, upstream calls %d", resp.StatusCode, body, calls)
+	}
+}
+
+func TestLogHeadersMasksCredentials(t *testing.T) {
+	t.Setenv("PROXY_LOG_HEADERS", "true")
+	for _, provider := range []string{"openai", "anthropic"} {
+		upstream(t, provider, func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	}
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`,
+		"Authorization", "Bearer sk-client-mistake", "Cookie", "session=s3cret", "X-Trace", "abc")
+	p.do(t, http.MethodPost, "/anthropic/v1/messages", `{"model":"claude-3-5-haiku"}`, "x-api-key", "sk-ant-client")
+	out := logs.String()
+	for _, want := range []string{
+		"Authorization: ***", "X-Proxy-Auth: ***", "Cookie: ***", "X-Trace: abc",
+		"Upstream headers for anthropic: ", "X-Api-Key: ***",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in the log:\n%s", want, out)
+		}
+	}
+	// Ни токен прокси, ни ключи клиента и провайдеров в лог не попадают
+	for _, secret := range []string{testAuthToken, "sk-client-mistake", "s3cret", "sk-ant-client", "sk-openai-test", "sk-anthropic-test"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("%q leaked into the log:\n%s", secret, out)
+		}
+	}
+}
+
+func TestLogHeadersOffByDefault(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Trace", "abc")
+	if strings.Contains(logs.String(), "X-Trace") {
+		t.Fatalf("headers logged without PROXY_LOG_HEADERS:\n%s", logs)
+	}
+}
+
+func TestMaskedHeaders(t *testing.T) {
+	got := maskedHeaders(map[string][]string{
+		"X-Proxy-Auth":        {"tok"},
+		"authorization":       {"Bearer sk-1"},
+		"Proxy-Authorization": {"Basic x"},
+		"Accept":              {"application/json", "text/event-stream"},
+		"X-Api-Key":           {"sk-2"},
+	})
+	golden(t, "masked_headers", []byte(got+"\n"))
+}
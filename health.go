@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// errorRateThreshold - доля ошибок (%) в окне, при которой провайдер отключается (0 - выключено)
+	errorRateThreshold int
+	// errorRateWindow - длина скользящего окна, считается посекундными корзинами
+	errorRateWindow time.Duration
+	// errorRateMinRequests - меньше запросов в окне - решение не принимается
+	errorRateMinRequests int
+	// errorRateCooldown - сколько провайдер остаётся отключённым; потом окно начинается заново
+	errorRateCooldown time.Duration
+
+	health = map[string]*providerHealth{}
+)
+
+type healthBucket struct {
+	sec    int64
+	total  int64
+	errors int64
+}
+
+// providerHealth - скользящее окно ошибок провайдера (сетевые ошибки и 5xx)
+type providerHealth struct {
+	mu            sync.Mutex
+	buckets       []healthBucket
+	disabledUntil time.Time
+}
+
+func initHealth() {
+	errorRateThreshold = envInt("PROXY_ERROR_RATE_THRESHOLD", 0)
+	errorRateWindow = time.Duration(envInt("PROXY_ERROR_RATE_WINDOW_SEC", 60)) * time.Second
+	errorRateMinRequests = envInt("PROXY_ERROR_RATE_MIN_REQUESTS", 10)
+	errorRateCooldown = time.Duration(envInt("PROXY_ERROR_RATE_COOLDOWN_SEC", 30)) * time.Second
+
+	size := max(int(errorRateWindow/time.Second), 1)
+	for _, p := range providers {
+		health[p.Name] = &providerHealth{buckets: make([]healthBucket, size)}
+	}
+}
+
+// counts суммирует корзины, попадающие в окно; вызывается под mu
+func (h *providerHealth) counts(now time.Time) (total, errors int64) {
+	sec := now.Unix()
+	for _, b := range h.buckets {
+		if sec-b.sec < int64(len(h.buckets)) {
+			total += b.total
+			errors += b.errors
+		}
+	}
+	return total, errors
+}
+
+// observe учитывает результат запроса к провайдеру и отключает его при превышении порога
+func (h *providerHealth) observe(provider string, failed bool, now time.Time) {
+	if errorRateThreshold <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sec := now.Unix()
+	b := &h.buckets[sec%int64(len(h.buckets))]
+	if b.sec != sec {
+		*b = healthBucket{sec: sec}
+	}
+	b.total++
+	if failed {
+		b.errors++
+	}
+
+	if now.Before(h.disabledUntil) {
+		return
+	}
+	total, errors := h.counts(now)
+	if total >= int64(errorRateMinRequests) && errors*100 >= int64(errorRateThreshold)*total {
+		h.disabledUntil = now.Add(errorRateCooldown)
+		// После паузы окно начинается с чистого листа
+		for i := range h.buckets {
+			h.buckets[i] = healthBucket{}
+		}
+		log.Printf("WARN: provider %s disabled for %s: %d of %d requests failed in the last %s",
+			provider, errorRateCooldown, errors, total, errorRateWindow)
+	}
+}
+
+// healthy - провайдер не отключён по доле ошибок
+func (h *providerHealth) healthy(now time.Time) bool {
+	if errorRateThreshold <= 0 || h == nil {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !now.Before(h.disabledUntil)
+}
+
+// retryAfter - секунд до повторного включения
+func (h *providerHealth) retryAfter(now time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return max(int(h.disabledUntil.Sub(now).Seconds()+0.999), 1)
+}
+
+func (h *providerHealth) snapshot(now time.Time) fiber.Map {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	total, errors := h.counts(now)
+	rate := 0.0
+	if total > 0 {
+		rate = float64(errors) / float64(total)
+	}
+	result := fiber.Map{
+		"healthy":    !now.Before(h.disabledUntil),
+		"requests":   total,
+		"errors":     errors,
+		"error_rate": rate,
+	}
+	if now.Before(h.disabledUntil) {
+		result["disabled_until"] = h.disabledUntil.UTC().Format(time.RFC3339)
+	}
+	return result
+}
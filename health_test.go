@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestErrorRateDisablesAndRecovers(t *testing.T) {
+	t.Setenv("PROXY_ERROR_RATE_THRESHOLD", "50")
+	t.Setenv("PROXY_ERROR_RATE_MIN_REQUESTS", "4")
+	t.Setenv("PROXY_ERROR_RATE_WINDOW_SEC", "10")
+	t.Setenv("PROXY_ERROR_RATE_COOLDOWN_SEC", "30")
+	initHealth()
+	h := health["openai"]
+	now := time.Unix(1_700_000_000, 0)
+
+	// 1 ошибка из 3 - ниже минимума запросов; четвёртая даёт 2 из 4 = 50%
+	h.observe("openai", true, now)
+	h.observe("openai", false, now.Add(time.Second))
+	h.observe("openai", false, now.Add(2*time.Second))
+	if !h.healthy(now.Add(2 * time.Second)) {
+		t.Fatal("disabled below the minimum number of requests")
+	}
+	h.observe("openai", true, now.Add(3*time.Second))
+	if h.healthy(now.Add(3 * time.Second)) {
+		t.Fatal("not disabled at 50% errors")
+	}
+	if got := h.retryAfter(now.Add(3 * time.Second)); got != 30 {
+		t.Fatalf("retryAfter = %d, want 30", got)
+	}
+	// Восстановление после паузы, окно начинается заново
+	later := now.Add(34 * time.Second)
+	if !h.healthy(later) {
+		t.Fatal("not re-enabled after the cooldown")
+	}
+	if s := h.snapshot(later); s["requests"] != int64(0) || s["healthy"] != true {
+		t.Fatalf("snapshot after recovery = %v", s)
+	}
+}
+
+func TestErrorRateWindowDecay(t *testing.T) {
+	t.Setenv("PROXY_ERROR_RATE_THRESHOLD", "50")
+	t.Setenv("PROXY_ERROR_RATE_MIN_REQUESTS", "4")
+	t.Setenv("PROXY_ERROR_RATE_WINDOW_SEC", "10")
+	initHealth()
+	h := health["openai"]
+	now := time.Unix(1_700_000_000, 0)
+
+	// Старые ошибки выходят из окна и не складываются с новыми
+	for i := range 3 {
+		h.observe("openai", true, now.Add(time.Duration(i)*time.Second))
+	}
+	later := now.Add(20 * time.Second)
+	for i := range 4 {
+		h.observe("openai", i == 0, later.Add(time.Duration(i)*time.Second))
+	}
+	if !h.healthy(later.Add(4 * time.Second)) {
+		t.Fatalf("disabled by errors outside the window: %v", h.snapshot(later.Add(4*time.Second)))
+	}
+	if s := h.snapshot(later.Add(4 * time.Second)); s["requests"] != int64(4) || s["error_rate"] != 0.25 {
+		t.Fatalf("snapshot = %v", s)
+	}
+}
+
+func TestDisabledProviderRoutedAround(t *testing.T) {
+	t.Setenv("PROXY_ERROR_RATE_THRESHOLD", "50")
+	t.Setenv("PROXY_ERROR_RATE_MIN_REQUESTS", "3")
+	t.Setenv("PROXY_ERROR_RATE_COOLDOWN_SEC", "1")
+	t.Setenv("PROXY_DEFAULT_PROVIDER", "deepseek")
+	failing := true
+	calls := map[string]int{}
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls["openai"]++
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	upstream(t, "deepseek", func(w http.ResponseWriter, r *http.Request) {
+		calls["deepseek"]++
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	for range 3 {
+		p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	}
+	h := p.providerStat(t, "openai", "health").(map[string]any)
+	// Окно обнуляется при отключении, в /stats остаётся срок отключения
+	if h["healthy"] != false || h["disabled_until"] == nil {
+		t.Fatalf("health = %v", h)
+	}
+	// Прямой маршрут - 503 без запроса к провайдеру, единый - через провайдера по умолчанию
+	resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("disabled provider: status %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if resp, _ := p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"gpt-4o"}`); resp.StatusCode != http.StatusOK || calls["deepseek"] != 1 {
+		t.Fatalf("unified route: status %d, deepseek calls %d", resp.StatusCode, calls["deepseek"])
+	}
+	if calls["openai"] != 3 {
+		t.Fatalf("disabled provider was called: %d calls", calls["openai"])
+	}
+
+	// После паузы провайдер снова принимает запросы
+	failing = false
+	time.Sleep(1100 * time.Millisecond)
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`); resp.StatusCode != http.StatusOK || calls["openai"] != 4 {
+		t.Fatalf("after cooldown: status %d, openai calls %d", resp.StatusCode, calls["openai"])
+	}
+}
+
+func TestBreakerOpenRetryAfter(t *testing.T) {
+	t.Setenv("PROXY_ERROR_RATE_THRESHOLD", "50")
+	t.Setenv("PROXY_ERROR_RATE_MIN_REQUESTS", "2")
+	t.Setenv("PROXY_ERROR_RATE_COOLDOWN_SEC", "30")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	p := startProxy(t)
+
+	for range 2 {
+		p.do(t, http.MethodGet, "/openai/v1/models", "")
+	}
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", resp.StatusCode)
+	}
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || retryAfter < 29 || retryAfter > 30 {
+		t.Fatalf("Retry-After = %q, want the ~30s cooldown remaining", resp.Header.Get("Retry-After"))
+	}
+	var out struct {
+		Type, Provider string
+		RetryAfter     int `json:"retry_after"`
+	}
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Type != "provider_unavailable" || out.Provider != "openai" || out.RetryAfter != retryAfter {
+		t.Fatalf("body = %s, Retry-After %d", body, retryAfter)
+	}
+}
+
+func TestRetryAfterCountsDown(t *testing.T) {
+	t.Setenv("PROXY_ERROR_RATE_THRESHOLD", "50")
+	t.Setenv("PROXY_ERROR_RATE_MIN_REQUESTS", "1")
+	t.Setenv("PROXY_ERROR_RATE_COOLDOWN_SEC", "30")
+	initHealth()
+	h := health["openai"]
+	now := time.Unix(1_700_000_000, 0)
+	h.observe("openai", true, now)
+
+	// Остаток паузы округляется вверх и не бывает меньше секунды
+	for _, c := range []struct {
+		after time.Duration
+		want  int
+	}{{0, 30}, {10 * time.Second, 20}, {29500 * time.Millisecond, 1}, {29999 * time.Millisecond, 1}} {
+		if got := h.retryAfter(now.Add(c.after)); got != c.want {
+			t.Errorf("retryAfter after %s = %d, want %d", c.after, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeyState - ключ провайдера и последние увиденные значения rate-limit заголовков
+type apiKeyState struct {
+	index int
+	value string
+
+	mu                sync.Mutex
+	remainingRequests int // -1 - неизвестно
+	remainingTokens   int // -1 - неизвестно
+	resetRequestsAt   time.Time
+	resetTokensAt     time.Time
+}
+
+// observe запоминает x-ratelimit-* заголовки ответа (формат OpenAI)
+func (k *apiKeyState) observe(h http.Header) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	if v, err := strconv.Atoi(h.Get("x-ratelimit-remaining-requests")); err == nil {
+		k.remainingRequests = v
+	}
+	if v, err := strconv.Atoi(h.Get("x-ratelimit-remaining-tokens")); err == nil {
+		k.remainingTokens = v
+	}
+	if d, err := time.ParseDuration(h.Get("x-ratelimit-reset-requests")); err == nil {
+		k.resetRequestsAt = now.Add(d)
+	}
+	if d, err := time.ParseDuration(h.Get("x-ratelimit-reset-tokens")); err == nil {
+		k.resetTokensAt = now.Add(d)
+	}
+}
+
+// id - обезличенный идентификатор ключа для логов: номер в пуле и начало SHA-256 значения
+// (номер сам по себе неоднозначен - у пулов <PROVIDER>_MODEL_KEYS своя нумерация)
+func (k *apiKeyState) id() string {
+	sum := sha256.Sum256([]byte(k.value))
+	return "#" + strconv.Itoa(k.index) + ":" + hex.EncodeToString(sum[:2])
+}
+
+// nearExhausted - ключ почти исчерпал лимит и окно ещё не сбросилось
+func (k *apiKeyState) nearExhausted(minRemaining int, now time.Time) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.remainingRequests >= 0 && k.remainingRequests <= minRemaining && now.Before(k.resetRequestsAt) {
+		return true
+	}
+	if k.remainingTokens >= 0 && k.remainingTokens <= minRemaining && now.Before(k.resetTokensAt) {
+		return true
+	}
+	return false
+}
+
+func (k *apiKeyState) snapshot(minRemaining int) map[string]any {
+	throttled := k.nearExhausted(minRemaining, time.Now())
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	s := map[string]any{
+		"key":                "#" + strconv.Itoa(k.index),
+		"remaining_requests": k.remainingRequests,
+		"remaining_tokens":   k.remainingTokens,
+		"near_exhausted":     throttled,
+	}
+	if !k.resetRequestsAt.IsZero() {
+		s["reset_requests_at"] = k.resetRequestsAt.UTC().Format(time.RFC3339)
+	}
+	if !k.resetTokensAt.IsZero() {
+		s["reset_tokens_at"] = k.resetTokensAt.UTC().Format(time.RFC3339)
+	}
+	return s
+}
+
+// keyPool - набор ключей провайдера с ротацией round-robin
+type keyPool struct {
+	mu   sync.Mutex
+	keys []*apiKeyState
+	next int
+}
+
+// newKeyPool разбирает значение env, ключи перечисляются через запятую
+func newKeyPool(raw string) *keyPool {
+	p := &keyPool{}
+	for _, v := range strings.Split(raw, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		p.keys = append(p.keys, &apiKeyState{
+			index:             len(p.keys),
+			value:             v,
+			remainingRequests: -1,
+			remainingTokens:   -1,
+		})
+	}
+	return p
+}
+
+// pick выбирает следующий ключ; при включённом троттлинге почти исчерпанные ключи пропускаются,
+// если есть другие. nil - ключей нет.
+func (p *keyPool) pick() *apiKeyState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.keys) == 0 {
+		return nil
+	}
+
+	start := p.next
+	p.next = (p.next + 1) % len(p.keys)
+
+	if rateLimitThrottle && len(p.keys) > 1 {
+		now := time.Now()
+		for i := 0; i < len(p.keys); i++ {
+			k := p.keys[(start+i)%len(p.keys)]
+			if !k.nearExhausted(rateLimitMinRemaining, now) {
+				p.next = (k.index + 1) % len(p.keys)
+				return k
+			}
+		}
+	}
+	return p.keys[start]
+}
+
+func (p *keyPool) snapshot() []map[string]any {
+	result := make([]map[string]any, 0, len(p.keys))
+	for _, k := range p.keys {
+		result = append(result, k.snapshot(rateLimitMinRemaining))
+	}
+	return result
+}
+
+var (
+	// rateLimitThrottle - обходить ключи, близкие к лимиту по x-ratelimit-* заголовкам
+	rateLimitThrottle bool
+	// rateLimitMinRemaining - остаток, при котором ключ считается почти исчерпанным
+	rateLimitMinRemaining int
+)
+
+func initRateLimitThrottle() {
+	rateLimitThrottle = envBool("PROXY_RATELIMIT_THROTTLE", false)
+	rateLimitMinRemaining = envInt("PROXY_RATELIMIT_MIN_REMAINING", 1)
+}
+
+// modelKeyRule - отдельный пул ключей для моделей с заданным префиксом (<PROVIDER>_MODEL_KEYS)
+type modelKeyRule struct {
+	prefix string
+	keys   *keyPool
+}
+
+// parseModelKeyRules разбирает "префикс модели=ИМЯ_ENV,...": ключи берутся из указанной
+// переменной (секреты, затем окружение), чтобы не держать их в строке правил
+func parseModelKeyRules(raw, secrets map[string]string) []modelKeyRule {
+	var rules []modelKeyRule
+	for prefix, env := range raw {
+		pool := newKeyPool(providerKeys(secrets, env))
+		if len(pool.keys) == 0 {
+			log.Printf("WARN: model key rule %s: %s is not set, rule ignored", prefix, env)
+			continue
+		}
+		rules = append(rules, modelKeyRule{prefix: prefix, keys: pool})
+	}
+	// Длинный префикс приоритетнее
+	sort.Slice(rules, func(i, j int) bool { return len(rules[i].prefix) > len(rules[j].prefix) })
+	return rules
+}
+
+// keysFor возвращает пул ключей для модели; без подходящего правила - основной пул провайдера
+func (p *provider) keysFor(model string) *keyPool {
+	if model != "" {
+		for _, r := range p.modelKeys {
+			if strings.HasPrefix(model, r.prefix) {
+				return r.keys
+			}
+		}
+	}
+	return p.keys
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rateLimitedUpstream отвечает ключу sk-low "осталось 0 запросов", остальным - запас
+func rateLimitedUpstream(t *testing.T, used *[]string) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		*used = append(*used, key)
+		remaining := "500"
+		if key == "sk-low" {
+			remaining = "0"
+		}
+		w.Header().Set("x-ratelimit-remaining-requests", remaining)
+		w.Header().Set("x-ratelimit-reset-requests", "1m0s")
+		w.Write([]byte(`{}`))
+	})
+}
+
+func TestNearExhaustedKeyIsDeprioritized(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-low,sk-high")
+	t.Setenv("PROXY_RATELIMIT_THROTTLE", "true")
+	t.Setenv("PROXY_RATELIMIT_MIN_REMAINING", "1")
+	var used []string
+	rateLimitedUpstream(t, &used)
+	p := startProxy(t)
+
+	for range 4 {
+		p.do(t, http.MethodGet, "/openai/v1/models", "")
+	}
+	// Первый запрос узнаёт об исчерпании sk-low, дальше ротация его обходит
+	if want := "sk-low sk-high sk-high sk-high"; strings.Join(used, " ") != want {
+		t.Fatalf("keys used = %v, want %s", used, want)
+	}
+
+	keys := p.providerStat(t, "openai", "keys").([]any)
+	low := keys[0].(map[string]any)
+	if low["near_exhausted"] != true || low["remaining_requests"] != float64(0) || low["reset_requests_at"] == nil {
+		t.Fatalf("stats of the exhausted key = %v", low)
+	}
+	if high := keys[1].(map[string]any); high["near_exhausted"] != false || high["remaining_requests"] != float64(500) {
+		t.Fatalf("stats of the healthy key = %v", high)
+	}
+}
+
+func TestRateLimitThrottleOffByDefault(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-low,sk-high")
+	var used []string
+	rateLimitedUpstream(t, &used)
+	p := startProxy(t)
+
+	for range 4 {
+		p.do(t, http.MethodGet, "/openai/v1/models", "")
+	}
+	if want := "sk-low sk-high sk-low sk-high"; strings.Join(used, " ") != want {
+		t.Fatalf("keys used = %v, want plain round-robin %s", used, want)
+	}
+}
+
+func TestKeyWindowReset(t *testing.T) {
+	k := newKeyPool("sk-a").keys[0]
+	h := http.Header{}
+	h.Set("x-ratelimit-remaining-tokens", "0")
+	h.Set("x-ratelimit-reset-tokens", "20ms")
+	k.observe(h)
+
+	now := time.Now()
+	if !k.nearExhausted(1, now) {
+		t.Fatal("key with 0 remaining tokens is not near exhausted")
+	}
+	// После сброса окна ключ снова доступен, даже без новых заголовков
+	if k.nearExhausted(1, now.Add(time.Second)) {
+		t.Fatal("key is still near exhausted after the reset time")
+	}
+}
+
+func TestModelKeySelection(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-default")
+	t.Setenv("OPENAI_KEY_GPT4O", "sk-gpt4o")
+	t.Setenv("OPENAI_KEY_MINI", "sk-mini-1,sk-mini-2")
+	t.Setenv("OPENAI_MODEL_KEYS", "gpt-4o=OPENAI_KEY_GPT4O,gpt-4o-mini=OPENAI_KEY_MINI,gpt-3.5=OPENAI_KEY_UNSET")
+	var used []string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		used = append(used, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		w.Write([]byte(`{}`))
+	})
+	logs := captureLog(t)
+	p := startProxy(t)
+
+	for _, model := range []string{"gpt-4o", "gpt-4o-mini", "gpt-4o-mini-2024-07-18", "gpt-3.5-turbo", "o3"} {
+		p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"`+model+`"}`)
+	}
+	p.do(t, http.MethodGet, "/openai/v1/models", "")
+	// Длинный префикс приоритетнее; правило без ключей и модели без правила - основной ключ
+	if want := "sk-gpt4o sk-mini-1 sk-mini-2 sk-default sk-default sk-default"; strings.Join(used, " ") != want {
+		t.Fatalf("keys used = %v, want %s", used, want)
+	}
+	if !strings.Contains(logs.String(), "WARN: model key rule gpt-3.5: OPENAI_KEY_UNSET is not set, rule ignored") {
+		t.Fatalf("no warning for the rule without keys:\n%s", logs)
+	}
+}
+
+// servedBy - ожидаемый X-Proxy-Served-By для ключа value с номером index
+func servedBy(provider string, index int, value string) string {
+	return provider + "/" + (&apiKeyState{index: index, value: value}).id()
+}
+
+func TestServedByReflectsSelectedKey(t *testing.T) {
+	t.Setenv("PROXY_SERVED_BY_HEADER", "true")
+	t.Setenv("OPENAI_API_KEY", "sk-first,sk-second")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	var got []string
+	for range 3 {
+		resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "")
+		got = append(got, resp.Header.Get("X-Proxy-Served-By"))
+	}
+	want := []string{servedBy("openai", 0, "sk-first"), servedBy("openai", 1, "sk-second"), servedBy("openai", 0, "sk-first")}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("X-Proxy-Served-By = %q, want %q", got, want)
+	}
+	if !regexp.MustCompile(`^openai/#0:[0-9a-f]{4}$`).MatchString(got[0]) {
+		t.Fatalf("served-by format: %q", got[0])
+	}
+	out := logs.String()
+	if !strings.Contains(out, "key="+strings.TrimPrefix(want[1], "openai/")) {
+		t.Fatalf("key id is not logged:\n%s", out)
+	}
+	// Значение ключа не попадает ни в заголовок, ни в лог
+	if strings.Contains(out, "sk-first") || strings.Contains(out, "sk-second") {
+		t.Fatalf("key value leaked into the log:\n%s", out)
+	}
+}
+
+func TestServedByAfterFailover(t *testing.T) {
+	t.Setenv("PROXY_SERVED_BY_HEADER", "true")
+	t.Setenv("PROXY_ERROR_RATE_THRESHOLD", "50")
+	t.Setenv("PROXY_ERROR_RATE_MIN_REQUESTS", "2")
+	t.Setenv("PROXY_DEFAULT_PROVIDER", "deepseek")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) })
+	upstream(t, "deepseek", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	resp, _ := p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if got := resp.Header.Get("X-Proxy-Served-By"); got != servedBy("openai", 0, "sk-openai-test") {
+		t.Fatalf("before failover: X-Proxy-Served-By = %q", got)
+	}
+	p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"gpt-4o"}`)
+	// OpenAI отключён по доле ошибок - тот же запрос обслуживает провайдер по умолчанию
+	resp, _ = p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Proxy-Served-By") != servedBy("deepseek", 0, "sk-deepseek-test") {
+		t.Fatalf("after failover: status %d, X-Proxy-Served-By = %q", resp.StatusCode, resp.Header.Get("X-Proxy-Served-By"))
+	}
+}
+
+func TestServedByHeaderOffByDefault(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.Header.Get("X-Proxy-Served-By") != "" {
+		t.Fatalf("X-Proxy-Served-By = %q without PROXY_SERVED_BY_HEADER", resp.Header.Get("X-Proxy-Served-By"))
+	}
+}
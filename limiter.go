@@ -0,0 +1,185 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// concurrencyLimiter ограничивает число одновременных запросов к провайдеру
+type concurrencyLimiter struct {
+	slots        chan struct{} // nil - без ограничения
+	queueTimeout time.Duration // 0 - сразу отказ при заполнении
+	maxQueue     int64         // предел ожидающих в очереди, 0 - без ограничения
+	inFlight     atomic.Int64
+	rejected     atomic.Int64
+
+	// Метрики очереди: текущая глубина, время ожидания допущенных запросов, отказы по причинам
+	queued          atomic.Int64
+	waitCount       atomic.Int64
+	waitNanos       atomic.Int64
+	waitBuckets     [len(queueWaitBuckets) + 1]atomic.Int64
+	rejectedByLimit atomic.Int64 // очередь выключена
+	rejectedFull    atomic.Int64 // очередь заполнена
+	rejectedTimeout atomic.Int64 // истекло ожидание в очереди
+}
+
+// queueWaitBuckets - верхние границы корзин гистограммы ожидания; последняя корзина - +Inf
+var queueWaitBuckets = [...]time.Duration{
+	10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second,
+}
+
+func newConcurrencyLimiter(max int, queueTimeout time.Duration, maxQueue int) *concurrencyLimiter {
+	l := &concurrencyLimiter{queueTimeout: queueTimeout, maxQueue: int64(maxQueue)}
+	if max > 0 {
+		l.slots = make(chan struct{}, max)
+	}
+	return l
+}
+
+// acquire занимает слот; false - лимит исчерпан (с учётом ожидания в очереди)
+func (l *concurrencyLimiter) acquire() bool {
+	if l.slots != nil {
+		start := time.Now()
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if l.queueTimeout <= 0 {
+				l.reject(&l.rejectedByLimit)
+				return false
+			}
+			if depth := l.queued.Add(1); l.maxQueue > 0 && depth > l.maxQueue {
+				l.queued.Add(-1)
+				l.reject(&l.rejectedFull)
+				return false
+			}
+			timer := time.NewTimer(l.queueTimeout)
+			defer timer.Stop()
+			select {
+			case l.slots <- struct{}{}:
+				l.queued.Add(-1)
+			case <-timer.C:
+				l.queued.Add(-1)
+				l.reject(&l.rejectedTimeout)
+				return false
+			}
+		}
+		l.observeWait(time.Since(start))
+	}
+	l.inFlight.Add(1)
+	return true
+}
+
+func (l *concurrencyLimiter) reject(reason *atomic.Int64) {
+	l.rejected.Add(1)
+	reason.Add(1)
+}
+
+func (l *concurrencyLimiter) observeWait(d time.Duration) {
+	l.waitCount.Add(1)
+	l.waitNanos.Add(int64(d))
+	i := 0
+	for i < len(queueWaitBuckets) && d > queueWaitBuckets[i] {
+		i++
+	}
+	l.waitBuckets[i].Add(1)
+}
+
+// queueSnapshot - метрики очереди для /stats; корзины кумулятивные, как у Prometheus (le)
+func (l *concurrencyLimiter) queueSnapshot() fiber.Map {
+	count := l.waitCount.Load()
+	avg := 0.0
+	if count > 0 {
+		avg = float64(l.waitNanos.Load()) / float64(count) / 1e6
+	}
+	buckets := fiber.Map{}
+	var cumulative int64
+	for i := range l.waitBuckets {
+		cumulative += l.waitBuckets[i].Load()
+		le := "+Inf"
+		if i < len(queueWaitBuckets) {
+			le = strconv.FormatInt(queueWaitBuckets[i].Milliseconds(), 10)
+		}
+		buckets[le] = cumulative
+	}
+	return fiber.Map{
+		"depth":          l.queued.Load(),
+		"max_queue_size": l.maxQueue,
+		"wait": fiber.Map{
+			"count":      count,
+			"avg_ms":     avg,
+			"buckets_ms": buckets,
+		},
+		"rejected": fiber.Map{
+			"concurrency": l.rejectedByLimit.Load(),
+			"queue_full":  l.rejectedFull.Load(),
+			"timeout":     l.rejectedTimeout.Load(),
+		},
+	}
+}
+
+func (l *concurrencyLimiter) release() {
+	l.inFlight.Add(-1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// limiters - лимитеры по имени провайдера, заполняются при старте
+var limiters = map[string]*concurrencyLimiter{}
+
+// initLimiters читает <PROVIDER>_MAX_CONCURRENCY, <PROVIDER>_QUEUE_TIMEOUT_MS и <PROVIDER>_MAX_QUEUE_SIZE
+func initLimiters() {
+	for _, p := range providers {
+		prefix := envPrefix(p.Name)
+		limiters[p.Name] = newConcurrencyLimiter(
+			envInt(prefix+"MAX_CONCURRENCY", 0),
+			time.Duration(envInt(prefix+"QUEUE_TIMEOUT_MS", 0))*time.Millisecond,
+			envInt(prefix+"MAX_QUEUE_SIZE", 0),
+		)
+	}
+}
+
+// ipLimiter ограничивает число одновременных запросов с одного IP клиента
+type ipLimiter struct {
+	max int // 0 - без ограничения
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// ipConns - лимит PROXY_MAX_CONNECTIONS_PER_IP; IP определяется с учётом PROXY_TRUSTED_PROXIES
+var ipConns = &ipLimiter{}
+
+func initIPLimiter() {
+	ipConns = &ipLimiter{max: envInt("PROXY_MAX_CONNECTIONS_PER_IP", 0), active: map[string]int{}}
+}
+
+// acquire занимает слот IP; false - у IP уже max активных запросов
+func (l *ipLimiter) acquire(ip string) bool {
+	if l.max <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[ip] >= l.max {
+		return false
+	}
+	l.active[ip]++
+	return true
+}
+
+func (l *ipLimiter) release(ip string) {
+	if l.max <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[ip]--; l.active[ip] <= 0 {
+		delete(l.active, ip)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// blockingUpstream держит запросы, пока тест не закроет release; entered - по запросу на вход
+func blockingUpstream(t *testing.T, provider string) (entered chan struct{}, release chan struct{}) {
+	entered, release = make(chan struct{}, 10), make(chan struct{})
+	upstream(t, provider, func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		w.Write([]byte(`{}`))
+	})
+	return entered, release
+}
+
+func TestProviderConcurrencyLimitsAreIndependent(t *testing.T) {
+	t.Setenv("OPENAI_MAX_CONCURRENCY", "1")
+	t.Setenv("DEEPSEEK_MAX_CONCURRENCY", "1")
+	entered, release := blockingUpstream(t, "openai")
+	upstream(t, "deepseek", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	done := make(chan int)
+	go func() {
+		resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "")
+		done <- resp.StatusCode
+	}()
+	<-entered
+
+	if got := p.providerStat(t, "openai", "in_flight"); got != float64(1) {
+		t.Fatalf("openai in_flight = %v, want 1", got)
+	}
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("second openai request: status %d %s, want 503", resp.StatusCode, body)
+	}
+	// Занятый лимит OpenAI не влияет на DeepSeek
+	if resp, body := p.do(t, http.MethodGet, "/deepseek/v1/models", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("deepseek request: status %d %s", resp.StatusCode, body)
+	}
+	if got := p.providerStat(t, "openai", "rejected"); got != float64(1) {
+		t.Fatalf("openai rejected = %v, want 1", got)
+	}
+	if got := p.providerStat(t, "deepseek", "rejected"); got != float64(0) {
+		t.Fatalf("deepseek rejected = %v, want 0", got)
+	}
+
+	close(release)
+	if status := <-done; status != http.StatusOK {
+		t.Fatalf("first openai request: status %d", status)
+	}
+	if got := p.providerStat(t, "openai", "in_flight"); got != float64(0) {
+		t.Fatalf("openai in_flight after release = %v", got)
+	}
+}
+
+func TestConcurrencyLimiterQueue(t *testing.T) {
+	l := newConcurrencyLimiter(1, 200*time.Millisecond, 0)
+	if !l.acquire() {
+		t.Fatal("first acquire failed")
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		l.release()
+	}()
+	// Слот освобождается раньше таймаута очереди - запрос дожидается его
+	if !l.acquire() {
+		t.Fatal("queued acquire failed")
+	}
+	start := time.Now()
+	if l.acquire() {
+		t.Fatal("acquire succeeded while the slot is busy")
+	}
+	if waited := time.Since(start); waited < 150*time.Millisecond {
+		t.Fatalf("rejected after %s, want to wait for the queue timeout", waited)
+	}
+}
+
+func TestConcurrencyLimiterUnlimited(t *testing.T) {
+	l := newConcurrencyLimiter(0, 0, 0)
+	for range 100 {
+		if !l.acquire() {
+			t.Fatal("unlimited limiter rejected a request")
+		}
+	}
+	if l.inFlight.Load() != 100 {
+		t.Fatalf("in_flight = %d", l.inFlight.Load())
+	}
+}
+
+// queueStat - метрика очереди провайдера из /stats по пути ключей ("rejected", "timeout")
+func (p *testProxy) queueStat(t *testing.T, provider string, keys ...string) any {
+	t.Helper()
+	return p.providerStat(t, provider, append([]string{"queue"}, keys...)...)
+}
+
+func TestQueueMetrics(t *testing.T) {
+	t.Setenv("OPENAI_MAX_CONCURRENCY", "1")
+	t.Setenv("OPENAI_QUEUE_TIMEOUT_MS", "5000")
+	t.Setenv("OPENAI_MAX_QUEUE_SIZE", "1")
+	entered, release := blockingUpstream(t, "openai")
+	p := startProxy(t)
+
+	done := make(chan int, 2)
+	for range 2 {
+		go func() {
+			resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "")
+			done <- resp.StatusCode
+		}()
+	}
+	<-entered
+	waitFor(t, "a queued request", func() bool { return p.queueStat(t, "openai", "depth") == float64(1) })
+
+	// Очередь заполнена - отказ сразу, без ожидания
+	if resp, body := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("over the queue size: status %d %s", resp.StatusCode, body)
+	}
+	close(release)
+	for range 2 {
+		if status := <-done; status != http.StatusOK {
+			t.Fatalf("admitted request: status %d", status)
+		}
+	}
+
+	q := p.queueStat(t, "openai").(map[string]any)
+	wait := q["wait"].(map[string]any)
+	if q["depth"] != float64(0) || q["max_queue_size"] != float64(1) || wait["count"] != float64(2) ||
+		wait["buckets_ms"].(map[string]any)["+Inf"] != float64(2) {
+		t.Fatalf("queue = %v", q)
+	}
+	if want := map[string]any{"concurrency": float64(0), "queue_full": float64(1), "timeout": float64(0)}; !sameJSON(q["rejected"], want) {
+		t.Fatalf("rejected = %v, want %v", q["rejected"], want)
+	}
+	if p.providerStat(t, "openai", "rejected") != float64(1) {
+		t.Fatalf("total rejected = %v", p.providerStat(t, "openai", "rejected"))
+	}
+}
+
+func TestQueueRejectionReasons(t *testing.T) {
+	for _, c := range []struct{ timeout, reason string }{{"", "concurrency"}, {"30", "timeout"}} {
+		t.Run(c.reason, func(t *testing.T) {
+			t.Setenv("OPENAI_MAX_CONCURRENCY", "1")
+			t.Setenv("OPENAI_QUEUE_TIMEOUT_MS", c.timeout)
+			entered, release := blockingUpstream(t, "openai")
+			p := startProxy(t)
+
+			done := make(chan struct{})
+			go func() {
+				p.do(t, http.MethodGet, "/openai/v1/models", "")
+				close(done)
+			}()
+			<-entered
+			if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.StatusCode != http.StatusServiceUnavailable {
+				t.Fatalf("status %d, want 503", resp.StatusCode)
+			}
+			close(release)
+			<-done
+
+			if got := p.queueStat(t, "openai", "rejected", c.reason); got != float64(1) {
+				t.Fatalf("rejected %s = %v: %v", c.reason, got, p.queueStat(t, "openai"))
+			}
+			if got := p.queueStat(t, "openai", "depth"); got != float64(0) {
+				t.Fatalf("depth after the rejection = %v", got)
+			}
+		})
+	}
+}
+
+func TestQueueWaitHistogram(t *testing.T) {
+	l := newConcurrencyLimiter(1, time.Second, 0)
+	for _, d := range []time.Duration{0, 10 * time.Millisecond, 30 * time.Millisecond, 700 * time.Millisecond, 3 * time.Second, 10 * time.Second} {
+		l.observeWait(d)
+	}
+	l.reject(&l.rejectedTimeout)
+	data, _ := json.MarshalIndent(l.queueSnapshot(), "", "  ")
+	golden(t, "queue_snapshot", append(data, '\n'))
+}
+
+// sameJSON сравнивает значения после кодирования в JSON
+func sameJSON(a, b any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+func TestPerIPConnectionLimit(t *testing.T) {
+	t.Setenv("PROXY_MAX_CONNECTIONS_PER_IP", "2")
+	entered, release := blockingUpstream(t, "openai")
+	p := startProxy(t)
+
+	done := make(chan int, 2)
+	for range 2 {
+		go func() {
+			resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "")
+			done <- resp.StatusCode
+		}()
+		<-entered
+	}
+	// Третий одновременный запрос с того же IP отклоняется без обращения к провайдеру
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("third request: status %d %s, want 429", resp.StatusCode, body)
+	}
+
+	// Завершённый запрос освобождает слот
+	release <- struct{}{}
+	if status := <-done; status != http.StatusOK {
+		t.Fatalf("first request: status %d", status)
+	}
+	go func() {
+		resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "")
+		done <- resp.StatusCode
+	}()
+	<-entered
+	close(release)
+	for range 2 {
+		if status := <-done; status != http.StatusOK {
+			t.Fatalf("request after a freed slot: status %d", status)
+		}
+	}
+}
+
+func TestPerIPLimitReleasedOnStreamDisconnect(t *testing.T) {
+	t.Setenv("PROXY_MAX_CONNECTIONS_PER_IP", "1")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for {
+			if _, err := w.Write([]byte("data: {}\n\n")); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	})
+	p := startProxy(t)
+
+	resp, err := http.DefaultClient.Do(p.newRequest(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`,
+		"Accept", "text/event-stream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Поток держит слот IP
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("request during the stream: status %d, want 429", resp.StatusCode)
+	}
+	resp.Body.Close()
+	waitFor(t, "the slot of the closed stream", func() bool {
+		resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "")
+		return resp.StatusCode != http.StatusTooManyRequests
+	})
+}
+
+func TestPerIPLimitUsesTrustedForwardedIP(t *testing.T) {
+	t.Setenv("PROXY_MAX_CONNECTIONS_PER_IP", "1")
+	t.Setenv("PROXY_TRUSTED_PROXIES", "127.0.0.1")
+	entered, release := blockingUpstream(t, "openai")
+	p := startProxy(t)
+
+	done := make(chan int, 1)
+	go func() {
+		resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Forwarded-For", "203.0.113.1")
+		done <- resp.StatusCode
+	}()
+	<-entered
+	// За балансировщиком у клиентов один адрес соединения, но разные IP в X-Forwarded-For
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Forwarded-For", "203.0.113.1"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("same client IP: status %d, want 429", resp.StatusCode)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Forwarded-For", "203.0.113.2"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("another client IP: status %d, want 200", resp.StatusCode)
+	}
+	<-done
+}
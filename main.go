@@ -4,16 +4,22 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 )
 
 const (
@@ -37,92 +43,654 @@ var httpClient = &http.Client{
 }
 
 func main() {
-	app := fiber.New(fiber.Config{
+	// ai_proxy encrypt-key: шифрование ключа провайдера для provider_keys токена
+	if len(os.Args) > 1 && os.Args[1] == "encrypt-key" {
+		if err := runEncryptKey(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	app, adminApp := newApp()
+	reg := currentRegistry()
+	watchSecretsReload()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	// Проверяем ключи провайдеров до старта, а не на первом запросе
+	if err := validateProviderKeys(reg, envBool("PROXY_STRICT_CONFIG", false), envBool("PROXY_SKIP_UNCONFIGURED_PROVIDERS", false)); err != nil {
+		log.Fatal(err)
+	}
+
+	// Самопроверка ключей и связности до приёма трафика
+	if envBool("PROXY_SELFTEST", false) {
+		ok := runSelfTest(app, reg, envBool("PROXY_ENABLE_MOCK", false))
+		if envBool("PROXY_SELFTEST_EXIT", false) {
+			if !ok {
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+
+	log.Printf("LLM Proxy starting on port %s", port)
+
+	serve(app, ":"+port, adminApp, os.Getenv("PROXY_ADMIN_ADDR"))
+}
+
+// newApp собирает приложение по переменным окружения: middleware, маршруты провайдеров
+// и служебные эндпоинты. adminApp - отдельный сервер для PROXY_ADMIN_ADDR, иначе nil.
+func newApp() (app, adminApp *fiber.App) {
+	// 100MB по умолчанию - увеличено для больших запросов
+	bodyLimit = envInt("PROXY_BODY_LIMIT_MB", 100) * 1024 * 1024
+	streamRequestBodies = envBool("PROXY_STREAM_REQUEST_BODY", false)
+
+	app = fiber.New(fiber.Config{
 		ReadTimeout:       720 * time.Second,
 		WriteTimeout:      720 * time.Second,
 		IdleTimeout:       720 * time.Second,
-		BodyLimit:         100 * 1024 * 1024, // 100MB - увеличено для больших запросов
+		BodyLimit:         bodyLimit,
 		StreamRequestBody: true,
-		ReadBufferSize:    64 * 1024, // 64KB
-		WriteBufferSize:   64 * 1024, // 64KB
+		// multipart не разбираем - тело загрузки передаётся провайдеру потоком
+		DisablePreParseMultipartForm: true,
+		ReadBufferSize:               64 * 1024, // 64KB
+		WriteBufferSize:              64 * 1024, // 64KB
+		ErrorHandler:                 errorHandler,
 	})
 
 	// Middleware
-	app.Use(recover.New())
-	app.Use(logger.New(logger.Config{
-		Format: "[${time}] ${status} - ${method} ${path} ${latency}\n",
+	app.Use(requestid.New())
+	app.Use(recover.New(recover.Config{
+		EnableStackTrace:  true,
+		StackTraceHandler: logPanic,
 	}))
+	if envBool("PROXY_ACCESS_LOG", true) {
+		app.Use(logger.New(logger.Config{
+			Format: "[${time}] ${status} - ${method} ${path} ${latency} ${locals:requestid} ${locals:served_by}\n",
+		}))
+	}
 
-	// Auth middleware
-	authToken := os.Getenv("PROXY_AUTH_TOKEN")
-	if authToken == "" {
-		log.Fatal("PROXY_AUTH_TOKEN must be set")
+	// Формат собственных ошибок прокси
+	openAIErrors = false
+	switch format := strings.ToLower(strings.TrimSpace(os.Getenv("PROXY_ERROR_FORMAT"))); format {
+	case "", "simple":
+	case "openai":
+		openAIErrors = true
+		app.Use(errorFormatMiddleware)
+	default:
+		log.Fatalf("PROXY_ERROR_FORMAT: unknown format %q", format)
 	}
 
-	app.Use(func(c *fiber.Ctx) error {
-		token := c.Get("X-Proxy-Auth")
-		if token != authToken {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Unauthorized",
-			})
-		}
-		return c.Next()
-	})
+	// Лимит тела по Content-Length - до авторизации и чтения тела
+	app.Use(bodyLimitMiddleware)
+
+	// Ограничение заголовков запроса: проверяется до авторизации
+	maxHeaderCount = envInt("PROXY_MAX_HEADER_COUNT", 0)
+	maxHeaderBytes = envInt("PROXY_MAX_HEADER_BYTES", 0)
+	if maxHeaderCount > 0 || maxHeaderBytes > 0 {
+		app.Use(headerLimitsMiddleware)
+	}
+
+	// Только HTTPS: X-Forwarded-Proto учитывается лишь от PROXY_TRUSTED_PROXIES
+	requireTLS = envBool("PROXY_REQUIRE_TLS", false)
+	if requireTLS {
+		app.Use(requireTLSMiddleware)
+	}
+
+	serverTiming = envBool("PROXY_SERVER_TIMING", false)
+	servedByHeader = envBool("PROXY_SERVED_BY_HEADER", false)
+	userAgentSuffix = strings.TrimSpace(os.Getenv("PROXY_USER_AGENT_SUFFIX"))
+	logHeaders = envBool("PROXY_LOG_HEADERS", false)
+
+	// Порог для slow-лога (0 - выключен)
+	slowLogThreshold.Store(int64(time.Duration(envInt("PROXY_SLOW_LOG_MS", 0)) * time.Millisecond))
+	largeResponseThreshold.Store(int64(envInt("PROXY_LARGE_RESPONSE_BYTES", 0)))
+	missingKeyStatus = envInt("PROXY_MISSING_KEY_STATUS", fiber.StatusServiceUnavailable)
+	if missingKeyStatus != fiber.StatusServiceUnavailable && missingKeyStatus != fiber.StatusInternalServerError {
+		log.Fatalf("PROXY_MISSING_KEY_STATUS: expected 503 or 500, got %d", missingKeyStatus)
+	}
+
+	// Auth middleware: общий PROXY_AUTH_TOKEN и/или токены клиентов из PROXY_TOKENS_FILE
+	masterToken = nil
+	if authToken := os.Getenv("PROXY_AUTH_TOKEN"); authToken != "" {
+		masterToken = &apiToken{Name: "default", Token: authToken}
+	}
+	if err := loadTokens(os.Getenv("PROXY_TOKENS_FILE")); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadCachePolicies(os.Getenv("PROXY_CACHE_POLICIES_FILE")); err != nil {
+		log.Fatal(err)
+	}
+	if masterToken == nil && len(tokens) == 0 {
+		log.Fatal("PROXY_AUTH_TOKEN or PROXY_TOKENS_FILE must be set")
+	}
+	initQuotas()
+
+	routes := []string{"/v1", "/mock"}
+	for _, p := range providers {
+		routes = append(routes, "/"+p.Name)
+	}
+	if err := initPublicPaths(routes); err != nil {
+		log.Fatal(err)
+	}
+	app.Use(authMiddleware)
+	initMaintenance(routes)
+	app.Use(maintenanceMiddleware)
+
+	// Служебные эндпоинты: на отдельном listener, если задан PROXY_ADMIN_ADDR
+	admin := fiber.Router(app)
+	if os.Getenv("PROXY_ADMIN_ADDR") != "" {
+		adminApp = newAdminApp()
+		admin = adminApp
+	}
+	admin.Use("/admin", requireAdmin)
 
 	// Health check
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{"status": "ok"})
 	})
 
-	// OpenAI routes
-	app.All("/openai/*", proxyHandler(OpenAIBase, "OPENAI_API_KEY", "openai"))
+	// Готовность: проверка провайдеров (<PROVIDER>_PROBE_PATH), результат кэшируется
+	initProbes()
+	app.Get("/ready", readyHandler)
 
-	// Nebius routes
-	app.All("/nebius/*", proxyHandler(NebiusBase, "NEBIUS_API_KEY", "nebius"))
+	// Возможности токена запроса
+	app.Get("/whoami", whoamiHandler)
 
-	// DeepSeek routes
-	app.All("/deepseek/*", proxyHandler(DeepSeekBase, "DEEPSEEK_API_KEY", "deepseek"))
+	// JSON Schema тел запросов
+	if err := loadRequestSchemas(os.Getenv("PROXY_REQUEST_SCHEMAS")); err != nil {
+		log.Fatal(err)
+	}
 
-	// Anthropic routes
-	app.All("/anthropic/*", proxyHandler(AnthropicBase, "ANTHROPIC_API_KEY", "anthropic"))
+	if err := initClientIP(); err != nil {
+		log.Fatal(err)
+	}
+	if requireTLS && len(trustedProxies) == 0 {
+		log.Printf("WARN: PROXY_REQUIRE_TLS without PROXY_TRUSTED_PROXIES: X-Forwarded-Proto is ignored, only direct TLS is accepted")
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if err := initEscalation(); err != nil {
+		log.Fatal(err)
 	}
 
-	log.Printf("LLM Proxy starting on port %s", port)
-	log.Printf("ANTHROPIC_API_KEY configured: %v", os.Getenv("ANTHROPIC_API_KEY") != "")
-	log.Printf("OPENAI_API_KEY configured: %v", os.Getenv("OPENAI_API_KEY") != "")
+	// Копии ответов для аудита
+	if err := initAudit(); err != nil {
+		log.Fatal(err)
+	}
+	if err := initDataset(); err != nil {
+		log.Fatal(err)
+	}
+	if err := initTransformHooks(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Запись/воспроизведение ответов провайдеров для тестов
+	if err := initRecording(); err != nil {
+		log.Fatal(err)
+	}
 
-	if err := app.Listen(":" + port); err != nil {
+	maxMessages = envInt("PROXY_MAX_MESSAGES", 0)
+
+	// Stats
+	admin.Get("/stats", requireAdmin, statsHandler)
+	admin.Post("/admin/stats/reset", statsResetHandler)
+	admin.Get("/admin/maintenance", maintenanceHandler)
+	admin.Post("/admin/maintenance", maintenanceHandler)
+
+	// Стратегия сброса streaming-ответов
+	streamFlushInterval = time.Duration(envInt("PROXY_STREAM_FLUSH_INTERVAL_MS", 0)) * time.Millisecond
+	streamFlushBytes = envInt("PROXY_STREAM_FLUSH_BYTES", 0)
+
+	// Политика TLS соединений с провайдерами - до сборки их клиентов
+	if err := initUpstreamTLS(); err != nil {
 		log.Fatal(err)
 	}
+	initUpstreamRedirects()
+
+	// Provider routes
+	initSecrets()
+	reg, err := buildRegistry()
+	if err != nil {
+		log.Fatal(err)
+	}
+	registry.Store(reg)
+	admin.Post("/admin/secrets/reload", secretsReloadHandler)
+
+	initStats()
+	initModelPrices()
+	embeddingBatchSize = envInt("PROXY_EMBEDDING_BATCH_SIZE", 0)
+	maxStreamDuration = time.Duration(envInt("PROXY_MAX_STREAM_SECONDS", 0)) * time.Second
+	streamIdleTimeout = time.Duration(envInt("PROXY_STREAM_IDLE_SECONDS", 0)) * time.Second
+	streamSoftTimeout = time.Duration(envInt("PROXY_STREAM_SOFT_TIMEOUT_SECONDS", 0)) * time.Second
+	if streamSoftTimeout > 0 && maxStreamDuration > 0 && streamSoftTimeout >= maxStreamDuration {
+		log.Printf("WARN: PROXY_STREAM_SOFT_TIMEOUT_SECONDS >= PROXY_MAX_STREAM_SECONDS, soft timeout never applies")
+	}
+	streamTimeoutErrorEvent = envBool("PROXY_STREAM_TIMEOUT_ERROR_EVENT", true)
+	requestTimeout = time.Duration(envInt("PROXY_REQUEST_TIMEOUT_MS", 0)) * time.Millisecond
+	streamDrainOnDisconnect = envBool("PROXY_STREAM_DRAIN_ON_DISCONNECT", false)
+	streamDrainTimeout = time.Duration(envInt("PROXY_STREAM_DRAIN_TIMEOUT_MS", 60000)) * time.Millisecond
+	streamGzip = envBool("PROXY_STREAM_GZIP", false)
+	promptCacheSessionHeader = os.Getenv("PROXY_PROMPT_CACHE_SESSION_HEADER")
+	injectUser = envBool("PROXY_INJECT_USER", false)
+	userHeader = strings.TrimSpace(os.Getenv("PROXY_USER_HEADER"))
+	userSalt = os.Getenv("PROXY_USER_SALT")
+	defaultSeed = strings.TrimSpace(os.Getenv("PROXY_DEFAULT_SEED"))
+	retryInvalidJSON = envBool("PROXY_RETRY_INVALID_JSON", false)
+	injectStreamUsage = envBool("PROXY_INJECT_STREAM_USAGE", false)
+	stripInjectedUsage = envBool("PROXY_STRIP_INJECTED_USAGE", true)
+	initLimiters()
+	initIPLimiter()
+	initRateLimitThrottle()
+	initRetries()
+	initHealth()
+	initResponseHeaderFilter()
+	modelAliasStrict = envBool("PROXY_MODEL_ALIAS_STRICT", false)
+
+	skipUnconfigured := envBool("PROXY_SKIP_UNCONFIGURED_PROVIDERS", false)
+	forwardOptions := envBool("PROXY_FORWARD_OPTIONS", false)
+	var registered []string
+	providerHandlers = map[string]fiber.Handler{}
+	for _, p := range reg.list {
+		// Без ключа маршрут не регистрируем - клиент получит 404 вместо 500 на каждый запрос
+		if skipUnconfigured && len(p.keys.keys) == 0 {
+			log.Printf("Provider %s skipped: %s not set", p.Name, p.APIKeyEnv)
+			continue
+		}
+		handler := proxyHandler(p.Name)
+		providerHandlers[p.Name] = handler
+		if !forwardOptions {
+			app.Options("/"+p.Name+"/*", optionsHandler)
+		}
+		app.All("/"+p.Name+"/*", withProviderOverride(p.Name, handler))
+		registered = append(registered, p.Name)
+	}
+	log.Printf("Registered providers: %s", strings.Join(registered, ", "))
+
+	// Единый маршрут с выбором провайдера по модели
+	initModelNormalization()
+	initCoalescing()
+	initAttemptLog()
+	initModelRoutes()
+	if !forwardOptions {
+		app.Options("/v1/*", optionsHandler)
+	}
+	app.All("/v1/*", unifiedHandler)
+
+	// Mock provider для локальной разработки
+	if envBool("PROXY_ENABLE_MOCK", false) {
+		mockLatency = time.Duration(envInt("PROXY_MOCK_LATENCY_MS", 0)) * time.Millisecond
+		app.All("/mock/*", mockHandler)
+		log.Printf("Mock provider enabled at /mock/*")
+	}
+	return app, adminApp
+}
+
+// setProviderAuth добавляет ключ в формате провайдера
+func setProviderAuth(req *http.Request, provider, key string) {
+	if provider == "anthropic" {
+		req.Header.Set("x-api-key", key)
+		req.Header.Set("anthropic-version", "2023-06-01")
+	} else {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+}
+
+// isAssistantsPath - эндпоинты Assistants/Threads API
+func isAssistantsPath(path string) bool {
+	for _, prefix := range []string{"v1/assistants", "v1/threads", "v1/vector_stores"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// userAgentSuffix дописывается к User-Agent запросов к провайдерам (PROXY_USER_AGENT_SUFFIX)
+var userAgentSuffix string
+
+// serverTiming - добавлять заголовок Server-Timing с разбивкой задержки
+var serverTiming bool
+
+// servedByHeader - сообщать клиенту провайдера и обезличенный ключ в X-Proxy-Served-By (PROXY_SERVED_BY_HEADER)
+var servedByHeader bool
+
+// setServerTiming выставляет Server-Timing: upstream - время у провайдера, proxy - накладные расходы прокси
+func setServerTiming(c *fiber.Ctx, upstream, total time.Duration) {
+	if !serverTiming {
+		return
+	}
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	c.Set("Server-Timing", fmt.Sprintf("upstream;dur=%.1f, proxy;dur=%.1f", ms(upstream), ms(total-upstream)))
+}
+
+// slowLogThreshold - запросы дольше этого порога (time.Duration) логируются как WARN.
+// Atomic: поток дописывает лог уже после возврата из хендлера, newApp может перечитать порог.
+var slowLogThreshold atomic.Int64
+
+// logSlowRequest пишет подробный WARN, если запрос выполнялся дольше slowLogThreshold
+func logSlowRequest(provider, path string, status int, latency time.Duration) {
+	threshold := time.Duration(slowLogThreshold.Load())
+	if threshold <= 0 || latency < threshold {
+		return
+	}
+	log.Printf("WARN: slow request provider=%s path=/%s status=%d latency=%s (threshold %s)",
+		provider, path, status, latency, threshold)
+}
+
+// missingKeyStatus - статус ответа, если у провайдера нет ключа (PROXY_MISSING_KEY_STATUS)
+var missingKeyStatus = fiber.StatusServiceUnavailable
+
+// largeResponseThreshold - ответы (и потоки) больше этого размера логируются как WARN;
+// atomic, как и slowLogThreshold
+var largeResponseThreshold atomic.Int64
+
+// logLargeResponse пишет WARN и учитывает в статистике ответ больше largeResponseThreshold:
+// признак зацикленной генерации или злоупотребления
+func logLargeResponse(reqID, provider, model string, size int64) {
+	threshold := largeResponseThreshold.Load()
+	if threshold <= 0 || size <= threshold {
+		return
+	}
+	log.Printf("WARN: large response request_id=%s provider=%s model=%s size=%d bytes (threshold %d)",
+		reqID, provider, model, size, threshold)
+	recordLargeResponse(provider)
 }
 
-func proxyHandler(targetBase, apiKeyEnv, provider string) fiber.Handler {
+func proxyHandler(provider string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Получаем путь после префикса
-		path := c.Params("*")
-		targetURL := targetBase + "/" + path
+		start := time.Now()
+		prov := currentRegistry().get(provider)
+		c.Locals("provider", provider)
 
-		apiKey := os.Getenv(apiKeyEnv)
-		if apiKey == "" {
-			log.Printf("ERROR: %s not configured", apiKeyEnv)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": apiKeyEnv + " not configured",
+		// Получаем путь после префикса (для /v1/* его задаёт unifiedHandler)
+		path := strings.Clone(c.Params("*"))
+		if lp, ok := c.Locals("proxyPath").(string); ok {
+			path = lp
+		}
+
+		// Тег для распределения затрат, провайдеру не передаётся
+		// (копируем: строки fiber ссылаются на буфер запроса и переиспользуются)
+		tag := strings.Clone(c.Get("X-Proxy-Tag"))
+
+		// Токен клиента: тег по умолчанию и ограничения
+		tok := callerToken(c)
+		if tag == "" && tok != nil {
+			tag = tok.Tag
+		}
+
+		// Для streaming slow-лог и статистика пишутся по завершении потока
+		streamed := false
+		// SLO - только для запросов, дошедших до провайдера: локальные отказы (401, 429, 400)
+		// и ответы из кэша задержку провайдера не отражают
+		reachedUpstream := false
+		defer func() {
+			if !streamed {
+				status := c.Response().StatusCode()
+				latency := time.Since(start)
+				recordRequest(provider, tag, status)
+				if reachedUpstream {
+					recordSLO(provider, latency)
+				}
+				logSlowRequest(provider, path, status, latency)
+			}
+		}()
+
+		// Одновременные запросы с одного IP; слот потока освобождается по его завершении
+		// (лимитер берём один раз: пересборка приложения подменяет его)
+		ip := strings.Clone(clientIP(c))
+		ipLimit := ipConns
+		if !ipLimit.acquire(ip) {
+			log.Printf("WARN: connection limit reached for client %s", ip)
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "too many concurrent requests from this IP",
+			})
+		}
+		defer func() {
+			if !streamed {
+				ipLimit.release(ip)
+			}
+		}()
+
+		if !tok.allowsProvider(provider) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "token is not allowed to use " + provider,
+			})
+		}
+		if tok.budgetExhausted() {
+			return c.Status(fiber.StatusPaymentRequired).JSON(fiber.Map{
+				"error": "token budget exhausted",
+			})
+		}
+		if !tok.allowRequest(time.Now()) {
+			c.Set("Retry-After", "60")
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "token rate limit exceeded",
+			})
+		}
+
+		targetURL := prov.baseURL + "/" + prov.rewritePath(path)
+		// Query-строка клиента передаётся провайдеру, дополненная <PROVIDER>_DEFAULT_QUERY;
+		// в лог не пишется: в ней бывают ключи
+		requestURL := targetURL
+		if q := prov.withDefaultQuery(string(c.Request().URI().QueryString())); q != "" {
+			requestURL += "?" + q
+		}
+
+		// Загрузки файлов и chunked-тела передаём потоком, не читая тело целиком;
+		// JSON токена с allowlist моделей читается и при PROXY_STREAM_REQUEST_BODY
+		uploadStream := shouldStreamRequestBody(c)
+		if uploadStream && len(tok.Models) > 0 && isJSON(c.Get("Content-Type")) {
+			uploadStream = false
+		}
+
+		var body []byte
+		var info requestInfo
+		// uploadHead - начало потокового тела, прочитанное ради поля model
+		var uploadHead []byte
+		// extraHeaders - заголовки для провайдера от хука преобразования запроса
+		var extraHeaders map[string][]string
+		if uploadStream && len(tok.Models) > 0 {
+			var model string
+			if isMultipart(c.Get("Content-Type")) {
+				var err error
+				if model, uploadHead, err = multipartModel(c); errors.Is(err, errBodyTooLarge) {
+					return bodyTooLarge(c)
+				}
+			}
+			if !tok.allowsModel(model) {
+				return modelNotAllowed(c, model)
+			}
+		}
+		if !uploadStream {
+			if err := readChunkedBody(c); errors.Is(err, errBodyTooLarge) {
+				return bodyTooLarge(c)
+			} else if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Failed to read request body: " + err.Error(),
+				})
+			}
+			// Внешний хук (PROXY_TRANSFORM_REQUEST_URL) - до проверок: схема, allowlist моделей
+			// и выбор ключа видят уже изменённое хуком тело
+			requestBody := c.Body()
+			var ok bool
+			if requestBody, extraHeaders, ok = transformRequest(c, provider, path, requestBody); !ok {
+				return nil
+			}
+
+			// Проверяем тело (схема, лимиты), чтобы не тратить запрос к провайдеру
+			if err := validateRequestBody(c.Path(), requestBody); err != nil {
+				log.Printf("Request validation failed for %s: %v", c.Path(), err)
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error":   "Request validation failed",
+					"details": err.Error(),
+				})
+			}
+
+			// Преобразуем JSON-тело (алиасы моделей и т.п.)
+			var err error
+			var session string
+			if promptCacheSessionHeader != "" {
+				session = c.Get(promptCacheSessionHeader)
+			}
+			seed := c.Get(seedHeader)
+			if seed == "" {
+				seed = defaultSeed
+			}
+			var user string
+			if injectUser {
+				user = endUserSource(c, tok)
+			}
+			body, info, err = transformRequestBody(prov, requestBody, session, seed, user)
+			if err != nil {
+				log.Printf("Request rejected for %s: %v", c.Path(), err)
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			// Запросы без тела (списки, получение объектов) allowlist моделей не ограничивает
+			if len(requestBody) > 0 && !tok.allowsModel(info.model) {
+				return modelNotAllowed(c, info.model)
+			}
+		}
+
+		// Запись/воспроизведение: ключ - хеш запроса после преобразований тела
+		var recKey string
+		method := strings.Clone(c.Method())
+		records := currentRecorder()
+		audit := currentAudit()
+		if records != nil && !uploadStream {
+			recKey = recordingKey(provider, method, path+"?"+string(c.Request().URI().QueryString()), c.Get(lastEventIDHeader), body)
+		}
+		if recKey != "" && records.replayEnabled() {
+			rec, err := records.load(recKey)
+			if err != nil {
+				log.Printf("ERROR: replay: %v", err)
+			}
+			if rec != nil {
+				log.Printf("Replaying recorded %s response %s", provider, recKey)
+				streamed, err = serveRecording(c, rec, provider, tag, path, info, start)
+				if streamed {
+					// Поток из записи отдаётся из памяти - слот IP не держим
+					ipLimit.release(ip)
+				}
+				return err
+			}
+			if records.mode == recordModeReplayStrict {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "replay: no recorded response for request",
+					"key":   recKey,
+				})
+			}
+		}
+
+		// Выборка запросов в датасет: исходное тело клиента, до преобразований
+		var sampled *datasetRecord
+		if !uploadStream {
+			sampled = currentDataset().sample(requestID(c), provider, method, path, string(c.Request().URI().QueryString()), info.model, c.Body())
+		}
+
+		// Ключ выбирается по модели из тела (<PROVIDER>_MODEL_KEYS), иначе основной пул;
+		// собственный ключ токена (BYOK) приоритетнее ключей прокси
+		key := tok.keyPoolFor(provider, prov.keysFor(info.model)).pick()
+		// Нет ключа - провайдер недоступен (503), а не ошибка прокси; PROXY_MISSING_KEY_STATUS=500 - как раньше
+		if key == nil {
+			log.Printf("ERROR: %s not configured", prov.APIKeyEnv)
+			return c.Status(missingKeyStatus).JSON(fiber.Map{
+				"error":    prov.APIKeyEnv + " not configured",
+				"type":     "provider_not_configured",
+				"provider": provider,
 			})
 		}
 
+		// Кэш ответов по политике пути (PROXY_CACHE_POLICIES_FILE); потоки - только с "stream": true
+		var cacheKey string
+		var policy *cachePolicy
+		if p := cachePolicyFor(path); p != nil && !uploadStream {
+			if p.Stream || !info.stream && !strings.Contains(c.Get("Accept"), "text/event-stream") {
+				policy = p
+			}
+		}
+		if policy != nil {
+			header := func(name string) string { return c.Get(name) }
+			cacheKey = requestHash(provider, method, path+"?"+string(c.Request().URI().QueryString()),
+				append(varyValues(header), cacheScope(tok, provider, key)), body, policy.IgnoreFields)
+			if e := responseCache.get(cacheKey, time.Now()); e != nil {
+				log.Printf("Serving cached %s response for %s", provider, path)
+				if e.stream {
+					return serveCachedStream(c, e, provider, info)
+				}
+				return serveCached(c, e, provider, tok, method, path)
+			}
+			c.Set("X-Proxy-Cache", "miss")
+		}
+
+		// Кто обслужил запрос: провайдер и обезличенный ключ - в access-лог и (опционально) клиенту
+		servedBy := provider + "/" + key.id()
+		c.Locals("served_by", servedBy)
+		if servedByHeader {
+			c.Set("X-Proxy-Served-By", servedBy)
+		}
+
+		// Провайдер отключён из-за высокой доли ошибок
+		// Retry-After - остаток cooldown, чтобы клиенты не долбили провайдера раньше времени
+		if h := health[provider]; !h.healthy(time.Now()) {
+			retryAfter := h.retryAfter(time.Now())
+			c.Set("Retry-After", strconv.Itoa(retryAfter))
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error":       provider + " is temporarily disabled due to a high error rate",
+				"type":        "provider_unavailable",
+				"provider":    provider,
+				"retry_after": retryAfter,
+			})
+		}
+
+		// Ограничение одновременных запросов к провайдеру
+		limiter := limiters[provider]
+		if !limiter.acquire() {
+			log.Printf("WARN: %s concurrency limit reached", provider)
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": provider + " concurrency limit reached",
+			})
+		}
+		defer func() {
+			if !streamed {
+				limiter.release()
+			}
+		}()
+
+		// Контекст трассировки (W3C traceparent или B3)
+		trace := extractTraceContext(c)
+
+		var reqBody io.Reader = bytes.NewReader(body)
+		bodySize := int64(len(body))
+		// sentBytes - отправленный размер тела; у потоковой загрузки длина заранее может быть неизвестна
+		sentBytes := func() int64 { return bodySize }
+		if stream := c.Request().BodyStream(); uploadStream && stream != nil && c.Request().Header.ContentLength() != 0 {
+			upload := &limitedBody{r: io.MultiReader(bytes.NewReader(uploadHead), stream), limit: int64(bodyLimit)}
+			reqBody = upload
+			bodySize = int64(c.Request().Header.ContentLength())
+			sentBytes = func() int64 { return upload.read }
+		} else if uploadStream {
+			// Тело уже прочитано fasthttp целиком
+			body = c.Body()
+			reqBody = bytes.NewReader(body)
+			bodySize = int64(len(body))
+			uploadStream = false
+		}
+
 		// Логируем запрос
-		log.Printf("Proxying %s request to %s (body size: %d bytes)", provider, targetURL, len(c.Body()))
+		log.Printf("Proxying %s request to %s (body size: %d bytes, key=%s, trace_id=%s span_id=%s)",
+			provider, targetURL, bodySize, key.id(), trace.TraceID, trace.SpanID)
 
 		// Создаём запрос к целевому API
 		req, err := http.NewRequestWithContext(
 			context.Background(),
 			c.Method(),
-			targetURL,
-			bytes.NewReader(c.Body()),
+			requestURL,
+			reqBody,
 		)
 		if err != nil {
 			log.Printf("ERROR: Failed to create request: %v", err)
@@ -130,16 +698,28 @@ func proxyHandler(targetBase, apiKeyEnv, provider string) fiber.Handler {
 				"error": "Failed to create request: " + err.Error(),
 			})
 		}
+		if uploadStream && bodySize >= 0 {
+			req.ContentLength = bodySize
+		}
+		// Журнал попыток пишется после хендлера: к этому моменту все попытки уже сделаны
+		req, attempts := withAttemptLog(req, key.id())
+		defer attempts.emit(requestID(c))
 
-		// Копируем заголовки (исключая служебные)
+		// Копируем заголовки (исключая служебные); Last-Event-ID уходит провайдеру как есть -
+		// возобновление SSE-потока, id: строки потока передаются клиенту без изменений
 		for k, v := range c.GetReqHeaders() {
 			lowerKey := strings.ToLower(k)
 			if lowerKey == "host" ||
 				lowerKey == "authorization" ||
 				lowerKey == "x-proxy-auth" ||
+				lowerKey == "x-proxy-tag" ||
+				lowerKey == "x-proxy-provider" ||
+				lowerKey == "x-proxy-provider-order" ||
+				lowerKey == "x-proxy-seed" ||
 				lowerKey == "x-api-key" ||
 				lowerKey == "content-length" ||
-				lowerKey == "connection" {
+				lowerKey == "connection" ||
+				(userHeader != "" && strings.EqualFold(k, userHeader)) {
 				continue
 			}
 			for _, val := range v {
@@ -147,20 +727,61 @@ func proxyHandler(targetBase, apiKeyEnv, provider string) fiber.Handler {
 			}
 		}
 
-		// Устанавливаем Content-Type
-		req.Header.Set("Content-Type", "application/json")
+		// Заголовки от хука преобразования; учётные данные провайдера ставятся ниже и не переопределяются
+		for k, v := range extraHeaders {
+			req.Header.Del(k)
+			for _, val := range v {
+				req.Header.Add(k, val)
+			}
+		}
+
+		// Пробрасываем трассировку
+		trace.inject(req.Header)
+
+		// Помечаем проксированный трафик в User-Agent, сохраняя клиентский
+		if userAgentSuffix != "" {
+			ua := strings.TrimSpace(req.Header.Get("User-Agent") + " " + userAgentSuffix)
+			req.Header.Set("User-Agent", ua)
+		}
+
+		// Content-Type клиента сохраняем (multipart boundary), по умолчанию - JSON
+		if req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		// Accept клиента сохраняем, без него - значение по умолчанию провайдера
+		if req.Header.Get("Accept") == "" && prov.defaultAccept != "" {
+			req.Header.Set("Accept", prov.defaultAccept)
+		}
 
 		// Добавляем API ключ в зависимости от провайдера
-		if provider == "anthropic" {
-			req.Header.Set("x-api-key", apiKey)
-			req.Header.Set("anthropic-version", "2023-06-01")
+		setProviderAuth(req, provider, key.value)
+		// Копируем anthropic-beta если передан
+		if beta := c.Get("Anthropic-Beta"); provider == "anthropic" && beta != "" {
+			req.Header.Set("anthropic-beta", beta)
+		}
 
-			// Копируем anthropic-beta если передан
-			if beta := c.Get("Anthropic-Beta"); beta != "" {
-				req.Header.Set("anthropic-beta", beta)
+		// IP клиента для аналитики провайдера (по умолчанию не передаётся)
+		if clientIPHeader != "" {
+			if strings.EqualFold(clientIPHeader, fiber.HeaderXForwardedFor) {
+				req.Header.Set(fiber.HeaderXForwardedFor, forwardedFor(c))
+			} else {
+				req.Header.Set(clientIPHeader, clientIP(c))
 			}
-		} else {
-			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+
+		// Assistants API требует OpenAI-Beta; клиентский заголовок имеет приоритет
+		if provider == "openai" && isAssistantsPath(path) && req.Header.Get("OpenAI-Beta") == "" {
+			req.Header.Set("OpenAI-Beta", "assistants=v2")
+		}
+
+		// Пределы PROXY_MAX_HEADER_* действуют и на то, что уходит провайдеру
+		if count, size, ok := upstreamHeadersWithinLimits(req.Header); !ok {
+			log.Printf("WARN: %s upstream request headers exceed limits: %d headers, %d bytes", provider, count, size)
+			return c.Status(fiber.StatusRequestHeaderFieldsTooLarge).JSON(fiber.Map{
+				"error": "upstream request headers too large",
+				"count": count,
+				"bytes": size,
+			})
 		}
 
 		// Логируем заголовки запроса
@@ -168,87 +789,464 @@ func proxyHandler(targetBase, apiKeyEnv, provider string) fiber.Handler {
 			provider,
 			req.Header.Get("x-api-key") != "",
 			req.Header.Get("anthropic-version"))
+		if logHeaders {
+			log.Printf("Client headers for %s: %s", provider, maskedHeaders(c.GetReqHeaders()))
+			log.Printf("Upstream headers for %s: %s", provider, maskedHeaders(req.Header))
+		}
+
+		// Проверяем, streaming ли запрос (SDK не всегда шлют Accept, тогда смотрим на "stream": true)
+		isStreaming := strings.Contains(c.Get("Accept"), "text/event-stream") || info.stream
+
+		// Лимит одновременных потоков токена; слот освобождается по завершении потока
+		if isStreaming {
+			if !tok.acquireStream() {
+				log.Printf("WARN: token %s reached its concurrent stream limit", tok.Name)
+				return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+					"error": "token concurrent stream limit exceeded",
+					"limit": tok.MaxStreams,
+				})
+			}
+			defer func() {
+				if !streamed {
+					tok.releaseStream()
+				}
+			}()
+		}
+
+		// Метрики раздельно для streaming и non-streaming
+		finishMode := beginMode(provider, isStreaming, start)
+		defer func() {
+			if !streamed {
+				finishMode(c.Response().StatusCode())
+			}
+		}()
+
+		// Сжимаем поток сами, поэтому от провайдера он нужен несжатым
+		gzipStream := isStreaming && wantsGzipStream(c)
+		if gzipStream {
+			req.Header.Del("Accept-Encoding")
+		}
+
+		// Дедлайн non-streaming запроса; таймаут прокси клиент отличает от 504 провайдера
+		var deadline time.Time
+		cancelDeadline := func() {}
+		if requestTimeout > 0 && !isStreaming {
+			req, deadline, cancelDeadline = withRequestDeadline(req)
+		}
+		defer func() {
+			if !streamed {
+				cancelDeadline()
+			}
+		}()
 
-		// Проверяем, streaming ли запрос
-		isStreaming := strings.Contains(c.Get("Accept"), "text/event-stream")
+		// Суточная квота токена списывается последней: локальные отказы (400, 403, 503) её не тратят
+		if now := time.Now(); !tok.allowQuota(now) {
+			reset := quotaDayStart(now).Add(24 * time.Hour)
+			c.Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":     "daily quota exceeded",
+				"quota":     tok.DailyQuota,
+				"remaining": 0,
+				"reset_at":  reset.Format(time.RFC3339),
+			})
+		}
 
 		// Выполняем запрос
-		resp, err := httpClient.Do(req)
+		upstreamStart := time.Now()
+		var resp *http.Response
+		var embJSON *jsonBody
+		var embInputs []json.RawMessage
+		if !uploadStream {
+			embJSON, embInputs = embeddingInputs(path, body)
+		}
+		ck := ""
+		if embInputs == nil {
+			ck = coalesceRequestKey(provider, req, body, cacheScope(tok, provider, key), isStreaming, uploadStream)
+		}
+		// Клиент может уйти, не дождавшись ответа: запрос к провайдеру отменяется, исход - 499.
+		// Поток сам замечает отключение на записи; объединённый запрос ждут и другие клиенты.
+		stopWatch := func() bool { return false }
+		if !isStreaming && ck == "" {
+			var watched context.Context
+			watched, stopWatch = watchClient(req.Context(), c.Context().Conn())
+			req = req.WithContext(watched)
+			defer stopWatch()
+		}
+		if embInputs != nil {
+			// Большой батч embeddings делим на несколько запросов и склеиваем ответ
+			resp, err = splitEmbeddings(prov, req, embJSON, embInputs)
+		} else if ck != "" {
+			// Такой же детерминированный запрос уже в работе - ждём его ответ
+			var shared bool
+			resp, shared, err = coalescer.do(ck, func() (*http.Response, error) {
+				return doUpstream(prov.client, req, provider)
+			})
+			if shared {
+				log.Printf("Coalesced %s request with an identical in-flight request (trace_id=%s)", provider, trace.TraceID)
+				c.Set("X-Proxy-Coalesced", "true")
+			}
+		} else {
+			resp, err = doUpstream(prov.client, req, provider)
+		}
+		upstreamDur := time.Since(upstreamStart)
+		if err != nil && stopWatch() {
+			return clientClosedRequest(c, provider)
+		}
+		reachedUpstream = true
+		health[provider].observe(provider, err != nil || resp.StatusCode >= 500, time.Now())
 		if err != nil {
-			log.Printf("ERROR: Request failed: %v", err)
+			if isProxyTimeout(err, deadline) {
+				log.Printf("ERROR: %s request exceeded proxy timeout %s (trace_id=%s)", provider, requestTimeout, trace.TraceID)
+				return proxyTimeout(c, deadline)
+			}
+			// Потоковое тело (chunked, multipart) оказалось длиннее лимита
+			if errors.Is(err, errBodyTooLarge) {
+				return bodyTooLarge(c)
+			}
+			// Провайдер недоступен (сеть, TLS, таймаут) - ошибка самого прокси, в отличие от 5xx провайдера
+			log.Printf("ERROR: Request failed: %v (trace_id=%s)", err, trace.TraceID)
 			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
-				"error": "Failed to proxy request: " + err.Error(),
+				"error": fiber.Map{
+					"type":    "upstream_unreachable",
+					"message": "Failed to proxy request: " + err.Error(),
+				},
 			})
 		}
-		defer resp.Body.Close()
 
-		log.Printf("Response from %s: status=%d", provider, resp.StatusCode)
+		log.Printf("Response from %s: status=%d (trace_id=%s)", provider, resp.StatusCode, trace.TraceID)
+		key.observe(resp.Header)
 
 		// Копируем заголовки ответа
-		for k, v := range resp.Header {
-			for _, val := range v {
-				c.Response().Header.Add(k, val)
-			}
-		}
+		copyResponseHeaders(c, resp)
 
-		c.Status(resp.StatusCode)
+		// Ответ пришёл от провайдера: клиент отличает его ошибки от ошибок прокси.
+		// Статус для клиента может быть переназначен (<PROVIDER>_STATUS_MAP)
+		c.Status(prov.clientStatus(resp.StatusCode))
+		c.Set("X-Proxy-Upstream-Status", strconv.Itoa(resp.StatusCode))
 
-		// Если streaming - передаём SSE корректно
-		if isStreaming && strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
-			c.Set("Content-Type", "text/event-stream")
+		// Если streaming - передаём SSE корректно; NDJSON-поток (Ollama и т.п.) передаём так же построчно
+		respType := resp.Header.Get("Content-Type")
+		ndjson := isNDJSON(respType)
+		if (isStreaming && strings.Contains(respType, "text/event-stream")) || ndjson {
+			if ndjson {
+				c.Set("Content-Type", respType)
+			} else {
+				c.Set("Content-Type", "text/event-stream")
+			}
 			c.Set("Cache-Control", "no-cache")
 			c.Set("Connection", "keep-alive")
 			c.Set("X-Accel-Buffering", "no")
+			if gzipStream {
+				c.Set("Content-Encoding", "gzip")
+				c.Set("Vary", "Accept-Encoding")
+			}
 
+			setServerTiming(c, upstreamDur, time.Since(start))
+
+			streamed = true
+			reqID := requestID(c)
+			// Хранилище кэша берём сейчас: поток сохраняется в него уже после возврата из хендлера
+			cache := responseCache
 			c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
-				reader := bufio.NewReaderSize(resp.Body, 64*1024) // 64KB buffer
-				var bytesWritten int64
-
-				for {
-					line, err := reader.ReadString('\n')
-					if err != nil {
-						if err != io.EOF {
-							log.Printf("Stream read error: %v (after %d bytes)", err, bytesWritten)
-						}
-						break
-					}
+				// Тело закрываем и слот освобождаем здесь: writer вызывается уже после возврата из хендлера
+				defer resp.Body.Close()
+				defer limiter.release()
+				defer ipLimit.release(ip)
+				if isStreaming {
+					defer tok.releaseStream()
+				}
+				defer cancelDeadline()
 
-					n, err := w.WriteString(line)
-					if err != nil {
-						log.Printf("Stream write error: %v", err)
-						break
-					}
-					bytesWritten += int64(n)
+				out := w
+				if gzipStream {
+					var finish func()
+					out, finish = newGzipStreamWriter(w)
+					defer finish()
+				}
+				if prov.normalizeStream && !ndjson {
+					var finish func()
+					out, finish = newNormalizedStreamWriter(out, provider)
+					defer finish()
+				}
 
-					if err := w.Flush(); err != nil {
-						log.Printf("Stream flush error: %v", err)
-						break
-					}
+				// Ограничение длительности потока
+				guard := newStreamGuard(resp.Body, ndjson)
 
-					if strings.TrimSpace(line) == "" {
-						w.Flush()
-					}
+				var src io.Reader = guard
+				// Копия для аудита пишется в память, в приёмник - в фоне после потока
+				var audited *cappedBuffer
+				if audit.enabled() {
+					audited = &cappedBuffer{limit: audit.maxBytes}
+					src = io.TeeReader(src, audited)
+				}
+				var sampledResp *cappedBuffer
+				if sampled != nil && sampled.sink.responses {
+					sampledResp = &cappedBuffer{limit: sampled.sink.maxBytes}
+					src = io.TeeReader(src, sampledResp)
+				}
+				// Полная копия потока - для записи и для кэша (политика с "stream": true)
+				var captured *bytes.Buffer
+				if (recKey != "" && records.recordingEnabled()) || cacheKey != "" {
+					captured = &bytes.Buffer{}
+					src = &lockedReader{r: io.TeeReader(src, captured)}
 				}
 
-				log.Printf("Stream completed: %d bytes written", bytesWritten)
+				tap := newStreamTap(provider)
+				tap.stripUsage = info.usageInjected && stripInjectedUsage
+				tap.ndjson = ndjson
+				bytesWritten := pipeStream(out, src, tap)
+				// Клиент ушёл до конца потока (дочитанный после этого поток не в счёт)
+				clientGone := tap.clientGone && !tap.completed
+				reason := guard.stop()
+				truncated := guard.truncated() && !tap.completed && !clientGone
+				if truncated {
+					log.Printf("WARN: %s stream truncated after %d bytes: %s (trace_id=%s)", provider, bytesWritten, reason, trace.TraceID)
+					writeStreamTruncated(out, provider, ndjson, reason)
+				} else if reason != "" {
+					log.Printf("WARN: %s stream aborted: %s (trace_id=%s)", provider, reason, trace.TraceID)
+					if streamTimeoutErrorEvent && !tap.completed && !ndjson {
+						writeStreamError(out, "stream_timeout", reason)
+					}
+				}
+				// Клиент отключился до конца кэшируемого потока: дочитываем его, чтобы закэшировать полный ответ
+				if clientGone && cacheKey != "" && streamDrainOnDisconnect {
+					if n, err := drainStream(resp.Body, src); n > 0 {
+						log.Printf("Drained %d bytes of %s stream after client disconnect (err=%v, trace_id=%s)", n, provider, err, trace.TraceID)
+						tap = tapBytes(provider, captured.Bytes())
+					}
+				}
+				if audited != nil {
+					body, truncated := audited.contents()
+					audit.submit(&auditRecord{
+						RequestID: reqID, Provider: provider, Path: path, Status: resp.StatusCode, Stream: true,
+						Truncated: truncated, Body: body, encoding: resp.Header.Get("Content-Encoding"),
+					})
+				}
+				if sampled != nil {
+					var body string
+					var truncated bool
+					if sampledResp != nil {
+						body, truncated = sampledResp.contents()
+					}
+					sampled.submit(resp.StatusCode, true, body, truncated, resp.Header.Get("Content-Encoding"))
+				}
+				// Кэшируем и записываем только завершённые потоки
+				if cacheKey != "" && tap.completed && !tap.failed && resp.StatusCode == http.StatusOK {
+					cache.put(cacheKey, &cacheEntry{
+						header: resp.Header.Clone(), body: captured.Bytes(), stream: true, expires: time.Now().Add(policy.ttl),
+					}, time.Now())
+				}
+				if recKey != "" && records.recordingEnabled() && tap.completed {
+					records.save(recKey, &recording{
+						Provider: provider, Method: method, Path: path,
+						Status: resp.StatusCode, Header: resp.Header, Stream: true, Body: captured.Bytes(),
+					})
+				}
+				// У NDJSON нет общего терминатора: поток без обрыва прокси считается завершённым
+				if tap.completed || (ndjson && reason == "") {
+					log.Printf("Stream completed: %d bytes written (trace_id=%s)", bytesWritten, trace.TraceID)
+				} else if truncated {
+					recordTruncatedStream(provider)
+				} else if clientGone {
+					log.Printf("Client closed %s stream after %d bytes (status %d, trace_id=%s)", provider, bytesWritten, statusClientClosed, trace.TraceID)
+				} else {
+					log.Printf("WARN: Stream ended without terminator: %d bytes written (trace_id=%s)", bytesWritten, trace.TraceID)
+					recordIncompleteStream(provider)
+				}
+				if u, ok := tap.finalUsage(); ok {
+					recordUsage(provider, tag, u)
+					tok.charge(u)
+				}
+				// Ошибка посреди потока: в статистике запрос неуспешен, хотя клиент получил 200
+				status := resp.StatusCode
+				if tap.failed {
+					log.Printf("WARN: %s stream returned an error event: %s (trace_id=%s)", provider, tap.errMessage, trace.TraceID)
+					status = http.StatusBadGateway
+				}
+				if clientGone {
+					status = statusClientClosed
+				}
+				recordRequest(provider, tag, status)
+				finishMode(status)
+				recordBytes(provider, sentBytes(), bytesWritten)
+				logLargeResponse(reqID, provider, info.model, bytesWritten)
+				logSlowRequest(provider, path, status, time.Since(start))
 			})
 			return nil
 		}
 
+		// Частичный контент (Range) отдаём потоком без буферизации
+		if resp.StatusCode == http.StatusPartialContent || resp.Header.Get("Content-Range") != "" {
+			// fasthttp сам закроет тело после отправки; размер ответа учитываем по отданным байтам.
+			// Тело читается уже после возврата из хендлера: дедлайн, слоты лимитов и статистику
+			// закрываем при его закрытии, как у SSE, а не в defer - иначе долгая загрузка обходит лимиты
+			status := c.Response().StatusCode()
+			recordSLO(provider, time.Since(start))
+			streamed = true
+			body := &passthroughBody{r: resp.Body, done: func(n int64) {
+				cancelDeadline()
+				limiter.release()
+				ipLimit.release(ip)
+				if isStreaming {
+					tok.releaseStream()
+				}
+				recordRequest(provider, tag, status)
+				finishMode(status)
+				recordBytes(provider, sentBytes(), n)
+				logSlowRequest(provider, path, status, time.Since(start))
+			}}
+			c.Context().SetBodyStream(body, int(resp.ContentLength))
+			return nil
+		}
+
 		// Обычный ответ
-		body, err := io.ReadAll(resp.Body)
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
 		if err != nil {
+			if stopWatch() {
+				for k := range resp.Header {
+					c.Response().Header.Del(k)
+				}
+				return clientClosedRequest(c, provider)
+			}
+			if isProxyTimeout(err, deadline) {
+				for k := range resp.Header {
+					c.Response().Header.Del(k)
+				}
+				c.Response().Header.Del("X-Proxy-Upstream-Status")
+				log.Printf("ERROR: %s response exceeded proxy timeout %s (trace_id=%s)", provider, requestTimeout, trace.TraceID)
+				return proxyTimeout(c, deadline)
+			}
 			log.Printf("ERROR: Failed to read response: %v", err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to read response: " + err.Error(),
 			})
 		}
 
+		// Битый JSON при успешном статусе (обрыв соединения) - один повтор для идемпотентных запросов
+		if retryInvalidJSON && isMalformedJSON(resp, respBody) {
+			if isIdempotent(c.Method(), c.Get("Idempotency-Key")) && req.GetBody != nil && retryBudget.tryRetry() {
+				log.Printf("WARN: malformed JSON response from %s, retrying once", provider)
+				if retryResp, err := resendRequest(prov.client, req, provider, "malformed JSON response"); err == nil {
+					retryBody, err := io.ReadAll(retryResp.Body)
+					retryResp.Body.Close()
+					if err == nil {
+						for k := range resp.Header {
+							c.Response().Header.Del(k)
+						}
+						copyResponseHeaders(c, retryResp)
+						c.Status(retryResp.StatusCode)
+						resp, respBody = retryResp, retryBody
+					}
+				}
+			}
+			if isMalformedJSON(resp, respBody) {
+				log.Printf("WARN: returning malformed JSON response from %s as is (%d bytes)", provider, len(respBody))
+			}
+		}
+
+		// Превышение контекстного окна: повтор с запасной моделью (<PROVIDER>_CONTEXT_FALLBACKS)
+		if len(prov.contextFallbacks) > 0 && info.model != "" {
+			if fbResp, fbBody, fbModel, fbKey := contextFallback(prov, tok, req, body, info.model, resp, respBody); fbModel != "" {
+				for k := range resp.Header {
+					c.Response().Header.Del(k)
+				}
+				copyResponseHeaders(c, fbResp)
+				c.Status(fbResp.StatusCode)
+				c.Set("X-Proxy-Context-Fallback", fbModel)
+				resp, respBody = fbResp, fbBody
+				// Ответ дал ключ запасной модели
+				servedBy = provider + "/" + fbKey.id()
+				c.Locals("served_by", servedBy)
+				if servedByHeader {
+					c.Set("X-Proxy-Served-By", servedBy)
+				}
+			}
+		}
+
+		// Цепочка эскалации: неудачный ответ дешёвой модели повторяем со следующей
+		if len(info.escalation) > 0 && needsEscalation(resp, respBody) {
+			if escResp, escBody, escKey := escalate(prov, tag, tok, req, body, info.escalation, resp, respBody); escKey != nil {
+				for k := range resp.Header {
+					c.Response().Header.Del(k)
+				}
+				copyResponseHeaders(c, escResp)
+				c.Status(escResp.StatusCode)
+				resp, respBody = escResp, escBody
+				// Ответ дал ключ модели, до которой дошла эскалация
+				servedBy = provider + "/" + escKey.id()
+				c.Locals("served_by", servedBy)
+				if servedByHeader {
+					c.Set("X-Proxy-Served-By", servedBy)
+				}
+			}
+		}
+
+		c.Status(prov.clientStatus(resp.StatusCode))
+		c.Set("X-Proxy-Upstream-Status", strconv.Itoa(resp.StatusCode))
+
+		upstreamDur = time.Since(upstreamStart)
+		setServerTiming(c, upstreamDur, time.Since(start))
+
 		// Логируем ответ при ошибке
 		if resp.StatusCode >= 400 {
-			log.Printf("ERROR response from %s: %s", provider, string(body))
+			log.Printf("ERROR response from %s: %s", provider, string(respBody))
+		}
+
+		if recKey != "" && records.recordingEnabled() {
+			records.save(recKey, &recording{
+				Provider: provider, Method: method, Path: path,
+				Status: resp.StatusCode, Header: resp.Header, Body: respBody,
+			})
+		}
+
+		recordBytes(provider, sentBytes(), int64(len(respBody)))
+		logLargeResponse(requestID(c), provider, info.model, int64(len(respBody)))
+
+		if cacheKey != "" && resp.StatusCode == http.StatusOK {
+			responseCache.put(cacheKey, &cacheEntry{
+				header: resp.Header.Clone(), body: respBody, expires: time.Now().Add(policy.ttl),
+			}, time.Now())
+		}
+
+		if audit.enabled() {
+			audited := &cappedBuffer{limit: audit.maxBytes}
+			audited.Write(respBody)
+			body, truncated := audited.contents()
+			audit.submit(&auditRecord{
+				RequestID: requestID(c), Provider: provider, Path: path, Status: resp.StatusCode,
+				Truncated: truncated, Body: body, encoding: resp.Header.Get("Content-Encoding"),
+			})
+		}
+
+		if sampled != nil {
+			limit := sampled.sink.maxBytes
+			sampledBody := string(respBody[:min(len(respBody), limit)])
+			sampled.submit(resp.StatusCode, false, sampledBody, len(respBody) > limit, resp.Header.Get("Content-Encoding"))
+		}
+
+		// Учитываем токены
+		if u, ok := extractUsage(provider, respBody, resp.Header.Get("Content-Encoding")); ok {
+			recordUsage(provider, tag, u)
+			tok.charge(u)
+		}
+
+		// Список моделей показываем в пределах allowlist токена
+		if tok != nil && len(tok.Models) > 0 && resp.StatusCode == http.StatusOK && isModelList(method, path) {
+			if decoded, err := decodeBody(respBody, resp.Header.Get("Content-Encoding")); err == nil {
+				if filtered, err := filterModelList(decoded, tok); err == nil {
+					c.Response().Header.Del("Content-Encoding")
+					respBody = filtered
+				}
+			}
+		}
+
+		// Внешний хук преобразования ответа (PROXY_TRANSFORM_RESPONSE_URL)
+		respBody, ok := transformResponse(c, provider, path, c.Response().StatusCode(), resp.Header, respBody)
+		if !ok {
+			return nil
 		}
 
-		return c.Send(body)
+		return c.Send(respBody)
 	}
 }
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// update перезаписывает эталоны testdata/*.golden: go test -run ... -update
+var update = flag.Bool("update", false, "rewrite testdata/*.golden files")
+
+// testAuthToken - PROXY_AUTH_TOKEN тестового прокси, если тест не задал свои токены
+const testAuthToken = "test-token"
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	// Лог прокси в тестах нужен только с -v; тесты, проверяющие лог, читают его через captureLog
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	// Провайдеры тестов - только httptest-серверы: ключи и адреса из окружения не используются
+	for _, p := range providers {
+		os.Unsetenv(envPrefix(p.Name) + "API_KEY")
+		os.Unsetenv(envPrefix(p.Name) + "BASE_URL")
+	}
+	os.Exit(m.Run())
+}
+
+// testProxy - прокси, собранный newApp из окружения теста и запущенный на локальном порту
+type testProxy struct {
+	url      string
+	adminURL string
+	app      *fiber.App
+}
+
+// startProxy собирает прокси из переменных, заданных тестом через t.Setenv
+func startProxy(t *testing.T) *testProxy {
+	t.Helper()
+	t.Setenv("PROXY_ACCESS_LOG", "false")
+	if os.Getenv("PROXY_AUTH_TOKEN") == "" && os.Getenv("PROXY_TOKENS_FILE") == "" {
+		t.Setenv("PROXY_AUTH_TOKEN", testAuthToken)
+	}
+	app, adminApp := newApp()
+	p := &testProxy{app: app, url: serveApp(t, app)}
+	if adminApp != nil {
+		p.adminURL = serveApp(t, adminApp)
+	}
+	return p
+}
+
+// serveApp запускает приложение на свободном порту (без баннера fiber) и возвращает его адрес
+func serveApp(t *testing.T, app *fiber.App) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Handler собирает дерево маршрутов, как это делает Listen
+	app.Handler()
+	go app.Server().Serve(ln)
+	t.Cleanup(func() { app.ShutdownWithTimeout(time.Second) })
+	return "http://" + ln.Addr().String()
+}
+
+// upstream - тестовый провайдер; base URL провайдера подменяется на его адрес
+func upstream(t *testing.T, provider string, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	t.Setenv(envPrefix(provider)+"BASE_URL", srv.URL)
+	if os.Getenv(envPrefix(provider)+"API_KEY") == "" {
+		t.Setenv(envPrefix(provider)+"API_KEY", "sk-"+provider+"-test")
+	}
+	return srv
+}
+
+// newRequest - запрос к прокси с тестовым токеном; headers - пары имя, значение
+func (p *testProxy) newRequest(t *testing.T, method, path, body string, headers ...string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, p.url+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Proxy-Auth", testAuthToken)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	return req
+}
+
+// do отправляет запрос и читает ответ целиком
+func (p *testProxy) do(t *testing.T, method, path, body string, headers ...string) (*http.Response, string) {
+	t.Helper()
+	return send(t, p.newRequest(t, method, path, body, headers...))
+}
+
+func send(t *testing.T, req *http.Request) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+// stats - /stats прокси (или admin-сервера, если он поднят)
+func (p *testProxy) stats(t *testing.T) map[string]any {
+	t.Helper()
+	base := p.url
+	if p.adminURL != "" {
+		base = p.adminURL
+	}
+	req, _ := http.NewRequest(http.MethodGet, base+"/stats", nil)
+	req.Header.Set("X-Proxy-Auth", testAuthToken)
+	resp, body := send(t, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/stats: status %d: %s", resp.StatusCode, body)
+	}
+	var out map[string]any
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// providerStat - значение счётчика провайдера из /stats по пути ключей ("usage", "prompt_tokens")
+func (p *testProxy) providerStat(t *testing.T, provider string, keys ...string) any {
+	t.Helper()
+	var v any = p.stats(t)["providers"].(map[string]any)[provider]
+	for _, k := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			t.Fatalf("stats %s: no %q in %v", provider, k, v)
+		}
+		v = m[k]
+	}
+	return v
+}
+
+// logBuffer - лог прокси, записанный за время теста
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog перенаправляет стандартный лог в буфер до конца теста
+func captureLog(t *testing.T) *logBuffer {
+	b := &logBuffer{}
+	prev := log.Writer()
+	log.SetOutput(b)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	return b
+}
+
+// waitFor ждёт выполнения условия (фоновые воркеры, запись после ответа)
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// dataLines - значения data: строк SSE-потока по порядку
+func dataLines(stream string) []string {
+	var data []string
+	for _, line := range strings.Split(stream, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimRight(line, "\r"), "data: "); ok {
+			data = append(data, v)
+		}
+	}
+	return data
+}
+
+// golden сравнивает got с testdata/<name>.golden; с -update эталон перезаписывается
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch:\n got: %q\nwant: %q", path, got, want)
+	}
+}
+
+// writeFile пишет файл конфигурации во временный каталог теста
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSlowRequestLog(t *testing.T) {
+	t.Setenv("PROXY_SLOW_LOG_MS", "100")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/slow" {
+			time.Sleep(150 * time.Millisecond)
+		}
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/fast", "")
+	if strings.Contains(logs.String(), "slow request") {
+		t.Fatalf("fast request logged as slow:\n%s", logs)
+	}
+
+	p.do(t, http.MethodGet, "/openai/v1/slow", "")
+	out := logs.String()
+	if !strings.Contains(out, "WARN: slow request provider=openai path=/v1/slow status=200") ||
+		!strings.Contains(out, "(threshold 100ms)") {
+		t.Fatalf("no slow-log line for a slow request:\n%s", out)
+	}
+	if strings.Contains(out, "path=/v1/fast") {
+		t.Fatalf("fast request logged as slow:\n%s", out)
+	}
+}
+
+func TestSlowRequestLogDisabled(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if strings.Contains(logs.String(), "slow request") {
+		t.Fatalf("slow log written without PROXY_SLOW_LOG_MS:\n%s", logs)
+	}
+}
+
+func TestLargeResponseLog(t *testing.T) {
+	t.Setenv("PROXY_LARGE_RESPONSE_BYTES", "100")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		content := "ok"
+		if strings.Contains(r.URL.Path, "large") {
+			content = strings.Repeat("x", 200)
+		}
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"" + content + "\"}}]}\n\ndata: [DONE]\n\n"))
+			return
+		}
+		w.Write([]byte(`{"content":"` + content + `"}`))
+	})
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/small", `{"model":"gpt-4o"}`)
+	p.do(t, http.MethodPost, "/openai/v1/small", `{"model":"gpt-4o","stream":true}`, "Accept", "text/event-stream")
+	if strings.Contains(logs.String(), "large response") {
+		t.Fatalf("small response logged as large:\n%s", logs)
+	}
+
+	p.do(t, http.MethodPost, "/openai/v1/large", `{"model":"gpt-4o"}`, "X-Request-ID", "big-1")
+	// Поток - по накопленному размеру, после отправки ответа
+	p.do(t, http.MethodPost, "/openai/v1/large", `{"model":"gpt-4o","stream":true}`, "Accept", "text/event-stream", "X-Request-ID", "big-2")
+	waitFor(t, "large responses counted", func() bool {
+		return p.providerStat(t, "openai", "large_responses") == float64(2)
+	})
+	out := logs.String()
+	for _, want := range []string{
+		"WARN: large response request_id=big-1 provider=openai model=gpt-4o size=214 bytes (threshold 100)",
+		"WARN: large response request_id=big-2 provider=openai model=gpt-4o size=",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in the log:\n%s", want, out)
+		}
+	}
+}
+
+func TestLargeResponseLogDisabled(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(strings.Repeat("x", 1<<16))) })
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if strings.Contains(logs.String(), "large response") || p.providerStat(t, "openai", "large_responses") != float64(0) {
+		t.Fatalf("large response logged without PROXY_LARGE_RESPONSE_BYTES:\n%s", logs)
+	}
+}
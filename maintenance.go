@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Режим обслуживания: маршруты провайдеров (/v1, /mock, /<provider>) отвечают 503 с сообщением,
+// служебные эндпоинты (health, stats, admin) работают. Включается PROXY_MAINTENANCE при старте
+// или POST /admin/maintenance без перезапуска.
+
+const defaultMaintenanceMessage = "service is under maintenance"
+
+// maintenanceState - текущее состояние; подменяется целиком
+type maintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+	// RetryAfter - значение Retry-After в секундах, 0 - без заголовка
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+var (
+	maintenance atomic.Pointer[maintenanceState]
+	// maintenanceMu сериализует изменения через admin
+	maintenanceMu sync.Mutex
+	// maintenanceRoutes - префиксы маршрутов, закрываемых на обслуживание
+	maintenanceRoutes []string
+)
+
+func initMaintenance(routes []string) {
+	maintenanceRoutes = routes
+	message := strings.TrimSpace(os.Getenv("PROXY_MAINTENANCE_MESSAGE"))
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	state := &maintenanceState{
+		Enabled:    envBool("PROXY_MAINTENANCE", false),
+		Message:    message,
+		RetryAfter: envInt("PROXY_MAINTENANCE_RETRY_AFTER", 0),
+	}
+	maintenance.Store(state)
+	if state.Enabled {
+		log.Printf("WARN: maintenance mode is enabled, proxy routes return 503")
+	}
+}
+
+// maintenanceMiddleware отвечает 503 на маршруты провайдеров, пока включён режим обслуживания
+func maintenanceMiddleware(c *fiber.Ctx) error {
+	state := maintenance.Load()
+	if state == nil || !state.Enabled {
+		return c.Next()
+	}
+	path := c.Path()
+	for _, route := range maintenanceRoutes {
+		if isPathUnder(path, route) {
+			if state.RetryAfter > 0 {
+				c.Set("Retry-After", strconv.Itoa(state.RetryAfter))
+			}
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error":       state.Message,
+				"maintenance": true,
+			})
+		}
+	}
+	return c.Next()
+}
+
+// maintenanceHandler - GET возвращает состояние, POST меняет его:
+// {"enabled": true, "message": "...", "retry_after": 60}; пропущенные поля не меняются
+func maintenanceHandler(c *fiber.Ctx) error {
+	if c.Method() != fiber.MethodPost {
+		return c.JSON(maintenance.Load())
+	}
+	var update struct {
+		Enabled    *bool   `json:"enabled"`
+		Message    *string `json:"message"`
+		RetryAfter *int    `json:"retry_after"`
+	}
+	if err := c.BodyParser(&update); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid maintenance update: " + err.Error()})
+	}
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	state := *maintenance.Load()
+	if update.Enabled != nil {
+		state.Enabled = *update.Enabled
+	}
+	if update.Message != nil {
+		state.Message = strings.TrimSpace(*update.Message)
+		if state.Message == "" {
+			state.Message = defaultMaintenanceMessage
+		}
+	}
+	if update.RetryAfter != nil {
+		if *update.RetryAfter < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "retry_after must not be negative"})
+		}
+		state.RetryAfter = *update.RetryAfter
+	}
+	maintenance.Store(&state)
+	who := "unknown"
+	if t := callerToken(c); t != nil {
+		who = t.Name
+	}
+	log.Printf("WARN: maintenance mode set to %t by %s: %s", state.Enabled, who, state.Message)
+	return c.JSON(&state)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// setMaintenance - POST /admin/maintenance от имени token
+func (p *testProxy) setMaintenance(t *testing.T, token, update string) (int, maintenanceState) {
+	t.Helper()
+	resp, body := p.do(t, http.MethodPost, "/admin/maintenance", update, "X-Proxy-Auth", token)
+	var state maintenanceState
+	json.Unmarshal([]byte(body), &state)
+	return resp.StatusCode, state
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	calls := 0
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("before maintenance: status %d", resp.StatusCode)
+	}
+	status, state := p.setMaintenance(t, testAuthToken, `{"enabled":true,"message":"provider incident","retry_after":60}`)
+	if status != http.StatusOK || !state.Enabled || state.Message != "provider incident" || state.RetryAfter != 60 {
+		t.Fatalf("enable: status %d, state %+v", status, state)
+	}
+
+	for _, path := range []string{"/openai/v1/models", "/v1/chat/completions", "/mock/v1/models", "/OPENAI/v1/models"} {
+		resp, body := p.do(t, http.MethodGet, path, "")
+		if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "60" ||
+			body != `{"error":"provider incident","maintenance":true}` {
+			t.Errorf("%s: status %d, Retry-After %q: %s", path, resp.StatusCode, resp.Header.Get("Retry-After"), body)
+		}
+	}
+	// Служебные эндпоинты работают
+	for _, path := range []string{"/health", "/whoami", "/stats", "/admin/maintenance"} {
+		if got := getStatus(t, p.url, path); got != http.StatusOK {
+			t.Errorf("%s in maintenance: status %d", path, got)
+		}
+	}
+
+	// Пропущенные поля не меняются
+	if _, state = p.setMaintenance(t, testAuthToken, `{"enabled":false}`); state.Enabled || state.Message != "provider incident" {
+		t.Fatalf("disable: state %+v", state)
+	}
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.StatusCode != http.StatusOK || calls != 2 {
+		t.Fatalf("after maintenance: status %d, upstream calls %d", resp.StatusCode, calls)
+	}
+}
+
+func TestMaintenanceFromEnv(t *testing.T) {
+	t.Setenv("PROXY_MAINTENANCE", "true")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "" ||
+		body != `{"error":"service is under maintenance","maintenance":true}` {
+		t.Fatalf("status %d, Retry-After %q: %s", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+}
+
+func TestMaintenanceInvalidUpdate(t *testing.T) {
+	p := startProxy(t)
+	for _, update := range []string{`{"retry_after":-1}`, `{"enabled":"yes"}`} {
+		if status, _ := p.setMaintenance(t, testAuthToken, update); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d", update, status)
+		}
+	}
+	if _, body := p.do(t, http.MethodGet, "/admin/maintenance", ""); body != `{"enabled":false,"message":"service is under maintenance"}` {
+		t.Fatalf("state after rejected updates: %s", body)
+	}
+}
+
+func TestAdminRequiresAdminToken(t *testing.T) {
+	useTokens(t, `[{"name":"team-a","token":"tok-a"},{"name":"ops","token":"tok-ops","admin":true}]`)
+	p := startProxy(t)
+
+	// Токен клиента не может включить обслуживание и сбросить статистику
+	if status, _ := p.setMaintenance(t, "tok-a", `{"enabled":true}`); status != http.StatusForbidden {
+		t.Fatalf("client token: status %d", status)
+	}
+	for _, path := range []string{"/admin/maintenance", "/admin/stats/reset"} {
+		if resp, body := p.do(t, http.MethodPost, path, "", "X-Proxy-Auth", "tok-a"); resp.StatusCode != http.StatusForbidden || body != `{"error":"admin token required"}` {
+			t.Errorf("%s with a client token: status %d: %s", path, resp.StatusCode, body)
+		}
+	}
+	if _, body := p.do(t, http.MethodGet, "/admin/maintenance", ""); body != `{"enabled":false,"message":"service is under maintenance"}` {
+		t.Fatalf("state changed by a client token: %s", body)
+	}
+
+	// Токен с admin: true и общий PROXY_AUTH_TOKEN - могут
+	for _, token := range []string{"tok-ops", testAuthToken} {
+		if status, _ := p.setMaintenance(t, token, `{"enabled":false}`); status != http.StatusOK {
+			t.Errorf("%s: status %d", token, status)
+		}
+	}
+	if p.whoami(t, "tok-a")["admin"] != false || p.whoami(t, "tok-ops")["admin"] != true {
+		t.Fatal("whoami does not report admin access")
+	}
+}
+
+func TestAdminListenerRequiresAdminToken(t *testing.T) {
+	t.Setenv("PROXY_ADMIN_ADDR", "127.0.0.1:0")
+	useTokens(t, `[{"name":"team-a","token":"tok-a"}]`)
+	p := startProxy(t)
+
+	req, _ := http.NewRequest(http.MethodGet, p.adminURL+"/admin/maintenance", nil)
+	req.Header.Set("X-Proxy-Auth", "tok-a")
+	if resp, _ := send(t, req); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("client token on the admin listener: status %d", resp.StatusCode)
+	}
+	if got := getStatus(t, p.adminURL, "/admin/maintenance"); got != http.StatusOK {
+		t.Fatalf("PROXY_AUTH_TOKEN on the admin listener: status %d", got)
+	}
+}
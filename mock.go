@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// mockLatency - базовая задержка ответа mock-провайдера (PROXY_MOCK_LATENCY_MS)
+var mockLatency time.Duration
+
+// mockHandler - встроенный OpenAI-совместимый провайдер для локальной разработки.
+// Управление через заголовки запроса:
+//
+//	X-Mock-Status: 429|500|...      - вернуть ошибку с этим статусом
+//	X-Mock-Latency-Ms: 200          - задержка перед ответом (перекрывает PROXY_MOCK_LATENCY_MS)
+//	X-Mock-Chunk-Delay-Ms: 50       - пауза между чанками streaming-ответа
+//
+// Если в запросе есть tools, модель "вызывает" первый инструмент; streaming-ответ
+// с stream_options.include_usage завершается usage-чанком, как у OpenAI.
+func mockHandler(c *fiber.Ctx) error {
+	path := c.Params("*")
+
+	latency := mockLatency
+	if ms, err := strconv.Atoi(c.Get("X-Mock-Latency-Ms")); err == nil {
+		latency = time.Duration(ms) * time.Millisecond
+	}
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	if status, err := strconv.Atoi(c.Get("X-Mock-Status")); err == nil && status >= 400 {
+		return c.Status(status).JSON(fiber.Map{
+			"error": fiber.Map{
+				"message": fmt.Sprintf("Simulated error with status %d", status),
+				"type":    "mock_error",
+				"code":    status,
+			},
+		})
+	}
+
+	switch strings.TrimSuffix(path, "/") {
+	case "v1/models":
+		return c.JSON(fiber.Map{
+			"object": "list",
+			"data": []fiber.Map{
+				{"id": "mock-model", "object": "model", "owned_by": "ai_proxy"},
+			},
+		})
+	case "v1/chat/completions":
+	default:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": fiber.Map{"message": "Unknown mock endpoint: /" + path, "type": "invalid_request_error"},
+		})
+	}
+
+	var req struct {
+		Model         string `json:"model"`
+		Stream        bool   `json:"stream"`
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+		Tools []struct {
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		} `json:"tools"`
+	}
+	_ = json.Unmarshal(c.Body(), &req)
+	if req.Model == "" {
+		req.Model = "mock-model"
+	}
+
+	id := "chatcmpl-mock-" + randomHex(6)
+	created := time.Now().Unix()
+	words := []string{"This", " is", " a", " mock", " response."}
+
+	var toolName string
+	if len(req.Tools) > 0 {
+		toolName = req.Tools[0].Function.Name
+		// Аргументы вызова приходят по частям, как у OpenAI
+		words = []string{`{"query":`, ` "mock`, ` arguments"`, `}`}
+	}
+	usage := fiber.Map{"prompt_tokens": 10, "completion_tokens": len(words), "total_tokens": 10 + len(words)}
+
+	if !req.Stream && !strings.Contains(c.Get("Accept"), "text/event-stream") {
+		if toolName != "" {
+			return c.JSON(fiber.Map{
+				"id":      id,
+				"object":  "chat.completion",
+				"created": created,
+				"model":   req.Model,
+				"choices": []fiber.Map{{
+					"index": 0,
+					"message": fiber.Map{"role": "assistant", "content": nil, "tool_calls": []fiber.Map{
+						mockToolCall(toolName, strings.Join(words, "")),
+					}},
+					"finish_reason": "tool_calls",
+				}},
+				"usage": usage,
+			})
+		}
+		return c.JSON(fiber.Map{
+			"id":      id,
+			"object":  "chat.completion",
+			"created": created,
+			"model":   req.Model,
+			"choices": []fiber.Map{{
+				"index":         0,
+				"message":       fiber.Map{"role": "assistant", "content": strings.Join(words, "")},
+				"finish_reason": "stop",
+			}},
+			"usage": usage,
+		})
+	}
+
+	var chunkDelay time.Duration
+	if ms, err := strconv.Atoi(c.Get("X-Mock-Chunk-Delay-Ms")); err == nil {
+		chunkDelay = time.Duration(ms) * time.Millisecond
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		for i, word := range words {
+			var finish any
+			delta := fiber.Map{"content": word}
+			if toolName != "" {
+				call := fiber.Map{"index": 0, "function": fiber.Map{"arguments": word}}
+				if i == 0 {
+					call = mockToolCall(toolName, word)
+					call["index"] = 0
+				}
+				delta = fiber.Map{"tool_calls": []fiber.Map{call}}
+			}
+			if i == len(words)-1 {
+				finish = "stop"
+				if toolName != "" {
+					finish = "tool_calls"
+				}
+			}
+			fields := fiber.Map{
+				"id":      id,
+				"object":  "chat.completion.chunk",
+				"created": created,
+				"model":   req.Model,
+				"choices": []fiber.Map{{"index": 0, "delta": delta, "finish_reason": finish}},
+			}
+			if req.StreamOptions.IncludeUsage {
+				fields["usage"] = nil
+			}
+			chunk, _ := json.Marshal(fields)
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+			if err := w.Flush(); err != nil {
+				return
+			}
+			if chunkDelay > 0 {
+				time.Sleep(chunkDelay)
+			}
+		}
+		if req.StreamOptions.IncludeUsage {
+			chunk, _ := json.Marshal(fiber.Map{
+				"id":      id,
+				"object":  "chat.completion.chunk",
+				"created": created,
+				"model":   req.Model,
+				"choices": []fiber.Map{},
+				"usage":   usage,
+			})
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		w.Flush()
+	})
+	return nil
+}
+
+// mockToolCall - вызов инструмента в формате OpenAI
+func mockToolCall(name, arguments string) fiber.Map {
+	return fiber.Map{
+		"id":       "call_mock_" + randomHex(4),
+		"type":     "function",
+		"function": fiber.Map{"name": name, "arguments": arguments},
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockContent склеивает delta.content чанков streaming-ответа
+func mockContent(t *testing.T, data []string) string {
+	t.Helper()
+	var sb strings.Builder
+	for _, d := range data {
+		if d == "[DONE]" {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct{ Content string }
+			}
+		}
+		if err := json.Unmarshal([]byte(d), &chunk); err != nil {
+			t.Fatalf("chunk %q: %v", d, err)
+		}
+		for _, c := range chunk.Choices {
+			sb.WriteString(c.Delta.Content)
+		}
+	}
+	return sb.String()
+}
+
+func TestMockStreaming(t *testing.T) {
+	t.Setenv("PROXY_ENABLE_MOCK", "true")
+	p := startProxy(t)
+
+	start := time.Now()
+	resp, body := p.do(t, http.MethodPost, "/mock/v1/chat/completions",
+		`{"model":"mock","stream":true,"messages":[{"role":"user","content":"hi"}]}`,
+		"X-Mock-Chunk-Delay-Ms", "30")
+	elapsed := time.Since(start)
+
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	data := dataLines(body)
+	if len(data) != 6 || data[len(data)-1] != "[DONE]" {
+		t.Fatalf("stream events = %q", data)
+	}
+	if got := mockContent(t, data); got != "This is a mock response." {
+		t.Fatalf("content = %q", got)
+	}
+	// Пауза после каждого из 5 чанков
+	if elapsed < 150*time.Millisecond {
+		t.Fatalf("stream took %s, chunk delay not applied", elapsed)
+	}
+}
+
+func TestMockErrorSimulation(t *testing.T) {
+	t.Setenv("PROXY_ENABLE_MOCK", "true")
+	p := startProxy(t)
+
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusInternalServerError} {
+		resp, body := p.do(t, http.MethodPost, "/mock/v1/chat/completions", `{"model":"mock"}`,
+			"X-Mock-Status", strconv.Itoa(status))
+		if resp.StatusCode != status {
+			t.Fatalf("X-Mock-Status %d: got status %d", status, resp.StatusCode)
+		}
+		var out struct {
+			Error struct{ Type string }
+		}
+		if err := json.Unmarshal([]byte(body), &out); err != nil || out.Error.Type != "mock_error" {
+			t.Fatalf("X-Mock-Status %d: body %s", status, body)
+		}
+	}
+}
+
+func TestMockLatencyAndChat(t *testing.T) {
+	t.Setenv("PROXY_ENABLE_MOCK", "true")
+	t.Setenv("PROXY_MOCK_LATENCY_MS", "50")
+	p := startProxy(t)
+
+	start := time.Now()
+	resp, body := p.do(t, http.MethodPost, "/mock/v1/chat/completions", `{"model":"mock"}`)
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("PROXY_MOCK_LATENCY_MS not applied")
+	}
+	var out struct {
+		Choices []struct {
+			Message struct{ Content string }
+		}
+		Usage struct {
+			TotalTokens int `json:"total_tokens"`
+		}
+	}
+	if err := json.Unmarshal([]byte(body), &out); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if out.Choices[0].Message.Content != "This is a mock response." || out.Usage.TotalTokens != 15 {
+		t.Fatalf("response = %s", body)
+	}
+
+	// Заголовок перекрывает задержку по умолчанию
+	start = time.Now()
+	p.do(t, http.MethodGet, "/mock/v1/models", "", "X-Mock-Latency-Ms", "0")
+	if time.Since(start) >= 50*time.Millisecond {
+		t.Fatal("X-Mock-Latency-Ms did not override the default latency")
+	}
+}
+
+func TestMockDisabledByDefault(t *testing.T) {
+	p := startProxy(t)
+	if resp, _ := p.do(t, http.MethodPost, "/mock/v1/chat/completions", `{}`); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("mock without PROXY_ENABLE_MOCK: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// modelAliasStrict - отклонять модели, не входящие в алиасы провайдера (<PROVIDER>_MODEL_ALIASES)
+var modelAliasStrict bool
+
+var (
+	// normalizeModels - убирать пробелы вокруг model (PROXY_NORMALIZE_MODEL)
+	normalizeModels bool
+	// canonicalModels - каноническое написание моделей по имени в нижнем регистре (PROXY_CANONICAL_MODELS)
+	canonicalModels map[string]string
+)
+
+func initModelNormalization() {
+	canonicalModels = map[string]string{}
+	for _, m := range envList("PROXY_CANONICAL_MODELS") {
+		canonicalModels[strings.ToLower(m)] = m
+	}
+	normalizeModels = envBool("PROXY_NORMALIZE_MODEL", false) || len(canonicalModels) > 0
+}
+
+// normalizeModel приводит " GPT-4o " к "gpt-4o": обрезает пробелы и исправляет регистр
+// известных моделей. Остальные имена сохраняют регистр - у части провайдеров он значим.
+func normalizeModel(model string) string {
+	if !normalizeModels {
+		return model
+	}
+	model = strings.TrimSpace(model)
+	if canonical, ok := canonicalModels[strings.ToLower(model)]; ok {
+		return canonical
+	}
+	return model
+}
+
+// resolveModel подставляет модель вместо алиаса. Неизвестные имена проходят как есть,
+// в strict-режиме допускаются только алиасы и модели, на которые они ссылаются.
+func resolveModel(p *provider, model string) (string, error) {
+	aliases := p.modelAliases
+	if target, ok := aliases[model]; ok {
+		return target, nil
+	}
+	if modelAliasStrict && len(aliases) > 0 {
+		for _, target := range aliases {
+			if target == model {
+				return model, nil
+			}
+		}
+		return "", fmt.Errorf("model %q is not allowed for %s", model, p.Name)
+	}
+	return model, nil
+}
+
+// isModelList - запрос списка моделей провайдера (GET .../models)
+func isModelList(method, path string) bool {
+	return method == "GET" && strings.HasSuffix(strings.TrimSuffix(path, "/"), "models")
+}
+
+// filterModelList оставляет в ответе списка моделей ({"data":[{"id":...}]}) только
+// модели, разрешённые токену; остальные поля ответа сохраняются
+func filterModelList(body []byte, tok *apiToken) ([]byte, error) {
+	var list map[string]json.RawMessage
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+	var models []json.RawMessage
+	if err := json.Unmarshal(list["data"], &models); err != nil {
+		return nil, err
+	}
+	allowed := make([]json.RawMessage, 0, len(models))
+	for _, m := range models {
+		var model struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(m, &model) == nil && model.ID != "" && tok.allowsModel(model.ID) {
+			allowed = append(allowed, m)
+		}
+	}
+	data, err := json.Marshal(allowed)
+	if err != nil {
+		return nil, err
+	}
+	list["data"] = data
+	return json.Marshal(list)
+}
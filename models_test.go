@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// modelUpstream запоминает тело последнего запроса к провайдеру
+func modelUpstream(t *testing.T, provider string) *map[string]any {
+	got := &map[string]any{}
+	upstream(t, provider, func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		*got = nil
+		json.Unmarshal(data, got)
+		w.Write([]byte(`{}`))
+	})
+	return got
+}
+
+func TestModelAliases(t *testing.T) {
+	t.Setenv("OPENAI_MODEL_ALIASES", "fast=gpt-4o-mini,smart=gpt-4o")
+	got := modelUpstream(t, "openai")
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"fast","temperature":0.2,"messages":[]}`)
+	if (*got)["model"] != "gpt-4o-mini" || (*got)["temperature"] != 0.2 {
+		t.Fatalf("alias: upstream body = %v", *got)
+	}
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4.1","messages":[]}`)
+	if (*got)["model"] != "gpt-4.1" {
+		t.Fatalf("non-aliased model: upstream body = %v", *got)
+	}
+}
+
+func TestModelAliasesStrict(t *testing.T) {
+	t.Setenv("OPENAI_MODEL_ALIASES", "fast=gpt-4o-mini")
+	t.Setenv("PROXY_MODEL_ALIAS_STRICT", "true")
+	got := modelUpstream(t, "openai")
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4.1"}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unknown model in strict mode: status %d %s", resp.StatusCode, body)
+	}
+	// Цель алиаса по прямому имени допустима
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o-mini"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("alias target in strict mode: status %d", resp.StatusCode)
+	}
+	if (*got)["model"] != "gpt-4o-mini" {
+		t.Fatalf("upstream body = %v", *got)
+	}
+}
+
+const modelList = `{"object":"list","data":[` +
+	`{"id":"gpt-4o","object":"model","owned_by":"openai"},` +
+	`{"id":"gpt-4o-mini","object":"model","owned_by":"openai"},` +
+	`{"id":"o3","object":"model","owned_by":"openai"}]}`
+
+// listedModels - id моделей из ответа списка
+func listedModels(t *testing.T, body string) []string {
+	t.Helper()
+	var list struct {
+		Object string
+		Data   []struct{ ID string }
+	}
+	if err := json.Unmarshal([]byte(body), &list); err != nil || list.Object != "list" {
+		t.Fatalf("model list %s: %v", body, err)
+	}
+	var ids []string
+	for _, m := range list.Data {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestModelListFilteredByToken(t *testing.T) {
+	useTokens(t, `[
+		{"name":"mini","token":"tok-mini","models":["gpt-4o-mini"]},
+		{"name":"gpt4o","token":"tok-4o","models":["gpt-4o*"]}
+	]`)
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(modelList)) })
+	p := startProxy(t)
+
+	for _, c := range []struct{ token, want string }{
+		{"tok-mini", "gpt-4o-mini"},
+		{"tok-4o", "gpt-4o gpt-4o-mini"},
+		// Токен без allowlist видит весь список провайдера
+		{testAuthToken, "gpt-4o gpt-4o-mini o3"},
+	} {
+		_, body := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", c.token)
+		// Остальные поля моделей и списка сохраняются
+		if !strings.Contains(body, `{"id":"gpt-4o-mini","object":"model","owned_by":"openai"}`) {
+			t.Errorf("%s: model entries changed: %s", c.token, body)
+		}
+		if got := strings.Join(listedModels(t, body), " "); got != c.want {
+			t.Errorf("%s: models %q, want %q", c.token, got, c.want)
+		}
+	}
+	// Модели вне allowlist не только скрыты, но и недоступны
+	resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"o3"}`, "X-Proxy-Auth", "tok-mini")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("disallowed model: status %d", resp.StatusCode)
+	}
+}
+
+func TestModelAllowlistStreamedBodies(t *testing.T) {
+	useTokens(t, `[{"name":"mini","token":"tok-mini","models":["gpt-4o-mini"]}]`)
+	var calls atomic.Int32
+	var gotBody atomic.Value
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		b, _ := io.ReadAll(r.Body)
+		gotBody.Store(string(b))
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	// chunked: длина тела неизвестна, JSON всё равно читается и модель проверяется
+	chunked := func(body string) *http.Response {
+		req := p.newRequest(t, http.MethodPost, "/openai/v1/chat/completions", "", "X-Proxy-Auth", "tok-mini", "Content-Type", "application/json")
+		req.Body = io.NopCloser(strings.NewReader(body))
+		req.ContentLength = -1
+		resp, _ := send(t, req)
+		return resp
+	}
+	if resp := chunked(`{"model":"gpt-4o"}`); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("chunked disallowed model: status %d", resp.StatusCode)
+	}
+	if resp := chunked(`{"model":"gpt-4o-mini"}`); resp.StatusCode != http.StatusOK || gotBody.Load() != `{"model":"gpt-4o-mini"}` {
+		t.Fatalf("chunked allowed model: status %d, upstream got %v", resp.StatusCode, gotBody.Load())
+	}
+	// Тело без модели при allowlist не проходит
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/moderations", `{"input":"x"}`, "X-Proxy-Auth", "tok-mini"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unknown model: status %d", resp.StatusCode)
+	}
+
+	// multipart: модель - поле формы, файл доходит до провайдера целиком
+	upload := func(model string) (*http.Response, string) {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		if model != "" {
+			mw.WriteField("model", model)
+		}
+		fw, _ := mw.CreateFormFile("file", "audio.mp3")
+		fw.Write(bytes.Repeat([]byte("a"), 64*1024))
+		mw.Close()
+		resp, _ := p.do(t, http.MethodPost, "/openai/v1/audio/transcriptions", buf.String(), "X-Proxy-Auth", "tok-mini", "Content-Type", mw.FormDataContentType())
+		return resp, buf.String()
+	}
+	for _, model := range []string{"whisper-1", ""} {
+		if resp, _ := upload(model); resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("multipart model %q: status %d", model, resp.StatusCode)
+		}
+	}
+	resp, body := upload("gpt-4o-mini")
+	if resp.StatusCode != http.StatusOK || gotBody.Load() != body {
+		t.Fatalf("multipart allowed model: status %d", resp.StatusCode)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("upstream calls = %d, want 2", n)
+	}
+}
+
+func TestModelAllowlistWithStreamRequestBody(t *testing.T) {
+	t.Setenv("PROXY_STREAM_REQUEST_BODY", "true")
+	useTokens(t, `[{"name":"mini","token":"tok-mini","models":["gpt-4o-mini"]}]`)
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`, "X-Proxy-Auth", "tok-mini"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("disallowed model: status %d", resp.StatusCode)
+	}
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/uploads/part", "raw", "X-Proxy-Auth", "tok-mini", "Content-Type", "application/octet-stream"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unknown model: status %d", resp.StatusCode)
+	}
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o-mini"}`, "X-Proxy-Auth", "tok-mini"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("allowed model: status %d", resp.StatusCode)
+	}
+}
+
+func TestModelNormalization(t *testing.T) {
+	t.Setenv("PROXY_CANONICAL_MODELS", "gpt-4o,deepseek-chat")
+	openai := modelUpstream(t, "openai")
+	deepseek := modelUpstream(t, "deepseek")
+	p := startProxy(t)
+
+	// Маршрут выбирается уже по нормализованному имени
+	if resp, body := p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":" GPT-4o "}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if (*openai)["model"] != "gpt-4o" {
+		t.Fatalf("openai got model %v", (*openai)["model"])
+	}
+	p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"DeepSeek-Chat\n"}`)
+	if (*deepseek)["model"] != "deepseek-chat" {
+		t.Fatalf("deepseek got model %v", (*deepseek)["model"])
+	}
+	// Модель не из списка только обрезается, регистр сохраняется
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"  ft:GPT-4o:Org  "}`)
+	if (*openai)["model"] != "ft:GPT-4o:Org" {
+		t.Fatalf("openai got model %v", (*openai)["model"])
+	}
+}
+
+func TestModelNormalizationOffByDefault(t *testing.T) {
+	openai := modelUpstream(t, "openai")
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":" GPT-4o "}`)
+	if (*openai)["model"] != " GPT-4o " {
+		t.Fatalf("openai got model %q without PROXY_NORMALIZE_MODEL", (*openai)["model"])
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// requestID возвращает ID запроса, выставленный middleware requestid
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals("requestid").(string)
+	return id
+}
+
+// logPanic - StackTraceHandler для recover: контекст запроса и стек в одном сообщении.
+// Провайдера выставляет proxyHandler: у /v1/* он определяется по модели, а не по пути.
+func logPanic(c *fiber.Ctx, e interface{}) {
+	provider, _ := c.Locals("provider").(string)
+	log.Printf("PANIC: %v request_id=%s provider=%s method=%s path=%s\n%s",
+		e, requestID(c), provider, c.Method(), c.Path(), debug.Stack())
+	c.Locals("panicked", true)
+}
+
+// errorHandler отдаёт JSON 500 с request_id после паники, остальные ошибки - как fiber по умолчанию
+// (при PROXY_ERROR_FORMAT=openai - всё в схеме ошибок OpenAI)
+func errorHandler(c *fiber.Ctx, err error) error {
+	panicked, _ := c.Locals("panicked").(bool)
+	// 413 от fasthttp (тело длиннее BodyLimit) - в общей схеме с лимитом
+	var fe *fiber.Error
+	if errors.As(err, &fe) && fe.Code == fiber.StatusRequestEntityTooLarge && !panicked {
+		if err := bodyTooLarge(c); err != nil || !openAIErrors {
+			return err
+		}
+		if body, ok := toOpenAIError(fiber.StatusRequestEntityTooLarge, c.Response().Body()); ok {
+			c.Response().SetBodyRaw(body)
+		}
+		return nil
+	}
+	if openAIErrors {
+		return openAIErrorResponse(c, err, panicked)
+	}
+	if panicked {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":      "Internal server error",
+			"request_id": requestID(c),
+		})
+	}
+	return fiber.DefaultErrorHandler(c, err)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// panicTransport паникует внутри обработчика запроса, на вызове провайдера
+type panicTransport struct{}
+
+func (panicTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	panic("transport exploded")
+}
+
+func TestPanicLoggedWithRequestContext(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {})
+	p := startProxy(t)
+	currentRegistry().get("openai").client = &http.Client{Transport: panicTransport{}}
+	logs := captureLog(t)
+
+	// Единый маршрут: провайдер в логе - выбранный по модели, а не сегмент пути "v1"
+	resp, body := p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	var out struct {
+		Error     string `json:"error"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatal(err)
+	}
+	id := resp.Header.Get("X-Request-ID")
+	if out.Error != "Internal server error" || id == "" || out.RequestID != id {
+		t.Fatalf("response %s, X-Request-ID %q", body, id)
+	}
+
+	log := logs.String()
+	if !strings.Contains(log, "PANIC: transport exploded request_id="+id+" provider=openai method=POST path=/v1/chat/completions\n") {
+		t.Fatalf("no enriched panic line:\n%s", log)
+	}
+	if !strings.Contains(log, "goroutine ") || !strings.Contains(log, "RoundTrip") {
+		t.Fatalf("no stack trace in the panic log:\n%s", log)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// modelPrice - цена за 1M токенов в USD
+type modelPrice struct {
+	prefix string
+	input  float64
+	output float64
+}
+
+// modelPrices отсортированы от длинного префикса к короткому
+var modelPrices []modelPrice
+
+// initModelPrices читает PROXY_MODEL_PRICES вида "gpt-4o=2.5:10,gpt-4o-mini=0.15:0.6"
+// (модель или префикс = цена входа:выхода за 1M токенов)
+func initModelPrices() {
+	modelPrices = nil
+	for _, item := range strings.Split(os.Getenv("PROXY_MODEL_PRICES"), ",") {
+		model, prices, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			continue
+		}
+		in, out, _ := strings.Cut(prices, ":")
+		inPrice, err1 := strconv.ParseFloat(strings.TrimSpace(in), 64)
+		outPrice, err2 := strconv.ParseFloat(strings.TrimSpace(out), 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		modelPrices = append(modelPrices, modelPrice{prefix: strings.TrimSpace(model), input: inPrice, output: outPrice})
+	}
+	sort.Slice(modelPrices, func(i, j int) bool {
+		return len(modelPrices[i].prefix) > len(modelPrices[j].prefix)
+	})
+}
+
+// costNanoUSD - стоимость запроса в миллиардных долях доллара (0, если цена модели неизвестна)
+func costNanoUSD(u tokenUsage) int64 {
+	for _, p := range modelPrices {
+		if strings.HasPrefix(u.Model, p.prefix) {
+			// цена за 1M токенов = 1000 nanoUSD за токен на каждый доллар
+			return int64(float64(u.PromptTokens)*p.input*1000 + float64(u.CompletionTokens)*p.output*1000)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Состояния провайдера по результату проверки готовности
+const (
+	probeUp         = "up"          // 2xx
+	probeKeyInvalid = "key_invalid" // 401/403: провайдер доступен, ключ не принят
+	probeReachable  = "reachable"   // прочие 4xx: провайдер отвечает, путь проверки не подтверждает работу
+	probeDown       = "down"        // 5xx или сетевая ошибка
+)
+
+// probeInterval - как долго результат проверки провайдера считается актуальным (PROXY_PROBE_INTERVAL_SEC)
+var probeInterval time.Duration
+
+// probePath - путь проверки из <PROVIDER>_PROBE_PATH, без него - путь провайдера по умолчанию
+func probePath(prefix, def string) string {
+	path := strings.TrimSpace(os.Getenv(prefix + "PROBE_PATH"))
+	if path == "" {
+		path = def
+	}
+	return "/" + strings.TrimPrefix(path, "/")
+}
+
+// providerProbe - последний результат проверки провайдера; проверки не чаще probeInterval
+type providerProbe struct {
+	mu      sync.Mutex
+	state   string
+	status  int
+	err     string
+	checked time.Time
+	running chan struct{} // закрывается по завершении идущей проверки
+}
+
+var probes = map[string]*providerProbe{}
+
+func initProbes() {
+	probeInterval = time.Duration(envInt("PROXY_PROBE_INTERVAL_SEC", 30)) * time.Second
+	for _, p := range providers {
+		probes[p.Name] = &providerProbe{}
+	}
+}
+
+// probeState переводит ответ проверки в состояние готовности
+func probeState(status int, err error) string {
+	switch {
+	case err != nil || status >= 500:
+		return probeDown
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return probeKeyInvalid
+	case status >= 200 && status < 300:
+		return probeUp
+	default:
+		return probeReachable
+	}
+}
+
+// check возвращает результат проверки, обращаясь к провайдеру только если кэш устарел.
+// Параллельные запросы /ready ждут одну проверку, а не шлют свои; запрос к провайдеру
+// идёт без блокировки, чтобы медленный провайдер не держал чтение результата.
+func (pr *providerProbe) check(p *provider) fiber.Map {
+	pr.mu.Lock()
+	if pr.checked.IsZero() || time.Since(pr.checked) >= probeInterval {
+		if done := pr.running; done != nil {
+			pr.mu.Unlock()
+			<-done
+			pr.mu.Lock()
+		} else {
+			done := make(chan struct{})
+			pr.running = done
+			pr.mu.Unlock()
+
+			status, err := probeProvider(p)
+
+			pr.mu.Lock()
+			pr.state, pr.status, pr.err, pr.checked = probeState(status, err), status, "", time.Now()
+			if err != nil {
+				pr.err = err.Error()
+			}
+			pr.running = nil
+			close(done)
+		}
+	}
+	defer pr.mu.Unlock()
+
+	result := fiber.Map{
+		"state":      pr.state,
+		"checked_at": pr.checked.UTC().Format(time.RFC3339),
+	}
+	if pr.status != 0 {
+		result["status"] = pr.status
+	}
+	if pr.err != "" {
+		result["error"] = pr.err
+	}
+	return result
+}
+
+func probeProvider(p *provider) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	probePath, rawQuery, _ := strings.Cut(p.probePath, "?")
+	probeURL := p.baseURL + probePath
+	if q := p.withDefaultQuery(rawQuery); q != "" {
+		probeURL += "?" + q
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	if key := p.keys.pick(); key != nil {
+		setProviderAuth(req, p.Name, key.value)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, withoutQuery(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	return resp.StatusCode, nil
+}
+
+// readyHandler проверяет провайдеров с ключами (параллельно); готов, если хотя бы один провайдер не down
+func readyHandler(c *fiber.Ctx) error {
+	reg := currentRegistry()
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		result = fiber.Map{}
+		ready  bool
+	)
+	for _, p := range reg.list {
+		if len(p.keys.keys) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probe := probes[p.Name].check(p)
+			mu.Lock()
+			defer mu.Unlock()
+			if probe["state"] != probeDown {
+				ready = true
+			}
+			result[p.Name] = probe
+		}()
+	}
+	wg.Wait()
+
+	status := fiber.StatusOK
+	if !ready {
+		status = fiber.StatusServiceUnavailable
+	}
+	return c.Status(status).JSON(fiber.Map{"ready": ready, "providers": result})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// probeUpstream отвечает на проверку статусом status и считает обращения
+func probeUpstream(t *testing.T, provider string, status int, delay time.Duration) *atomic.Int32 {
+	calls := &atomic.Int32{}
+	upstream(t, provider, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(delay)
+		w.WriteHeader(status)
+	})
+	return calls
+}
+
+// ready - статус и тело /ready
+func (p *testProxy) ready(t *testing.T) (int, map[string]any) {
+	t.Helper()
+	resp, body := p.do(t, http.MethodGet, "/ready", "")
+	out := map[string]any{}
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatalf("/ready: %s: %v", body, err)
+	}
+	return resp.StatusCode, out
+}
+
+func TestReadyProbeStates(t *testing.T) {
+	t.Setenv("OPENAI_PROBE_PATH", "/v1/models")
+	var gotPath, gotAuth string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		w.Write([]byte(`{"data":[]}`))
+	})
+	probeUpstream(t, "deepseek", http.StatusUnauthorized, 0)
+	probeUpstream(t, "nebius", http.StatusServiceUnavailable, 0)
+	probeUpstream(t, "anthropic", http.StatusNotFound, 0)
+	p := startProxy(t)
+
+	status, out := p.ready(t)
+	if status != http.StatusOK || out["ready"] != true {
+		t.Fatalf("/ready: status %d, %v", status, out)
+	}
+	if gotPath != "/v1/models" || gotAuth != "Bearer sk-openai-test" {
+		t.Fatalf("probe request: path %q, Authorization %q", gotPath, gotAuth)
+	}
+	providers := out["providers"].(map[string]any)
+	for name, want := range map[string]string{"openai": probeUp, "deepseek": probeKeyInvalid, "nebius": probeDown, "anthropic": probeReachable} {
+		if got := providers[name].(map[string]any)["state"]; got != want {
+			t.Errorf("%s: state %v, want %s", name, got, want)
+		}
+	}
+}
+
+func TestReadyAllDown(t *testing.T) {
+	probeUpstream(t, "openai", http.StatusServiceUnavailable, 0)
+	p := startProxy(t)
+
+	status, out := p.ready(t)
+	if status != http.StatusServiceUnavailable || out["ready"] != false {
+		t.Fatalf("/ready: status %d, %v", status, out)
+	}
+	// Провайдеры без ключа не проверяются
+	if providers := out["providers"].(map[string]any); len(providers) != 1 {
+		t.Fatalf("probed providers = %v, want only openai", providers)
+	}
+}
+
+func TestReadyProbeCached(t *testing.T) {
+	calls := probeUpstream(t, "openai", http.StatusOK, 0)
+	p := startProxy(t)
+
+	for range 3 {
+		p.ready(t)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("probes = %d within the interval, want 1", n)
+	}
+}
+
+func TestReadyProbesConcurrently(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	openai := probeUpstream(t, "openai", http.StatusOK, delay)
+	deepseek := probeUpstream(t, "deepseek", http.StatusOK, delay)
+	p := startProxy(t)
+
+	// Провайдеры проверяются параллельно, одновременные /ready ждут одну проверку
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status, out := p.ready(t); status != http.StatusOK {
+				t.Errorf("/ready: status %d, %v", status, out)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed >= 2*delay {
+		t.Fatalf("/ready took %s, want providers probed in parallel", elapsed)
+	}
+	if openai.Load() != 1 || deepseek.Load() != 1 {
+		t.Fatalf("probes: openai %d, deepseek %d, want 1 each", openai.Load(), deepseek.Load())
+	}
+}
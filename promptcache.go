@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// promptCacheSessionHeader - заголовок с id сессии клиента, из которого выводится
+// prompt_cache_key (PROXY_PROMPT_CACHE_SESSION_HEADER), пусто - выключено
+var promptCacheSessionHeader string
+
+// supportsPromptCacheKey - провайдеры, принимающие prompt_cache_key в теле.
+// DeepSeek кэширует префиксы автоматически, ключ ему не нужен.
+func supportsPromptCacheKey(provider string) bool {
+	return provider == "openai"
+}
+
+// derivePromptCacheKey - стабильный ключ кэша: одна сессия - один ключ, сам id провайдеру не уходит
+func derivePromptCacheKey(session string) string {
+	sum := sha256.Sum256([]byte(session))
+	return "sess-" + hex.EncodeToString(sum[:16])
+}
+
+// injectPromptCacheKey добавляет prompt_cache_key, если клиент не передал свой
+func injectPromptCacheKey(jb *jsonBody, session string) bool {
+	if _, ok := jb.fields["prompt_cache_key"]; ok {
+		return false
+	}
+	jb.set("prompt_cache_key", derivePromptCacheKey(session))
+	return true
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// cacheKeyUpstream запоминает prompt_cache_key из тела запроса провайдеру
+func cacheKeyUpstream(t *testing.T, provider string, keys *[]string) {
+	upstream(t, provider, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		var req struct {
+			PromptCacheKey string `json:"prompt_cache_key"`
+		}
+		json.Unmarshal(b, &req)
+		*keys = append(*keys, req.PromptCacheKey)
+		w.Write([]byte(`{}`))
+	})
+}
+
+func TestPromptCacheKeyPassthrough(t *testing.T) {
+	var gotBody string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	body := `{"model":"gpt-4o","prompt_cache_key":"client-key","messages":[]}`
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", body)
+	if gotBody != body {
+		t.Fatalf("upstream body = %s, want %s", gotBody, body)
+	}
+}
+
+func TestPromptCacheKeyFromSession(t *testing.T) {
+	t.Setenv("PROXY_PROMPT_CACHE_SESSION_HEADER", "X-Session-Id")
+	var keys []string
+	cacheKeyUpstream(t, "openai", &keys)
+	p := startProxy(t)
+
+	for _, session := range []string{"sess-a", "sess-a", "sess-b"} {
+		p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`, "X-Session-Id", session)
+	}
+	// Без сессии и с ключом клиента - без подмены
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","prompt_cache_key":"mine"}`, "X-Session-Id", "sess-a")
+
+	want := []string{derivePromptCacheKey("sess-a"), derivePromptCacheKey("sess-a"), derivePromptCacheKey("sess-b"), "", "mine"}
+	if len(keys) != len(want) {
+		t.Fatalf("prompt_cache_key = %q, want %q", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("prompt_cache_key = %q, want %q", keys, want)
+		}
+	}
+	if keys[0] == keys[2] || keys[0] == "sess-a" {
+		t.Fatalf("derived keys %q: sessions must differ and the id must not leak", keys)
+	}
+}
+
+func TestPromptCacheKeyOnlyForSupportingProviders(t *testing.T) {
+	t.Setenv("PROXY_PROMPT_CACHE_SESSION_HEADER", "X-Session-Id")
+	var keys []string
+	cacheKeyUpstream(t, "deepseek", &keys)
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/deepseek/chat/completions", `{"model":"deepseek-chat"}`, "X-Session-Id", "sess-a")
+	if len(keys) != 1 || keys[0] != "" {
+		t.Fatalf("prompt_cache_key sent to deepseek: %q", keys)
+	}
+}
+
+func TestPromptCacheHitTokensInStats(t *testing.T) {
+	upstream(t, "deepseek", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"deepseek-chat","usage":{"prompt_tokens":100,"completion_tokens":5,"prompt_cache_hit_tokens":64,"prompt_cache_miss_tokens":36}}`))
+	})
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/deepseek/chat/completions", `{"model":"deepseek-chat"}`)
+	if got := p.providerStat(t, "deepseek", "usage", "cached_tokens"); got != float64(64) {
+		t.Fatalf("cached_tokens = %v, want 64", got)
+	}
+}
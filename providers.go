@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// providerConfig описывает провайдера, для которого регистрируются маршруты /<name>/*
+type providerConfig struct {
+	Name      string
+	Base      string
+	APIKeyEnv string
+	// ProbePath - путь проверки готовности и самопроверки, если <PROVIDER>_PROBE_PATH не задан
+	ProbePath string
+}
+
+var providers = []providerConfig{
+	{Name: "openai", Base: OpenAIBase, APIKeyEnv: "OPENAI_API_KEY", ProbePath: "/v1/models"},
+	{Name: "nebius", Base: NebiusBase, APIKeyEnv: "NEBIUS_API_KEY", ProbePath: "/v1/models"},
+	{Name: "deepseek", Base: DeepSeekBase, APIKeyEnv: "DEEPSEEK_API_KEY", ProbePath: "/models"},
+	{Name: "anthropic", Base: AnthropicBase, APIKeyEnv: "ANTHROPIC_API_KEY", ProbePath: "/v1/models"},
+}
+
+// provider - конфигурация провайдера, разрешённая из окружения при сборке реестра.
+// В обработчике запроса env не читается.
+type provider struct {
+	providerConfig
+
+	baseURL      string
+	keys         *keyPool
+	client       *http.Client
+	rewrites     []rewriteRule
+	modelAliases map[string]string
+	modelKeys    []modelKeyRule
+
+	escalationChains map[string][]string
+	statusMap        map[int]int
+	// defaultAccept - Accept для запросов без клиентского Accept (<PROVIDER>_DEFAULT_ACCEPT)
+	defaultAccept string
+	// stripParams - поля тела, которые провайдер не поддерживает (<PROVIDER>_STRIP_PARAMS)
+	stripParams []string
+	// probePath - путь проверки готовности (<PROVIDER>_PROBE_PATH); лучше дешёвый
+	// эндпоинт с авторизацией вроде /v1/models, чем корень с неоднозначным 404
+	probePath string
+	// normalizeStream - приводить поток к формату OpenAI (<PROVIDER>_NORMALIZE_STREAM)
+	normalizeStream bool
+	// defaultQuery - параметры запроса, добавляемые, если клиент их не передал (<PROVIDER>_DEFAULT_QUERY)
+	defaultQuery []queryParam
+	// contextFallbacks - модель -> модель с большим контекстом (<PROVIDER>_CONTEXT_FALLBACKS)
+	contextFallbacks    map[string]string
+	contextErrorPattern *regexp.Regexp
+}
+
+// clientStatus - статус ответа клиенту с учётом <PROVIDER>_STATUS_MAP
+func (p *provider) clientStatus(upstream int) int {
+	if mapped, ok := p.statusMap[upstream]; ok {
+		return mapped
+	}
+	return upstream
+}
+
+// parseStatusMap разбирает "429=503,500=502"
+func parseStatusMap(raw map[string]string) (map[int]int, error) {
+	result := map[int]int{}
+	for from, to := range raw {
+		f, err1 := strconv.Atoi(from)
+		t, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil || f < 100 || f > 599 || t < 100 || t > 599 {
+			return nil, fmt.Errorf("invalid status mapping %s=%s", from, to)
+		}
+		result[f] = t
+	}
+	return result, nil
+}
+
+// providerRegistry - снимок конфигурации всех провайдеров; при перезагрузке подменяется целиком.
+// Рабочее состояние провайдеров (лимитеры, статистика и т.п.) в реестр не входит: это карты
+// по имени провайдера из providers, они заполняются при сборке приложения и при подмене
+// реестра не пересоздаются - набор провайдеров и их имена реестр не меняет.
+type providerRegistry struct {
+	list   []*provider
+	byName map[string]*provider
+}
+
+func (r *providerRegistry) get(name string) *provider {
+	return r.byName[name]
+}
+
+var registry atomic.Pointer[providerRegistry]
+
+// currentRegistry возвращает действующий реестр провайдеров
+func currentRegistry() *providerRegistry {
+	return registry.Load()
+}
+
+// buildRegistry читает <PROVIDER>_* переменные окружения и собирает реестр
+func buildRegistry() (*providerRegistry, error) {
+	reg := &providerRegistry{byName: map[string]*provider{}}
+	secrets, err := readSecrets()
+	if err != nil {
+		return nil, err
+	}
+	for _, cfg := range providers {
+		prefix := envPrefix(cfg.Name)
+
+		rewrites, err := parseRewriteRules(os.Getenv(prefix + "PATH_REWRITES"))
+		if err != nil {
+			return nil, fmt.Errorf("%sPATH_REWRITES: %w", prefix, err)
+		}
+
+		statusMap, err := parseStatusMap(envMap(prefix + "STATUS_MAP"))
+		if err != nil {
+			return nil, fmt.Errorf("%sSTATUS_MAP: %w", prefix, err)
+		}
+
+		contextErrorPattern, err := parseContextErrorPattern(prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		baseURL := cfg.Base
+		if v := strings.TrimSpace(os.Getenv(prefix + "BASE_URL")); v != "" {
+			baseURL = strings.TrimRight(v, "/")
+		}
+
+		p := &provider{
+			providerConfig: cfg,
+			baseURL:        baseURL,
+			keys:           newKeyPool(providerKeys(secrets, cfg.APIKeyEnv)),
+			client:         gzipRequestClient(pinnedClient(upstreamClient(httpClient, prefix), prefix), prefix, baseURL),
+			rewrites:       rewrites,
+			modelAliases:   envMap(prefix + "MODEL_ALIASES"),
+			modelKeys:      parseModelKeyRules(envMap(prefix+"MODEL_KEYS"), secrets),
+
+			escalationChains: parseEscalationChains(envMap(prefix + "ESCALATION_CHAINS")),
+			statusMap:        statusMap,
+			defaultAccept:    strings.TrimSpace(os.Getenv(prefix + "DEFAULT_ACCEPT")),
+			stripParams:      envList(prefix + "STRIP_PARAMS"),
+			probePath:        probePath(prefix, cfg.ProbePath),
+			defaultQuery:     parseDefaultQuery(envMap(prefix + "DEFAULT_QUERY")),
+
+			contextFallbacks:    envMap(prefix + "CONTEXT_FALLBACKS"),
+			contextErrorPattern: contextErrorPattern,
+		}
+		if envBool(prefix+"NORMALIZE_STREAM", false) {
+			if supportsStreamNormalization(cfg.Name) {
+				p.normalizeStream = true
+			} else {
+				log.Printf("WARN: %sNORMALIZE_STREAM ignored: %s streams are not normalized", prefix, cfg.Name)
+			}
+		}
+		reg.list = append(reg.list, p)
+		reg.byName[p.Name] = p
+	}
+	return reg, nil
+}
+
+// validateProviderKeys логирует провайдеров без ключа; в strict-режиме возвращает ошибку.
+// skipUnconfigured (PROXY_SKIP_UNCONFIGURED_PROVIDERS): провайдеры без ключа не зарегистрированы,
+// newApp уже сообщил об этом - они не проверяются.
+func validateProviderKeys(reg *providerRegistry, strict, skipUnconfigured bool) error {
+	var missing []string
+	for _, p := range reg.list {
+		configured := len(p.keys.keys) > 0
+		if skipUnconfigured && !configured {
+			continue
+		}
+		log.Printf("%s configured: %v", p.APIKeyEnv, configured)
+		if !configured {
+			log.Printf("WARN: provider %s is registered but %s is not set", p.Name, p.APIKeyEnv)
+			missing = append(missing, p.APIKeyEnv)
+		}
+	}
+
+	if strict && len(missing) > 0 {
+		return fmt.Errorf("PROXY_STRICT_CONFIG: missing required keys: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// unsetProviderKeys убирает ключи провайдеров, пришедшие из окружения разработчика
+func unsetProviderKeys(t *testing.T) {
+	for _, p := range providers {
+		t.Setenv(p.APIKeyEnv, "")
+	}
+}
+
+// testRegistry собирает реестр провайдеров из окружения теста
+func testRegistry(t *testing.T) *providerRegistry {
+	t.Helper()
+	initSecrets()
+	reg, err := buildRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+func TestValidateProviderKeysWarns(t *testing.T) {
+	unsetProviderKeys(t)
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	reg := testRegistry(t)
+	logs := captureLog(t)
+
+	if err := validateProviderKeys(reg, false, false); err != nil {
+		t.Fatalf("non-strict validation failed: %v", err)
+	}
+	out := logs.String()
+	for _, p := range []string{"nebius", "deepseek", "anthropic"} {
+		if !strings.Contains(out, "WARN: provider "+p+" is registered but ") {
+			t.Errorf("no warning for %s:\n%s", p, out)
+		}
+	}
+	if strings.Contains(out, "WARN: provider openai") {
+		t.Errorf("warning for configured provider:\n%s", out)
+	}
+}
+
+func TestValidateProviderKeysStrict(t *testing.T) {
+	unsetProviderKeys(t)
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("ANTHROPIC_API_KEY", "sk-ant-test")
+
+	err := validateProviderKeys(testRegistry(t), true, false)
+	if err == nil {
+		t.Fatal("strict validation passed with missing keys")
+	}
+	if want := "missing required keys: NEBIUS_API_KEY, DEEPSEEK_API_KEY"; !strings.Contains(err.Error(), want) {
+		t.Fatalf("error = %v, want %q", err, want)
+	}
+
+	for _, p := range providers {
+		t.Setenv(p.APIKeyEnv, "sk-"+p.Name)
+	}
+	if err := validateProviderKeys(testRegistry(t), true, false); err != nil {
+		t.Fatalf("strict validation with all keys: %v", err)
+	}
+}
+
+func TestValidateProviderKeysSkipsUnconfigured(t *testing.T) {
+	unsetProviderKeys(t)
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	logs := captureLog(t)
+
+	// Пропущенные провайдеры не зарегистрированы: ни предупреждения, ни отказа в strict-режиме
+	if err := validateProviderKeys(testRegistry(t), true, true); err != nil {
+		t.Fatalf("strict validation failed on skipped providers: %v", err)
+	}
+	if out := logs.String(); strings.Contains(out, "is registered but") {
+		t.Fatalf("warning for a skipped provider:\n%s", out)
+	}
+}
+
+func TestSkipUnconfiguredProviders(t *testing.T) {
+	unsetProviderKeys(t)
+	t.Setenv("PROXY_SKIP_UNCONFIGURED_PROVIDERS", "true")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	logs := captureLog(t)
+	p := startProxy(t)
+
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("configured provider: status %d", resp.StatusCode)
+	}
+	if resp, _ := p.do(t, http.MethodGet, "/nebius/v1/models", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unconfigured provider: status %d, want 404", resp.StatusCode)
+	}
+	out := logs.String()
+	if !strings.Contains(out, "Registered providers: openai\n") || !strings.Contains(out, "Provider nebius skipped: NEBIUS_API_KEY not set") {
+		t.Fatalf("startup log:\n%s", out)
+	}
+}
+
+func TestUnconfiguredProviderRegisteredByDefault(t *testing.T) {
+	unsetProviderKeys(t)
+	// Статус отсутствующего ключа по умолчанию 503, здесь - прежний 500
+	t.Setenv("PROXY_MISSING_KEY_STATUS", "500")
+	p := startProxy(t)
+
+	if resp, _ := p.do(t, http.MethodGet, "/nebius/v1/models", ""); resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("unconfigured provider: status %d, want 500", resp.StatusCode)
+	}
+}
+
+func TestMissingKeyStatus(t *testing.T) {
+	unsetProviderKeys(t)
+	calls := 0
+	upstream(t, "nebius", func(w http.ResponseWriter, r *http.Request) { calls++ })
+	t.Setenv("NEBIUS_API_KEY", "")
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	resp, body := p.do(t, http.MethodPost, "/nebius/v1/chat/completions", `{"model":"llama"}`)
+	if resp.StatusCode != http.StatusServiceUnavailable || calls != 0 {
+		t.Fatalf("status %d, upstream calls %d: %s", resp.StatusCode, calls, body)
+	}
+	golden(t, "missing_key_error", []byte(body+"\n"))
+	if !strings.Contains(logs.String(), "ERROR: NEBIUS_API_KEY not configured") {
+		t.Fatalf("missing key not logged:\n%s", logs)
+	}
+
+	// В схеме OpenAI тип ошибки сохраняется
+	t.Setenv("PROXY_ERROR_FORMAT", "openai")
+	p = startProxy(t)
+	resp, body = p.do(t, http.MethodPost, "/nebius/v1/chat/completions", `{"model":"llama"}`)
+	if e := openAIError(t, body); resp.StatusCode != http.StatusServiceUnavailable || e["type"] != "provider_not_configured" ||
+		e["message"] != "NEBIUS_API_KEY not configured" || e["provider"] != "nebius" {
+		t.Fatalf("OpenAI format: status %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestRegistryResolvesProviders(t *testing.T) {
+	unsetProviderKeys(t)
+	t.Setenv("OPENAI_API_KEY", "sk-a,sk-b")
+	t.Setenv("DEEPSEEK_BASE_URL", "http://127.0.0.1:9999/")
+	t.Setenv("DEEPSEEK_MODEL_ALIASES", "fast=deepseek-chat")
+	reg := testRegistry(t)
+
+	if len(reg.list) != len(providers) {
+		t.Fatalf("registry has %d providers, want %d", len(reg.list), len(providers))
+	}
+	openai := reg.get("openai")
+	if openai.baseURL != OpenAIBase || len(openai.keys.keys) != 2 || openai.client == nil {
+		t.Fatalf("openai: base %q, %d keys", openai.baseURL, len(openai.keys.keys))
+	}
+	deepseek := reg.get("deepseek")
+	if deepseek.baseURL != "http://127.0.0.1:9999" || deepseek.modelAliases["fast"] != "deepseek-chat" {
+		t.Fatalf("deepseek: base %q, aliases %v", deepseek.baseURL, deepseek.modelAliases)
+	}
+	if len(reg.get("nebius").keys.keys) != 0 || reg.get("unknown") != nil {
+		t.Fatal("registry resolved a provider that is not configured")
+	}
+}
+
+func TestRegistryEnvReadAtBuildTime(t *testing.T) {
+	var gotKey string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("Authorization")
+		w.Write([]byte(`{}`))
+	})
+	t.Setenv("OPENAI_API_KEY", "sk-startup")
+	p := startProxy(t)
+
+	// Изменения окружения после старта не видны обработчику запросов
+	t.Setenv("OPENAI_API_KEY", "sk-changed")
+	t.Setenv("OPENAI_BASE_URL", "http://127.0.0.1:1")
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if gotKey != "Bearer sk-startup" {
+		t.Fatalf("Authorization = %q, want the key read at startup", gotKey)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// audioFile - содержимое "аудио" у тестового провайдера для Range-запросов
+var audioFile = bytes.Repeat([]byte("0123456789"), 100)
+
+func TestRangePassthrough(t *testing.T) {
+	var gotRange string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotRange = r.Header.Get("Range")
+		// ServeContent отвечает 206 с Content-Range и Accept-Ranges, как файловые эндпоинты провайдеров
+		http.ServeContent(w, r, "speech.mp3", time.Time{}, bytes.NewReader(audioFile))
+	})
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/files/file-1/content", "", "Range", "bytes=100-199")
+	if gotRange != "bytes=100-199" {
+		t.Fatalf("upstream Range = %q", gotRange)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", resp.StatusCode)
+	}
+	if cr := resp.Header.Get("Content-Range"); cr != fmt.Sprintf("bytes 100-199/%d", len(audioFile)) {
+		t.Fatalf("Content-Range = %q", cr)
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("Accept-Ranges = %q", resp.Header.Get("Accept-Ranges"))
+	}
+	if body != string(audioFile[100:200]) {
+		t.Fatalf("body = %q", body)
+	}
+}
+
+func TestRangeChunkedPartialContent(t *testing.T) {
+	part := strings.Repeat("x", 4096)
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		// Без Content-Length: 206 приходит chunked, размер известен только по концу тела
+		w.Header().Set("Content-Range", "bytes 0-8191/100000")
+		w.WriteHeader(http.StatusPartialContent)
+		for range 2 {
+			w.Write([]byte(part))
+			w.(http.Flusher).Flush()
+		}
+	})
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/audio/file", "", "Range", "bytes=0-8191")
+	if resp.StatusCode != http.StatusPartialContent || len(body) != 2*len(part) {
+		t.Fatalf("status = %d, body %d bytes", resp.StatusCode, len(body))
+	}
+	// Размер учитывается при закрытии тела, уже после отправки ответа
+	waitFor(t, "bytes_out of the 206 response", func() bool {
+		return p.providerStat(t, "openai", "bytes_out") == float64(len(body))
+	})
+}
+
+func TestRangeDownloadHoldsConcurrencySlot(t *testing.T) {
+	t.Setenv("OPENAI_MAX_CONCURRENCY", "1")
+	release := make(chan struct{})
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-8191/100000")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(strings.Repeat("x", 4096)))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.Write([]byte(strings.Repeat("y", 4096)))
+	})
+	p := startProxy(t)
+
+	resp, err := http.DefaultClient.Do(p.newRequest(t, http.MethodGet, "/openai/v1/audio/file", "", "Range", "bytes=0-8191"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", resp.StatusCode)
+	}
+	// Хендлер уже вернулся, но тело ещё передаётся: слот провайдера занят
+	if resp, body := p.do(t, http.MethodGet, "/openai/v1/audio/file", "", "Range", "bytes=0-8191"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("request during the download: status %d %s, want 503", resp.StatusCode, body)
+	}
+
+	close(release)
+	if data, err := io.ReadAll(resp.Body); err != nil || len(data) != 8192 {
+		t.Fatalf("download: %d bytes, err %v", len(data), err)
+	}
+	// Слот и учёт запроса - при закрытии тела: 206 и отклонённый 503
+	waitFor(t, "the slot and stats of the finished download", func() bool {
+		return p.providerStat(t, "openai", "in_flight") == float64(0) &&
+			p.providerStat(t, "openai", "requests") == float64(2)
+	})
+}
+
+func TestRangeSlowLogCoversBody(t *testing.T) {
+	t.Setenv("PROXY_SLOW_LOG_MS", "100")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		// Заголовки приходят сразу, медленная - передача тела
+		w.Header().Set("Content-Range", "bytes 0-8191/100000")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(strings.Repeat("x", 4096)))
+		w.(http.Flusher).Flush()
+		time.Sleep(150 * time.Millisecond)
+		w.Write([]byte(strings.Repeat("y", 4096)))
+	})
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/audio/file", "", "Range", "bytes=0-8191")
+	if resp.StatusCode != http.StatusPartialContent || len(body) != 8192 {
+		t.Fatalf("status = %d, body %d bytes", resp.StatusCode, len(body))
+	}
+	// Лог пишется при закрытии тела, уже после отправки ответа
+	waitFor(t, "slow-log line of the 206 response", func() bool {
+		return strings.Contains(logs.String(), "WARN: slow request provider=openai path=/v1/audio/file status=206")
+	})
+}
+
+var serverTimingRe = regexp.MustCompile(`^upstream;dur=([0-9.]+), proxy;dur=([0-9.]+)$`)
+
+// serverTimingOf разбирает Server-Timing прокси в миллисекунды
+func serverTimingOf(t *testing.T, resp *http.Response) (upstream, proxy float64) {
+	t.Helper()
+	m := serverTimingRe.FindStringSubmatch(resp.Header.Get("Server-Timing"))
+	if m == nil {
+		t.Fatalf("Server-Timing = %q", resp.Header.Get("Server-Timing"))
+	}
+	upstream, _ = strconv.ParseFloat(m[1], 64)
+	proxy, _ = strconv.ParseFloat(m[2], 64)
+	return upstream, proxy
+}
+
+func TestServerTiming(t *testing.T) {
+	t.Setenv("PROXY_SERVER_TIMING", "true")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	start := time.Now()
+	resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	total := float64(time.Since(start).Microseconds()) / 1000
+	up, proxy := serverTimingOf(t, resp)
+	if up < 50 || proxy < 0 || up+proxy > total {
+		t.Fatalf("upstream=%.1fms proxy=%.1fms, total %.1fms", up, proxy, total)
+	}
+
+	// У потока заголовок уходит в начале ответа
+	resp, _ = p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`, "Accept", "text/event-stream")
+	if up, _ := serverTimingOf(t, resp); up < 50 {
+		t.Fatalf("streaming upstream=%.1fms", up)
+	}
+}
+
+func TestServerTimingDisabledByDefault(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.Header.Get("Server-Timing") != "" {
+		t.Fatalf("Server-Timing = %q without PROXY_SERVER_TIMING", resp.Header.Get("Server-Timing"))
+	}
+}
+
+func TestUserAgentSuffix(t *testing.T) {
+	t.Setenv("PROXY_USER_AGENT_SUFFIX", "ai_proxy/1.2.3")
+	var gotUA string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotUA = r.Header.Get("User-Agent")
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "User-Agent", "openai-python/1.40.0")
+	if gotUA != "openai-python/1.40.0 ai_proxy/1.2.3" {
+		t.Fatalf("upstream User-Agent = %q", gotUA)
+	}
+	// Без клиентского UA уходит только суффикс
+	req := p.newRequest(t, http.MethodGet, "/openai/v1/models", "")
+	req.Header.Set("User-Agent", "")
+	send(t, req)
+	if gotUA != "ai_proxy/1.2.3" {
+		t.Fatalf("upstream User-Agent without a client UA = %q", gotUA)
+	}
+}
+
+func TestUserAgentForwardedAsIs(t *testing.T) {
+	var gotUA string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotUA = r.Header.Get("User-Agent")
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "User-Agent", "openai-python/1.40.0")
+	if gotUA != "openai-python/1.40.0" {
+		t.Fatalf("upstream User-Agent = %q", gotUA)
+	}
+}
+
+func TestUpstreamUnreachable(t *testing.T) {
+	// Порт, на котором никто не слушает
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	t.Setenv("OPENAI_BASE_URL", "http://"+ln.Addr().String())
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	var out struct {
+		Error struct{ Type, Message string }
+	}
+	json.Unmarshal([]byte(body), &out)
+	if resp.StatusCode != http.StatusBadGateway || out.Error.Type != "upstream_unreachable" || !strings.Contains(out.Error.Message, "connection refused") {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Proxy-Upstream-Status") != "" {
+		t.Fatalf("X-Proxy-Upstream-Status = %q without an upstream response", resp.Header.Get("X-Proxy-Upstream-Status"))
+	}
+}
+
+func TestUpstreamUnreachableHidesQuery(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	t.Setenv("OPENAI_BASE_URL", "http://"+ln.Addr().String())
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("OPENAI_DEFAULT_QUERY", "key=secret-default")
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	// Ни параметр по умолчанию, ни query клиента не попадают в ответ 502 и в лог
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models?token=secret-client", "")
+	if resp.StatusCode != http.StatusBadGateway || !strings.Contains(body, "connection refused") || !strings.Contains(body, "/v1/models") {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	for _, secret := range []string{"secret-default", "secret-client"} {
+		if strings.Contains(body, secret) || strings.Contains(logs.String(), secret) {
+			t.Fatalf("%s leaked:\nbody: %s\nlog:\n%s", secret, body, logs)
+		}
+	}
+}
+
+func TestUpstreamErrorPassthrough(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":{"message":"The server had an error","type":"server_error"}}`))
+	})
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if resp.StatusCode != http.StatusInternalServerError || body != `{"error":{"message":"The server had an error","type":"server_error"}}` {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Proxy-Upstream-Status") != "500" {
+		t.Fatalf("X-Proxy-Upstream-Status = %q", resp.Header.Get("X-Proxy-Upstream-Status"))
+	}
+}
+
+// statusUpstream отвечает статусом из пути: /v1/status/429
+func statusUpstream(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/v1/status/"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"error":{"code":%d}}`, code)
+	})
+}
+
+func TestStatusRemap(t *testing.T) {
+	t.Setenv("OPENAI_STATUS_MAP", "429=503,404=400")
+	statusUpstream(t)
+	p := startProxy(t)
+
+	for _, tc := range []struct{ upstream, client int }{{429, 503}, {404, 400}, {400, 400}, {500, 500}, {200, 200}} {
+		resp, body := p.do(t, http.MethodGet, fmt.Sprintf("/openai/v1/status/%d", tc.upstream), "")
+		if resp.StatusCode != tc.client {
+			t.Errorf("upstream %d: client status %d, want %d", tc.upstream, resp.StatusCode, tc.client)
+		}
+		// Тело и исходный статус сохраняются
+		if want := fmt.Sprintf(`{"error":{"code":%d}}`, tc.upstream); body != want {
+			t.Errorf("upstream %d: body %s, want %s", tc.upstream, body, want)
+		}
+		if got := resp.Header.Get("X-Proxy-Upstream-Status"); got != strconv.Itoa(tc.upstream) {
+			t.Errorf("upstream %d: X-Proxy-Upstream-Status = %q", tc.upstream, got)
+		}
+	}
+}
+
+func TestStatusRemapOffByDefault(t *testing.T) {
+	statusUpstream(t)
+	p := startProxy(t)
+
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/status/429", ""); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status %d without OPENAI_STATUS_MAP, want 429", resp.StatusCode)
+	}
+}
+
+func TestParseStatusMapErrors(t *testing.T) {
+	for _, raw := range []map[string]string{{"429": "abc"}, {"x": "503"}, {"429": "99"}, {"600": "503"}} {
+		if _, err := parseStatusMap(raw); err == nil {
+			t.Errorf("mapping %v accepted", raw)
+		}
+	}
+}
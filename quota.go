@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// quotaStore - настройки квот и токены, счётчики которых сохраняются; собирается newApp.
+// Фоновое сохранение каждый раз берёт действующее хранилище, поэтому пересборка приложения
+// (перечитывание конфигурации, тесты) не меняет файл и список токенов под ним.
+type quotaStore struct {
+	// resetHour - час UTC, с которого начинаются сутки квоты (PROXY_QUOTA_RESET_HOUR_UTC)
+	resetHour int
+	// file - файл со счётчиками квот, чтобы перезапуск не обнулял их (PROXY_QUOTA_STATE_FILE)
+	file   string
+	tokens []*apiToken
+	dirty  atomic.Bool
+}
+
+var (
+	// quotas - действующее хранилище квот; nil до первой сборки приложения
+	quotas     atomic.Pointer[quotaStore]
+	quotaSaver sync.Once
+)
+
+// quotaState - сохраняемое состояние квоты токена
+type quotaState struct {
+	Day   time.Time `json:"day"`
+	Count int       `json:"count"`
+}
+
+// quotaDayStart - начало текущих суток квоты
+func quotaDayStart(now time.Time) time.Time {
+	hour := 0
+	if s := quotas.Load(); s != nil {
+		hour = s.resetHour
+	}
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+	if now.Before(start) {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start
+}
+
+// markQuotaDirty отмечает, что счётчики изменились и их нужно сохранить
+func markQuotaDirty() {
+	if s := quotas.Load(); s != nil {
+		s.dirty.Store(true)
+	}
+}
+
+// initQuotas восстанавливает счётчики из файла и запускает периодическое сохранение
+func initQuotas() {
+	s := &quotaStore{
+		resetHour: min(max(envInt("PROXY_QUOTA_RESET_HOUR_UTC", 0), 0), 23),
+		file:      os.Getenv("PROXY_QUOTA_STATE_FILE"),
+		tokens:    tokens,
+	}
+	quotas.Store(s)
+	if s.file == "" {
+		return
+	}
+
+	if data, err := os.ReadFile(s.file); err == nil {
+		var state map[string]quotaState
+		if err := json.Unmarshal(data, &state); err != nil {
+			log.Printf("WARN: PROXY_QUOTA_STATE_FILE: %v", err)
+		}
+		for _, t := range s.tokens {
+			if st, ok := state[t.Name]; ok {
+				t.mu.Lock()
+				t.quotaDay, t.quotaCount = st.Day, st.Count
+				t.mu.Unlock()
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("WARN: PROXY_QUOTA_STATE_FILE: %v", err)
+	}
+
+	// Повторная сборка приложения (перечитывание конфигурации, тесты) не плодит воркеры
+	quotaSaver.Do(func() {
+		go func() {
+			for range time.Tick(5 * time.Second) {
+				if s := quotas.Load(); s.dirty.Swap(false) {
+					s.save()
+				}
+			}
+		}()
+	})
+}
+
+// saveQuotas сохраняет счётчики действующего хранилища
+func saveQuotas() {
+	if s := quotas.Load(); s != nil {
+		s.save()
+	}
+}
+
+// save пишет счётчики через временный файл
+func (s *quotaStore) save() {
+	if s.file == "" {
+		return
+	}
+	state := map[string]quotaState{}
+	for _, t := range s.tokens {
+		if t.DailyQuota <= 0 {
+			continue
+		}
+		t.mu.Lock()
+		state[t.Name] = quotaState{Day: t.quotaDay, Count: t.quotaCount}
+		t.mu.Unlock()
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		log.Printf("WARN: failed to save quota state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.file); err != nil {
+		log.Printf("WARN: failed to save quota state: %v", err)
+	}
+}
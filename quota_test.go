@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const quotaTokens = `[{"name":"team-a","token":"tok-a","daily_quota":2}]`
+
+func TestDailyQuota(t *testing.T) {
+	useTokens(t, quotaTokens)
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	for i := range 2 {
+		if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", "tok-a"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, resp.StatusCode)
+		}
+	}
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", "tok-a")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("over quota: status %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	var out struct {
+		Error     string
+		Quota     int
+		Remaining *int
+		ResetAt   time.Time `json:"reset_at"`
+	}
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Error != "daily quota exceeded" || out.Quota != 2 || out.Remaining == nil || *out.Remaining != 0 {
+		t.Fatalf("over quota: %s", body)
+	}
+	if want := quotaDayStart(time.Now()).Add(24 * time.Hour); !out.ResetAt.Equal(want) {
+		t.Fatalf("reset_at = %s, want %s", out.ResetAt, want)
+	}
+
+	q := p.whoami(t, "tok-a")["daily_quota"].(map[string]any)
+	if q["limit"] != float64(2) || q["remaining"] != float64(0) {
+		t.Fatalf("whoami daily_quota = %v", q)
+	}
+}
+
+func TestDailyQuotaNotChargedForLocalRejections(t *testing.T) {
+	t.Setenv("PROXY_MAX_MESSAGES", "1")
+	useTokens(t, `[{"name":"team-a","token":"tok-a","daily_quota":2,"models":["gpt-4o-mini"]}]`)
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	// До провайдера запрос не дошёл - квота не тратится
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o-mini","messages":[{},{}]}`, "X-Proxy-Auth", "tok-a"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("too many messages: status %d", resp.StatusCode)
+	}
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"o3"}`, "X-Proxy-Auth", "tok-a"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("disallowed model: status %d", resp.StatusCode)
+	}
+	if q := p.whoami(t, "tok-a")["daily_quota"].(map[string]any); q["remaining"] != float64(2) {
+		t.Fatalf("whoami daily_quota = %v after local rejections", q)
+	}
+}
+
+func TestDailyQuotaResetBoundary(t *testing.T) {
+	prev := quotas.Load()
+	quotas.Store(&quotaStore{resetHour: 6})
+	t.Cleanup(func() { quotas.Store(prev) })
+
+	tok := &apiToken{Name: "team-a", DailyQuota: 1}
+	before := time.Date(2026, 3, 10, 5, 59, 0, 0, time.UTC)
+	if !tok.allowQuota(before) || tok.allowQuota(before) {
+		t.Fatal("quota of 1 is not enforced")
+	}
+	// До 06:00 UTC - те же сутки, после - новые
+	if tok.allowQuota(before.Add(30 * time.Second)) {
+		t.Fatal("quota was reset before the boundary")
+	}
+	after := before.Add(time.Minute)
+	if snap := tok.quotaSnapshot(after); snap["remaining"] != 1 || snap["reset_at"] != "2026-03-11T06:00:00Z" {
+		t.Fatalf("snapshot after the boundary = %v", snap)
+	}
+	if !tok.allowQuota(after) {
+		t.Fatal("quota was not reset at the boundary")
+	}
+}
+
+func TestDailyQuotaSurvivesRestart(t *testing.T) {
+	useTokens(t, quotaTokens)
+	t.Setenv("PROXY_QUOTA_STATE_FILE", filepath.Join(t.TempDir(), "quota.json"))
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", "tok-a")
+	// То же, что делает serve после остановки listener-ов
+	saveQuotas()
+
+	p = startProxy(t)
+	if q := p.whoami(t, "tok-a")["daily_quota"].(map[string]any); q["remaining"] != float64(1) {
+		t.Fatalf("daily_quota after restart = %v", q)
+	}
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", "tok-a")
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", "tok-a"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("third request of the day after restart: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Режимы PROXY_RECORD_MODE
+const (
+	recordModeRecord       = "record"
+	recordModeReplay       = "replay"
+	recordModeReplayStrict = "replay-strict"
+)
+
+// recordStore - каталог записей и режим, собранные newApp. Хендлер берёт его один раз на запрос:
+// поток сохраняет запись уже после возврата из хендлера, а пересборка приложения (тесты)
+// подменяет хранилище целиком, не трогая поля того, с которым поток работает.
+type recordStore struct {
+	// dir - каталог с записанными ответами (PROXY_RECORD_DIR)
+	dir string
+	// mode - record: сохранять ответы; replay: отдавать записанные, при промахе идти к провайдеру;
+	// replay-strict: при промахе ошибка
+	mode string
+	// gzip - сжимать новые записи (PROXY_RECORD_GZIP); читаются записи в обоих форматах
+	gzip bool
+}
+
+// recorder - хранилище записей; nil - запись и воспроизведение выключены
+var recorder atomic.Pointer[recordStore]
+
+// currentRecorder возвращает действующее хранилище записей, nil - выключено
+func currentRecorder() *recordStore {
+	return recorder.Load()
+}
+
+// recording - сохранённый ответ провайдера; для SSE Body - сырой поток событий
+type recording struct {
+	Provider string      `json:"provider"`
+	Method   string      `json:"method"`
+	Path     string      `json:"path"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Stream   bool        `json:"stream,omitempty"`
+	Body     []byte      `json:"body"`
+}
+
+func initRecording() error {
+	recorder.Store(nil)
+	s := &recordStore{dir: strings.TrimSpace(os.Getenv("PROXY_RECORD_DIR"))}
+	if s.dir == "" {
+		return nil
+	}
+	s.gzip = envBool("PROXY_RECORD_GZIP", false)
+	s.mode = strings.ToLower(strings.TrimSpace(os.Getenv("PROXY_RECORD_MODE")))
+	switch s.mode {
+	case "":
+		s.mode = recordModeRecord
+	case recordModeRecord, recordModeReplay, recordModeReplayStrict:
+	default:
+		return fmt.Errorf("PROXY_RECORD_MODE: unknown mode %q", s.mode)
+	}
+	if s.mode == recordModeRecord {
+		if err := os.MkdirAll(s.dir, 0o755); err != nil {
+			return fmt.Errorf("PROXY_RECORD_DIR: %w", err)
+		}
+	}
+	recorder.Store(s)
+	log.Printf("Recording mode: %s (dir %s)", s.mode, s.dir)
+	return nil
+}
+
+func (s *recordStore) recordingEnabled() bool { return s != nil && s.mode == recordModeRecord }
+func (s *recordStore) replayEnabled() bool    { return s != nil && s.mode != recordModeRecord }
+
+// recordingKey - хеш запроса: провайдер, метод, путь с query и тело (после преобразований).
+// Возобновлённый поток (Last-Event-ID) - другой ответ, поэтому ID входит в ключ.
+func recordingKey(provider, method, pathWithQuery, lastEventID string, body []byte) string {
+	var headers []string
+	if lastEventID != "" {
+		headers = []string{lastEventID}
+	}
+	return requestHash(provider, method, pathWithQuery, headers, body, nil)
+}
+
+func (s *recordStore) path(key string, compressed bool) string {
+	if compressed {
+		return filepath.Join(s.dir, key+".json.gz")
+	}
+	return filepath.Join(s.dir, key+".json")
+}
+
+// load возвращает nil без ошибки, если запись не найдена.
+// Сжатая запись приоритетнее: она появляется, когда PROXY_RECORD_GZIP включили позже.
+func (s *recordStore) load(key string) (*recording, error) {
+	data, err := os.ReadFile(s.path(key, true))
+	if err == nil {
+		data, err = decodeBody(data, "gzip")
+		if err != nil {
+			return nil, fmt.Errorf("recording %s: %w", key, err)
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		data, err = os.ReadFile(s.path(key, false))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("recording %s: %w", key, err)
+	}
+	return &rec, nil
+}
+
+// save пишет запись через временный файл, чтобы параллельные запросы не видели обрывок
+func (s *recordStore) save(key string, rec *recording) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("WARN: failed to encode recording %s: %v", key, err)
+		return
+	}
+	if s.gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		data = buf.Bytes()
+	}
+	tmp, err := os.CreateTemp(s.dir, key+".*.tmp")
+	if err != nil {
+		log.Printf("WARN: failed to save recording %s: %v", key, err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(key, s.gzip))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("WARN: failed to save recording %s: %v", key, err)
+	}
+}
+
+// serveRecording отдаёт записанный ответ. SSE проходит через тот же tap, что и живой поток,
+// поэтому учёт токенов и скрытие добавленного usage работают одинаково.
+// streamed - ответ отдаётся через stream writer, статистику пишет он сам.
+func serveRecording(c *fiber.Ctx, rec *recording, provider, tag, path string, info requestInfo, start time.Time) (streamed bool, err error) {
+	copyResponseHeaders(c, &http.Response{Header: rec.Header})
+	c.Set("X-Proxy-Replay", "hit")
+	c.Set("X-Proxy-Upstream-Status", strconv.Itoa(rec.Status))
+	c.Status(rec.Status)
+
+	if rec.Stream {
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			tap := newStreamTap(provider)
+			tap.stripUsage = info.usageInjected && stripInjectedUsage
+			out := w
+			if p := currentRegistry().get(provider); p != nil && p.normalizeStream {
+				var finish func()
+				out, finish = newNormalizedStreamWriter(w, provider)
+				defer finish()
+			}
+			pipeStream(out, bytes.NewReader(rec.Body), tap)
+			if u, ok := tap.finalUsage(); ok {
+				recordUsage(provider, tag, u)
+			}
+			recordRequest(provider, tag, rec.Status)
+			logSlowRequest(provider, path, rec.Status, time.Since(start))
+		})
+		return true, nil
+	}
+
+	if u, ok := extractUsage(provider, rec.Body, rec.Header.Get("Content-Encoding")); ok {
+		recordUsage(provider, tag, u)
+	}
+	body, ok := transformResponse(c, provider, path, rec.Status, rec.Header, rec.Body)
+	if !ok {
+		return false, nil
+	}
+	return false, c.Send(body)
+}
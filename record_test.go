@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const recordedChunks = "data: {\"choices\":[{\"delta\":{\"content\":\"rec\"}}]}\n\n" +
+	"data: {\"choices\":[{\"delta\":{\"content\":\"orded\"}}]}\n\n" +
+	"data: [DONE]\n\n"
+
+// recordingUpstream отвечает JSON или SSE в зависимости от "stream" в теле
+func recordingUpstream(t *testing.T) *int {
+	calls := new(int)
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if r.URL.Path == "/v1/chat/completions" && r.Header.Get("Accept") == "text/event-stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte(recordedChunks))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Upstream", "live")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"chatcmpl-rec","choices":[{"message":{"content":"recorded"}}]}`))
+	})
+	return calls
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROXY_RECORD_DIR", dir)
+	calls := recordingUpstream(t)
+	p := startProxy(t)
+
+	const chat = `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+	const streamChat = `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	_, liveBody := p.do(t, http.MethodPost, "/openai/v1/chat/completions", chat)
+	_, liveStream := p.do(t, http.MethodPost, "/openai/v1/chat/completions", streamChat, "Accept", "text/event-stream")
+	if liveStream != recordedChunks {
+		t.Fatalf("live stream = %q", liveStream)
+	}
+	waitFor(t, "two recordings", func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		return len(files) == 2
+	})
+
+	// Повторный запуск в strict replay: провайдер не вызывается
+	t.Setenv("PROXY_RECORD_MODE", "replay-strict")
+	p = startProxy(t)
+	*calls = 0
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", chat)
+	if resp.StatusCode != http.StatusCreated || body != liveBody {
+		t.Fatalf("replayed: status %d body %s, want 201 %s", resp.StatusCode, body, liveBody)
+	}
+	if resp.Header.Get("X-Proxy-Replay") != "hit" || resp.Header.Get("X-Upstream") != "live" {
+		t.Fatalf("replayed headers = %v", resp.Header)
+	}
+	resp, stream := p.do(t, http.MethodPost, "/openai/v1/chat/completions", streamChat, "Accept", "text/event-stream")
+	if stream != recordedChunks || resp.Header.Get("X-Proxy-Replay") != "hit" {
+		t.Fatalf("replayed stream = %q", stream)
+	}
+	if *calls != 0 {
+		t.Fatalf("replay called the provider %d times", *calls)
+	}
+
+	// Промах в strict replay - ошибка, а не запрос к провайдеру
+	resp, body = p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","messages":[]}`)
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "no recorded response") || *calls != 0 {
+		t.Fatalf("strict miss: status %d: %s, provider calls %d", resp.StatusCode, body, *calls)
+	}
+}
+
+func TestReplayMissFallsThrough(t *testing.T) {
+	t.Setenv("PROXY_RECORD_DIR", t.TempDir())
+	t.Setenv("PROXY_RECORD_MODE", "replay")
+	calls := recordingUpstream(t)
+	p := startProxy(t)
+
+	resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if resp.StatusCode != http.StatusCreated || *calls != 1 || resp.Header.Get("X-Proxy-Replay") != "" {
+		t.Fatalf("replay miss: status %d, provider calls %d", resp.StatusCode, *calls)
+	}
+}
+
+// disconnectUpstream отдаёт первое событие, ждёт отключения клиента и досылает остальные
+func disconnectUpstream(t *testing.T, clientGone chan struct{}) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"0\"}}]}\n\n"))
+		w.(http.Flusher).Flush()
+		<-clientGone
+		for i := 1; i <= 20; i++ {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":\"%d\"}}]}\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(5 * time.Millisecond)
+		}
+		w.Write([]byte("data: [DONE]\n\n"))
+	})
+}
+
+// readFirstEventAndDisconnect читает первое событие потока и обрывает соединение
+func readFirstEventAndDisconnect(t *testing.T, p *testProxy) {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(p.newRequest(t, http.MethodPost, "/openai/v1/chat/completions",
+		`{"model":"gpt-4o","stream":true}`, "Accept", "text/event-stream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, _ := bufio.NewReader(resp.Body).ReadString('\n'); !strings.HasPrefix(line, "data: ") {
+		t.Fatalf("first line = %q", line)
+	}
+	resp.Body.Close()
+}
+
+func TestStreamNotDrainedForRecordingOnly(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROXY_RECORD_DIR", dir)
+	t.Setenv("PROXY_STREAM_DRAIN_ON_DISCONNECT", "true")
+	clientGone := make(chan struct{})
+	disconnectUpstream(t, clientGone)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	readFirstEventAndDisconnect(t, p)
+	close(clientGone)
+
+	// Дочитываются только кэшируемые потоки: запись сама по себе не стоит лишних токенов провайдера
+	waitFor(t, "the stream to end", func() bool { return strings.Contains(logs.String(), "Client closed openai stream") })
+	if strings.Contains(logs.String(), "Drained") {
+		t.Fatalf("stream drained without a cache policy:\n%s", logs)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 0 {
+		t.Fatalf("incomplete stream recorded: %v", files)
+	}
+}
+
+func TestStreamNotDrainedByDefault(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROXY_RECORD_DIR", dir)
+	clientGone := make(chan struct{})
+	disconnectUpstream(t, clientGone)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	readFirstEventAndDisconnect(t, p)
+	close(clientGone)
+
+	// Оборванный поток не записывается
+	waitFor(t, "the stream to end", func() bool { return strings.Contains(logs.String(), "Client closed openai stream") })
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 0 {
+		t.Fatalf("incomplete stream recorded: %v", files)
+	}
+}
+
+func TestRecordingGzipRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROXY_RECORD_DIR", dir)
+	t.Setenv("PROXY_RECORD_GZIP", "true")
+	calls := recordingUpstream(t)
+	p := startProxy(t)
+
+	const chat = `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+	const streamChat = `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	_, liveBody := p.do(t, http.MethodPost, "/openai/v1/chat/completions", chat)
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", streamChat, "Accept", "text/event-stream")
+	waitFor(t, "two gzip recordings", func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json.gz"))
+		return len(files) == 2
+	})
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json.gz"))
+	for _, f := range files {
+		if data, _ := os.ReadFile(f); len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+			t.Fatalf("%s is not gzip: %q", f, data)
+		}
+	}
+
+	// Сжатые записи читаются прозрачно, в том числе с выключенным PROXY_RECORD_GZIP
+	for _, gz := range []string{"true", "false"} {
+		t.Setenv("PROXY_RECORD_GZIP", gz)
+		t.Setenv("PROXY_RECORD_MODE", "replay-strict")
+		p = startProxy(t)
+		*calls = 0
+
+		resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", chat)
+		if resp.StatusCode != http.StatusCreated || body != liveBody {
+			t.Fatalf("gzip=%s: replayed status %d body %s, want 201 %s", gz, resp.StatusCode, body, liveBody)
+		}
+		if _, stream := p.do(t, http.MethodPost, "/openai/v1/chat/completions", streamChat, "Accept", "text/event-stream"); stream != recordedChunks {
+			t.Fatalf("gzip=%s: replayed stream = %q", gz, stream)
+		}
+		if *calls != 0 {
+			t.Fatalf("gzip=%s: replay called the provider %d times", gz, *calls)
+		}
+	}
+}
+
+func TestRecordingGzipReadsPlainRecordings(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROXY_RECORD_DIR", dir)
+	recordingUpstream(t)
+	p := startProxy(t)
+	_, liveBody := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	waitFor(t, "a plain recording", func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		return len(files) == 1
+	})
+
+	// Записи, сделанные до включения сжатия, остаются пригодными
+	t.Setenv("PROXY_RECORD_GZIP", "true")
+	t.Setenv("PROXY_RECORD_MODE", "replay-strict")
+	p = startProxy(t)
+	if resp, body := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.Header.Get("X-Proxy-Replay") != "hit" || body != liveBody {
+		t.Fatalf("plain recording with gzip on: replay %q, body %s", resp.Header.Get("X-Proxy-Replay"), body)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultGzipRequestMinBytes - порог сжатия тела запроса по умолчанию
+const defaultGzipRequestMinBytes = 64 * 1024
+
+// gzipRequestTransport сжимает JSON-тела запросов к провайдеру от порога размера и ставит
+// Content-Encoding: gzip. Включается только для провайдеров, принимающих сжатые тела:
+//
+//	<PROVIDER>_GZIP_REQUESTS           - сжимать тела запросов
+//	<PROVIDER>_GZIP_REQUESTS_MIN_BYTES - порог размера тела (по умолчанию 64KB)
+//	<PROVIDER>_GZIP_REQUESTS_PATHS     - префиксы путей провайдера (v1/embeddings,...), пусто - все
+//
+// Сжатие на уровне транспорта, поэтому действует на все попытки: повторы, эскалацию, части embeddings.
+type gzipRequestTransport struct {
+	base     http.RoundTripper
+	minBytes int64
+	// basePath - путь base URL провайдера, отрезается перед сравнением с paths
+	basePath string
+	paths    []string
+}
+
+// gzipRequestClient оборачивает транспорт клиента, если сжатие включено для провайдера
+func gzipRequestClient(base *http.Client, prefix, baseURL string) *http.Client {
+	if !envBool(prefix+"GZIP_REQUESTS", false) {
+		return base
+	}
+	t := &gzipRequestTransport{
+		base:     base.Transport,
+		minBytes: int64(envInt(prefix+"GZIP_REQUESTS_MIN_BYTES", defaultGzipRequestMinBytes)),
+	}
+	if u, err := url.Parse(baseURL); err == nil {
+		t.basePath = strings.TrimRight(u.Path, "/")
+	}
+	for _, p := range envList(prefix + "GZIP_REQUESTS_PATHS") {
+		t.paths = append(t.paths, strings.TrimPrefix(p, "/"))
+	}
+	client := *base
+	client.Transport = t
+	return &client
+}
+
+// applies - запрос подлежит сжатию: известная длина от порога, JSON, ещё не сжат, путь из списка.
+// Потоковые загрузки (multipart, длина неизвестна) не трогаем.
+func (t *gzipRequestTransport) applies(req *http.Request) bool {
+	if req.Body == nil || req.ContentLength < t.minBytes || req.ContentLength <= 0 ||
+		req.Header.Get("Content-Encoding") != "" ||
+		!strings.Contains(strings.ToLower(req.Header.Get("Content-Type")), "json") {
+		return false
+	}
+	if len(t.paths) == 0 {
+		return true
+	}
+	path := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, t.basePath), "/")
+	for _, p := range t.paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *gzipRequestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.applies(req) {
+		return t.base.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(body)
+	zw.Close()
+
+	next := req.Clone(req.Context())
+	payload := body
+	// Несжимаемое тело (base64 и т.п.) отправляем как есть
+	if buf.Len() < len(body) {
+		payload = buf.Bytes()
+		next.Header.Set("Content-Encoding", "gzip")
+	}
+	next.Body = io.NopCloser(bytes.NewReader(payload))
+	next.ContentLength = int64(len(payload))
+	next.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(payload)), nil }
+	return t.base.RoundTrip(next)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// gzipProbe - что провайдер получил: Content-Encoding, длину на проводе и распакованное тело
+type gzipProbe struct {
+	encoding string
+	wireLen  int64
+	body     string
+}
+
+func gzipEcho(t *testing.T, provider string, got *gzipProbe) {
+	upstream(t, provider, func(w http.ResponseWriter, r *http.Request) {
+		got.encoding, got.wireLen = r.Header.Get("Content-Encoding"), r.ContentLength
+		var body io.Reader = r.Body
+		if got.encoding == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("invalid gzip body: %v", err)
+				return
+			}
+			body = zr
+		}
+		data, _ := io.ReadAll(body)
+		got.body = string(data)
+		w.Write([]byte(`{}`))
+	})
+}
+
+// largeEmbeddings - хорошо сжимаемое тело embeddings на ~100KB
+var largeEmbeddings = `{"model":"text-embedding-3-small","input":["` + strings.Repeat("lorem ipsum ", 8*1024) + `"]}`
+
+func TestGzipRequestBody(t *testing.T) {
+	t.Setenv("OPENAI_GZIP_REQUESTS", "true")
+	t.Setenv("OPENAI_GZIP_REQUESTS_PATHS", "v1/embeddings")
+	var openai, deepseek gzipProbe
+	gzipEcho(t, "openai", &openai)
+	gzipEcho(t, "deepseek", &deepseek)
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/embeddings", largeEmbeddings)
+	if openai.encoding != "gzip" || openai.wireLen >= int64(len(largeEmbeddings)) || openai.body != largeEmbeddings {
+		t.Fatalf("enabled provider: encoding %q, %d bytes on the wire, body intact %t", openai.encoding, openai.wireLen, openai.body == largeEmbeddings)
+	}
+
+	// Путь не из списка, малое тело и провайдер без GZIP_REQUESTS - как есть
+	for _, c := range []struct {
+		path, body string
+		got        *gzipProbe
+	}{
+		{"/openai/v1/chat/completions", largeEmbeddings, &openai},
+		{"/openai/v1/embeddings", `{"input":"hi"}`, &openai},
+		{"/deepseek/v1/embeddings", largeEmbeddings, &deepseek},
+	} {
+		p.do(t, http.MethodPost, c.path, c.body)
+		if c.got.encoding != "" || c.got.wireLen != int64(len(c.body)) || c.got.body != c.body {
+			t.Errorf("%s (%d bytes): encoding %q, %d bytes on the wire", c.path, len(c.body), c.got.encoding, c.got.wireLen)
+		}
+	}
+}
+
+func TestGzipRequestBodyThreshold(t *testing.T) {
+	t.Setenv("OPENAI_GZIP_REQUESTS", "true")
+	t.Setenv("OPENAI_GZIP_REQUESTS_MIN_BYTES", "100")
+	var got gzipProbe
+	gzipEcho(t, "openai", &got)
+	p := startProxy(t)
+
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"` + strings.Repeat("a", 200) + `"}]}`
+	if p.do(t, http.MethodPost, "/openai/v1/chat/completions", body); got.encoding != "gzip" || got.body != body {
+		t.Fatalf("body above the threshold: encoding %q", got.encoding)
+	}
+	// Не-JSON тело не сжимается
+	if p.do(t, http.MethodPost, "/openai/v1/chat/completions", strings.Repeat("a", 200), "Content-Type", "text/plain"); got.encoding != "" {
+		t.Fatalf("text body: encoding %q", got.encoding)
+	}
+}
+
+func TestGzipRequestBodyOffByDefault(t *testing.T) {
+	var got gzipProbe
+	gzipEcho(t, "openai", &got)
+	p := startProxy(t)
+
+	if p.do(t, http.MethodPost, "/openai/v1/embeddings", largeEmbeddings); got.encoding != "" || got.body != largeEmbeddings {
+		t.Fatalf("encoding %q without OPENAI_GZIP_REQUESTS", got.encoding)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryBudgetState - token bucket на повторы: каждый запрос пополняет бюджет на ratio,
+// каждый повтор тратит единицу. Не даёт повторам умножать нагрузку при падении провайдера.
+type retryBudgetState struct {
+	mu         sync.Mutex
+	ratio      float64
+	max        float64
+	tokens     float64
+	retries    int64
+	suppressed int64
+}
+
+func newRetryBudget(ratio, max float64) *retryBudgetState {
+	return &retryBudgetState{ratio: ratio, max: max, tokens: max}
+}
+
+func (b *retryBudgetState) onRequest() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.max, b.tokens+b.ratio)
+}
+
+// tryRetry списывает токен; false - бюджет исчерпан, повтор подавлен
+func (b *retryBudgetState) tryRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		b.suppressed++
+		return false
+	}
+	b.tokens--
+	b.retries++
+	return true
+}
+
+func (b *retryBudgetState) snapshot() map[string]any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string]any{
+		"tokens":     b.tokens,
+		"max":        b.max,
+		"ratio":      b.ratio,
+		"retries":    b.retries,
+		"suppressed": b.suppressed,
+	}
+}
+
+var (
+	// maxRetries - число повторов при сетевой ошибке или 502/503/504 (0 - без повторов)
+	maxRetries int
+	// retryBackoff - пауза перед повтором, удваивается с каждой попыткой
+	retryBackoff time.Duration
+	retryBudget  *retryBudgetState
+)
+
+func initRetries() {
+	maxRetries = envInt("PROXY_MAX_RETRIES", 0)
+	retryBackoff = time.Duration(envInt("PROXY_RETRY_BACKOFF_MS", 200)) * time.Millisecond
+	retryBudget = newRetryBudget(
+		float64(envInt("PROXY_RETRY_BUDGET_PERCENT", 10))/100,
+		float64(envInt("PROXY_RETRY_BUDGET_MAX", 10)),
+	)
+}
+
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// doUpstream выполняет запрос с повторами в пределах retry-бюджета.
+// Если бюджет исчерпан, возвращается результат последней попытки. Повторяются только
+// идемпотентные запросы (POST - с Idempotency-Key): иначе повтор после ошибки может
+// выполнить запрос у провайдера дважды. Отменённый запрос (дедлайн прокси, клиент ушёл)
+// не повторяется.
+func doUpstream(client *http.Client, req *http.Request, provider string) (*http.Response, error) {
+	retryBudget.onRequest()
+
+	resp, err := sendAttempt(client, req, provider, "")
+	if !isIdempotent(req.Method, req.Header.Get("Idempotency-Key")) {
+		return resp, err
+	}
+	for attempt := 1; attempt <= maxRetries && isRetryable(resp, err) && req.GetBody != nil && req.Context().Err() == nil; attempt++ {
+		if !retryBudget.tryRetry() {
+			log.Printf("WARN: retry budget exhausted, not retrying %s request", provider)
+			break
+		}
+		reason := retryReason(resp, err)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(retryBackoff << (attempt - 1)):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		log.Printf("Retrying %s request (attempt %d/%d)", provider, attempt, maxRetries)
+
+		resp, err = resendRequest(client, req, provider, reason)
+	}
+	return resp, err
+}
+
+// resendRequest повторяет запрос с тем же телом; reason - причина повтора для журнала попыток
+func resendRequest(client *http.Client, req *http.Request, provider, reason string) (*http.Response, error) {
+	next := req.Clone(req.Context())
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	next.Body = body
+	return sendAttempt(client, next, provider, reason)
+}
+
+// cloneWithBody копирует запрос с другим телом (GetBody тоже подменяется, чтобы работали повторы)
+func cloneWithBody(req *http.Request, body []byte) *http.Request {
+	next := req.Clone(req.Context())
+	next.Body = io.NopCloser(bytes.NewReader(body))
+	next.ContentLength = int64(len(body))
+	next.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	return next
+}
+
+// retryInvalidJSON - повторять идемпотентные запросы, если 200-ответ оказался битым JSON
+var retryInvalidJSON bool
+
+// isIdempotent - метод идемпотентен или клиент передал Idempotency-Key
+func isIdempotent(method string, idempotencyKey string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return idempotencyKey != ""
+}
+
+// isMalformedJSON - успешный JSON-ответ пустой или не разбирается
+func isMalformedJSON(resp *http.Response, body []byte) bool {
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		return false
+	}
+	decoded, err := decodeBody(body, resp.Header.Get("Content-Encoding"))
+	return err != nil || !json.Valid(decoded)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetryBudgetThrottlesRetries(t *testing.T) {
+	t.Setenv("PROXY_MAX_RETRIES", "3")
+	t.Setenv("PROXY_RETRY_BACKOFF_MS", "1")
+	t.Setenv("PROXY_RETRY_BUDGET_PERCENT", "10")
+	t.Setenv("PROXY_RETRY_BUDGET_MAX", "2")
+	var calls atomic.Int64
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	p := startProxy(t)
+
+	// Первый запрос тратит весь бюджет (2 повтора), третий повтор подавлен
+	resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if resp.StatusCode != http.StatusServiceUnavailable || calls.Load() != 3 {
+		t.Fatalf("first request: status %d, upstream calls %d, want 503 after 3 calls", resp.StatusCode, calls.Load())
+	}
+	// Дальше при продолжающемся сбое - без повторов, клиент получает исходную ошибку
+	for range 5 {
+		p.do(t, http.MethodGet, "/openai/v1/models", "")
+	}
+	if calls.Load() != 8 {
+		t.Fatalf("upstream calls = %d, want 8 (retries suppressed)", calls.Load())
+	}
+
+	budget := p.stats(t)["retry_budget"].(map[string]any)
+	if budget["retries"] != float64(2) || budget["suppressed"] != float64(6) {
+		t.Fatalf("retry_budget = %v", budget)
+	}
+}
+
+func TestRetryBudgetRefills(t *testing.T) {
+	b := newRetryBudget(0.5, 1)
+	if !b.tryRetry() {
+		t.Fatal("full budget rejected a retry")
+	}
+	if b.tryRetry() {
+		t.Fatal("empty budget allowed a retry")
+	}
+	// Два запроса по 0.5 - один повтор
+	b.onRequest()
+	b.onRequest()
+	if !b.tryRetry() {
+		t.Fatal("refilled budget rejected a retry")
+	}
+	// Бюджет не копится выше max
+	for range 10 {
+		b.onRequest()
+	}
+	if !b.tryRetry() || b.tryRetry() {
+		t.Fatal("budget exceeded its max")
+	}
+}
+
+func TestRetrySucceedsWithinBudget(t *testing.T) {
+	t.Setenv("PROXY_MAX_RETRIES", "2")
+	t.Setenv("PROXY_RETRY_BACKOFF_MS", "1")
+	var calls atomic.Int64
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	if resp, _ := p.do(t, http.MethodGet, "/openai/v1/models", ""); resp.StatusCode != http.StatusOK || calls.Load() != 2 {
+		t.Fatalf("status %d after %d calls", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestRetryOnlyIdempotentRequests(t *testing.T) {
+	t.Setenv("PROXY_MAX_RETRIES", "2")
+	t.Setenv("PROXY_RETRY_BACKOFF_MS", "1")
+	var calls atomic.Int64
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	p := startProxy(t)
+
+	// POST мог быть выполнен провайдером до ошибки: без Idempotency-Key не повторяется
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("POST without Idempotency-Key: %d upstream calls, want 1", n)
+	}
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`, "Idempotency-Key", "req-1")
+	if n := calls.Load(); n != 4 {
+		t.Fatalf("POST with Idempotency-Key: %d upstream calls, want 3", n-1)
+	}
+}
+
+// flakyJSONUpstream отдаёт 200 с обрезанным JSON первые bad ответов, потом валидный
+func flakyJSONUpstream(t *testing.T, bad int64) *atomic.Int64 {
+	var calls atomic.Int64
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if calls.Add(1) <= bad {
+			w.Write([]byte(`{"id":"chatcmpl-1","choices":[{"mess`))
+			return
+		}
+		w.Write([]byte(`{"id":"chatcmpl-2","choices":[]}`))
+	})
+	return &calls
+}
+
+func TestRetryOnMalformedJSON(t *testing.T) {
+	t.Setenv("PROXY_RETRY_INVALID_JSON", "true")
+	calls := flakyJSONUpstream(t, 1)
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`, "Idempotency-Key", "req-1")
+	if resp.StatusCode != http.StatusOK || body != `{"id":"chatcmpl-2","choices":[]}` || calls.Load() != 2 {
+		t.Fatalf("status %d body %s after %d calls", resp.StatusCode, body, calls.Load())
+	}
+}
+
+func TestPersistentlyMalformedJSON(t *testing.T) {
+	t.Setenv("PROXY_RETRY_INVALID_JSON", "true")
+	calls := flakyJSONUpstream(t, 100)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	// Повтор один: после него клиент получает тело как есть
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if resp.StatusCode != http.StatusOK || body != `{"id":"chatcmpl-1","choices":[{"mess` || calls.Load() != 2 {
+		t.Fatalf("status %d body %s after %d calls", resp.StatusCode, body, calls.Load())
+	}
+	if !strings.Contains(logs.String(), "WARN: returning malformed JSON response from openai as is") {
+		t.Fatalf("no warning:\n%s", logs)
+	}
+
+	// POST без Idempotency-Key не повторяется
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if calls.Load() != 3 {
+		t.Fatalf("non-idempotent request retried: %d calls", calls.Load())
+	}
+}
+
+func TestMalformedJSONNotRetriedByDefault(t *testing.T) {
+	calls := flakyJSONUpstream(t, 1)
+	p := startProxy(t)
+
+	if _, body := p.do(t, http.MethodGet, "/openai/v1/models", ""); body != `{"id":"chatcmpl-1","choices":[{"mess` || calls.Load() != 1 {
+		t.Fatalf("body %s after %d calls", body, calls.Load())
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// rewriteRule - правило переписывания пути провайдера (префикс или regexp)
+type rewriteRule struct {
+	prefix string
+	re     *regexp.Regexp
+	to     string
+}
+
+// apply возвращает новый путь и true, если правило сработало
+func (r rewriteRule) apply(path string) (string, bool) {
+	if r.re != nil {
+		if !r.re.MatchString(path) {
+			return path, false
+		}
+		return r.re.ReplaceAllString(path, r.to), true
+	}
+	// Префикс совпадает по границе сегмента: правило v1/chat не трогает v1/chatty
+	rest, ok := strings.CutPrefix(path, r.prefix)
+	if !ok || (rest != "" && rest[0] != '/' && !strings.HasSuffix(r.prefix, "/")) {
+		return path, false
+	}
+	return r.to + rest, true
+}
+
+// parseRewriteRules разбирает "from=>to;from2=>to2". from с префиксом ~ - регулярное выражение
+// (в to доступны $1, $2...), иначе - префикс пути без ведущего слеша, целыми сегментами.
+func parseRewriteRules(spec string) ([]rewriteRule, error) {
+	var rules []rewriteRule
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(item, "=>")
+		if !ok {
+			return nil, fmt.Errorf("invalid rewrite rule %q, expected from=>to", item)
+		}
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+
+		if pattern, isRegex := strings.CutPrefix(from, "~"); isRegex {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rewrite regexp %q: %w", pattern, err)
+			}
+			rules = append(rules, rewriteRule{re: re, to: to})
+			continue
+		}
+		rules = append(rules, rewriteRule{prefix: from, to: to})
+	}
+	return rules, nil
+}
+
+// rewritePath применяет первое подходящее правило провайдера
+func (p *provider) rewritePath(path string) string {
+	for _, r := range p.rewrites {
+		if rewritten, ok := r.apply(path); ok {
+			return rewritten
+		}
+	}
+	return path
+}
+
+// queryParam - параметр запроса по умолчанию (<PROVIDER>_DEFAULT_QUERY)
+type queryParam struct{ name, value string }
+
+// parseDefaultQuery разбирает "alt=sse,api-version=2024-06-01"; порядок по имени,
+// чтобы URL (и ключи, построенные по нему) не зависел от порядка обхода map
+func parseDefaultQuery(raw map[string]string) []queryParam {
+	var params []queryParam
+	for name, value := range raw {
+		params = append(params, queryParam{name, value})
+	}
+	slices.SortFunc(params, func(a, b queryParam) int { return strings.Compare(a.name, b.name) })
+	return params
+}
+
+// withDefaultQuery дописывает к query-строке клиента параметры по умолчанию, которых в ней нет;
+// значения клиента не переопределяются, сама строка клиента передаётся без перекодирования
+func (p *provider) withDefaultQuery(rawQuery string) string {
+	if len(p.defaultQuery) == 0 {
+		return rawQuery
+	}
+	client, _ := url.ParseQuery(rawQuery)
+	var b strings.Builder
+	b.WriteString(rawQuery)
+	for _, q := range p.defaultQuery {
+		if client.Has(q.name) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(url.QueryEscape(q.name) + "=" + url.QueryEscape(q.value))
+	}
+	return b.String()
+}
+
+// withoutQuery убирает из ошибки транспорта (*url.Error) query-строку и userinfo URL:
+// в них бывают секреты (?key= у Gemini, <PROVIDER>_DEFAULT_QUERY), а текст ошибки попадает
+// в лог и в ответ 502. Остальная цепочка ошибки (таймаут, errors.Is) сохраняется.
+func withoutQuery(err error) error {
+	var ue *url.Error
+	if !errors.As(err, &ue) {
+		return err
+	}
+	u, perr := url.Parse(ue.URL)
+	if perr != nil {
+		return ue.Err
+	}
+	u.RawQuery, u.ForceQuery, u.Fragment, u.User = "", false, "", nil
+	return &url.Error{Op: ue.Op, URL: u.String(), Err: ue.Err}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPathRewriteRules(t *testing.T) {
+	t.Setenv("OPENAI_PATH_REWRITES", "chat=>v1/chat/completions;~^m/(.+)$=>v1/models/$1;v1/old/=>v1/new/")
+	var got []string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.RequestURI())
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	cases := []struct{ client, upstream string }{
+		// Префикс; query клиента сохраняется
+		{"/openai/chat?x=1", "/v1/chat/completions?x=1"},
+		{"/openai/chat/stream", "/v1/chat/completions/stream"},
+		// Regexp с захватом
+		{"/openai/m/gpt-4o", "/v1/models/gpt-4o"},
+		// Префикс со слешем на конце
+		{"/openai/v1/old/files", "/v1/new/files"},
+		// Совпадение не по границе сегмента не переписывается
+		{"/openai/chatty", "/chatty"},
+		{"/openai/v1/models", "/v1/models"},
+	}
+	for _, c := range cases {
+		got = nil
+		p.do(t, http.MethodGet, c.client, "")
+		if len(got) != 1 || got[0] != c.upstream {
+			t.Errorf("%s: upstream got %v, want %s", c.client, got, c.upstream)
+		}
+	}
+}
+
+func TestRewriteRuleSegmentBoundary(t *testing.T) {
+	rules, err := parseRewriteRules("v1/chat=>v2/chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{rewrites: rules}
+	for path, want := range map[string]string{
+		"v1/chat":             "v2/chat",
+		"v1/chat/completions": "v2/chat/completions",
+		"v1/chatty":           "v1/chatty",
+		"v1/chat-completions": "v1/chat-completions",
+	} {
+		if got := p.rewritePath(path); got != want {
+			t.Errorf("rewritePath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestParseRewriteRulesErrors(t *testing.T) {
+	for _, spec := range []string{"chat", "~(=>x"} {
+		if _, err := parseRewriteRules(spec); err == nil {
+			t.Errorf("parseRewriteRules(%q) accepted", spec)
+		}
+	}
+}
+
+func TestDefaultQuery(t *testing.T) {
+	t.Setenv("OPENAI_DEFAULT_QUERY", "api-version=2024-06-01,alt=sse")
+	var got string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RawQuery
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	cases := []struct{ client, upstream string }{
+		// Параметры по умолчанию - в порядке имени
+		{"/openai/v1/models", "alt=sse&api-version=2024-06-01"},
+		// Значение клиента приоритетнее, его строка не перекодируется
+		{"/openai/v1/models?api-version=2025-01-01&q=a%20b", "api-version=2025-01-01&q=a%20b&alt=sse"},
+		{"/openai/v1/models?alt=json&api-version=x", "alt=json&api-version=x"},
+	}
+	for _, c := range cases {
+		p.do(t, http.MethodGet, c.client, "")
+		if got != c.upstream {
+			t.Errorf("%s: upstream query %q, want %q", c.client, got, c.upstream)
+		}
+	}
+}
+
+func TestDefaultQueryInProbe(t *testing.T) {
+	t.Setenv("OPENAI_DEFAULT_QUERY", "api-version=2024-06-01")
+	t.Setenv("OPENAI_PROBE_PATH", "/v1/models?limit=1")
+	var got string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RequestURI()
+		w.Write([]byte(`{"data":[]}`))
+	})
+	p := startProxy(t)
+
+	p.ready(t)
+	if got != "/v1/models?limit=1&api-version=2024-06-01" {
+		t.Fatalf("probe request = %q", got)
+	}
+}
+
+func TestClientQueryForwardedWithoutDefaults(t *testing.T) {
+	var got string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.RawQuery
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	if p.do(t, http.MethodGet, "/openai/v1/files?purpose=batch&limit=2", ""); got != "purpose=batch&limit=2" {
+		t.Fatalf("upstream query %q", got)
+	}
+	if p.do(t, http.MethodGet, "/openai/v1/files", ""); got != "" {
+		t.Fatalf("upstream query without a client query %q", got)
+	}
+}
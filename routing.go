@@ -0,0 +1,200 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultModelRoutes - префикс модели -> провайдер для единого маршрута /v1/*
+var defaultModelRoutes = map[string]string{
+	"gpt-":            "openai",
+	"o1":              "openai",
+	"o3":              "openai",
+	"o4":              "openai",
+	"text-embedding-": "openai",
+	"deepseek-":       "deepseek",
+	"claude-":         "anthropic",
+	"meta-llama/":     "nebius",
+	"Qwen/":           "nebius",
+	"mistralai/":      "nebius",
+	"deepseek-ai/":    "nebius",
+}
+
+var (
+	// modelRoutes - префиксы моделей, отсортированные от длинного к короткому
+	modelRoutes []modelRoute
+	// defaultProvider - куда отправлять запросы /v1/* с неизвестной моделью (пусто - 400)
+	defaultProvider string
+	// providerHandlers - обработчики провайдеров для диспетчеризации из /v1/*
+	providerHandlers = map[string]fiber.Handler{}
+)
+
+type modelRoute struct {
+	prefix   string
+	provider string
+}
+
+// initModelRoutes читает PROXY_MODEL_ROUTES ("prefix=provider,...") и PROXY_DEFAULT_PROVIDER
+func initModelRoutes() {
+	modelRoutes = nil
+	routes := defaultModelRoutes
+	if os.Getenv("PROXY_MODEL_ROUTES") != "" {
+		routes = envMap("PROXY_MODEL_ROUTES")
+	}
+	for prefix, provider := range routes {
+		modelRoutes = append(modelRoutes, modelRoute{prefix: prefix, provider: provider})
+	}
+	sort.Slice(modelRoutes, func(i, j int) bool {
+		return len(modelRoutes[i].prefix) > len(modelRoutes[j].prefix)
+	})
+
+	defaultProvider = strings.TrimSpace(os.Getenv("PROXY_DEFAULT_PROVIDER"))
+}
+
+// routeByModel возвращает провайдера по имени модели, "" - не удалось определить
+func routeByModel(model string) string {
+	if model == "" {
+		return ""
+	}
+	for _, r := range modelRoutes {
+		if strings.HasPrefix(model, r.prefix) {
+			return r.provider
+		}
+	}
+	return ""
+}
+
+// providerOverrideHeader - явный выбор провайдера клиентом вместо префикса URL или модели
+const providerOverrideHeader = "X-Proxy-Provider"
+
+// overrideHandler возвращает обработчик провайдера из X-Proxy-Provider.
+// Права токена на провайдера проверяет сам proxyHandler.
+func overrideHandler(c *fiber.Ctx, provider string) (fiber.Handler, error) {
+	if currentRegistry().get(provider) == nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Unknown provider " + strconv.Quote(provider),
+		})
+	}
+	handler, ok := providerHandlers[provider]
+	if !ok {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Provider " + provider + " is not available",
+		})
+	}
+	return handler, nil
+}
+
+// providerOrderHeader - порядок предпочтения провайдеров клиента ("deepseek,openai")
+const providerOrderHeader = "X-Proxy-Provider-Order"
+
+// preferredProvider - первый подходящий провайдер из X-Proxy-Provider-Order: неизвестные,
+// запрещённые токену и отключённые по доле ошибок пропускаются.
+// "" - заголовка нет или подходящих нет, маршрут выбирается как обычно.
+func preferredProvider(c *fiber.Ctx) string {
+	order := c.Get(providerOrderHeader)
+	if order == "" {
+		return ""
+	}
+	tok := callerToken(c)
+	now := time.Now()
+	for _, p := range strings.Split(order, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if _, ok := providerHandlers[p]; !ok || currentRegistry().get(p) == nil {
+			continue
+		}
+		if tok.allowsProvider(p) && health[p].healthy(now) {
+			return strings.Clone(p)
+		}
+	}
+	log.Printf("WARN: no usable provider in %s %q, using default routing", providerOrderHeader, order)
+	return ""
+}
+
+// withProviderOverride - маршрут /<provider>/*, который X-Proxy-Provider может перенаправить
+// к другому провайдеру с тем же путём
+func withProviderOverride(route string, next fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		provider := strings.TrimSpace(c.Get(providerOverrideHeader))
+		if provider == "" {
+			provider = preferredProvider(c)
+		}
+		if provider == "" || provider == route {
+			return next(c)
+		}
+		handler, err := overrideHandler(c, provider)
+		if handler == nil {
+			return err
+		}
+		log.Printf("Provider override: /%s request routed to %s", route, provider)
+		c.Locals("proxyPath", strings.Clone(c.Params("*")))
+		return handler(c)
+	}
+}
+
+// unifiedHandler - маршрут /v1/*: провайдер выбирается по полю model тела запроса
+// или заголовками X-Proxy-Provider / X-Proxy-Provider-Order
+func unifiedHandler(c *fiber.Ctx) error {
+	if provider := strings.TrimSpace(c.Get(providerOverrideHeader)); provider != "" {
+		handler, err := overrideHandler(c, provider)
+		if handler == nil {
+			return err
+		}
+		c.Locals("proxyPath", "v1/"+c.Params("*"))
+		return handler(c)
+	}
+	if provider := preferredProvider(c); provider != "" {
+		log.Printf("Provider order: request routed to %s", provider)
+		c.Locals("proxyPath", "v1/"+c.Params("*"))
+		return providerHandlers[provider](c)
+	}
+
+	var model string
+	if jb := parseJSONBody(c.Body()); jb != nil {
+		model, _ = jb.getString("model")
+		model = normalizeModel(model)
+	}
+
+	provider := routeByModel(model)
+	if provider == "" {
+		if defaultProvider == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Cannot route request: unknown model " + strconv.Quote(model),
+			})
+		}
+		log.Printf("Model %s not routed, using default provider %s", strconv.Quote(model), defaultProvider)
+		provider = defaultProvider
+	}
+
+	// Отключённого по доле ошибок провайдера обходим через провайдера по умолчанию
+	now := time.Now()
+	if defaultProvider != "" && provider != defaultProvider && !health[provider].healthy(now) && health[defaultProvider].healthy(now) {
+		log.Printf("WARN: provider %s is disabled, routing model %s to %s", provider, strconv.Quote(model), defaultProvider)
+		provider = defaultProvider
+	}
+
+	handler, ok := providerHandlers[provider]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Provider " + provider + " is not available",
+		})
+	}
+
+	c.Locals("proxyPath", "v1/"+c.Params("*"))
+	return handler(c)
+}
+
+// proxiedMethods - методы, которые проксируются провайдерам (Allow в ответе на OPTIONS)
+const proxiedMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+
+// optionsHandler отвечает на OPTIONS сам: провайдеры такие запросы обычно отклоняют
+// (PROXY_FORWARD_OPTIONS=true - проксировать как остальные)
+func optionsHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderAllow, proxiedMethods)
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// routedUpstreams поднимает openai и deepseek и записывает, кто из них какой путь получил
+func routedUpstreams(t *testing.T) *[]string {
+	got := &[]string{}
+	for _, provider := range []string{"openai", "deepseek"} {
+		upstream(t, provider, func(w http.ResponseWriter, r *http.Request) {
+			*got = append(*got, provider+" "+r.URL.Path)
+			w.Write([]byte(`{}`))
+		})
+	}
+	return got
+}
+
+func TestUnifiedRouteByModel(t *testing.T) {
+	got := routedUpstreams(t)
+	p := startProxy(t)
+
+	if resp, body := p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"deepseek-chat"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"gpt-4o"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if want := "deepseek /v1/chat/completions,openai /v1/chat/completions"; strings.Join(*got, ",") != want {
+		t.Fatalf("routed to %v, want %s", *got, want)
+	}
+}
+
+func TestUnifiedRouteUnknownModelDefaultProvider(t *testing.T) {
+	t.Setenv("PROXY_DEFAULT_PROVIDER", "deepseek")
+	got := routedUpstreams(t)
+	p := startProxy(t)
+
+	for _, body := range []string{`{"model":"my-finetune"}`, `{"messages":[]}`} {
+		if resp, out := p.do(t, http.MethodPost, "/v1/chat/completions", body); resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", body, resp.StatusCode, out)
+		}
+	}
+	if want := "deepseek /v1/chat/completions,deepseek /v1/chat/completions"; strings.Join(*got, ",") != want {
+		t.Fatalf("routed to %v, want %s", *got, want)
+	}
+}
+
+func TestUnifiedRouteUnknownModelStrict(t *testing.T) {
+	got := routedUpstreams(t)
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"my-finetune"}`)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, `unknown model \"my-finetune\"`) {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if len(*got) != 0 {
+		t.Fatalf("unroutable request reached %v", *got)
+	}
+}
+
+func TestProviderOverrideHeader(t *testing.T) {
+	got := routedUpstreams(t)
+	p := startProxy(t)
+
+	// Тот же путь клиента уходит провайдеру из заголовка
+	resp, body := p.do(t, http.MethodPost, "/openai/chat/completions", `{"model":"gpt-4o"}`, "X-Proxy-Provider", "deepseek")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"gpt-4o"}`, "X-Proxy-Provider", "deepseek")
+	if want := "deepseek /chat/completions,deepseek /v1/chat/completions"; strings.Join(*got, ",") != want {
+		t.Fatalf("routed to %v, want %s", *got, want)
+	}
+}
+
+func TestProviderOverrideDeniedByACL(t *testing.T) {
+	useTokens(t, `[{"name":"openai-only","token":"tok-openai","providers":["openai"]}]`)
+	got := routedUpstreams(t)
+	p := startProxy(t)
+
+	resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{}`, "X-Proxy-Auth", "tok-openai", "X-Proxy-Provider", "deepseek")
+	if resp.StatusCode != http.StatusForbidden || len(*got) != 0 {
+		t.Fatalf("status %d, routed to %v", resp.StatusCode, *got)
+	}
+}
+
+func TestProviderOverrideUnknownProvider(t *testing.T) {
+	got := routedUpstreams(t)
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{}`, "X-Proxy-Provider", "mistral")
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, `Unknown provider \"mistral\"`) || len(*got) != 0 {
+		t.Fatalf("status %d: %s, routed to %v", resp.StatusCode, body, *got)
+	}
+}
+
+func TestProviderOrder(t *testing.T) {
+	got := routedUpstreams(t)
+	p := startProxy(t)
+
+	// Порядок клиента важнее маршрута по модели и префикса пути
+	p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"gpt-4o"}`, "X-Proxy-Provider-Order", "deepseek,openai")
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{}`, "X-Proxy-Provider-Order", " DeepSeek , openai")
+	p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"deepseek-chat"}`, "X-Proxy-Provider-Order", "openai")
+	if want := "deepseek /v1/chat/completions,deepseek /v1/chat/completions,openai /v1/chat/completions"; strings.Join(*got, ",") != want {
+		t.Fatalf("routed to %v, want %s", *got, want)
+	}
+}
+
+func TestProviderOrderSkipsDisallowed(t *testing.T) {
+	useTokens(t, `[{"name":"openai-only","token":"tok-openai","providers":["openai"]}]`)
+	got := routedUpstreams(t)
+	p := startProxy(t)
+
+	// mistral неизвестен, deepseek запрещён токену - выбирается openai
+	resp, _ := p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"deepseek-chat"}`,
+		"X-Proxy-Auth", "tok-openai", "X-Proxy-Provider-Order", "mistral,deepseek,openai")
+	if resp.StatusCode != http.StatusOK || strings.Join(*got, ",") != "openai /v1/chat/completions" {
+		t.Fatalf("status %d, routed to %v", resp.StatusCode, *got)
+	}
+}
+
+func TestProviderOrderSkipsDisabledProvider(t *testing.T) {
+	t.Setenv("PROXY_ERROR_RATE_THRESHOLD", "50")
+	t.Setenv("PROXY_ERROR_RATE_MIN_REQUESTS", "1")
+	upstream(t, "deepseek", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) })
+	var openaiCalls int
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		openaiCalls++
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/deepseek/v1/chat/completions", `{}`)
+	resp, _ := p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"deepseek-chat"}`, "X-Proxy-Provider-Order", "deepseek,openai")
+	if resp.StatusCode != http.StatusOK || openaiCalls != 1 {
+		t.Fatalf("status %d, openai calls %d", resp.StatusCode, openaiCalls)
+	}
+}
+
+func TestProviderOrderAllInvalid(t *testing.T) {
+	useTokens(t, `[{"name":"openai-only","token":"tok-openai","providers":["openai"]}]`)
+	got := routedUpstreams(t)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	// Ни одного подходящего - обычная маршрутизация по модели
+	resp, _ := p.do(t, http.MethodPost, "/v1/chat/completions", `{"model":"gpt-4o"}`,
+		"X-Proxy-Auth", "tok-openai", "X-Proxy-Provider-Order", "mistral,deepseek")
+	if resp.StatusCode != http.StatusOK || strings.Join(*got, ",") != "openai /v1/chat/completions" {
+		t.Fatalf("status %d, routed to %v", resp.StatusCode, *got)
+	}
+	if !strings.Contains(logs.String(), `WARN: no usable provider in X-Proxy-Provider-Order "mistral,deepseek"`) {
+		t.Fatalf("no warning about the unusable order:\n%s", logs)
+	}
+}
+
+func TestOptionsAnsweredLocally(t *testing.T) {
+	calls := 0
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+	p := startProxy(t)
+
+	for _, path := range []string{"/openai/v1/chat/completions", "/v1/chat/completions"} {
+		resp, body := p.do(t, http.MethodOptions, path, "")
+		if resp.StatusCode != http.StatusNoContent || body != "" {
+			t.Errorf("OPTIONS %s: status %d %q", path, resp.StatusCode, body)
+		}
+		if allow := resp.Header.Get("Allow"); allow != proxiedMethods {
+			t.Errorf("OPTIONS %s: Allow = %q, want %q", path, allow, proxiedMethods)
+		}
+	}
+	if calls != 0 {
+		t.Fatalf("OPTIONS forwarded upstream %d times", calls)
+	}
+}
+
+func TestOptionsForwarded(t *testing.T) {
+	t.Setenv("PROXY_FORWARD_OPTIONS", "true")
+	var gotMethod string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusOK)
+	})
+	p := startProxy(t)
+
+	resp, _ := p.do(t, http.MethodOptions, "/openai/v1/chat/completions", "")
+	if gotMethod != http.MethodOptions || resp.Header.Get("Allow") != "POST" {
+		t.Fatalf("upstream method %q, Allow %q", gotMethod, resp.Header.Get("Allow"))
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Ключи провайдеров из файлов для ротации без перезапуска:
+//
+//	PROXY_SECRETS_FILE - строки OPENAI_API_KEY=sk-a,sk-b (формат .env, # - комментарий)
+//	PROXY_SECRETS_DIR  - каталог с файлом на переменную (OPENAI_API_KEY), ключи через запятую
+//	                     или по одному на строку - как монтируют секреты Kubernetes/Vault
+//
+// То же для переменных, на которые ссылается <PROVIDER>_MODEL_KEYS.
+// Приоритет: каталог, файл, переменная окружения. Перечитываются по SIGHUP и
+// POST /admin/secrets/reload; реестр провайдеров подменяется целиком.
+var (
+	secretsFile string
+	secretsDir  string
+	// reloadMu - одна перезагрузка за раз
+	reloadMu sync.Mutex
+)
+
+func initSecrets() {
+	secretsFile = strings.TrimSpace(os.Getenv("PROXY_SECRETS_FILE"))
+	secretsDir = strings.TrimSpace(os.Getenv("PROXY_SECRETS_DIR"))
+}
+
+// readSecrets читает ключи из файла и каталога; имя переменной -> значение для newKeyPool
+func readSecrets() (map[string]string, error) {
+	result := map[string]string{}
+	if secretsFile != "" {
+		f, err := os.Open(secretsFile)
+		if err != nil {
+			return nil, fmt.Errorf("PROXY_SECRETS_FILE: %w", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			// Содержимое строки в ошибку не попадает - в ней может быть ключ
+			name, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("PROXY_SECRETS_FILE: line %d: expected NAME=value", n)
+			}
+			result[strings.TrimSpace(name)] = strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("PROXY_SECRETS_FILE: %w", err)
+		}
+	}
+	if secretsDir != "" {
+		for _, name := range secretNames() {
+			data, err := os.ReadFile(filepath.Join(secretsDir, name))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("PROXY_SECRETS_DIR: %w", err)
+			}
+			result[name] = strings.Join(strings.Fields(strings.ReplaceAll(string(data), ",", " ")), ",")
+		}
+	}
+	return result, nil
+}
+
+// secretNames - переменные с ключами: <PROVIDER>_API_KEY и указанные в <PROVIDER>_MODEL_KEYS
+func secretNames() []string {
+	var names []string
+	for _, p := range providers {
+		names = append(names, p.APIKeyEnv)
+		for _, env := range envMap(envPrefix(p.Name) + "MODEL_KEYS") {
+			names = append(names, env)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// providerKeys - значение ключей провайдера: из секретов, если заданы, иначе из env
+func providerKeys(secrets map[string]string, envName string) string {
+	if v, ok := secrets[envName]; ok {
+		return v
+	}
+	return os.Getenv(envName)
+}
+
+// sameKeys - пулы содержат те же ключи в том же порядке
+func sameKeys(a, b *keyPool) bool {
+	return slices.EqualFunc(a.keys, b.keys, func(x, y *apiKeyState) bool { return x.value == y.value })
+}
+
+// reloadModelKeys пересобирает правила <PROVIDER>_MODEL_KEYS из новых секретов; пул правила
+// с прежними ключами сохраняется. changed - у какого-либо правила сменились ключи
+func reloadModelKeys(p *provider, secrets map[string]string) (rules []modelKeyRule, changed bool) {
+	old := map[string]*keyPool{}
+	for _, r := range p.modelKeys {
+		old[r.prefix] = r.keys
+	}
+	rules = parseModelKeyRules(envMap(envPrefix(p.Name)+"MODEL_KEYS"), secrets)
+	for i, r := range rules {
+		if prev, ok := old[r.prefix]; ok && sameKeys(prev, r.keys) {
+			rules[i].keys = prev
+			continue
+		}
+		changed = true
+		log.Printf("Reloaded %s model key rule %s: %d key(s)", p.Name, r.prefix, len(r.keys.keys))
+	}
+	return rules, changed || len(rules) != len(p.modelKeys)
+}
+
+// reloadSecrets перечитывает ключи (основные и <PROVIDER>_MODEL_KEYS) и подменяет реестр;
+// пулы с прежними ключами (и состояние rate-limit) сохраняются. Набор провайдеров тот же,
+// поэтому лимитеры, health и stats по имени провайдера продолжают работать. Возвращает провайдеров,
+// у которых ключи сменились. Собственные ключи токенов (BYOK) живут в файле токенов
+// и здесь не перечитываются.
+func reloadSecrets() ([]string, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	secrets, err := readSecrets()
+	if err != nil {
+		return nil, err
+	}
+	old := currentRegistry()
+	next := &providerRegistry{byName: map[string]*provider{}}
+	var changed []string
+	for _, p := range old.list {
+		cp := *p
+		keysChanged := false
+		if keys := newKeyPool(providerKeys(secrets, p.APIKeyEnv)); !sameKeys(keys, p.keys) {
+			cp.keys = keys
+			keysChanged = true
+			log.Printf("Reloaded %s: %d key(s)", p.APIKeyEnv, len(keys.keys))
+		}
+		var rulesChanged bool
+		cp.modelKeys, rulesChanged = reloadModelKeys(p, secrets)
+		if keysChanged || rulesChanged {
+			changed = append(changed, p.Name)
+		}
+		next.list = append(next.list, &cp)
+		next.byName[cp.Name] = &cp
+	}
+	registry.Store(next)
+	return changed, nil
+}
+
+// watchSecretsReload перечитывает ключи по SIGHUP
+func watchSecretsReload() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Printf("SIGHUP received, reloading provider keys")
+			if _, err := reloadSecrets(); err != nil {
+				log.Printf("ERROR: reload provider keys: %v (previous keys kept)", err)
+			}
+		}
+	}()
+}
+
+// secretsReloadHandler - POST /admin/secrets/reload
+func secretsReloadHandler(c *fiber.Ctx) error {
+	changed, err := reloadSecrets()
+	if err != nil {
+		log.Printf("ERROR: reload provider keys: %v (previous keys kept)", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "reload failed, previous keys kept: " + err.Error(),
+		})
+	}
+	if changed == nil {
+		changed = []string{}
+	}
+	return c.JSON(fiber.Map{"status": "ok", "changed": changed})
+}
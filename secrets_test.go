@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// keyUpstream запоминает ключи, с которыми пришли запросы к провайдеру
+func keyUpstream(t *testing.T, used *[]string) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		*used = append(*used, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		w.Write([]byte(`{}`))
+	})
+}
+
+// reloadSecretsAs - POST /admin/secrets/reload от имени token
+func (p *testProxy) reloadSecretsAs(t *testing.T, token string) (int, map[string]any) {
+	t.Helper()
+	resp, body := p.do(t, http.MethodPost, "/admin/secrets/reload", "", "X-Proxy-Auth", token)
+	var out map[string]any
+	json.Unmarshal([]byte(body), &out)
+	return resp.StatusCode, out
+}
+
+// rewriteFile перезаписывает файл секретов, как это делает менеджер секретов
+func rewriteFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSecretsFileReload(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-env")
+	file := writeFile(t, "secrets.env", "# rotated by vault\nOPENAI_API_KEY=\"sk-old\"\n")
+	t.Setenv("PROXY_SECRETS_FILE", file)
+	var used []string
+	keyUpstream(t, &used)
+	p := startProxy(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "")
+	rewriteFile(t, file, "OPENAI_API_KEY=sk-new-1,sk-new-2\n")
+	status, out := p.reloadSecretsAs(t, testAuthToken)
+	if status != http.StatusOK || out["status"] != "ok" || len(out["changed"].([]any)) != 1 || out["changed"].([]any)[0] != "openai" {
+		t.Fatalf("reload: status %d: %v", status, out)
+	}
+	p.do(t, http.MethodGet, "/openai/v1/models", "")
+	p.do(t, http.MethodGet, "/openai/v1/models", "")
+	// Файл приоритетнее окружения; после перезагрузки - новый пул
+	if want := "sk-old sk-new-1 sk-new-2"; strings.Join(used, " ") != want {
+		t.Fatalf("keys used = %v, want %s", used, want)
+	}
+
+	// Повтор без изменений файла ничего не меняет
+	if status, out := p.reloadSecretsAs(t, testAuthToken); status != http.StatusOK || len(out["changed"].([]any)) != 0 {
+		t.Fatalf("reload without changes: status %d: %v", status, out)
+	}
+}
+
+func TestSecretsReloadErrorKeepsKeys(t *testing.T) {
+	file := writeFile(t, "secrets.env", "OPENAI_API_KEY=sk-old\n")
+	t.Setenv("PROXY_SECRETS_FILE", file)
+	var used []string
+	keyUpstream(t, &used)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	rewriteFile(t, file, "OPENAI_API_KEY sk-leaked\n")
+	status, out := p.reloadSecretsAs(t, testAuthToken)
+	if status != http.StatusInternalServerError || !strings.Contains(out["error"].(string), "line 1: expected NAME=value") {
+		t.Fatalf("broken file: status %d: %v", status, out)
+	}
+	p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if strings.Join(used, " ") != "sk-old" {
+		t.Fatalf("keys used after a failed reload = %v", used)
+	}
+	if strings.Contains(logs.String(), "sk-leaked") || strings.Contains(out["error"].(string), "sk-leaked") {
+		t.Fatalf("secrets file content leaked: %v\n%s", out, logs)
+	}
+}
+
+func TestSecretsDirReloadModelKeys(t *testing.T) {
+	dir := t.TempDir()
+	rewriteFile(t, filepath.Join(dir, "OPENAI_API_KEY"), "sk-default\n")
+	rewriteFile(t, filepath.Join(dir, "OPENAI_KEY_GPT4O"), "sk-gpt4o-old\n")
+	t.Setenv("PROXY_SECRETS_DIR", dir)
+	t.Setenv("OPENAI_MODEL_KEYS", "gpt-4o=OPENAI_KEY_GPT4O")
+	var used []string
+	keyUpstream(t, &used)
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	// Ключи по одному на строку, основной пул не меняется
+	rewriteFile(t, filepath.Join(dir, "OPENAI_KEY_GPT4O"), "sk-gpt4o-new-1\nsk-gpt4o-new-2\n")
+	if status, out := p.reloadSecretsAs(t, testAuthToken); status != http.StatusOK || len(out["changed"].([]any)) != 1 {
+		t.Fatalf("reload: status %d: %v", status, out)
+	}
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"o3"}`)
+	if want := "sk-gpt4o-old sk-gpt4o-new-1 sk-gpt4o-new-2 sk-default"; strings.Join(used, " ") != want {
+		t.Fatalf("keys used = %v, want %s", used, want)
+	}
+}
+
+func TestSecretsReloadRequiresAdmin(t *testing.T) {
+	useTokens(t, `[{"name":"team-a","token":"tok-a"},{"name":"ops","token":"tok-ops","admin":true}]`)
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	if status, out := p.reloadSecretsAs(t, "tok-a"); status != http.StatusForbidden || out["error"] != "admin token required" {
+		t.Fatalf("reload by a regular token: status %d: %v", status, out)
+	}
+	if status, _ := p.reloadSecretsAs(t, "tok-ops"); status != http.StatusOK {
+		t.Fatalf("reload by an admin token: status %d", status)
+	}
+}
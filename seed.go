@@ -0,0 +1,41 @@
+package main
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// seedHeader - seed от клиента для воспроизводимых прогонов; приоритетнее PROXY_DEFAULT_SEED
+const seedHeader = "X-Proxy-Seed"
+
+// defaultSeed - seed для chat-запросов без своего seed (PROXY_DEFAULT_SEED), пусто - не добавлять
+var defaultSeed string
+
+// supportsSeed - провайдеры, принимающие seed в теле chat-запроса
+func supportsSeed(provider string) bool {
+	return provider == "openai" || provider == "nebius"
+}
+
+// seedValue - число как есть, любая другая строка (имя теста и т.п.) хешируется в стабильное число
+func seedValue(s string) int64 {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return int64(h.Sum32())
+}
+
+// injectSeed добавляет seed в chat-запрос, если клиент не передал свой
+func injectSeed(jb *jsonBody, seed string) bool {
+	if _, ok := jb.fields["messages"]; !ok {
+		return false
+	}
+	if _, ok := jb.fields["seed"]; ok {
+		return false
+	}
+	jb.set("seed", seedValue(seed))
+	return true
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// seedUpstream запоминает seed из тела последнего запроса и заголовок X-Proxy-Seed
+func seedUpstream(t *testing.T, provider string) (*any, *string) {
+	seed, header := new(any), new(string)
+	upstream(t, provider, func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		json.Unmarshal(data, &body)
+		*seed, *header = body["seed"], r.Header.Get(seedHeader)
+		w.Write([]byte(`{}`))
+	})
+	return seed, header
+}
+
+const seedChat = `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+
+func TestSeedFromHeader(t *testing.T) {
+	seed, header := seedUpstream(t, "openai")
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", seedChat, seedHeader, "42")
+	if *seed != float64(42) || *header != "" {
+		t.Fatalf("seed = %v, upstream %s = %q", *seed, seedHeader, *header)
+	}
+	// Имя прогона превращается в стабильное число
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", seedChat, seedHeader, "eval-suite-1")
+	first := *seed
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", seedChat, seedHeader, "eval-suite-1")
+	if first != float64(seedValue("eval-suite-1")) || *seed != first {
+		t.Fatalf("named seed = %v, then %v", first, *seed)
+	}
+	// Свой seed клиента не перезаписывается
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","seed":7,"messages":[]}`, seedHeader, "42")
+	if *seed != float64(7) {
+		t.Fatalf("client seed = %v, want 7", *seed)
+	}
+	// Без заголовка и PROXY_DEFAULT_SEED seed не добавляется
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", seedChat)
+	if *seed != nil {
+		t.Fatalf("seed = %v without a source", *seed)
+	}
+}
+
+func TestDefaultSeed(t *testing.T) {
+	t.Setenv("PROXY_DEFAULT_SEED", "1234")
+	seed, _ := seedUpstream(t, "openai")
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", seedChat)
+	if *seed != float64(1234) {
+		t.Fatalf("default seed = %v", *seed)
+	}
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", seedChat, seedHeader, "5")
+	if *seed != float64(5) {
+		t.Fatalf("header seed = %v, want it over the default", *seed)
+	}
+	// Только chat-запросы
+	p.do(t, http.MethodPost, "/openai/v1/embeddings", `{"model":"text-embedding-3-small","input":"hi"}`)
+	if *seed != nil {
+		t.Fatalf("seed = %v in an embeddings request", *seed)
+	}
+}
+
+func TestSeedSkipsUnsupportedProvider(t *testing.T) {
+	t.Setenv("PROXY_DEFAULT_SEED", "1234")
+	seed, _ := seedUpstream(t, "anthropic")
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/anthropic/v1/messages", `{"model":"claude-sonnet-4","messages":[]}`, seedHeader, "42")
+	if *seed != nil {
+		t.Fatalf("seed = %v sent to anthropic", *seed)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// selfTestTimeout - предел на один запрос самопроверки
+const selfTestTimeout = 30 * time.Second
+
+// mockSelfTestBody - запрос к mock-провайдеру при самопроверке
+const mockSelfTestBody = `{"model":"mock","messages":[{"role":"user","content":"ping"}]}`
+
+// selfTestTok - внутренний токен запросов самопроверки со случайным значением; существует только
+// пока идёт самопроверка. Квоты, бюджеты и лимиты настоящих токенов на него не тратятся.
+var selfTestTok *apiToken
+
+// runSelfTest (PROXY_SELFTEST) перед стартом отправляет через сам прокси - с авторизацией,
+// middleware и транспортом провайдера (прокси, TLS) - запрос к каждому провайдеру с ключом:
+// GET <PROVIDER>_PROBE_PATH, у mock - chat completion. true - все проверки прошли (2xx).
+// Трафик самопроверки не попадает в /stats: она идёт до приёма запросов, и счётчики после неё обнуляются.
+func runSelfTest(app *fiber.App, reg *providerRegistry, mock bool) bool {
+	type check struct{ name, method, path, body string }
+	var checks []check
+	for _, p := range reg.list {
+		if _, ok := providerHandlers[p.Name]; ok && len(p.keys.keys) > 0 {
+			checks = append(checks, check{p.Name, http.MethodGet, "/" + p.Name + p.probePath, ""})
+		}
+	}
+	if mock {
+		checks = append(checks, check{"mock", http.MethodPost, "/mock/v1/chat/completions", mockSelfTestBody})
+	}
+	if len(checks) == 0 {
+		log.Printf("WARN: self-test: no providers with keys to check")
+		return false
+	}
+
+	selfTestTok = &apiToken{Name: "selftest", Token: randomHex(16)}
+	defer func() {
+		selfTestTok = nil
+		statsMu.Lock()
+		resetStats()
+		statsMu.Unlock()
+	}()
+
+	passed := 0
+	for _, ch := range checks {
+		req := httptest.NewRequest(ch.method, ch.path, strings.NewReader(ch.body))
+		req.Header.Set("X-Proxy-Auth", selfTestTok.Token)
+		req.Header.Set("X-Proxy-Tag", "selftest")
+		if ch.body != "" {
+			req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+		}
+
+		start := time.Now()
+		resp, err := app.Test(req, int(selfTestTimeout.Milliseconds()))
+		latency := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			log.Printf("Self-test %s: FAIL %s %s: %v (latency %s)", ch.name, ch.method, ch.path, err, latency)
+			continue
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			log.Printf("Self-test %s: FAIL %s %s: status %d (latency %s): %s",
+				ch.name, ch.method, ch.path, resp.StatusCode, latency, strings.TrimSpace(string(body)))
+			continue
+		}
+		passed++
+		log.Printf("Self-test %s: PASS status %d (latency %s)", ch.name, resp.StatusCode, latency)
+	}
+	log.Printf("Self-test: %d/%d passed", passed, len(checks))
+	return passed == len(checks)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSelfTestPass(t *testing.T) {
+	t.Setenv("PROXY_ENABLE_MOCK", "true")
+	var paths []string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.Write([]byte(`{"data":[]}`))
+	})
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	if !runSelfTest(p.app, currentRegistry(), true) {
+		t.Fatalf("self-test failed:\n%s", logs)
+	}
+	// Путь проверки по умолчанию - список моделей, а не корень
+	if strings.Join(paths, ",") != "GET /v1/models" {
+		t.Fatalf("upstream got %v", paths)
+	}
+	out := logs.String()
+	for _, want := range []string{"Self-test openai: PASS status 200", "Self-test mock: PASS status 200", "Self-test: 2/2 passed"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in the log:\n%s", want, out)
+		}
+	}
+}
+
+func TestSelfTestUnreachableProvider(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	t.Setenv("OPENAI_BASE_URL", "http://"+ln.Addr().String())
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("PROXY_ENABLE_MOCK", "true")
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	if runSelfTest(p.app, currentRegistry(), true) {
+		t.Fatal("self-test passed with an unreachable provider")
+	}
+	out := logs.String()
+	if !strings.Contains(out, "Self-test openai: FAIL GET /openai/v1/models: status 502") || !strings.Contains(out, "Self-test: 1/2 passed") {
+		t.Fatalf("unexpected self-test log:\n%s", out)
+	}
+}
+
+func TestSelfTestProbePath(t *testing.T) {
+	t.Setenv("OPENAI_PROBE_PATH", "v1/me")
+	var path string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	runSelfTest(p.app, currentRegistry(), false)
+	if path != "/v1/me" {
+		t.Fatalf("upstream path = %q, want OPENAI_PROBE_PATH", path)
+	}
+}
+
+func TestSelfTestSkipsAccounting(t *testing.T) {
+	// Только токены клиентов, без PROXY_AUTH_TOKEN
+	t.Setenv("PROXY_TOKENS_FILE", writeFile(t, "tokens.json",
+		`[{"name":"team-a","token":"tok-a","daily_quota":1,"rate_limit_rpm":1,"budget_usd":0.000001}]`))
+	t.Setenv("PROXY_AUTH_TOKEN", "")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"usage":{"prompt_tokens":1000,"completion_tokens":1000}}`))
+	})
+	p := startProxy(t)
+
+	if !runSelfTest(p.app, currentRegistry(), false) {
+		t.Fatal("self-test failed")
+	}
+	if n := stats["openai"].requests.Load(); n != 0 {
+		t.Fatalf("self-test counted in stats: %d requests", n)
+	}
+	// Квота, лимит и бюджет токена после самопроверки не тронуты
+	if resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", "tok-a"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first request of the token after the self-test: status %d: %s", resp.StatusCode, body)
+	}
+	if selfTestTok != nil {
+		t.Fatal("self-test token is still valid after the self-test")
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// statusClientClosed - статус для учёта запроса, который клиент бросил до конца ответа
+// (как 499 в nginx); клиенту не отправляется и ошибкой провайдера не считается
+const statusClientClosed = 499
+
+// isErrorStatus - статус учитывается как ошибка
+func isErrorStatus(status int) bool {
+	return status >= 400 && status != statusClientClosed
+}
+
+// usageCounters - накопленные токены и стоимость
+type usageCounters struct {
+	promptTokens     atomic.Int64
+	completionTokens atomic.Int64
+	reasoningTokens  atomic.Int64
+	cachedTokens     atomic.Int64
+	costNanoUSD      atomic.Int64
+}
+
+func (s *usageCounters) add(u tokenUsage, cost int64) {
+	s.promptTokens.Add(u.PromptTokens)
+	s.completionTokens.Add(u.CompletionTokens)
+	s.reasoningTokens.Add(u.ReasoningTokens)
+	s.cachedTokens.Add(u.CachedTokens)
+	s.costNanoUSD.Add(cost)
+}
+
+func (s *usageCounters) reset() {
+	s.promptTokens.Store(0)
+	s.completionTokens.Store(0)
+	s.reasoningTokens.Store(0)
+	s.cachedTokens.Store(0)
+	s.costNanoUSD.Store(0)
+}
+
+func (s *usageCounters) snapshot() fiber.Map {
+	return fiber.Map{
+		"prompt_tokens":     s.promptTokens.Load(),
+		"completion_tokens": s.completionTokens.Load(),
+		"reasoning_tokens":  s.reasoningTokens.Load(),
+		"cached_tokens":     s.cachedTokens.Load(),
+		"cost_usd":          float64(s.costNanoUSD.Load()) / 1e9,
+	}
+}
+
+// providerStats - накопленные счётчики провайдера
+type providerStats struct {
+	requests          atomic.Int64
+	errors            atomic.Int64
+	streamsIncomplete atomic.Int64
+	streamsTruncated  atomic.Int64
+	largeResponses    atomic.Int64
+	clientCancels     atomic.Int64
+	escalations       atomic.Int64
+	contextFallbacks  atomic.Int64
+	sloMet            atomic.Int64
+	sloMissed         atomic.Int64
+	bytesIn           atomic.Int64
+	bytesOut          atomic.Int64
+	usage             usageCounters
+
+	// Раздельно streaming и non-streaming запросы (по Accept/"stream" запроса)
+	streaming modeStats
+	buffered  modeStats
+}
+
+// modeStats - счётчики запросов одного режима, начиная с отправки провайдеру
+type modeStats struct {
+	requests     atomic.Int64
+	errors       atomic.Int64
+	inFlight     atomic.Int64
+	latencyNanos atomic.Int64
+}
+
+func (m *modeStats) reset() {
+	m.requests.Store(0)
+	m.errors.Store(0)
+	m.latencyNanos.Store(0)
+}
+
+func (m *modeStats) snapshot() fiber.Map {
+	requests := m.requests.Load()
+	avg := 0.0
+	if requests > 0 {
+		avg = float64(m.latencyNanos.Load()) / float64(requests) / 1e6
+	}
+	return fiber.Map{
+		"requests":       requests,
+		"errors":         m.errors.Load(),
+		"in_flight":      m.inFlight.Load(),
+		"avg_latency_ms": avg,
+	}
+}
+
+// beginMode учитывает запрос в счётчиках режима; возвращённая функция завершает его
+// (для потока - после передачи последнего байта)
+func beginMode(provider string, streaming bool, start time.Time) func(status int) {
+	statsMu.RLock()
+	s := stats[provider]
+	statsMu.RUnlock()
+	m := &s.buffered
+	if streaming {
+		m = &s.streaming
+	}
+	m.inFlight.Add(1)
+	return func(status int) {
+		m.inFlight.Add(-1)
+		statsMu.RLock()
+		defer statsMu.RUnlock()
+		m.requests.Add(1)
+		if isErrorStatus(status) {
+			m.errors.Add(1)
+		}
+		m.latencyNanos.Add(int64(time.Since(start)))
+	}
+}
+
+// tagStats - счётчики по тегу X-Proxy-Tag для распределения затрат
+type tagStats struct {
+	requests atomic.Int64
+	errors   atomic.Int64
+	usage    usageCounters
+}
+
+const (
+	untaggedTag = "untagged"
+	overflowTag = "other"
+)
+
+var (
+	// statsMu: запись счётчиков - под RLock, сброс - под Lock, чтобы запрос
+	// не учитывался наполовину до и наполовину после сброса
+	statsMu sync.RWMutex
+
+	// stats - счётчики по имени провайдера, заполняются при старте
+	stats = map[string]*providerStats{}
+
+	tagsMu sync.Mutex
+	tags   = map[string]*tagStats{}
+	// maxTags - лимит различных тегов, остальные попадают в "other"
+	maxTags int
+	// sloTarget - целевая задержка non-streaming запросов (PROXY_SLO_LATENCY_MS), 0 - не считать
+	sloTarget time.Duration
+)
+
+// initStats заводит счётчики провайдеров. Карта очищается под statsMu, а не подменяется:
+// поток прежней сборки приложения (тесты) может ещё учитывать свой запрос.
+func initStats() {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	clear(stats)
+	for _, p := range providers {
+		stats[p.Name] = &providerStats{}
+	}
+	tagsMu.Lock()
+	tags = map[string]*tagStats{}
+	maxTags = envInt("PROXY_MAX_TAGS", 100)
+	tagsMu.Unlock()
+	sloTarget = time.Duration(envInt("PROXY_SLO_LATENCY_MS", 0)) * time.Millisecond
+}
+
+// tagFor возвращает счётчики тега, ограничивая их число maxTags; последний слот
+// оставлен под overflowTag
+func tagFor(tag string) *tagStats {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		tag = untaggedTag
+	}
+
+	tagsMu.Lock()
+	defer tagsMu.Unlock()
+	if t, ok := tags[tag]; ok {
+		return t
+	}
+	if len(tags) >= maxTags-1 {
+		tag = overflowTag
+		if t, ok := tags[tag]; ok {
+			return t
+		}
+	}
+	t := &tagStats{}
+	tags[tag] = t
+	return t
+}
+
+// recordRequest учитывает завершённый запрос
+func recordRequest(provider, tag string, status int) {
+	statsMu.RLock()
+	defer statsMu.RUnlock()
+
+	s, t := stats[provider], tagFor(tag)
+	s.requests.Add(1)
+	t.requests.Add(1)
+	if status == statusClientClosed {
+		s.clientCancels.Add(1)
+	}
+	if isErrorStatus(status) {
+		s.errors.Add(1)
+		t.errors.Add(1)
+	}
+}
+
+// recordUsage учитывает токены и стоимость запроса
+func recordUsage(provider, tag string, u tokenUsage) {
+	cost := costNanoUSD(u)
+
+	statsMu.RLock()
+	stats[provider].usage.add(u, cost)
+	tagFor(tag).usage.add(u, cost)
+	statsMu.RUnlock()
+
+	log.Printf("Usage %s: model=%s prompt=%d completion=%d reasoning=%d cached=%d cost=$%.6f tag=%q",
+		provider, u.Model, u.PromptTokens, u.CompletionTokens, u.ReasoningTokens, u.CachedTokens, float64(cost)/1e9, tag)
+}
+
+// recordBytes учитывает размер тела запроса и ответа (для потоков - переданные клиенту байты)
+func recordBytes(provider string, in, out int64) {
+	statsMu.RLock()
+	s := stats[provider]
+	s.bytesIn.Add(max(in, 0))
+	s.bytesOut.Add(max(out, 0))
+	statsMu.RUnlock()
+
+	log.Printf("Bytes %s: request=%d response=%d", provider, in, out)
+}
+
+// recordIncompleteStream учитывает поток, оборвавшийся без терминатора
+func recordIncompleteStream(provider string) {
+	statsMu.RLock()
+	defer statsMu.RUnlock()
+	stats[provider].streamsIncomplete.Add(1)
+}
+
+// recordLargeResponse учитывает ответ больше PROXY_LARGE_RESPONSE_BYTES
+func recordLargeResponse(provider string) {
+	statsMu.RLock()
+	defer statsMu.RUnlock()
+	stats[provider].largeResponses.Add(1)
+}
+
+// recordTruncatedStream учитывает поток, усечённый мягким пределом длительности
+func recordTruncatedStream(provider string) {
+	statsMu.RLock()
+	defer statsMu.RUnlock()
+	stats[provider].streamsTruncated.Add(1)
+}
+
+// recordSLO учитывает, уложился ли non-streaming запрос в целевую задержку
+func recordSLO(provider string, latency time.Duration) {
+	statsMu.RLock()
+	defer statsMu.RUnlock()
+	if sloTarget <= 0 {
+		return
+	}
+	if latency <= sloTarget {
+		stats[provider].sloMet.Add(1)
+	} else {
+		stats[provider].sloMissed.Add(1)
+	}
+}
+
+// sloSnapshot - доля запросов в пределах цели; без запросов compliance = 1
+func (s *providerStats) sloSnapshot() fiber.Map {
+	met, missed := s.sloMet.Load(), s.sloMissed.Load()
+	compliance := 1.0
+	if met+missed > 0 {
+		compliance = float64(met) / float64(met+missed)
+	}
+	return fiber.Map{
+		"target_ms":  sloTarget.Milliseconds(),
+		"met":        met,
+		"missed":     missed,
+		"compliance": compliance,
+	}
+}
+
+// recordEscalation учитывает переход на следующую модель цепочки эскалации
+func recordEscalation(provider string) {
+	statsMu.RLock()
+	defer statsMu.RUnlock()
+	stats[provider].escalations.Add(1)
+}
+
+// recordContextFallback учитывает повтор с моделью с большим контекстом
+func recordContextFallback(provider string) {
+	statsMu.RLock()
+	defer statsMu.RUnlock()
+	stats[provider].contextFallbacks.Add(1)
+}
+
+// statsHandler отдаёт текущее состояние провайдеров
+func statsHandler(c *fiber.Ctx) error {
+	statsMu.RLock()
+	snapshot := statsSnapshot()
+	statsMu.RUnlock()
+	return c.JSON(snapshot)
+}
+
+// statsResetHandler обнуляет накопленные счётчики и возвращает их значения до сброса.
+// Текущее состояние (in-flight, ключи, retry-бюджет) не сбрасывается.
+func statsResetHandler(c *fiber.Ctx) error {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	snapshot := statsSnapshot()
+	resetStats()
+
+	log.Printf("Stats reset")
+	return c.JSON(snapshot)
+}
+
+// resetStats обнуляет счётчики провайдеров и тегов; вызывается под statsMu
+func resetStats() {
+	for _, s := range stats {
+		s.requests.Store(0)
+		s.errors.Store(0)
+		s.streamsIncomplete.Store(0)
+		s.streamsTruncated.Store(0)
+		s.largeResponses.Store(0)
+		s.clientCancels.Store(0)
+		s.escalations.Store(0)
+		s.contextFallbacks.Store(0)
+		s.sloMet.Store(0)
+		s.sloMissed.Store(0)
+		s.bytesIn.Store(0)
+		s.bytesOut.Store(0)
+		s.streaming.reset()
+		s.buffered.reset()
+		s.usage.reset()
+	}
+	tagsMu.Lock()
+	tags = map[string]*tagStats{}
+	tagsMu.Unlock()
+}
+
+// statsSnapshot - счётчики для /stats; вызывается под statsMu
+func statsSnapshot() fiber.Map {
+	reg := currentRegistry()
+	result := fiber.Map{}
+	for _, p := range providers {
+		l := limiters[p.Name]
+		s := stats[p.Name]
+		entry := fiber.Map{
+			"requests":           s.requests.Load(),
+			"errors":             s.errors.Load(),
+			"streams_incomplete": s.streamsIncomplete.Load(),
+			"streams_truncated":  s.streamsTruncated.Load(),
+			"large_responses":    s.largeResponses.Load(),
+			"client_cancels":     s.clientCancels.Load(),
+			"escalations":        s.escalations.Load(),
+			"context_fallbacks":  s.contextFallbacks.Load(),
+			"bytes_in":           s.bytesIn.Load(),
+			"bytes_out":          s.bytesOut.Load(),
+			"in_flight":          l.inFlight.Load(),
+			"max_concurrency":    cap(l.slots),
+			"rejected":           l.rejected.Load(),
+			"queue":              l.queueSnapshot(),
+			"keys":               reg.get(p.Name).keys.snapshot(),
+			"usage":              s.usage.snapshot(),
+			"streaming":          s.streaming.snapshot(),
+			"buffered":           s.buffered.snapshot(),
+		}
+		if sloTarget > 0 {
+			entry["slo"] = s.sloSnapshot()
+		}
+		if errorRateThreshold > 0 {
+			entry["health"] = health[p.Name].snapshot(time.Now())
+		}
+		result[p.Name] = entry
+	}
+
+	tagsMu.Lock()
+	byTag := fiber.Map{}
+	for name, t := range tags {
+		byTag[name] = fiber.Map{
+			"requests": t.requests.Load(),
+			"errors":   t.errors.Load(),
+			"usage":    t.usage.snapshot(),
+		}
+	}
+	tagsMu.Unlock()
+
+	return fiber.Map{
+		"providers":    result,
+		"tags":         byTag,
+		"retry_budget": retryBudget.snapshot(),
+		"tokens":       tokenStreamsSnapshot(),
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// usageUpstream отвечает chat completion с фиксированным usage
+func usageUpstream(t *testing.T, forwardedTag *string) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		*forwardedTag = r.Header.Get("X-Proxy-Tag")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"gpt-4o","usage":{"prompt_tokens":1000,"completion_tokens":100,"total_tokens":1100}}`))
+	})
+}
+
+// tagStat - счётчики тега из /stats
+func (p *testProxy) tagStat(t *testing.T, tag string) map[string]any {
+	t.Helper()
+	s, _ := p.stats(t)["tags"].(map[string]any)[tag].(map[string]any)
+	return s
+}
+
+func TestUsageAttributedToTag(t *testing.T) {
+	t.Setenv("PROXY_MODEL_PRICES", "gpt-4o=2.5:10")
+	var forwarded string
+	usageUpstream(t, &forwarded)
+	p := startProxy(t)
+
+	for _, tag := range []string{"team-a", "team-a", "team-b", ""} {
+		p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`, "X-Proxy-Tag", tag)
+	}
+	if forwarded != "" {
+		t.Fatalf("X-Proxy-Tag forwarded upstream: %q", forwarded)
+	}
+
+	a := p.tagStat(t, "team-a")
+	usage := a["usage"].(map[string]any)
+	// 2 x (1000 x $2.5 + 100 x $10) / 1M
+	if a["requests"] != float64(2) || usage["prompt_tokens"] != float64(2000) || usage["completion_tokens"] != float64(200) || usage["cost_usd"] != 0.007 {
+		t.Fatalf("team-a = %v", a)
+	}
+	if b := p.tagStat(t, "team-b"); b["requests"] != float64(1) || b["usage"].(map[string]any)["prompt_tokens"] != float64(1000) {
+		t.Fatalf("team-b = %v", b)
+	}
+	if u := p.tagStat(t, untaggedTag); u["requests"] != float64(1) {
+		t.Fatalf("untagged = %v", u)
+	}
+}
+
+func TestTagCardinalityCap(t *testing.T) {
+	t.Setenv("PROXY_MAX_TAGS", "3")
+	var forwarded string
+	usageUpstream(t, &forwarded)
+	p := startProxy(t)
+
+	for _, tag := range []string{"team-a", "team-b", "team-c", "team-d", "team-a"} {
+		p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`, "X-Proxy-Tag", tag)
+	}
+	// overflowTag входит в лимит
+	tags := p.stats(t)["tags"].(map[string]any)
+	if len(tags) > 3 || tags["team-b"] == nil || tags["team-c"] != nil {
+		t.Fatalf("tags = %v, want team-a, team-b and %s", tags, overflowTag)
+	}
+	if a := p.tagStat(t, "team-a"); a["requests"] != float64(2) {
+		t.Fatalf("team-a = %v", a)
+	}
+	if other := p.tagStat(t, overflowTag); other["requests"] != float64(2) || other["usage"].(map[string]any)["prompt_tokens"] != float64(2000) {
+		t.Fatalf("%s = %v", overflowTag, other)
+	}
+}
+
+// resetStats - POST /admin/stats/reset, возвращает снимок до сброса
+func (p *testProxy) resetStats(t *testing.T) map[string]any {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, p.url+"/admin/stats/reset", nil)
+	req.Header.Set("X-Proxy-Auth", testAuthToken)
+	resp, body := send(t, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("reset: status %d: %s", resp.StatusCode, body)
+	}
+	var out map[string]any
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestStatsReset(t *testing.T) {
+	var forwarded string
+	usageUpstream(t, &forwarded)
+	p := startProxy(t)
+
+	for range 3 {
+		p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`, "X-Proxy-Tag", "team-a")
+	}
+	if got := p.providerStat(t, "openai", "requests"); got != float64(3) {
+		t.Fatalf("requests before reset = %v", got)
+	}
+
+	before := p.resetStats(t)
+	openai := before["providers"].(map[string]any)["openai"].(map[string]any)
+	if openai["requests"] != float64(3) || openai["usage"].(map[string]any)["prompt_tokens"] != float64(3000) {
+		t.Fatalf("pre-reset snapshot = %v", openai)
+	}
+	if before["tags"].(map[string]any)["team-a"] == nil {
+		t.Fatalf("pre-reset snapshot has no tags: %v", before["tags"])
+	}
+
+	if got := p.providerStat(t, "openai", "requests"); got != float64(0) {
+		t.Fatalf("requests after reset = %v", got)
+	}
+	if got := p.providerStat(t, "openai", "usage", "prompt_tokens"); got != float64(0) {
+		t.Fatalf("prompt tokens after reset = %v", got)
+	}
+	if tags := p.stats(t)["tags"].(map[string]any); len(tags) != 0 {
+		t.Fatalf("tags after reset = %v", tags)
+	}
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if got := p.providerStat(t, "openai", "requests"); got != float64(1) {
+		t.Fatalf("requests counted after reset = %v", got)
+	}
+}
+
+func TestStatsResetUnderLoad(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	const workers, perWorker = 4, 25
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perWorker {
+				p.do(t, http.MethodGet, "/openai/v1/models", "")
+			}
+		}()
+	}
+	// Каждый запрос попадает ровно в один интервал: сумма снимков равна числу запросов
+	var counted float64
+	for range 5 {
+		counted += p.resetStats(t)["providers"].(map[string]any)["openai"].(map[string]any)["requests"].(float64)
+	}
+	wg.Wait()
+	counted += p.providerStat(t, "openai", "requests").(float64)
+	if counted != workers*perWorker {
+		t.Fatalf("requests counted across resets = %v, want %d", counted, workers*perWorker)
+	}
+}
+
+func TestSLOCounters(t *testing.T) {
+	t.Setenv("PROXY_SLO_LATENCY_MS", "100")
+	t.Setenv("PROXY_MAX_MESSAGES", "1")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/slow" {
+			time.Sleep(150 * time.Millisecond)
+		}
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/fast", "")
+	p.do(t, http.MethodGet, "/openai/v1/fast", "")
+	p.do(t, http.MethodGet, "/openai/v1/fast", "")
+	p.do(t, http.MethodGet, "/openai/v1/slow", "")
+
+	// Локальные отказы до провайдера не доходят и в SLO не входят
+	req := p.newRequest(t, http.MethodGet, "/openai/v1/fast", "")
+	req.Header.Set("X-Proxy-Auth", "wrong")
+	send(t, req)
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"messages":[{},{}]}`)
+
+	slo := p.providerStat(t, "openai", "slo").(map[string]any)
+	if slo["met"] != float64(3) || slo["missed"] != float64(1) || slo["compliance"] != 0.75 || slo["target_ms"] != float64(100) {
+		t.Fatalf("slo = %v, want met 3, missed 1", slo)
+	}
+}
+
+func TestSLODisabledByDefault(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if slo := p.providerStat(t, "openai", "slo"); slo != nil {
+		t.Fatalf("slo reported without PROXY_SLO_LATENCY_MS: %v", slo)
+	}
+}
+
+func TestByteVolume(t *testing.T) {
+	// Размеры, которые видит провайдер: ровно они должны попасть в счётчики
+	var received int64
+	reply := `{"id":"chatcmpl-1","choices":[{"message":{"content":"` + strings.Repeat("x", 300) + `"}}]}`
+	events := "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n" + "data: [DONE]\n\n"
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		received += int64(len(data))
+		if strings.Contains(string(data), `"stream":true`) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte(events))
+			return
+		}
+		w.Write([]byte(reply))
+	})
+	p := startProxy(t)
+
+	request := `{"model":"gpt-4o","messages":[{"role":"user","content":"` + strings.Repeat("y", 200) + `"}]}`
+	if _, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", request); body != reply {
+		t.Fatalf("body = %s", body)
+	}
+	if in, out := p.providerStat(t, "openai", "bytes_in"), p.providerStat(t, "openai", "bytes_out"); in != float64(received) || out != float64(len(reply)) {
+		t.Fatalf("bytes_in = %v (upstream got %d), bytes_out = %v (reply %d)", in, received, out, len(reply))
+	}
+
+	// Поток учитывается по байтам, записанным клиенту, после его завершения
+	streamRequest := `{"model":"gpt-4o","stream":true}`
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", streamRequest, "Accept", "text/event-stream")
+	waitFor(t, "bytes of the stream", func() bool {
+		return p.providerStat(t, "openai", "bytes_out") == float64(len(reply)+len(events))
+	})
+	if in := p.providerStat(t, "openai", "bytes_in"); in != float64(received) {
+		t.Fatalf("bytes_in = %v after the stream, upstream got %d", in, received)
+	}
+}
+
+// modeStat - счётчик режима ("streaming" или "buffered") провайдера openai
+func (p *testProxy) modeStat(t *testing.T, mode, key string) any {
+	t.Helper()
+	return p.providerStat(t, "openai", mode, key)
+}
+
+func TestStreamingAndBufferedStats(t *testing.T) {
+	release := make(chan struct{})
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {}\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("data: [DONE]\n\n"))
+	})
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if p.modeStat(t, "buffered", "requests") != float64(1) || p.modeStat(t, "streaming", "requests") != float64(0) {
+		t.Fatalf("after a buffered request: %v", p.stats(t)["providers"].(map[string]any)["openai"])
+	}
+
+	// Поток учитывается в in_flight, пока идёт, и в requests - по завершении
+	resp, err := http.DefaultClient.Do(p.newRequest(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","stream":true}`,
+		"Accept", "text/event-stream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if p.modeStat(t, "streaming", "in_flight") != float64(1) || p.modeStat(t, "buffered", "in_flight") != float64(0) {
+		t.Fatalf("during the stream: %v", p.stats(t)["providers"].(map[string]any)["openai"])
+	}
+	close(release)
+	io.ReadAll(resp.Body)
+	waitFor(t, "the finished stream", func() bool {
+		return p.modeStat(t, "streaming", "requests") == float64(1) && p.modeStat(t, "streaming", "in_flight") == float64(0)
+	})
+	if p.modeStat(t, "buffered", "requests") != float64(1) {
+		t.Fatalf("buffered requests = %v after the stream", p.modeStat(t, "buffered", "requests"))
+	}
+}
+
+func TestModeStatsExcludeLocalRejections(t *testing.T) {
+	useTokens(t, `[{"name":"team-a","token":"tok-a","providers":["deepseek"]}]`)
+	t.Setenv("PROXY_MAX_MESSAGES", "1")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	// Отказы прокси до запроса к провайдеру: неизвестный токен, провайдер вне ACL, валидация
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`, "X-Proxy-Auth", "wrong")
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`, "X-Proxy-Auth", "tok-a")
+	resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", chatWithMessages(2))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("validation: status %d", resp.StatusCode)
+	}
+	for _, mode := range []string{"streaming", "buffered"} {
+		if got := p.modeStat(t, mode, "requests"); got != float64(0) {
+			t.Errorf("%s requests = %v after local rejections", mode, got)
+		}
+		if got := p.modeStat(t, mode, "errors"); got != float64(0) {
+			t.Errorf("%s errors = %v after local rejections", mode, got)
+		}
+	}
+}
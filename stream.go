@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	// streamFlushInterval - период сброса буфера стрима (0 - сброс после каждой строки)
+	streamFlushInterval time.Duration
+	// streamFlushBytes - сброс раньше интервала при накоплении стольких байт (0 - только по интервалу)
+	streamFlushBytes int
+
+	// streamDrainOnDisconnect - дочитывать кэшируемый поток после отключения клиента,
+	// чтобы закэшировать полный ответ (PROXY_STREAM_DRAIN_ON_DISCONNECT)
+	streamDrainOnDisconnect bool
+	// streamDrainTimeout - сколько максимум дочитывать поток без клиента
+	streamDrainTimeout time.Duration
+)
+
+// lockedReader сериализует чтения: в batched-режиме читающая горутина ещё может работать,
+// пока drainStream дочитывает тот же поток
+type lockedReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(p)
+}
+
+// passthroughBody - тело, которое fasthttp отправляет клиенту уже после возврата из хендлера
+// (206): считает отданные байты и при закрытии сообщает их число в done.
+// Content-Length у chunked-ответа нет, поэтому размер известен только в конце.
+type passthroughBody struct {
+	r    io.ReadCloser
+	n    int64
+	done func(n int64)
+	once sync.Once
+}
+
+func (b *passthroughBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *passthroughBody) Close() error {
+	err := b.r.Close()
+	b.once.Do(func() { b.done(b.n) })
+	return err
+}
+
+// drainStream дочитывает src до конца, не дольше streamDrainTimeout: по таймауту body закрывается
+func drainStream(body io.Closer, src io.Reader) (int64, error) {
+	timer := time.AfterFunc(streamDrainTimeout, func() { body.Close() })
+	defer timer.Stop()
+	return io.Copy(io.Discard, src)
+}
+
+// tapBytes прогоняет уже полученный поток через новый tap (завершённость и usage по полным данным)
+func tapBytes(provider string, data []byte) *streamTap {
+	tap := newStreamTap(provider)
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			tap.observe(line)
+		}
+		if err != nil {
+			return tap
+		}
+	}
+}
+
+// pipeStream копирует SSE-поток построчно в w согласно настроенной стратегии сброса.
+// Строки читаются bufio.Reader как есть, без декодирования UTF-8 и Scanner-лимитов:
+// бинарные и не-UTF-8 байты, \r\n и незавершённая последняя строка доходят без изменений.
+func pipeStream(w *bufio.Writer, body io.Reader, tap *streamTap) int64 {
+	reader := bufio.NewReaderSize(body, 64*1024) // 64KB buffer
+	if streamFlushInterval <= 0 {
+		return pipeStreamPerEvent(w, reader, tap)
+	}
+	return pipeStreamBatched(w, reader, tap)
+}
+
+// pipeStreamPerEvent сбрасывает буфер после каждой строки - максимальная интерактивность
+func pipeStreamPerEvent(w *bufio.Writer, reader *bufio.Reader, tap *streamTap) int64 {
+	var bytesWritten int64
+
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" && tap.observe(line) {
+			n, werr := w.WriteString(line)
+			if werr != nil {
+				log.Printf("Stream write error: %v", werr)
+				tap.clientGone = true
+				return bytesWritten
+			}
+			bytesWritten += int64(n)
+
+			if werr := w.Flush(); werr != nil {
+				log.Printf("Stream flush error: %v", werr)
+				tap.clientGone = true
+				return bytesWritten
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Stream read error: %v (after %d bytes)", err, bytesWritten)
+			}
+			return bytesWritten
+		}
+	}
+}
+
+// pipeStreamBatched копит строки и сбрасывает их не реже streamFlushInterval.
+// Чтение идёт в отдельной горутине, поэтому пауза upstream не задерживает уже полученные данные.
+func pipeStreamBatched(w *bufio.Writer, reader *bufio.Reader, tap *streamTap) int64 {
+	lines := make(chan string, 256)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				select {
+				case lines <- line:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				close(lines)
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(streamFlushInterval)
+	defer ticker.Stop()
+
+	var bytesWritten int64
+	pending := 0
+	flush := func() bool {
+		if pending == 0 {
+			return true
+		}
+		pending = 0
+		if err := w.Flush(); err != nil {
+			log.Printf("Stream flush error: %v", err)
+			tap.clientGone = true
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				flush()
+				if err := <-readErr; err != io.EOF {
+					log.Printf("Stream read error: %v (after %d bytes)", err, bytesWritten)
+				}
+				return bytesWritten
+			}
+
+			if !tap.observe(line) {
+				continue
+			}
+			n, err := w.WriteString(line)
+			if err != nil {
+				log.Printf("Stream write error: %v", err)
+				tap.clientGone = true
+				return bytesWritten
+			}
+			bytesWritten += int64(n)
+			pending += n
+
+			// Терминатор потока не держим в буфере
+			if (streamFlushBytes > 0 && pending >= streamFlushBytes) || tap.completed {
+				if !flush() {
+					return bytesWritten
+				}
+			}
+		case <-ticker.C:
+			if !flush() {
+				return bytesWritten
+			}
+		}
+	}
+}
+
+// lastEventIDHeader - ID последнего полученного события SSE для возобновления потока
+const lastEventIDHeader = "Last-Event-ID"
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flushCounter - приёмник под bufio.Writer: каждый сброс буфера - один Write
+type flushCounter struct {
+	mu     sync.Mutex
+	writes int
+	data   strings.Builder
+}
+
+func (f *flushCounter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes++
+	f.data.Write(p)
+	return len(p), nil
+}
+
+func (f *flushCounter) contents() (int, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writes, f.data.String()
+}
+
+// setFlushStrategy задаёт стратегию сброса на время теста
+func setFlushStrategy(t *testing.T, interval time.Duration, bytes int) {
+	prevInterval, prevBytes := streamFlushInterval, streamFlushBytes
+	streamFlushInterval, streamFlushBytes = interval, bytes
+	t.Cleanup(func() { streamFlushInterval, streamFlushBytes = prevInterval, prevBytes })
+}
+
+// chunkStream - n событий SSE и [DONE]; каждое событие - две строки (data и пустая)
+func chunkStream(n int) string {
+	var sb strings.Builder
+	for i := range n {
+		fmt.Fprintf(&sb, "data: {\"choices\":[{\"delta\":{\"content\":\"%d\"}}]}\n\n", i)
+	}
+	sb.WriteString("data: [DONE]\n\n")
+	return sb.String()
+}
+
+func runPipe(t *testing.T, src io.Reader) (*flushCounter, *streamTap) {
+	t.Helper()
+	out := &flushCounter{}
+	w := bufio.NewWriterSize(out, 64*1024)
+	tap := newStreamTap("openai")
+	pipeStream(w, src, tap)
+	return out, tap
+}
+
+func TestStreamFlushPerEvent(t *testing.T) {
+	setFlushStrategy(t, 0, 0)
+	stream := chunkStream(20)
+
+	out, tap := runPipe(t, strings.NewReader(stream))
+	writes, data := out.contents()
+	if data != stream || !tap.completed {
+		t.Fatalf("stream changed or not completed: %q", data)
+	}
+	// По умолчанию сброс после каждой строки
+	if want := strings.Count(stream, "\n"); writes != want {
+		t.Fatalf("flushes = %d, want %d", writes, want)
+	}
+}
+
+func TestStreamFlushBatchedByInterval(t *testing.T) {
+	setFlushStrategy(t, 50*time.Millisecond, 0)
+	stream := chunkStream(20)
+
+	out, _ := runPipe(t, strings.NewReader(stream))
+	writes, data := out.contents()
+	if data != stream {
+		t.Fatalf("stream changed: %q", data)
+	}
+	// Всё пришло сразу - хватает пары сбросов вместо сброса на каждую строку
+	if writes > 3 {
+		t.Fatalf("flushes = %d, want the stream batched", writes)
+	}
+}
+
+func TestStreamFlushBatchedByBytes(t *testing.T) {
+	event := "data: {\"choices\":[{\"delta\":{\"content\":\"0\"}}]}\n\n"
+	setFlushStrategy(t, time.Hour, 4*len(event))
+	stream := chunkStream(20)
+
+	out, _ := runPipe(t, strings.NewReader(stream))
+	writes, data := out.contents()
+	if data != stream {
+		t.Fatalf("stream changed: %q", data)
+	}
+	// Сброс по порогу примерно каждые 4 события, плюс [DONE]
+	if writes < 5 || writes > 7 {
+		t.Fatalf("flushes = %d, want about 6", writes)
+	}
+}
+
+func TestStreamFlushBatchedDoesNotHoldTerminator(t *testing.T) {
+	setFlushStrategy(t, time.Hour, 0)
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	out := &flushCounter{}
+	go pipeStream(bufio.NewWriterSize(out, 64*1024), pr, newStreamTap("openai"))
+
+	// Провайдер прислал последний чанк и [DONE], но соединение ещё не закрыл
+	fmt.Fprint(pw, chunkStream(1))
+	waitFor(t, "[DONE] flushed before the interval", func() bool {
+		_, data := out.contents()
+		return strings.Contains(data, "data: [DONE]\n")
+	})
+}
+
+func TestAssistantsStreamPassthrough(t *testing.T) {
+	stream, err := os.ReadFile("testdata/assistants_stream.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotBeta []string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotBeta = append(gotBeta, r.Header.Get("OpenAI-Beta"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Мелкие куски режут события, строки и многобайтные последовательности
+		for rest := stream; len(rest) > 0; {
+			n := min(7, len(rest))
+			w.Write(rest[:n])
+			w.(http.Flusher).Flush()
+			rest = rest[n:]
+		}
+	})
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/threads/runs", `{"assistant_id":"asst_1","stream":true}`,
+		"Accept", "text/event-stream")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	golden(t, "assistants_stream", []byte(body))
+
+	// Клиентский OpenAI-Beta не перезаписывается
+	p.do(t, http.MethodPost, "/openai/v1/threads/runs", `{"stream":true}`, "Accept", "text/event-stream", "OpenAI-Beta", "assistants=v1")
+	if len(gotBeta) != 2 || gotBeta[0] != "assistants=v2" || gotBeta[1] != "assistants=v1" {
+		t.Fatalf("upstream OpenAI-Beta = %q", gotBeta)
+	}
+}
+
+func TestOpenAIBetaOnlyForAssistants(t *testing.T) {
+	var gotBeta string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotBeta = r.Header.Get("OpenAI-Beta")
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if gotBeta != "" {
+		t.Fatalf("OpenAI-Beta injected for chat completions: %q", gotBeta)
+	}
+}
+
+// binaryStream - поток с байтами, которые не являются UTF-8: обрезанная
+// многобайтная последовательность, 0xff, NUL, \r\n и незавершённая последняя строка
+const binaryStream = "event: audio\r\ndata: \xff\xfe\x00\x01RIFF\x80\x81\r\n\r\n" +
+	"data: {\"delta\":\"\xd0\"}\n\n" +
+	"data: \xe2\x82\n\n" +
+	"data: tail\xc3"
+
+func TestStreamNonUTF8Passthrough(t *testing.T) {
+	out, _ := runPipe(t, strings.NewReader(binaryStream))
+	if _, data := out.contents(); data != binaryStream {
+		t.Fatalf("pipeStream output = %q, want %q", data, binaryStream)
+	}
+
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Куски по 3 байта режут последовательности посередине
+		for rest := binaryStream; len(rest) > 0; {
+			n := min(3, len(rest))
+			io.WriteString(w, rest[:n])
+			w.(http.Flusher).Flush()
+			rest = rest[n:]
+		}
+	})
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/audio/speech", `{"stream":true}`, "Accept", "text/event-stream")
+	if resp.StatusCode != http.StatusOK || body != binaryStream {
+		t.Fatalf("status %d, body %q, want %q", resp.StatusCode, body, binaryStream)
+	}
+}
+
+// resumableEvents - поток с id: у каждого события; resumableStream отдаёт события после lastID
+var resumableEvents = []string{
+	"id: 1\ndata: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\n",
+	"id: 2\nevent: message\ndata: {\"choices\":[{\"delta\":{\"content\":\"b\"}}]}\n\n",
+	"id: 3\ndata: [DONE]\n\n",
+}
+
+func resumableStream(lastID string) string {
+	for i := range resumableEvents {
+		if lastID == strconv.Itoa(i+1) {
+			return strings.Join(resumableEvents[i+1:], "")
+		}
+	}
+	return strings.Join(resumableEvents, "")
+}
+
+func TestStreamResumption(t *testing.T) {
+	var gotLastID []string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotLastID = append(gotLastID, r.Header.Get("Last-Event-ID"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, resumableStream(r.Header.Get("Last-Event-ID")))
+	})
+	p := startProxy(t)
+
+	for _, strategy := range []struct {
+		name     string
+		interval time.Duration
+		bytes    int
+	}{{"per event", 0, 0}, {"batched", 50 * time.Millisecond, 4096}} {
+		setFlushStrategy(t, strategy.interval, strategy.bytes)
+		gotLastID = nil
+
+		// id: строки доходят до клиента как есть
+		_, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`, "Accept", "text/event-stream")
+		if body != resumableStream("") {
+			t.Errorf("%s: stream = %q", strategy.name, body)
+		}
+		// Возобновление: Last-Event-ID уходит провайдеру
+		_, body = p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`,
+			"Accept", "text/event-stream", "Last-Event-ID", "2")
+		if body != resumableEvents[2] {
+			t.Errorf("%s: resumed stream = %q", strategy.name, body)
+		}
+		if strings.Join(gotLastID, ",") != ",2" {
+			t.Errorf("%s: upstream Last-Event-ID = %q", strategy.name, gotLastID)
+		}
+	}
+}
+
+func TestRecordingKeyLastEventID(t *testing.T) {
+	body := []byte(`{"stream":true}`)
+	fresh := recordingKey("openai", "POST", "v1/chat/completions?", "", body)
+	// Без заголовка ключ прежний - существующие записи находятся
+	if fresh != requestHash("openai", "POST", "v1/chat/completions?", nil, body, nil) {
+		t.Fatal("recording key without Last-Event-ID changed")
+	}
+	if recordingKey("openai", "POST", "v1/chat/completions?", "2", body) == fresh {
+		t.Fatal("resumed stream shares the recording key of the full stream")
+	}
+}
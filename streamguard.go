@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	// maxStreamDuration - предельная длительность потока (PROXY_MAX_STREAM_SECONDS), 0 - без ограничения
+	maxStreamDuration time.Duration
+	// streamIdleTimeout - предельная пауза между данными от провайдера (PROXY_STREAM_IDLE_SECONDS), 0 - без ограничения
+	streamIdleTimeout time.Duration
+	// streamTimeoutErrorEvent - завершать оборванный по таймауту поток событием error
+	streamTimeoutErrorEvent bool
+	// streamSoftTimeout - мягкий предел длительности потока (PROXY_STREAM_SOFT_TIMEOUT_SECONDS):
+	// чтение прекращается на границе события, клиент получает принятое и событие об усечении
+	streamSoftTimeout time.Duration
+)
+
+// softTimeoutReason - причина обрыва по мягкому пределу
+const softTimeoutReason = "soft stream timeout reached"
+
+// streamGuard закрывает тело ответа провайдера по истечении предельного времени потока
+// или паузы без данных; чтение после этого завершается ошибкой, и pipeStream заканчивает поток
+type streamGuard struct {
+	body io.ReadCloser
+
+	mu        sync.Mutex
+	reason    string
+	soft      bool // поток оборван мягким пределом
+	timer     *time.Timer
+	idleTimer *time.Timer
+	softTimer *time.Timer
+
+	// С мягким пределом Read отдаёт только целые события (SSE - до пустой строки, NDJSON - строки):
+	// неполное событие на момент обрыва отбрасывается, чтобы событие об усечении не склеилось с ним
+	ndjson  bool
+	pending []byte
+	readErr error
+}
+
+func newStreamGuard(body io.ReadCloser, ndjson bool) *streamGuard {
+	g := &streamGuard{body: body, ndjson: ndjson}
+	if maxStreamDuration > 0 {
+		g.timer = time.AfterFunc(maxStreamDuration, func() { g.abort("max stream duration exceeded", false) })
+	}
+	if streamIdleTimeout > 0 {
+		g.idleTimer = time.AfterFunc(streamIdleTimeout, func() { g.abort("stream idle timeout exceeded", false) })
+	}
+	if streamSoftTimeout > 0 {
+		g.softTimer = time.AfterFunc(streamSoftTimeout, func() { g.abort(softTimeoutReason, true) })
+	}
+	return g
+}
+
+// Read перезапускает таймер паузы на каждой полученной порции данных
+func (g *streamGuard) Read(p []byte) (int, error) {
+	if g.softTimer != nil {
+		return g.readEvents(p)
+	}
+	return g.read(p)
+}
+
+func (g *streamGuard) read(p []byte) (int, error) {
+	n, err := g.body.Read(p)
+	if n > 0 && g.idleTimer != nil {
+		g.idleTimer.Reset(streamIdleTimeout)
+	}
+	return n, err
+}
+
+// readEvents отдаёт данные до конца последнего целого события, остаток придерживает до следующего
+// чтения. После мягкого обрыва неполное событие отбрасывается, поток завершается как io.EOF.
+func (g *streamGuard) readEvents(p []byte) (int, error) {
+	for {
+		if end := lastEventEnd(g.pending, g.ndjson); end > 0 {
+			n := copy(p, g.pending[:end])
+			g.pending = g.pending[n:]
+			return n, nil
+		}
+		if g.readErr != nil {
+			if g.truncated() {
+				g.pending = nil
+				return 0, io.EOF
+			}
+			// Штатный конец или жёсткий обрыв: последнее неполное событие уходит как есть
+			n := copy(p, g.pending)
+			g.pending = g.pending[n:]
+			if len(g.pending) > 0 {
+				return n, nil
+			}
+			return n, g.readErr
+		}
+		n, err := g.read(p)
+		g.pending = append(g.pending, p[:n]...)
+		g.readErr = err
+	}
+}
+
+// lastEventEnd - длина префикса buf из целых событий, 0 - ни одного целого события.
+// Событие SSE заканчивается пустой строкой (\n\n или \n\r\n), NDJSON - переводом строки.
+func lastEventEnd(buf []byte, ndjson bool) int {
+	if ndjson {
+		return bytes.LastIndexByte(buf, '\n') + 1
+	}
+	end := 0
+	if i := bytes.LastIndex(buf, []byte("\n\n")); i >= 0 {
+		end = i + 2
+	}
+	if i := bytes.LastIndex(buf, []byte("\n\r\n")); i >= 0 && i+3 > end {
+		end = i + 3
+	}
+	return end
+}
+
+func (g *streamGuard) abort(reason string, soft bool) {
+	g.mu.Lock()
+	if g.reason == "" {
+		g.reason, g.soft = reason, soft
+	}
+	g.mu.Unlock()
+	g.body.Close()
+}
+
+// truncated - поток оборван мягким пределом
+func (g *streamGuard) truncated() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.soft
+}
+
+// stop отключает таймеры; возвращает причину обрыва, "" - поток не обрывался
+func (g *streamGuard) stop() string {
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	if g.idleTimer != nil {
+		g.idleTimer.Stop()
+	}
+	if g.softTimer != nil {
+		g.softTimer.Stop()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.reason
+}
+
+// writeStreamError дописывает клиенту событие об обрыве потока прокси
+func writeStreamError(w *bufio.Writer, errType, message string) {
+	data, _ := json.Marshal(map[string]any{"error": map[string]string{"type": errType, "message": message}})
+	w.WriteString("event: error\ndata: ")
+	w.Write(data)
+	w.WriteString("\n\n")
+	w.Flush()
+}
+
+// writeStreamTruncated завершает усечённый мягким пределом поток в формате провайдера:
+// OpenAI - чанк с finish_reason "length" и полем proxy_truncated, затем [DONE];
+// Anthropic - событие proxy_truncated и message_stop; NDJSON - строка с "done":true.
+// Нормализованный поток Anthropic переводит эти события сам.
+func writeStreamTruncated(w *bufio.Writer, provider string, ndjson bool, message string) {
+	marker := map[string]string{"type": "soft_timeout", "message": message}
+	switch {
+	case ndjson:
+		data, _ := json.Marshal(map[string]any{"done": true, "done_reason": "proxy_truncated", "proxy_truncated": marker})
+		w.Write(data)
+		w.WriteString("\n")
+	case provider == "anthropic":
+		data, _ := json.Marshal(map[string]any{"type": "proxy_truncated", "proxy_truncated": marker})
+		w.WriteString("event: proxy_truncated\ndata: ")
+		w.Write(data)
+		w.WriteString("\n\nevent: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	default:
+		w.WriteString(sseData(truncatedChunk("", "", 0, marker)))
+		w.WriteString("data: [DONE]\n\n")
+	}
+	w.Flush()
+}
+
+// truncatedChunk - завершающий чанк OpenAI усечённого потока
+func truncatedChunk(id, model string, created int64, marker any) map[string]any {
+	finish := "length"
+	chunk := map[string]any{
+		"object":          "chat.completion.chunk",
+		"choices":         []openAIChunkChoice{{Index: 0, Delta: map[string]any{}, FinishReason: &finish}},
+		"proxy_truncated": marker,
+	}
+	if id != "" {
+		chunk["id"], chunk["model"], chunk["created"] = id, model, created
+	}
+	return chunk
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// endlessUpstream стримит чанки каждые interval, пока прокси не закроет соединение
+func endlessUpstream(t *testing.T, interval time.Duration) chan struct{} {
+	closed := make(chan struct{})
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		defer close(closed)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for {
+			if _, err := w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\".\"}}]}\n\n")); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	})
+	return closed
+}
+
+func TestMaxStreamDuration(t *testing.T) {
+	t.Setenv("PROXY_MAX_STREAM_SECONDS", "1")
+	upstreamClosed := endlessUpstream(t, 20*time.Millisecond)
+	p := startProxy(t)
+
+	start := time.Now()
+	_, stream := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`, "Accept", "text/event-stream")
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 3*time.Second {
+		t.Fatalf("stream ended after %s, want about 1s", elapsed)
+	}
+	data := dataLines(stream)
+	if len(data) < 10 {
+		t.Fatalf("stream cut too early: %d events", len(data))
+	}
+	if !strings.HasSuffix(stream, "event: error\ndata: {\"error\":{\"message\":\"max stream duration exceeded\",\"type\":\"stream_timeout\"}}\n\n") {
+		t.Fatalf("no terminal error event, stream ends with %q", stream[max(len(stream)-200, 0):])
+	}
+	select {
+	case <-upstreamClosed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("upstream connection left open")
+	}
+}
+
+func TestMaxStreamDurationWithoutErrorEvent(t *testing.T) {
+	t.Setenv("PROXY_MAX_STREAM_SECONDS", "1")
+	t.Setenv("PROXY_STREAM_TIMEOUT_ERROR_EVENT", "false")
+	endlessUpstream(t, 20*time.Millisecond)
+	p := startProxy(t)
+
+	_, stream := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`, "Accept", "text/event-stream")
+	if strings.Contains(stream, "event: error") || !strings.HasSuffix(stream, "}\n\n") {
+		t.Fatalf("stream ends with %q", stream[max(len(stream)-200, 0):])
+	}
+}
+
+// stallingUpstream отдаёт chunks чанков с паузой gap между ними, затем зависает до закрытия соединения
+func stallingUpstream(t *testing.T, chunks int, gap time.Duration) chan struct{} {
+	closed := make(chan struct{})
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		defer close(closed)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for range chunks {
+			w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\".\"}}]}\n\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(gap)
+		}
+		<-r.Context().Done()
+	})
+	return closed
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	t.Setenv("PROXY_STREAM_IDLE_SECONDS", "1")
+	// Паузы между чанками короче предела и в сумме длиннее его: таймер сбрасывается на каждом чанке
+	upstreamClosed := stallingUpstream(t, 4, 400*time.Millisecond)
+	p := startProxy(t)
+
+	start := time.Now()
+	_, stream := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`, "Accept", "text/event-stream")
+	elapsed := time.Since(start)
+	if data := dataLines(stream); len(data) != 5 {
+		t.Fatalf("stream = %q, want 4 chunks and an error", stream)
+	}
+	// 4 x 400ms до зависания и 1s тишины
+	if elapsed < 2*time.Second || elapsed > 4*time.Second {
+		t.Fatalf("stream ended after %s", elapsed)
+	}
+	if !strings.HasSuffix(stream, "event: error\ndata: {\"error\":{\"message\":\"stream idle timeout exceeded\",\"type\":\"stream_timeout\"}}\n\n") {
+		t.Fatalf("no idle timeout error event: %q", stream)
+	}
+	select {
+	case <-upstreamClosed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("upstream connection left open")
+	}
+}
+
+// softCutUpstream отдаёт events целых событий, затем половину события, и зависает
+func softCutUpstream(t *testing.T, provider, event, half string, events int) chan struct{} {
+	closed := make(chan struct{})
+	upstream(t, provider, func(w http.ResponseWriter, r *http.Request) {
+		defer close(closed)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for range events {
+			w.Write([]byte(event))
+		}
+		w.Write([]byte(half))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	return closed
+}
+
+func TestStreamSoftTimeout(t *testing.T) {
+	t.Setenv("PROXY_STREAM_SOFT_TIMEOUT_SECONDS", "1")
+	event := "data: {\"choices\":[{\"delta\":{\"content\":\".\"}}]}\n\n"
+	// Обрыв приходится между строками события: data уже пришла, пустой строки ещё нет
+	upstreamClosed := softCutUpstream(t, "openai", event, "data: {\"choices\":[{\"delta\":{\"content\":\"half\"}}]}\n", 3)
+	p := startProxy(t)
+
+	resp, stream := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`, "Accept", "text/event-stream")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	want := strings.Repeat(event, 3) +
+		`data: {"choices":[{"index":0,"delta":{},"finish_reason":"length"}],"object":"chat.completion.chunk","proxy_truncated":{"message":"soft stream timeout reached","type":"soft_timeout"}}` + "\n\n" +
+		"data: [DONE]\n\n"
+	if stream != want {
+		t.Fatalf("stream:\n got %q\nwant %q", stream, want)
+	}
+	if got := p.providerStat(t, "openai", "streams_truncated"); got != float64(1) {
+		t.Fatalf("streams_truncated = %v", got)
+	}
+	if got := p.providerStat(t, "openai", "streams_incomplete"); got != float64(0) {
+		t.Fatalf("truncated stream counted as incomplete: %v", got)
+	}
+	select {
+	case <-upstreamClosed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("upstream connection left open")
+	}
+}
+
+func TestStreamSoftTimeoutAnthropic(t *testing.T) {
+	t.Setenv("PROXY_STREAM_SOFT_TIMEOUT_SECONDS", "1")
+	event := "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\".\"}}\n\n"
+	softCutUpstream(t, "anthropic", event, "event: content_block_delta\n", 2)
+	p := startProxy(t)
+
+	_, stream := p.do(t, http.MethodPost, "/anthropic/v1/messages", `{"stream":true}`, "Accept", "text/event-stream")
+	want := strings.Repeat(event, 2) +
+		"event: proxy_truncated\ndata: {\"proxy_truncated\":{\"message\":\"soft stream timeout reached\",\"type\":\"soft_timeout\"},\"type\":\"proxy_truncated\"}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+	if stream != want {
+		t.Fatalf("stream:\n got %q\nwant %q", stream, want)
+	}
+}
+
+func TestStreamSoftTimeoutCompletedStream(t *testing.T) {
+	t.Setenv("PROXY_STREAM_SOFT_TIMEOUT_SECONDS", "1")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Последнее событие без пустой строки: при штатном конце уходит как есть
+		w.Write([]byte("data: {\"choices\":[]}\n\ndata: [DONE]\n"))
+	})
+	p := startProxy(t)
+
+	_, stream := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`, "Accept", "text/event-stream")
+	if stream != "data: {\"choices\":[]}\n\ndata: [DONE]\n" {
+		t.Fatalf("stream = %q", stream)
+	}
+	if got := p.providerStat(t, "openai", "streams_truncated"); got != float64(0) {
+		t.Fatalf("streams_truncated = %v", got)
+	}
+}
+
+func TestLastEventEnd(t *testing.T) {
+	for _, tc := range []struct {
+		buf    string
+		ndjson bool
+		want   int
+	}{
+		{"data: a\n", false, 0},
+		{"data: a\n\ndata: b\n", false, 9},
+		{"data: a\r\n\r\ndata: b", false, 11},
+		{"event: x\ndata: a\n\n", false, 18},
+		{`{"a":1}` + "\n" + `{"b"`, true, 8},
+		{`{"a":1}`, true, 0},
+	} {
+		if got := lastEventEnd([]byte(tc.buf), tc.ndjson); got != tc.want {
+			t.Errorf("lastEventEnd(%q, %t) = %d, want %d", tc.buf, tc.ndjson, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// streamGzip - разрешить gzip для SSE по явному согласию клиента (PROXY_STREAM_GZIP)
+var streamGzip bool
+
+// streamCompressionHeader - клиент подтверждает, что умеет читать сжатый поток событий
+const streamCompressionHeader = "X-Proxy-Stream-Compression"
+
+// wantsGzipStream - клиент прислал Accept-Encoding: gzip и X-Proxy-Stream-Compression: gzip.
+// Одного Accept-Encoding мало: многие SSE-клиенты его шлют, но не распаковывают поток по событиям.
+func wantsGzipStream(c *fiber.Ctx) bool {
+	if !streamGzip {
+		return false
+	}
+	return strings.Contains(strings.ToLower(c.Get(streamCompressionHeader)), "gzip") &&
+		strings.Contains(strings.ToLower(c.Get("Accept-Encoding")), "gzip")
+}
+
+// gzipFlushWriter сжимает каждый сброс bufio.Writer отдельным gzip-flush,
+// чтобы событие доходило до клиента сразу, а не ждало заполнения окна компрессора
+type gzipFlushWriter struct {
+	gz  *gzip.Writer
+	out *bufio.Writer
+}
+
+func (g *gzipFlushWriter) Write(p []byte) (int, error) {
+	n, err := g.gz.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if err := g.gz.Flush(); err != nil {
+		return n, err
+	}
+	return n, g.out.Flush()
+}
+
+// newGzipStreamWriter оборачивает w; finish дописывает хвост gzip и должен быть вызван в конце потока
+func newGzipStreamWriter(w *bufio.Writer) (*bufio.Writer, func()) {
+	gw := &gzipFlushWriter{gz: gzip.NewWriter(w), out: w}
+	bw := bufio.NewWriterSize(gw, 64*1024)
+	return bw, func() {
+		bw.Flush()
+		gw.gz.Close()
+		w.Flush()
+	}
+}
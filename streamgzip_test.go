@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+var gzipEvents = []string{
+	"data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n",
+	"data: {\"choices\":[{\"delta\":{\"content\":\" world\"}}]}\n\n",
+	"data: [DONE]\n\n",
+}
+
+// steppedUpstream отдаёт следующее событие только после сигнала next
+func steppedUpstream(t *testing.T, next chan struct{}) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i, ev := range gzipEvents {
+			if i > 0 {
+				<-next
+			}
+			w.Write([]byte(ev))
+			w.(http.Flusher).Flush()
+		}
+	})
+}
+
+func TestGzipStreamRoundTrip(t *testing.T) {
+	t.Setenv("PROXY_STREAM_GZIP", "true")
+	next := make(chan struct{})
+	steppedUpstream(t, next)
+	p := startProxy(t)
+
+	req := p.newRequest(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`,
+		"Accept", "text/event-stream", "Accept-Encoding", "gzip", streamCompressionHeader, "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q", resp.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := bufio.NewReader(zr)
+
+	// Каждое событие распаковывается сразу, до отправки следующего
+	var got strings.Builder
+	for i := range gzipEvents[:2] {
+		line := make(chan string)
+		go func() {
+			s, _ := events.ReadString('\n')
+			blank, _ := events.ReadString('\n')
+			line <- s + blank
+		}()
+		select {
+		case ev := <-line:
+			if ev != gzipEvents[i] {
+				t.Fatalf("event %d = %q, want %q", i, ev, gzipEvents[i])
+			}
+			got.WriteString(ev)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("event %d stuck in the compressor", i)
+		}
+		next <- struct{}{}
+	}
+	rest, err := io.ReadAll(events)
+	if err != nil {
+		t.Fatalf("gzip stream is not terminated properly: %v", err)
+	}
+	got.Write(rest)
+	if got.String() != strings.Join(gzipEvents, "") {
+		t.Fatalf("decompressed stream = %q", got.String())
+	}
+}
+
+func TestGzipStreamRequiresNegotiation(t *testing.T) {
+	t.Setenv("PROXY_STREAM_GZIP", "true")
+	next := make(chan struct{}, len(gzipEvents))
+	for range gzipEvents {
+		next <- struct{}{}
+	}
+	steppedUpstream(t, next)
+	p := startProxy(t)
+
+	// Один Accept-Encoding без согласия клиента - поток не сжимается
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`,
+		"Accept", "text/event-stream", "Accept-Encoding", "gzip")
+	if resp.Header.Get("Content-Encoding") != "" || body != strings.Join(gzipEvents, "") {
+		t.Fatalf("Content-Encoding %q, body %q", resp.Header.Get("Content-Encoding"), body)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Нормализация потоков к формату OpenAI chat.completion.chunk (<PROVIDER>_NORMALIZE_STREAM):
+// клиенты OpenAI получают одинаковый поток от любого провайдера. По умолчанию поток
+// передаётся как есть. Учёт usage и завершённости (streamTap) идёт по исходному потоку.
+
+// supportsStreamNormalization - провайдеры, поток которых умеем приводить к формату OpenAI
+func supportsStreamNormalization(provider string) bool {
+	switch provider {
+	case "deepseek", "nebius", "anthropic":
+		return true
+	}
+	return false
+}
+
+// streamNormalizer переводит строку исходного потока в события OpenAI (строки "data: ...")
+type streamNormalizer interface {
+	line(s string) []string
+}
+
+func newStreamNormalizer(provider string) streamNormalizer {
+	if provider == "anthropic" {
+		return &anthropicStreamNormalizer{created: time.Now().Unix(), blockTool: map[int]int{}}
+	}
+	return openAIStreamNormalizer{}
+}
+
+// normalizeWriter разбирает записанное на строки и пишет в out нормализованные события
+type normalizeWriter struct {
+	n       streamNormalizer
+	out     *bufio.Writer
+	pending []byte
+}
+
+func (w *normalizeWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(w.pending[:i+1])
+		w.pending = w.pending[i+1:]
+		for _, ev := range w.n.line(line) {
+			if _, err := w.out.WriteString(ev); err != nil {
+				return len(p), err
+			}
+		}
+	}
+	return len(p), w.out.Flush()
+}
+
+// newNormalizedStreamWriter оборачивает w; finish обрабатывает неполную последнюю строку
+func newNormalizedStreamWriter(w *bufio.Writer, provider string) (*bufio.Writer, func()) {
+	nw := &normalizeWriter{n: newStreamNormalizer(provider), out: w}
+	bw := bufio.NewWriterSize(nw, 64*1024)
+	return bw, func() {
+		bw.Flush()
+		if len(nw.pending) > 0 {
+			nw.Write([]byte("\n"))
+		}
+		w.Flush()
+	}
+}
+
+func sseData(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return "data: " + string(data) + "\n\n"
+}
+
+// openAIStreamNormalizer - DeepSeek/Nebius: формат уже OpenAI, убираем keep-alive комментарии
+// и строки event:, дополняем object и пустой choices у usage-чанка
+type openAIStreamNormalizer struct{}
+
+func (openAIStreamNormalizer) line(s string) []string {
+	data, ok := strings.CutPrefix(strings.TrimRight(s, "\r\n"), "data:")
+	if !ok {
+		return nil
+	}
+	data = strings.TrimSpace(data)
+	if data == "[DONE]" {
+		return []string{"data: [DONE]\n\n"}
+	}
+	var chunk map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil || chunk["error"] != nil {
+		return []string{"data: " + data + "\n\n"}
+	}
+	if _, ok := chunk["object"]; !ok {
+		chunk["object"] = json.RawMessage(`"chat.completion.chunk"`)
+	}
+	if _, ok := chunk["choices"]; !ok {
+		chunk["choices"] = json.RawMessage(`[]`)
+	}
+	return []string{sseData(chunk)}
+}
+
+// anthropicStreamNormalizer переводит события Messages API в chat.completion.chunk
+type anthropicStreamNormalizer struct {
+	id      string
+	model   string
+	created int64
+	usage   rawUsage
+	// blockTool - индекс блока tool_use -> индекс в tool_calls
+	blockTool map[int]int
+}
+
+type openAIChunkChoice struct {
+	Index        int            `json:"index"`
+	Delta        map[string]any `json:"delta"`
+	FinishReason *string        `json:"finish_reason"`
+}
+
+func (a *anthropicStreamNormalizer) chunk(delta map[string]any, finish *string) string {
+	return sseData(map[string]any{
+		"id":      a.id,
+		"object":  "chat.completion.chunk",
+		"created": a.created,
+		"model":   a.model,
+		"choices": []openAIChunkChoice{{Index: 0, Delta: delta, FinishReason: finish}},
+	})
+}
+
+// anthropicFinishReason - stop_reason Anthropic в finish_reason OpenAI
+func anthropicFinishReason(reason string) string {
+	switch reason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	default:
+		return "stop"
+	}
+}
+
+func (a *anthropicStreamNormalizer) line(s string) []string {
+	data, ok := strings.CutPrefix(strings.TrimRight(s, "\r\n"), "data:")
+	if !ok {
+		return nil
+	}
+	var ev struct {
+		Type  string          `json:"type"`
+		Index int             `json:"index"`
+		Error json.RawMessage `json:"error"`
+		// message_start
+		Message struct {
+			ID    string   `json:"id"`
+			Model string   `json:"model"`
+			Usage rawUsage `json:"usage"`
+		} `json:"message"`
+		// content_block_start
+		ContentBlock struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"content_block"`
+		// content_block_delta, message_delta
+		Delta struct {
+			Type        string `json:"type"`
+			Text        string `json:"text"`
+			Thinking    string `json:"thinking"`
+			PartialJSON string `json:"partial_json"`
+			StopReason  string `json:"stop_reason"`
+		} `json:"delta"`
+		Usage *rawUsage `json:"usage"`
+		// proxy_truncated - событие самого прокси об усечении потока
+		Truncated json.RawMessage `json:"proxy_truncated"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &ev); err != nil {
+		return nil
+	}
+
+	// Ошибка провайдера или событие stream_timeout самого прокси
+	if ev.Type == "error" || (len(ev.Error) > 0 && string(ev.Error) != "null") {
+		return []string{sseData(map[string]json.RawMessage{"error": ev.Error})}
+	}
+
+	switch ev.Type {
+	case "message_start":
+		a.id, a.model, a.usage = ev.Message.ID, ev.Message.Model, ev.Message.Usage
+		return []string{a.chunk(map[string]any{"role": "assistant", "content": ""}, nil)}
+	case "content_block_start":
+		if ev.ContentBlock.Type != "tool_use" {
+			return nil
+		}
+		idx := len(a.blockTool)
+		a.blockTool[ev.Index] = idx
+		return []string{a.chunk(map[string]any{"tool_calls": []any{map[string]any{
+			"index":    idx,
+			"id":       ev.ContentBlock.ID,
+			"type":     "function",
+			"function": map[string]any{"name": ev.ContentBlock.Name, "arguments": ""},
+		}}}, nil)}
+	case "content_block_delta":
+		switch ev.Delta.Type {
+		case "text_delta":
+			return []string{a.chunk(map[string]any{"content": ev.Delta.Text}, nil)}
+		case "thinking_delta":
+			// Как reasoning_content у DeepSeek
+			return []string{a.chunk(map[string]any{"reasoning_content": ev.Delta.Thinking}, nil)}
+		case "input_json_delta":
+			return []string{a.chunk(map[string]any{"tool_calls": []any{map[string]any{
+				"index":    a.blockTool[ev.Index],
+				"function": map[string]any{"arguments": ev.Delta.PartialJSON},
+			}}}, nil)}
+		}
+		return nil
+	case "message_delta":
+		if ev.Usage != nil {
+			a.usage.OutputTokens = ev.Usage.OutputTokens
+		}
+		if ev.Delta.StopReason == "" {
+			return nil
+		}
+		finish := anthropicFinishReason(ev.Delta.StopReason)
+		return []string{a.chunk(map[string]any{}, &finish)}
+	case "proxy_truncated":
+		return []string{sseData(truncatedChunk(a.id, a.model, a.created, ev.Truncated))}
+	case "message_stop":
+		prompt := a.usage.InputTokens + a.usage.CacheReadInputTokens
+		usage := map[string]any{
+			"prompt_tokens":         prompt,
+			"completion_tokens":     a.usage.OutputTokens,
+			"total_tokens":          prompt + a.usage.OutputTokens,
+			"prompt_tokens_details": map[string]any{"cached_tokens": a.usage.CacheReadInputTokens},
+		}
+		return []string{sseData(map[string]any{
+			"id":      a.id,
+			"object":  "chat.completion.chunk",
+			"created": a.created,
+			"model":   a.model,
+			"choices": []any{},
+			"usage":   usage,
+		}), "data: [DONE]\n\n"}
+	}
+	// ping и прочие служебные события
+	return nil
+}
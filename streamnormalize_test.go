@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Исходные потоки провайдеров: служебные события, keep-alive, чанки без object/choices
+const (
+	anthropicRawStream = "event: message_start\n" +
+		`data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4","usage":{"input_tokens":12,"cache_read_input_tokens":3,"output_tokens":1}}}` + "\n\n" +
+		"event: ping\n" + `data: {"type":"ping"}` + "\n\n" +
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"thinking"}}` + "\n\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"hmm"}}` + "\n\n" +
+		`data: {"type":"content_block_start","index":1,"content_block":{"type":"text"}}` + "\n\n" +
+		`data: {"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Hi"}}` + "\n\n" +
+		`data: {"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"lookup"}}` + "\n\n" +
+		`data: {"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"q\":"}}` + "\n\n" +
+		`data: {"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"\"x\"}"}}` + "\n\n" +
+		`data: {"type":"content_block_stop","index":2}` + "\n\n" +
+		`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}` + "\n\n" +
+		`data: {"type":"message_stop"}` + "\n\n"
+
+	openAIishRawStream = ": keep-alive\n\n" +
+		`data: {"id":"c1","created":1,"model":"m","choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}` + "\n\n" +
+		": keep-alive\n\n" +
+		`data: {"id":"c1","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":"stop"}]}` + "\n\n" +
+		`data: {"id":"c1","created":1,"model":"m","usage":{"prompt_tokens":4,"completion_tokens":2,"total_tokens":6}}` + "\n\n" +
+		"data: [DONE]\n\n"
+)
+
+// createdRe - время создания чанка, которое нормализатор Anthropic ставит сам
+var createdRe = regexp.MustCompile(`"created":\d+`)
+
+// normalizedStream - поток провайдера через прокси с <PROVIDER>_NORMALIZE_STREAM=normalize
+func normalizedStream(t *testing.T, provider, path, raw, normalize string) (*testProxy, string) {
+	t.Helper()
+	t.Setenv(envPrefix(provider)+"NORMALIZE_STREAM", normalize)
+	upstream(t, provider, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(raw))
+	})
+	p := startProxy(t)
+	_, body := p.do(t, http.MethodPost, "/"+provider+path, `{"model":"m","stream":true}`, "Accept", "text/event-stream")
+	return p, body
+}
+
+func TestStreamNormalizationAnthropic(t *testing.T) {
+	p, body := normalizedStream(t, "anthropic", "/v1/messages", anthropicRawStream, "true")
+	golden(t, "normalized_anthropic_stream", []byte(createdRe.ReplaceAllString(body, `"created":0`)))
+
+	// Учёт usage идёт по исходному потоку
+	waitFor(t, "usage of the normalized stream", func() bool {
+		return p.providerStat(t, "anthropic", "usage", "completion_tokens") == float64(7)
+	})
+}
+
+func TestStreamNormalizationOpenAICompatible(t *testing.T) {
+	for _, provider := range []string{"deepseek", "nebius"} {
+		t.Run(provider, func(t *testing.T) {
+			_, body := normalizedStream(t, provider, "/v1/chat/completions", openAIishRawStream, "true")
+			golden(t, "normalized_"+provider+"_stream", []byte(body))
+		})
+	}
+}
+
+func TestStreamNormalizationOffByDefault(t *testing.T) {
+	_, body := normalizedStream(t, "anthropic", "/v1/messages", anthropicRawStream, "")
+	if body != anthropicRawStream {
+		t.Fatalf("stream changed without ANTHROPIC_NORMALIZE_STREAM:\n%s", body)
+	}
+}
+
+func TestStreamNormalizationUnsupportedProvider(t *testing.T) {
+	t.Setenv("OPENAI_NORMALIZE_STREAM", "true")
+	logs := captureLog(t)
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {})
+	startProxy(t)
+	if !strings.Contains(logs.String(), "WARN: OPENAI_NORMALIZE_STREAM ignored: openai streams are not normalized") {
+		t.Fatalf("no warning for an unsupported provider:\n%s", logs)
+	}
+}
+
+func TestNormalizeWriterIncompleteLastLine(t *testing.T) {
+	out := &flushCounter{}
+	w, finish := newNormalizedStreamWriter(bufio.NewWriter(out), "deepseek")
+	w.WriteString("data: {\"choices\":[]}\n\ndata: [DONE]")
+	finish()
+	if _, data := out.contents(); data != "data: {\"choices\":[],\"object\":\"chat.completion.chunk\"}\n\ndata: [DONE]\n\n" {
+		t.Fatalf("normalized = %q", data)
+	}
+}
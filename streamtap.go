@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// usageNullRe - "usage":null в любом форматировании (провайдеры присылают и "usage": null)
+var usageNullRe = regexp.MustCompile(`"usage"\s*:\s*null`)
+
+// streamTap наблюдает за строками SSE-потока: фиксирует штатное завершение и usage.
+// Строки не изменяет; единственное исключение - usage-чанк, добавленный по запросу прокси.
+type streamTap struct {
+	provider   string
+	stripUsage bool
+	ndjson     bool // поток newline-delimited JSON вместо SSE
+	completed  bool
+	failed     bool   // провайдер прислал событие с ошибкой посреди потока
+	errMessage string // текст этой ошибки для лога
+	clientGone bool   // запись клиенту не удалась - клиент закрыл соединение
+	hasUsage   bool
+	usage      rawUsage
+	model      string
+}
+
+func newStreamTap(provider string) *streamTap {
+	return &streamTap{provider: provider}
+}
+
+// isNDJSON - ответ потоком newline-delimited JSON
+func isNDJSON(contentType string) bool {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	switch strings.TrimSpace(mediaType) {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return true
+	}
+	return false
+}
+
+// observe разбирает очередную строку потока; false - строку клиенту не передавать
+func (t *streamTap) observe(line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+
+	// NDJSON (Ollama): каждая строка - JSON-объект, последняя помечена "done": true.
+	// Поле разбираем: то же сочетание может встретиться внутри сгенерированного текста
+	if t.ndjson {
+		if strings.Contains(line, `"done"`) {
+			var obj struct {
+				Done bool `json:"done"`
+			}
+			if json.Unmarshal([]byte(line), &obj) == nil && obj.Done {
+				t.completed = true
+			}
+		}
+		if strings.Contains(line, `"error"`) {
+			t.parseError(line)
+		}
+		return true
+	}
+
+	// Anthropic сигнализирует конец событием message_stop
+	if line == "event: message_stop" {
+		t.completed = true
+		return true
+	}
+
+	data, ok := strings.CutPrefix(line, "data:")
+	if !ok {
+		return true
+	}
+	data = strings.TrimSpace(data)
+
+	// OpenAI-совместимые провайдеры завершают поток [DONE]
+	if data == "[DONE]" {
+		t.completed = true
+		return true
+	}
+	// Ошибка после 200: клиенту передаём как есть, но запрос считаем неуспешным
+	if strings.Contains(data, `"error"`) {
+		t.parseError(data)
+	}
+	// С include_usage каждый чанк (в том числе дельты tool_calls) несёт "usage":null -
+	// такие не разбираем; usage приходит отдельным чанком после них
+	if !strings.Contains(data, `"usage"`) && !strings.Contains(data, `"message_stop"`) {
+		return true
+	}
+	if usageNullRe.MatchString(data) && !strings.Contains(data, `"message_stop"`) {
+		return true
+	}
+	t.parseChunk(data)
+
+	return !(t.stripUsage && isUsageOnlyChunk(data))
+}
+
+// parseChunk достаёт из data-чанка usage и признак завершения
+func (t *streamTap) parseChunk(data string) {
+	var chunk struct {
+		Type    string    `json:"type"`
+		Model   string    `json:"model"`
+		Usage   *rawUsage `json:"usage"`
+		Message struct {
+			Model string    `json:"model"`
+			Usage *rawUsage `json:"usage"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return
+	}
+
+	switch {
+	case chunk.Type == "message_stop":
+		t.completed = true
+	case chunk.Type == "message_start" && chunk.Message.Usage != nil:
+		// Anthropic: входные токены приходят в message_start, выходные - в message_delta
+		t.model = chunk.Message.Model
+		t.usage.InputTokens = chunk.Message.Usage.InputTokens
+		t.usage.CacheReadInputTokens = chunk.Message.Usage.CacheReadInputTokens
+		t.hasUsage = true
+	case chunk.Type == "message_delta" && chunk.Usage != nil:
+		t.usage.OutputTokens = chunk.Usage.OutputTokens
+		t.hasUsage = true
+	case chunk.Usage != nil:
+		t.model = chunk.Model
+		t.usage = *chunk.Usage
+		t.hasUsage = true
+	}
+}
+
+// parseError отмечает поток неуспешным, если чанк - объект ошибки:
+// {"error":{...}} у OpenAI, {"type":"error","error":{...}} у Anthropic, {"error":"..."} у Ollama
+func (t *streamTap) parseError(data string) {
+	var chunk struct {
+		Type  string          `json:"type"`
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return
+	}
+	if chunk.Type != "error" && (len(chunk.Error) == 0 || string(chunk.Error) == "null") {
+		return
+	}
+	t.failed = true
+	var detail struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(chunk.Error, &detail) == nil && detail.Message != "" {
+		t.errMessage = detail.Message
+	} else {
+		t.errMessage = string(chunk.Error)
+	}
+}
+
+// finalUsage - нормализованный usage потока, если провайдер его прислал
+func (t *streamTap) finalUsage() (tokenUsage, bool) {
+	if !t.hasUsage {
+		return tokenUsage{}, false
+	}
+	return normalizeUsage(t.provider, t.model, t.usage), true
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// observeAll прогоняет поток через tap построчно
+func observeAll(tap *streamTap, stream string) {
+	for _, line := range strings.SplitAfter(stream, "\n") {
+		if line != "" {
+			tap.observe(line)
+		}
+	}
+}
+
+func TestStreamTerminators(t *testing.T) {
+	cases := []struct {
+		name, provider, stream string
+		ndjson, completed      bool
+	}{
+		{"openai done", "openai", "data: {\"choices\":[]}\n\ndata: [DONE]\n\n", false, true},
+		{"openai done without space", "deepseek", "data:{\"choices\":[]}\n\ndata:[DONE]\n\n", false, true},
+		{"openai cut off", "openai", "data: {\"choices\":[]}\n\n", false, false},
+		{"anthropic message_stop event", "anthropic",
+			"event: message_start\ndata: {\"type\":\"message_start\"}\n\nevent: message_stop\ndata: {\"type\":\"message_stop\"}\n\n", false, true},
+		{"anthropic data only", "anthropic", "data: {\"type\":\"message_stop\"}\n\n", false, true},
+		{"anthropic cut off", "anthropic", "event: content_block_delta\ndata: {\"type\":\"content_block_delta\"}\n\n", false, false},
+		{"ndjson done", "openai", "{\"response\":\"a\",\"done\":false}\n{\"response\":\"\",\"done\":true}\n", true, true},
+		{"ndjson cut off", "openai", "{\"response\":\"a\",\"done\":false}\n", true, false},
+		{"ndjson done with spacing", "openai", "{\"response\": \"\", \"done\": true}\n", true, true},
+		{"ndjson done in generated text", "openai", "{\"response\":\"{\\\"done\\\":true}\",\"done\":false}\n", true, false},
+	}
+	for _, c := range cases {
+		tap := newStreamTap(c.provider)
+		tap.ndjson = c.ndjson
+		observeAll(tap, c.stream)
+		if tap.completed != c.completed {
+			t.Errorf("%s: completed = %v, want %v", c.name, tap.completed, c.completed)
+		}
+	}
+}
+
+func TestStreamCompletionForwardsDone(t *testing.T) {
+	stream := "data: {\"model\":\"gpt-4o\",\"choices\":[]}\n\n" +
+		"data: {\"model\":\"gpt-4o\",\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":4}}\n\n" +
+		"data: [DONE]\n\n"
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(stream))
+	})
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	_, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","stream":true}`, "Accept", "text/event-stream")
+	if body != stream {
+		t.Fatalf("stream changed:\n%q\nwant\n%q", body, stream)
+	}
+	// Завершение фиксируется после отправки: usage и счётчики
+	waitFor(t, "usage of the completed stream", func() bool {
+		return p.providerStat(t, "openai", "usage", "completion_tokens") == float64(4)
+	})
+	if !strings.Contains(logs.String(), "Stream completed: ") {
+		t.Fatalf("completion is not logged:\n%s", logs)
+	}
+	if got := p.providerStat(t, "openai", "streams_incomplete"); got != float64(0) {
+		t.Fatalf("streams_incomplete = %v", got)
+	}
+}
+
+func TestStreamWithoutTerminatorIsIncomplete(t *testing.T) {
+	upstream(t, "anthropic", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: content_block_delta\ndata: {\"type\":\"content_block_delta\"}\n\n"))
+	})
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/anthropic/v1/messages", `{"model":"claude","stream":true}`, "Accept", "text/event-stream")
+	waitFor(t, "incomplete stream counter", func() bool {
+		return p.providerStat(t, "anthropic", "streams_incomplete") == float64(1)
+	})
+}
+
+func TestStreamDetectedFromBody(t *testing.T) {
+	stream := "data: {\"model\":\"gpt-4o\",\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":4}}\n\n" +
+		"data: [DONE]\n\n"
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(stream))
+	})
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	// SDK без Accept: text/event-stream - поток определяется по "stream": true в теле
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","stream":true}`)
+	if body != stream || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("stream changed (%s):\n%q", resp.Header.Get("Content-Type"), body)
+	}
+	waitFor(t, "usage of the completed stream", func() bool {
+		return p.providerStat(t, "openai", "usage", "completion_tokens") == float64(4)
+	})
+	if !strings.Contains(logs.String(), "Stream completed: ") {
+		t.Fatalf("stream is not handled as a stream:\n%s", logs)
+	}
+}
+
+func TestToolCallStreamUsage(t *testing.T) {
+	stream, err := os.ReadFile("testdata/toolcall_stream.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write(stream)
+	})
+	p := startProxy(t)
+
+	_, got := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","stream":true,"tools":[]}`)
+	golden(t, "toolcall_stream", []byte(got))
+	waitFor(t, "usage after tool-call deltas", func() bool {
+		return p.providerStat(t, "openai", "usage", "prompt_tokens") == float64(82)
+	})
+	if got := p.providerStat(t, "openai", "usage", "completion_tokens"); got != float64(17) {
+		t.Fatalf("completion_tokens = %v", got)
+	}
+}
+
+func TestUsageNullSpacing(t *testing.T) {
+	// Чанк с "usage": null (с пробелом) не затирает уже полученный usage и не считается usage-чанком
+	tap := newStreamTap("openai")
+	tap.stripUsage = true
+	observeAll(tap, "data: {\"model\":\"m\",\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":1}}\n\n")
+	for _, chunk := range []string{
+		`{"choices":[{"delta":{}}],"usage": null}`,
+		`{"choices":[],"usage" :null}`,
+		`{"choices":[],"usage":	null}`,
+	} {
+		if !tap.observe("data: " + chunk) {
+			t.Errorf("chunk %s hidden from the client", chunk)
+		}
+	}
+	if u, ok := tap.finalUsage(); !ok || u.PromptTokens != 5 {
+		t.Fatalf("usage = %+v, %v", u, ok)
+	}
+}
+
+func TestNDJSONStreamIncremental(t *testing.T) {
+	lines := []string{
+		"{\"model\":\"llama3\",\"response\":\"Hel\",\"done\":false}\n",
+		"{\"model\":\"llama3\",\"response\":\"lo\",\"done\":false}\n",
+		"{\"model\":\"llama3\",\"response\":\"\",\"done\":true,\"done_reason\":\"stop\"}\n",
+	}
+	next := make(chan struct{})
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for i, line := range lines {
+			if i > 0 {
+				<-next
+			}
+			w.Write([]byte(line))
+			w.(http.Flusher).Flush()
+		}
+	})
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	// Без Accept: text/event-stream и stream:true - поток распознаётся по Content-Type
+	resp, err := http.DefaultClient.Do(p.newRequest(t, http.MethodPost, "/openai/api/generate", `{"model":"llama3"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+	// Каждая строка доходит до клиента раньше, чем провайдер пришлёт следующую
+	r := bufio.NewReader(resp.Body)
+	for i, want := range lines {
+		got, err := r.ReadString('\n')
+		if err != nil || got != want {
+			t.Fatalf("line %d = %q, %v; want %q", i, got, err, want)
+		}
+		if i < len(lines)-1 {
+			next <- struct{}{}
+		}
+	}
+	waitFor(t, "completion of the NDJSON stream", func() bool {
+		return strings.Contains(logs.String(), "Stream completed: ")
+	})
+}
+
+func TestMidStreamErrorCountedAsFailure(t *testing.T) {
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"the error was\"}}]}\n\n" +
+		"data: {\"error\":{\"message\":\"The server had an error while processing your request.\",\"type\":\"server_error\"}}\n\n"
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(stream))
+	})
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	// Клиент получает поток как есть, со статусом 200
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","stream":true}`)
+	if resp.StatusCode != http.StatusOK || body != stream {
+		t.Fatalf("status %d, body %q", resp.StatusCode, body)
+	}
+	waitFor(t, "the failed stream in stats", func() bool {
+		return p.providerStat(t, "openai", "errors") == float64(1)
+	})
+	if !strings.Contains(logs.String(), "WARN: openai stream returned an error event: The server had an error while processing your request.") {
+		t.Fatalf("error event is not logged:\n%s", logs)
+	}
+}
+
+func TestStreamErrorDetection(t *testing.T) {
+	cases := []struct {
+		name, stream   string
+		ndjson, failed bool
+	}{
+		{"openai error", "data: {\"error\":{\"message\":\"boom\"}}\n\n", false, true},
+		{"anthropic error event", "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n", false, true},
+		{"ndjson error", "{\"error\":\"model not found\"}\n", true, true},
+		{"error in content", "data: {\"choices\":[{\"delta\":{\"content\":\"\\\"error\\\": 1\"}}]}\n\n", false, false},
+		{"null error", "data: {\"choices\":[],\"error\":null}\n\n", false, false},
+	}
+	for _, c := range cases {
+		tap := newStreamTap("openai")
+		tap.ndjson = c.ndjson
+		observeAll(tap, c.stream)
+		if tap.failed != c.failed {
+			t.Errorf("%s: failed = %v, want %v", c.name, tap.failed, c.failed)
+		}
+	}
+}
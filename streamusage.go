@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+var (
+	// injectStreamUsage - добавлять stream_options.include_usage в streaming-запросы
+	injectStreamUsage bool
+	// stripInjectedUsage - не отдавать клиенту usage-чанк, если его запросил прокси, а не клиент
+	stripInjectedUsage bool
+)
+
+// supportsStreamUsage - провайдеры с OpenAI-совместимым stream_options
+func supportsStreamUsage(provider string) bool {
+	switch provider {
+	case "openai", "deepseek", "nebius":
+		return true
+	}
+	return false
+}
+
+// injectIncludeUsage выставляет stream_options.include_usage=true, сохраняя прочие опции.
+// Возвращает true, если клиент сам usage не запрашивал.
+func injectIncludeUsage(jb *jsonBody) bool {
+	opts := map[string]json.RawMessage{}
+	if raw, ok := jb.fields["stream_options"]; ok {
+		if err := json.Unmarshal(raw, &opts); err != nil {
+			return false
+		}
+	}
+
+	var includeUsage bool
+	if raw, ok := opts["include_usage"]; ok {
+		_ = json.Unmarshal(raw, &includeUsage)
+	}
+	if includeUsage {
+		return false
+	}
+
+	opts["include_usage"] = json.RawMessage("true")
+	jb.set("stream_options", opts)
+	return true
+}
+
+// isUsageOnlyChunk - финальный чанк OpenAI с usage и пустым choices
+func isUsageOnlyChunk(data string) bool {
+	if !strings.Contains(data, `"usage"`) {
+		return false
+	}
+	var chunk struct {
+		Choices []json.RawMessage `json:"choices"`
+		Usage   json.RawMessage   `json:"usage"`
+	}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return false
+	}
+	return len(chunk.Choices) == 0 && len(chunk.Usage) > 0 && string(chunk.Usage) != "null"
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// includeUsageUpstream стримит ответ OpenAI; с include_usage в конце идёт usage-чанк
+func includeUsageUpstream(t *testing.T, provider string, gotBody *string) {
+	upstream(t, provider, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		*gotBody = string(b)
+		var req struct {
+			StreamOptions struct {
+				IncludeUsage bool `json:"include_usage"`
+			} `json:"stream_options"`
+		}
+		json.Unmarshal(b, &req)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"model\":\"gpt-4o\",\"choices\":[{\"delta\":{\"content\":\"hi\"}}],\"usage\":null}\n\n"))
+		if req.StreamOptions.IncludeUsage {
+			w.Write([]byte("data: {\"model\":\"gpt-4o\",\"choices\":[],\"usage\":{\"prompt_tokens\":7,\"completion_tokens\":1,\"total_tokens\":8}}\n\n"))
+		}
+		w.Write([]byte("data: [DONE]\n\n"))
+	})
+}
+
+const streamedChat = `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+
+func TestInjectStreamUsage(t *testing.T) {
+	t.Setenv("PROXY_INJECT_STREAM_USAGE", "true")
+	var gotBody string
+	includeUsageUpstream(t, "openai", &gotBody)
+	p := startProxy(t)
+
+	_, stream := p.do(t, http.MethodPost, "/openai/v1/chat/completions", streamedChat, "Accept", "text/event-stream")
+	if !strings.Contains(gotBody, `"stream_options":{"include_usage":true}`) {
+		t.Fatalf("upstream body = %s", gotBody)
+	}
+	// Клиент usage не просил - usage-чанк ему не отдаётся, но учитывается
+	if data := dataLines(stream); len(data) != 2 || data[1] != "[DONE]" {
+		t.Fatalf("client stream = %q", stream)
+	}
+	waitFor(t, "usage of the injected chunk", func() bool {
+		return p.providerStat(t, "openai", "usage", "prompt_tokens") == float64(7)
+	})
+}
+
+func TestInjectStreamUsageWithoutAccept(t *testing.T) {
+	t.Setenv("PROXY_INJECT_STREAM_USAGE", "true")
+	var gotBody string
+	includeUsageUpstream(t, "openai", &gotBody)
+	p := startProxy(t)
+
+	// Поток только по "stream": true в теле: usage так же внедряется и скрывается от клиента
+	_, stream := p.do(t, http.MethodPost, "/openai/v1/chat/completions", streamedChat)
+	if !strings.Contains(gotBody, `"stream_options":{"include_usage":true}`) {
+		t.Fatalf("upstream body = %s", gotBody)
+	}
+	if data := dataLines(stream); len(data) != 2 || data[1] != "[DONE]" {
+		t.Fatalf("client stream = %q", stream)
+	}
+}
+
+func TestInjectStreamUsageWithoutStripping(t *testing.T) {
+	t.Setenv("PROXY_INJECT_STREAM_USAGE", "true")
+	t.Setenv("PROXY_STRIP_INJECTED_USAGE", "false")
+	var gotBody string
+	includeUsageUpstream(t, "openai", &gotBody)
+	p := startProxy(t)
+
+	_, stream := p.do(t, http.MethodPost, "/openai/v1/chat/completions", streamedChat, "Accept", "text/event-stream")
+	if !strings.Contains(stream, `"prompt_tokens":7`) {
+		t.Fatalf("usage chunk hidden with PROXY_STRIP_INJECTED_USAGE=false:\n%s", stream)
+	}
+}
+
+func TestClientRequestedUsageIsKept(t *testing.T) {
+	t.Setenv("PROXY_INJECT_STREAM_USAGE", "true")
+	var gotBody string
+	includeUsageUpstream(t, "openai", &gotBody)
+	p := startProxy(t)
+
+	body := `{"model":"gpt-4o","stream":true,"stream_options":{"include_usage":true}}`
+	_, stream := p.do(t, http.MethodPost, "/openai/v1/chat/completions", body)
+	if gotBody != body {
+		t.Fatalf("upstream body = %s, want the client body unchanged", gotBody)
+	}
+	if !strings.Contains(stream, `"prompt_tokens":7`) {
+		t.Fatalf("usage chunk the client asked for was stripped:\n%s", stream)
+	}
+}
+
+func TestInjectStreamUsageSkipsUnsupportedProviders(t *testing.T) {
+	t.Setenv("PROXY_INJECT_STREAM_USAGE", "true")
+	var gotBody string
+	includeUsageUpstream(t, "anthropic", &gotBody)
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/anthropic/v1/messages", streamedChat, "Accept", "text/event-stream")
+	if strings.Contains(gotBody, "stream_options") {
+		t.Fatalf("stream_options injected for anthropic: %s", gotBody)
+	}
+}
+
+func TestInjectStreamUsageOffByDefault(t *testing.T) {
+	var gotBody string
+	includeUsageUpstream(t, "openai", &gotBody)
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", streamedChat, "Accept", "text/event-stream")
+	if gotBody != streamedChat {
+		t.Fatalf("upstream body = %s", gotBody)
+	}
+}
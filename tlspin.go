@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var errPinMismatch = errors.New("upstream certificate does not match any configured pin")
+
+// parsePins разбирает список SPKI-пинов (base64 от SHA-256, допускается префикс sha256/)
+func parsePins(spec string) []string {
+	var pins []string
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimPrefix(strings.TrimSpace(p), "sha256/")
+		if p != "" {
+			pins = append(pins, p)
+		}
+	}
+	return pins
+}
+
+// spkiHash - base64(SHA-256(SubjectPublicKeyInfo)), как в HPKP
+func spkiHash(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// pinVerifier проверяет, что в цепочке есть сертификат с одним из пинов.
+// Вызывается после стандартной проверки CA; несколько пинов позволяют ротацию.
+func pinVerifier(pins []string) func(tls.ConnectionState) error {
+	allowed := map[string]bool{}
+	for _, p := range pins {
+		allowed[p] = true
+	}
+	return func(cs tls.ConnectionState) error {
+		for _, cert := range cs.PeerCertificates {
+			if allowed[spkiHash(cert.RawSubjectPublicKeyInfo)] {
+				return nil
+			}
+		}
+		return errPinMismatch
+	}
+}
+
+// pinnedClient возвращает клиент с проверкой пинов из <PROVIDER>_TLS_PINS или base, если пины не заданы
+func pinnedClient(base *http.Client, prefix string) *http.Client {
+	pins := parsePins(os.Getenv(prefix + "TLS_PINS"))
+	if len(pins) == 0 {
+		return base
+	}
+
+	transport := base.Transport.(*http.Transport).Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.VerifyConnection = pinVerifier(pins)
+
+	client := *base
+	client.Transport = transport
+	return &client
+}
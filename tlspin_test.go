@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// tlsUpstream - локальный TLS-сервер и клиент, доверяющий его сертификату
+func tlsUpstream(t *testing.T) (*httptest.Server, *http.Client) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	base := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	return srv, base
+}
+
+func TestTLSPinMatches(t *testing.T) {
+	srv, base := tlsUpstream(t)
+	pin := spkiHash(srv.Certificate().RawSubjectPublicKeyInfo)
+	// Старый пин и новый в процессе ротации
+	t.Setenv("OPENAI_TLS_PINS", "sha256/Xb6ZH8ZXqR55kqWs8XKk+6lNABw8yCZHZwiYXh+N2hU=, sha256/"+pin)
+
+	resp, err := pinnedClient(base, "OPENAI_").Get(srv.URL)
+	if err != nil {
+		t.Fatalf("matching pin rejected: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestTLSPinMismatch(t *testing.T) {
+	srv, base := tlsUpstream(t)
+	t.Setenv("OPENAI_TLS_PINS", "sha256/Xb6ZH8ZXqR55kqWs8XKk+6lNABw8yCZHZwiYXh+N2hU=")
+
+	_, err := pinnedClient(base, "OPENAI_").Get(srv.URL)
+	if !errors.Is(err, errPinMismatch) {
+		t.Fatalf("err = %v, want %v", err, errPinMismatch)
+	}
+	// Без пинов - обычная проверка CA
+	if pinnedClient(base, "NEBIUS_") != base {
+		t.Fatal("client without pins is not the base client")
+	}
+}
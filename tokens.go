@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiToken - клиентский токен с ограничениями (PROXY_TOKENS_FILE).
+// Общий PROXY_AUTH_TOKEN соответствует токену без ограничений.
+type apiToken struct {
+	Name         string   `json:"name"`
+	Token        string   `json:"token"`
+	Providers    []string `json:"providers,omitempty"`      // пусто - все провайдеры
+	Models       []string `json:"models,omitempty"`         // пусто - все модели; "gpt-4o*" - префикс
+	RateLimitRPM int      `json:"rate_limit_rpm,omitempty"` // 0 - без лимита
+	BudgetUSD    float64  `json:"budget_usd,omitempty"`     // 0 - без лимита
+	Tag          string   `json:"tag,omitempty"`            // тег, если клиент не передал X-Proxy-Tag
+	DailyQuota   int      `json:"daily_quota,omitempty"`    // запросов в сутки, 0 - без лимита
+	MaxStreams   int      `json:"max_streams,omitempty"`    // одновременных streaming-запросов, 0 - без лимита
+	Admin        bool     `json:"admin,omitempty"`          // доступ к /stats и /admin/*
+	// ProviderKeys - собственные ключи провайдеров (BYOK), зашифрованные; см. byok.go
+	ProviderKeys map[string]string `json:"provider_keys,omitempty"`
+
+	ownKeys map[string]*keyPool
+
+	spentNanoUSD  atomic.Int64
+	activeStreams atomic.Int64
+
+	mu          sync.Mutex
+	windowStart time.Time
+	windowCount int
+
+	// Суточная квота; сутки начинаются в PROXY_QUOTA_RESET_HOUR_UTC
+	quotaDay   time.Time
+	quotaCount int
+}
+
+var (
+	// tokens - токены из PROXY_TOKENS_FILE
+	tokens []*apiToken
+	// masterToken - PROXY_AUTH_TOKEN
+	masterToken *apiToken
+)
+
+// loadTokens читает JSON-массив токенов из файла
+func loadTokens(path string) error {
+	tokens = nil
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("PROXY_TOKENS_FILE: %w", err)
+	}
+	var list []*apiToken
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("PROXY_TOKENS_FILE: %w", err)
+	}
+	names := map[string]bool{}
+	for i, t := range list {
+		if t.Token == "" || t.Name == "" {
+			return fmt.Errorf("PROXY_TOKENS_FILE: token #%d: name and token are required", i)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("PROXY_TOKENS_FILE: duplicate token name %q", t.Name)
+		}
+		names[t.Name] = true
+	}
+	if err := loadProviderKeys(list); err != nil {
+		return err
+	}
+	tokens = list
+	return nil
+}
+
+// lookupToken находит токен по значению X-Proxy-Auth; nil - неизвестный токен
+func lookupToken(value string) *apiToken {
+	if value == "" {
+		return nil
+	}
+	if masterToken != nil && subtle.ConstantTimeCompare([]byte(value), []byte(masterToken.Token)) == 1 {
+		return masterToken
+	}
+	if selfTestTok != nil && subtle.ConstantTimeCompare([]byte(value), []byte(selfTestTok.Token)) == 1 {
+		return selfTestTok
+	}
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(value), []byte(t.Token)) == 1 {
+			return t
+		}
+	}
+	return nil
+}
+
+// isAdmin - токен управляет прокси (/stats, /admin/*): общий PROXY_AUTH_TOKEN или "admin": true
+func (t *apiToken) isAdmin() bool {
+	return t != nil && (t == masterToken || t.Admin)
+}
+
+// callerToken - токен текущего запроса, выставляется auth middleware
+func callerToken(c *fiber.Ctx) *apiToken {
+	t, _ := c.Locals("token").(*apiToken)
+	return t
+}
+
+func (t *apiToken) allowsProvider(provider string) bool {
+	return t == nil || len(t.Providers) == 0 || slices.Contains(t.Providers, provider)
+}
+
+// allowsModel - модель входит в allowlist токена; неизвестная (пустая) модель при allowlist не проходит
+func (t *apiToken) allowsModel(model string) bool {
+	if t == nil || len(t.Models) == 0 {
+		return true
+	}
+	for _, m := range t.Models {
+		if prefix, ok := strings.CutSuffix(m, "*"); ok && strings.HasPrefix(model, prefix) || m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// modelNotAllowed - ответ 403 на модель вне allowlist токена
+func modelNotAllowed(c *fiber.Ctx, model string) error {
+	msg := "token is not allowed to use model " + model
+	if model == "" {
+		msg = "token is limited to specific models, request model is unknown"
+	}
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": msg})
+}
+
+// acquireStream занимает слот streaming-запроса; false - лимит max_streams исчерпан
+func (t *apiToken) acquireStream() bool {
+	if t == nil {
+		return true
+	}
+	if n := t.activeStreams.Add(1); t.MaxStreams > 0 && n > int64(t.MaxStreams) {
+		t.activeStreams.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (t *apiToken) releaseStream() {
+	if t != nil {
+		t.activeStreams.Add(-1)
+	}
+}
+
+// tokenStreamsSnapshot - активные потоки по токенам для /stats
+func tokenStreamsSnapshot() fiber.Map {
+	result := fiber.Map{}
+	for _, t := range append([]*apiToken{masterToken}, tokens...) {
+		if t != nil {
+			result[t.Name] = fiber.Map{"active_streams": t.activeStreams.Load(), "max_streams": t.MaxStreams}
+		}
+	}
+	return result
+}
+
+// allowRequest учитывает запрос в минутном окне; false - лимит исчерпан
+func (t *apiToken) allowRequest(now time.Time) bool {
+	if t == nil || t.RateLimitRPM <= 0 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.windowStart) >= time.Minute {
+		t.windowStart, t.windowCount = now, 0
+	}
+	if t.windowCount >= t.RateLimitRPM {
+		return false
+	}
+	t.windowCount++
+	return true
+}
+
+// remainingRequests - остаток запросов в текущем окне, -1 - без лимита
+func (t *apiToken) remainingRequests(now time.Time) int {
+	if t.RateLimitRPM <= 0 {
+		return -1
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.windowStart) >= time.Minute {
+		return t.RateLimitRPM
+	}
+	return t.RateLimitRPM - t.windowCount
+}
+
+func (t *apiToken) budgetExhausted() bool {
+	return t != nil && t.BudgetUSD > 0 && float64(t.spentNanoUSD.Load())/1e9 >= t.BudgetUSD
+}
+
+// charge списывает стоимость запроса с бюджета токена
+func (t *apiToken) charge(u tokenUsage) {
+	if t != nil {
+		t.spentNanoUSD.Add(costNanoUSD(u))
+	}
+}
+
+// allowQuota учитывает запрос в суточной квоте; false - квота исчерпана
+func (t *apiToken) allowQuota(now time.Time) bool {
+	if t == nil || t.DailyQuota <= 0 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollQuota(now)
+	if t.quotaCount >= t.DailyQuota {
+		return false
+	}
+	t.quotaCount++
+	markQuotaDirty()
+	return true
+}
+
+// rollQuota обнуляет счётчик с началом новых суток; вызывается под mu
+func (t *apiToken) rollQuota(now time.Time) {
+	if day := quotaDayStart(now); !day.Equal(t.quotaDay) {
+		t.quotaDay, t.quotaCount = day, 0
+	}
+}
+
+// quotaSnapshot - лимит, остаток и время сброса суточной квоты
+func (t *apiToken) quotaSnapshot(now time.Time) fiber.Map {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollQuota(now)
+	result := fiber.Map{"limit": t.DailyQuota, "reset_at": t.quotaDay.Add(24 * time.Hour).Format(time.RFC3339)}
+	if t.DailyQuota > 0 {
+		result["remaining"] = max(t.DailyQuota-t.quotaCount, 0)
+	}
+	return result
+}
+
+// whoamiHandler описывает возможности предъявленного токена (без значения самого токена)
+func whoamiHandler(c *fiber.Ctx) error {
+	t := callerToken(c)
+	if t == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	allowed := t.Providers
+	if len(allowed) == 0 {
+		allowed = make([]string, 0, len(providers))
+		for _, p := range providers {
+			allowed = append(allowed, p.Name)
+		}
+	}
+
+	spent := float64(t.spentNanoUSD.Load()) / 1e9
+	budget := fiber.Map{"limit_usd": t.BudgetUSD, "spent_usd": spent}
+	if t.BudgetUSD > 0 {
+		budget["remaining_usd"] = max(t.BudgetUSD-spent, 0)
+	}
+
+	return c.JSON(fiber.Map{
+		"name":      t.Name,
+		"providers": allowed,
+		"models":    t.Models,
+		"rate_limit": fiber.Map{
+			"requests_per_minute": t.RateLimitRPM,
+			"remaining":           t.remainingRequests(time.Now()),
+		},
+		"budget":      budget,
+		"daily_quota": t.quotaSnapshot(time.Now()),
+		"default_tag": t.Tag,
+		"admin":       t.isAdmin(),
+		"streams":     fiber.Map{"active": t.activeStreams.Load(), "limit": t.MaxStreams},
+	})
+}
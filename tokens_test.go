@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTokens включает PROXY_TOKENS_FILE c переданным JSON; /stats остаётся под testAuthToken
+func useTokens(t *testing.T, list string) {
+	t.Setenv("PROXY_TOKENS_FILE", writeFile(t, "tokens.json", list))
+	t.Setenv("PROXY_AUTH_TOKEN", testAuthToken)
+}
+
+func (p *testProxy) whoami(t *testing.T, token string) map[string]any {
+	t.Helper()
+	resp, body := p.do(t, http.MethodGet, "/whoami", "", "X-Proxy-Auth", token)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/whoami: status %d: %s", resp.StatusCode, body)
+	}
+	var out map[string]any
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestWhoami(t *testing.T) {
+	useTokens(t, `[
+		{"name":"team-a","token":"tok-a","providers":["openai"],"models":["gpt-4o*"],"rate_limit_rpm":10,"budget_usd":1,"tag":"billing-a"},
+		{"name":"team-b","token":"tok-b-secret","providers":["deepseek"]}
+	]`)
+	t.Setenv("PROXY_MODEL_PRICES", "gpt-4o=2.5:10")
+	usageUpstream(t, new(string))
+	p := startProxy(t)
+
+	// Один запрос: $0.0035 из бюджета и минус один из лимита в минуту
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`, "X-Proxy-Auth", "tok-a")
+
+	me := p.whoami(t, "tok-a")
+	if me["name"] != "team-a" || me["default_tag"] != "billing-a" {
+		t.Fatalf("whoami = %v", me)
+	}
+	if providers := me["providers"].([]any); len(providers) != 1 || providers[0] != "openai" {
+		t.Fatalf("providers = %v", providers)
+	}
+	if models := me["models"].([]any); len(models) != 1 || models[0] != "gpt-4o*" {
+		t.Fatalf("models = %v", models)
+	}
+	rl := me["rate_limit"].(map[string]any)
+	if rl["requests_per_minute"] != float64(10) || rl["remaining"] != float64(9) {
+		t.Fatalf("rate_limit = %v", rl)
+	}
+	budget := me["budget"].(map[string]any)
+	if budget["limit_usd"] != float64(1) || budget["spent_usd"] != 0.0035 || budget["remaining_usd"] != 0.9965 {
+		t.Fatalf("budget = %v", budget)
+	}
+
+	// Другие токены в ответе не раскрываются, в том числе значение своего токена
+	_, raw := p.do(t, http.MethodGet, "/whoami", "", "X-Proxy-Auth", "tok-a")
+	if strings.Contains(raw, "team-b") || strings.Contains(raw, "tok-b-secret") || strings.Contains(raw, "tok-a") {
+		t.Fatalf("whoami leaks tokens: %s", raw)
+	}
+}
+
+func TestWhoamiUnrestrictedToken(t *testing.T) {
+	p := startProxy(t)
+
+	me := p.whoami(t, testAuthToken)
+	if providers := me["providers"].([]any); len(providers) != 4 {
+		t.Fatalf("providers of the master token = %v, want all", providers)
+	}
+	if me["budget"].(map[string]any)["remaining_usd"] != nil {
+		t.Fatalf("budget of an unlimited token = %v", me["budget"])
+	}
+	if resp, _ := p.do(t, http.MethodGet, "/whoami", "", "X-Proxy-Auth", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("/whoami with a wrong token: status %d", resp.StatusCode)
+	}
+}
+
+// holdingStreamUpstream держит поток открытым, пока клиент не отключится. Комментарии
+// каждые 20ms нужны прокси, чтобы заметить отключение на записи.
+func holdingStreamUpstream(t *testing.T) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for {
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	})
+}
+
+func TestTokenStreamLimit(t *testing.T) {
+	useTokens(t, `[{"name":"team-a","token":"tok-a","max_streams":2},{"name":"team-b","token":"tok-b"}]`)
+	holdingStreamUpstream(t)
+	p := startProxy(t)
+
+	openStream := func(token string) *http.Response {
+		t.Helper()
+		resp, err := http.DefaultClient.Do(p.newRequest(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`,
+			"Accept", "text/event-stream", "X-Proxy-Auth", token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	first, second := openStream("tok-a"), openStream("tok-a")
+	if first.StatusCode != http.StatusOK || second.StatusCode != http.StatusOK {
+		t.Fatalf("streams within the limit: status %d, %d", first.StatusCode, second.StatusCode)
+	}
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"stream":true}`,
+		"Accept", "text/event-stream", "X-Proxy-Auth", "tok-a")
+	if resp.StatusCode != http.StatusTooManyRequests || !strings.Contains(body, `"limit":2`) {
+		t.Fatalf("third stream: status %d: %s", resp.StatusCode, body)
+	}
+	// Лимит только на потоки и только у своего токена
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{}`, "X-Proxy-Auth", "tok-a"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("non-streaming request: status %d", resp.StatusCode)
+	}
+	if resp := openStream("tok-b"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream of another token: status %d", resp.StatusCode)
+	}
+
+	if s := p.whoami(t, "tok-a")["streams"].(map[string]any); s["active"] != float64(2) || s["limit"] != float64(2) {
+		t.Fatalf("whoami streams = %v", s)
+	}
+	if s := p.stats(t)["tokens"].(map[string]any)["team-a"].(map[string]any); s["active_streams"] != float64(2) {
+		t.Fatalf("stats tokens.team-a = %v", s)
+	}
+
+	// Отключение клиента освобождает слот
+	first.Body.Close()
+	waitFor(t, "the slot of the closed stream", func() bool {
+		return p.whoami(t, "tok-a")["streams"].(map[string]any)["active"] == float64(1)
+	})
+	if resp := openStream("tok-a"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream after a slot was freed: status %d", resp.StatusCode)
+	}
+}
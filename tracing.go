@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// traceContext - контекст трассировки запроса, общий для W3C и B3
+type traceContext struct {
+	TraceID      string // 32 hex
+	SpanID       string // 16 hex, span запроса к провайдеру
+	ParentSpanID string // 16 hex, span клиента (пусто для нового трейса)
+	Sampled      bool
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.Trim(s, "0") != ""
+}
+
+// parseTraceparent разбирает W3C traceparent: 00-<trace-id>-<parent-id>-<flags>
+func parseTraceparent(v string) (traceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || !isHex(parts[1], 32) || !isHex(parts[2], 16) || len(parts[3]) != 2 {
+		return traceContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return traceContext{}, false
+	}
+	return traceContext{
+		TraceID:      strings.ToLower(parts[1]),
+		ParentSpanID: strings.ToLower(parts[2]),
+		Sampled:      flags[0]&0x01 == 1,
+	}, true
+}
+
+// parseB3 разбирает B3 в multi-header (x-b3-*) или single-header (b3) форме
+func parseB3(c *fiber.Ctx) (traceContext, bool) {
+	traceID, spanID, sampled := c.Get("X-B3-TraceId"), c.Get("X-B3-SpanId"), c.Get("X-B3-Sampled")
+	if single := c.Get("b3"); traceID == "" && single != "" {
+		parts := strings.Split(single, "-")
+		if len(parts) < 2 {
+			return traceContext{}, false
+		}
+		traceID, spanID = parts[0], parts[1]
+		if len(parts) > 2 {
+			sampled = parts[2]
+		}
+	}
+
+	traceID = strings.ToLower(traceID)
+	if isHex(traceID, 16) {
+		// 64-битный B3 trace id дополняем слева нулями до 128 бит
+		traceID = strings.Repeat("0", 16) + traceID
+	}
+	if !isHex(traceID, 32) || !isHex(strings.ToLower(spanID), 16) {
+		return traceContext{}, false
+	}
+	// "d" и X-B3-Flags: 1 - debug-трейс, он всегда sampled
+	return traceContext{
+		TraceID:      traceID,
+		ParentSpanID: strings.ToLower(spanID),
+		Sampled:      c.Get("X-B3-Flags") == "1" || sampled != "0" && sampled != "false",
+	}, true
+}
+
+// extractTraceContext берёт контекст из traceparent, затем из B3, иначе начинает новый трейс.
+// Для запроса к провайдеру всегда создаётся новый span.
+func extractTraceContext(c *fiber.Ctx) traceContext {
+	tc, ok := parseTraceparent(c.Get("traceparent"))
+	if !ok {
+		tc, ok = parseB3(c)
+	}
+	if !ok {
+		tc = traceContext{TraceID: randomHex(16), Sampled: true}
+	}
+	// Значения заголовков fiber переиспользуются после ответа, а контекст живёт до конца стрима
+	tc.TraceID = strings.Clone(tc.TraceID)
+	tc.ParentSpanID = strings.Clone(tc.ParentSpanID)
+	tc.SpanID = randomHex(8)
+	return tc
+}
+
+// inject проставляет контекст в запрос к провайдеру в обоих форматах
+func (tc traceContext) inject(h http.Header) {
+	flags, sampled := "00", "0"
+	if tc.Sampled {
+		flags, sampled = "01", "1"
+	}
+	h.Set("traceparent", "00-"+tc.TraceID+"-"+tc.SpanID+"-"+flags)
+
+	h.Del("b3")
+	h.Set("X-B3-TraceId", tc.TraceID)
+	h.Set("X-B3-SpanId", tc.SpanID)
+	h.Set("X-B3-Sampled", sampled)
+	if tc.ParentSpanID != "" {
+		h.Set("X-B3-ParentSpanId", tc.ParentSpanID)
+	} else {
+		h.Del("X-B3-ParentSpanId")
+	}
+}
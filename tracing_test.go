@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// tracedUpstream запоминает заголовки трассировки последнего запроса к провайдеру
+func tracedUpstream(t *testing.T) *http.Header {
+	got := &http.Header{}
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		*got = r.Header.Clone()
+		w.Write([]byte(`{}`))
+	})
+	return got
+}
+
+var traceparentRe = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-(0[01])$`)
+
+// checkPropagated проверяет, что оба формата несут один трейс с новым span
+func checkPropagated(t *testing.T, h http.Header, traceID, parentSpan, flags string) {
+	t.Helper()
+	m := traceparentRe.FindStringSubmatch(h.Get("traceparent"))
+	if m == nil {
+		t.Fatalf("traceparent = %q", h.Get("traceparent"))
+	}
+	if traceID != "" && m[1] != traceID {
+		t.Errorf("trace id = %s, want %s", m[1], traceID)
+	}
+	if m[3] != flags {
+		t.Errorf("flags = %s, want %s", m[3], flags)
+	}
+	if m[2] == parentSpan {
+		t.Errorf("span id %s is the client's span, want a new one", m[2])
+	}
+	if h.Get("X-B3-TraceId") != m[1] || h.Get("X-B3-SpanId") != m[2] {
+		t.Errorf("B3 ids %s/%s differ from traceparent %s", h.Get("X-B3-TraceId"), h.Get("X-B3-SpanId"), h.Get("traceparent"))
+	}
+	if h.Get("X-B3-ParentSpanId") != parentSpan {
+		t.Errorf("X-B3-ParentSpanId = %q, want %q", h.Get("X-B3-ParentSpanId"), parentSpan)
+	}
+}
+
+func TestTraceIncomingW3C(t *testing.T) {
+	got := tracedUpstream(t)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "",
+		"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	checkPropagated(t, *got, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", "01")
+	if !strings.Contains(logs.String(), "trace_id=4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Errorf("trace id is not logged:\n%s", logs)
+	}
+}
+
+func TestTraceIncomingB3(t *testing.T) {
+	got := tracedUpstream(t)
+	p := startProxy(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "",
+		"X-B3-TraceId", "80f198ee56343ba864fe8b2a57d3eff7",
+		"X-B3-SpanId", "e457b5a2e4d86bd1",
+		"X-B3-Sampled", "0")
+	checkPropagated(t, *got, "80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1", "00")
+
+	// Single-header b3 с 64-битным trace id
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "b3", "a3ce929d0e0e4736-00f067aa0ba902b7-1")
+	checkPropagated(t, *got, "0000000000000000a3ce929d0e0e4736", "00f067aa0ba902b7", "01")
+	if got.Get("b3") != "" {
+		t.Errorf("single-header b3 forwarded as is: %q", got.Get("b3"))
+	}
+
+	// Debug-флаг ("d" в b3, X-B3-Flags: 1) означает sampled
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "b3", "a3ce929d0e0e4736-00f067aa0ba902b7-d")
+	checkPropagated(t, *got, "0000000000000000a3ce929d0e0e4736", "00f067aa0ba902b7", "01")
+	p.do(t, http.MethodGet, "/openai/v1/models", "",
+		"X-B3-TraceId", "80f198ee56343ba864fe8b2a57d3eff7",
+		"X-B3-SpanId", "e457b5a2e4d86bd1",
+		"X-B3-Sampled", "0",
+		"X-B3-Flags", "1")
+	checkPropagated(t, *got, "80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1", "01")
+}
+
+func TestTraceNewContext(t *testing.T) {
+	got := tracedUpstream(t)
+	p := startProxy(t)
+
+	p.do(t, http.MethodGet, "/openai/v1/models", "")
+	checkPropagated(t, *got, "", "", "01")
+	first := got.Get("X-B3-TraceId")
+
+	// Битый traceparent игнорируется - начинается новый трейс
+	p.do(t, http.MethodGet, "/openai/v1/models", "", "traceparent", "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	checkPropagated(t, *got, "", "", "01")
+	if got.Get("X-B3-TraceId") == first {
+		t.Error("two requests without context share a trace id")
+	}
+}
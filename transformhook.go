@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Внешние преобразования запросов и ответов (webhook). Прокси отправляет POST с JSON
+//
+//	{"provider": "openai", "method": "POST", "path": "v1/chat/completions", "query": "...",
+//	 "headers": {"Content-Type": ["application/json"]}, "body": {...}}
+//
+// на PROXY_TRANSFORM_REQUEST_URL до проверок тела (схема, allowlist моделей) и на
+// PROXY_TRANSFORM_RESPONSE_URL (с "status") перед ответом клиенту. Ответ хука - тот же формат; пропущенные поля не меняются,
+// "headers" дописываются к запросу провайдеру / ответу клиенту. Тело - JSON как есть, если
+// это валидный JSON, иначе строка. Ответы хук получает только non-streaming, загрузки файлов
+// в хук не передаются. Ошибка хука: PROXY_TRANSFORM_FAIL_MODE=open - запрос идёт без изменений,
+// closed - клиент получает 502.
+var (
+	transformRequestURL  string
+	transformResponseURL string
+	transformFailClosed  bool
+	transformClient      *http.Client
+)
+
+// transformMessage - содержимое запроса/ответа для хука
+type transformMessage struct {
+	Provider string              `json:"provider,omitempty"`
+	Method   string              `json:"method,omitempty"`
+	Path     string              `json:"path,omitempty"`
+	Query    string              `json:"query,omitempty"`
+	Status   int                 `json:"status,omitempty"`
+	Headers  map[string][]string `json:"headers,omitempty"`
+	Body     json.RawMessage     `json:"body,omitempty"`
+}
+
+func initTransformHooks() error {
+	transformRequestURL = strings.TrimSpace(os.Getenv("PROXY_TRANSFORM_REQUEST_URL"))
+	transformResponseURL = strings.TrimSpace(os.Getenv("PROXY_TRANSFORM_RESPONSE_URL"))
+	transformFailClosed = false
+	if transformRequestURL == "" && transformResponseURL == "" {
+		return nil
+	}
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("PROXY_TRANSFORM_FAIL_MODE"))); mode {
+	case "", "open":
+	case "closed":
+		transformFailClosed = true
+	default:
+		return fmt.Errorf("PROXY_TRANSFORM_FAIL_MODE: expected open or closed, got %q", mode)
+	}
+	transformClient = &http.Client{
+		Timeout: time.Duration(envInt("PROXY_TRANSFORM_TIMEOUT_MS", 500)) * time.Millisecond,
+		// Редиректы хука не выполняются: тело и заголовки клиента уходят только на заданный URL,
+		// 3xx - ошибка хука
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	log.Printf("Transform hooks enabled (request=%t response=%t fail_closed=%t)",
+		transformRequestURL != "", transformResponseURL != "", transformFailClosed)
+	return nil
+}
+
+// hookBody - тело для хука: JSON как есть, иначе строкой
+func hookBody(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	quoted, _ := json.Marshal(string(body))
+	return quoted
+}
+
+// fromHookBody - тело из ответа хука; строка JSON разворачивается
+func fromHookBody(raw json.RawMessage) []byte {
+	var s string
+	if len(raw) > 0 && raw[0] == '"' && json.Unmarshal(raw, &s) == nil {
+		return []byte(s)
+	}
+	return raw
+}
+
+// callTransformHook отправляет сообщение хуку и возвращает его ответ
+func callTransformHook(url string, msg *transformMessage) (*transformMessage, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := transformClient.Post(url, fiber.MIMEApplicationJSON, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("transform hook returned status %d", resp.StatusCode)
+	}
+	var out transformMessage
+	if err := json.NewDecoder(io.LimitReader(resp.Body, int64(bodyLimit))).Decode(&out); err != nil {
+		if errors.Is(err, io.EOF) {
+			// Пустой ответ - без изменений
+			return &transformMessage{}, nil
+		}
+		return nil, fmt.Errorf("invalid transform hook response: %w", err)
+	}
+	return &out, nil
+}
+
+// hookHeaders - заголовки клиента для хука, без учётных данных
+func hookHeaders(c *fiber.Ctx) map[string][]string {
+	headers := map[string][]string{}
+	for k, v := range c.GetReqHeaders() {
+		if matchHeader(secretHeaders, strings.ToLower(k)) {
+			continue
+		}
+		headers[k] = v
+	}
+	return headers
+}
+
+// transformRequest прогоняет тело запроса через хук; возвращает новое тело и заголовки
+// для провайдера. ok=false - хук не сработал при fail-closed, ответ клиенту уже записан.
+func transformRequest(c *fiber.Ctx, provider, path string, body []byte) ([]byte, map[string][]string, bool) {
+	if transformRequestURL == "" {
+		return body, nil, true
+	}
+	out, err := callTransformHook(transformRequestURL, &transformMessage{
+		Provider: provider,
+		Method:   c.Method(),
+		Path:     path,
+		Query:    string(c.Request().URI().QueryString()),
+		Headers:  hookHeaders(c),
+		Body:     hookBody(body),
+	})
+	if err != nil {
+		return body, nil, transformFailed(c, "request", err)
+	}
+	if out.Body != nil {
+		body = fromHookBody(out.Body)
+	}
+	return body, out.Headers, true
+}
+
+// transformResponse прогоняет non-streaming ответ через хук перед отправкой клиенту;
+// так же для ответов из кэша и записей: там хранится исходный ответ провайдера
+func transformResponse(c *fiber.Ctx, provider, path string, status int, header http.Header, body []byte) ([]byte, bool) {
+	if transformResponseURL == "" {
+		return body, true
+	}
+	// Кодировка тела, которое уйдёт клиенту: после фильтрации списка моделей оно уже распаковано
+	decoded, err := decodeBody(body, string(c.Response().Header.Peek(fiber.HeaderContentEncoding)))
+	if err != nil {
+		return body, transformFailed(c, "response", err)
+	}
+	out, err := callTransformHook(transformResponseURL, &transformMessage{
+		Provider: provider,
+		Path:     path,
+		Status:   status,
+		Headers:  header,
+		Body:     hookBody(decoded),
+	})
+	if err != nil {
+		return body, transformFailed(c, "response", err)
+	}
+	if out.Status != 0 {
+		c.Status(out.Status)
+	}
+	for k, v := range out.Headers {
+		c.Response().Header.Del(k)
+		for _, val := range v {
+			c.Response().Header.Add(k, val)
+		}
+	}
+	if out.Body == nil {
+		return body, true
+	}
+	c.Response().Header.Del("Content-Encoding")
+	return fromHookBody(out.Body), true
+}
+
+// transformFailed - fail-open: WARN и продолжаем; fail-closed: 502 клиенту
+func transformFailed(c *fiber.Ctx, stage string, err error) bool {
+	if !transformFailClosed {
+		log.Printf("WARN: %s transform hook failed, passing through unchanged: %v", stage, err)
+		return true
+	}
+	log.Printf("ERROR: %s transform hook failed: %v", stage, err)
+	c.Response().Header.Del("Content-Encoding")
+	c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+		"error": stage + " transform hook failed: " + err.Error(),
+		"type":  "transform_hook_error",
+	})
+	return false
+}
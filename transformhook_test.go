@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// transformHook - тестовый хук: запоминает полученные сообщения, отвечает handler
+type transformHook struct {
+	mu       sync.Mutex
+	received []transformMessage
+}
+
+func (h *transformHook) last() transformMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.received) == 0 {
+		return transformMessage{}
+	}
+	return h.received[len(h.received)-1]
+}
+
+func (h *transformHook) calls() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.received)
+}
+
+// startTransformHook поднимает хук и задаёт PROXY_TRANSFORM_<env>_URL
+func startTransformHook(t *testing.T, env string, reply func(msg transformMessage) string) *transformHook {
+	h := &transformHook{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg transformMessage
+		json.NewDecoder(r.Body).Decode(&msg)
+		h.mu.Lock()
+		h.received = append(h.received, msg)
+		h.mu.Unlock()
+		w.Write([]byte(reply(msg)))
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("PROXY_TRANSFORM_"+env+"_URL", srv.URL)
+	return h
+}
+
+// transformedUpstream запоминает тело и X-Tenant запроса, отвечает fixed
+func transformedUpstream(t *testing.T, gotBody, gotTenant *string) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		*gotBody, *gotTenant = string(data), r.Header.Get("X-Tenant")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"content":"raw"}}]}`))
+	})
+}
+
+func TestTransformRequestHook(t *testing.T) {
+	hook := startTransformHook(t, "REQUEST", func(msg transformMessage) string {
+		return `{"body":{"model":"gpt-4o-mini","messages":[]},"headers":{"X-Tenant":["acme"]}}`
+	})
+	var gotBody, gotTenant string
+	transformedUpstream(t, &gotBody, &gotTenant)
+	p := startProxy(t)
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions?x=1", `{"model":"gpt-4o","messages":[]}`, "X-Client", "sdk")
+	if gotBody != `{"model":"gpt-4o-mini","messages":[]}` || gotTenant != "acme" {
+		t.Fatalf("upstream got body %s, X-Tenant %q", gotBody, gotTenant)
+	}
+	msg := hook.last()
+	if msg.Provider != "openai" || msg.Method != http.MethodPost || msg.Path != "v1/chat/completions" || msg.Query != "x=1" ||
+		string(msg.Body) != `{"model":"gpt-4o","messages":[]}` {
+		t.Fatalf("hook got %+v", msg)
+	}
+	// Учётные данные прокси в хук не уходят
+	if msg.Headers["X-Client"] == nil || msg.Headers["X-Proxy-Auth"] != nil {
+		t.Fatalf("hook headers = %v", msg.Headers)
+	}
+}
+
+func TestTransformRequestHookModelAllowlist(t *testing.T) {
+	useTokens(t, `[{"name":"mini","token":"tok-mini","models":["gpt-4o-mini"]}]`)
+	startTransformHook(t, "REQUEST", func(msg transformMessage) string {
+		return `{"body":{"model":"o3","messages":[]}}`
+	})
+	var gotBody, gotTenant string
+	transformedUpstream(t, &gotBody, &gotTenant)
+	p := startProxy(t)
+
+	// Allowlist проверяется по телу после хука
+	resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o-mini","messages":[]}`, "X-Proxy-Auth", "tok-mini")
+	if resp.StatusCode != http.StatusForbidden || gotBody != "" {
+		t.Fatalf("status %d, upstream got %q", resp.StatusCode, gotBody)
+	}
+}
+
+func TestTransformResponseHook(t *testing.T) {
+	hook := startTransformHook(t, "RESPONSE", func(msg transformMessage) string {
+		return `{"status":203,"body":{"choices":[{"message":{"content":"rewritten"}}]},"headers":{"X-Hooked":["1"]}}`
+	})
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		// Сжатый ответ хук получает распакованным
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(`{"choices":[{"message":{"content":"raw"}}]}`))
+		zw.Close()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(buf.Bytes())
+	})
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if resp.StatusCode != 203 || resp.Header.Get("X-Hooked") != "1" || body != `{"choices":[{"message":{"content":"rewritten"}}]}` {
+		t.Fatalf("status %d, X-Hooked %q: %s", resp.StatusCode, resp.Header.Get("X-Hooked"), body)
+	}
+	if msg := hook.last(); msg.Status != http.StatusOK || string(msg.Body) != `{"choices":[{"message":{"content":"raw"}}]}` {
+		t.Fatalf("hook got %+v", msg)
+	}
+}
+
+func TestTransformResponseHookFilteredModelList(t *testing.T) {
+	useTokens(t, `[{"name":"team-a","token":"tok-a","models":["gpt-4o"]}]`)
+	hook := startTransformHook(t, "RESPONSE", func(msg transformMessage) string { return `{}` })
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(`{"object":"list","data":[{"id":"gpt-4o"},{"id":"o3"}]}`))
+		zw.Close()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(buf.Bytes())
+	})
+	p := startProxy(t)
+
+	// Отфильтрованный список уже распакован: хук получает его, а не ошибку распаковки
+	_, body := p.do(t, http.MethodGet, "/openai/v1/models", "", "X-Proxy-Auth", "tok-a")
+	msg := hook.last()
+	if !strings.Contains(string(msg.Body), `"gpt-4o"`) || strings.Contains(string(msg.Body), `"o3"`) || strings.Contains(body, `"o3"`) {
+		t.Fatalf("hook got %s, client got %s", msg.Body, body)
+	}
+}
+
+func TestTransformResponseHookOnCacheAndReplay(t *testing.T) {
+	t.Setenv("PROXY_CACHE_POLICIES_FILE", writeFile(t, "cache.json", `[{"path": "v1/embeddings", "ttl_sec": 60}]`))
+	dir := t.TempDir()
+	t.Setenv("PROXY_RECORD_DIR", dir)
+	hook := startTransformHook(t, "RESPONSE", func(msg transformMessage) string { return `{"body":"hooked"}` })
+	var gotBody, gotTenant string
+	transformedUpstream(t, &gotBody, &gotTenant)
+	p := startProxy(t)
+
+	for i := range 2 {
+		resp, body := p.do(t, http.MethodPost, "/openai/v1/embeddings", `{"input":"x"}`)
+		if body != "hooked" {
+			t.Fatalf("request %d (cache %s): %s", i+1, resp.Header.Get("X-Proxy-Cache"), body)
+		}
+	}
+	if hook.calls() != 2 {
+		t.Fatalf("hook calls = %d, want one per response, cached included", hook.calls())
+	}
+
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	waitFor(t, "recording", func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		return len(files) > 0
+	})
+	t.Setenv("PROXY_RECORD_MODE", "replay-strict")
+	p = startProxy(t)
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if resp.Header.Get("X-Proxy-Replay") != "hit" || body != "hooked" {
+		t.Fatalf("replayed (%s): %s", resp.Header.Get("X-Proxy-Replay"), body)
+	}
+}
+
+func TestTransformHookFailModes(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"body":"late"}`))
+	}))
+	t.Cleanup(slow.Close)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(failing.Close)
+	// Редирект хука не выполняется: тело клиента не уходит на другой адрес
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"body":"redirected"}`))
+	}))
+	t.Cleanup(target.Close)
+	redirecting := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	t.Cleanup(redirecting.Close)
+	t.Setenv("PROXY_TRANSFORM_TIMEOUT_MS", "50")
+
+	for _, c := range []struct {
+		name, env, url string
+	}{
+		{"request timeout", "REQUEST", slow.URL},
+		{"request error status", "REQUEST", failing.URL},
+		{"request redirect", "REQUEST", redirecting.URL},
+		{"response timeout", "RESPONSE", slow.URL},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			t.Setenv("PROXY_TRANSFORM_"+c.env+"_URL", c.url)
+			var gotBody, gotTenant string
+			transformedUpstream(t, &gotBody, &gotTenant)
+
+			// open (по умолчанию) - запрос и ответ без изменений
+			t.Setenv("PROXY_TRANSFORM_FAIL_MODE", "")
+			p := startProxy(t)
+			resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+			if resp.StatusCode != http.StatusOK || gotBody != `{"model":"gpt-4o"}` || !strings.Contains(body, `"raw"`) {
+				t.Fatalf("fail-open: status %d, upstream got %s: %s", resp.StatusCode, gotBody, body)
+			}
+
+			t.Setenv("PROXY_TRANSFORM_FAIL_MODE", "closed")
+			p = startProxy(t)
+			resp, body = p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+			if resp.StatusCode != http.StatusBadGateway || !strings.Contains(body, `"type":"transform_hook_error"`) {
+				t.Fatalf("fail-closed: status %d: %s", resp.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestInitTransformHooksErrors(t *testing.T) {
+	t.Setenv("PROXY_TRANSFORM_REQUEST_URL", "http://127.0.0.1:1")
+	t.Setenv("PROXY_TRANSFORM_FAIL_MODE", "maybe")
+	if err := initTransformHooks(); err == nil {
+		t.Fatal("unknown fail mode accepted")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// upstreamClient применяет настройки соединений провайдера (<PROVIDER>_HTTP2, keepalive и пул).
+// Без настроек возвращается общий клиент.
+//
+//	<PROVIDER>_HTTP2                   - пытаться договориться о HTTP/2 (ForceAttemptHTTP2)
+//	<PROVIDER>_HTTP2_PING_MS           - ping простаивающего HTTP/2 соединения (0 - выключен)
+//	<PROVIDER>_TCP_KEEPALIVE_MS        - период TCP keepalive (-1 - выключен)
+//	<PROVIDER>_IDLE_CONN_TIMEOUT_MS    - сколько держать простаивающее соединение
+//	<PROVIDER>_MAX_IDLE_CONNS_PER_HOST - размер пула простаивающих соединений
+//	<PROVIDER>_DISABLE_KEEPALIVES      - новое соединение на каждый запрос (для шлюзов,
+//	                                     которые ломаются на переиспользовании соединений)
+func upstreamClient(base *http.Client, prefix string) *http.Client {
+	set := func(name string) bool { return os.Getenv(prefix+name) != "" }
+	if !set("HTTP2") && !set("HTTP2_PING_MS") && !set("TCP_KEEPALIVE_MS") &&
+		!set("IDLE_CONN_TIMEOUT_MS") && !set("MAX_IDLE_CONNS_PER_HOST") && !set("DISABLE_KEEPALIVES") {
+		return base
+	}
+
+	transport := base.Transport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = envBool(prefix+"HTTP2", transport.ForceAttemptHTTP2)
+	transport.DisableKeepAlives = envBool(prefix+"DISABLE_KEEPALIVES", false)
+
+	if set("TCP_KEEPALIVE_MS") {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: time.Duration(envInt(prefix+"TCP_KEEPALIVE_MS", 0)) * time.Millisecond,
+		}
+		transport.DialContext = dialer.DialContext
+	}
+	if set("IDLE_CONN_TIMEOUT_MS") {
+		transport.IdleConnTimeout = time.Duration(envInt(prefix+"IDLE_CONN_TIMEOUT_MS", 0)) * time.Millisecond
+	}
+	if set("MAX_IDLE_CONNS_PER_HOST") {
+		transport.MaxIdleConnsPerHost = envInt(prefix+"MAX_IDLE_CONNS_PER_HOST", transport.MaxIdleConnsPerHost)
+	}
+	if ping := envInt(prefix+"HTTP2_PING_MS", 0); ping > 0 {
+		transport.HTTP2 = &http.HTTP2Config{SendPingTimeout: time.Duration(ping) * time.Millisecond}
+	}
+
+	client := *base
+	client.Transport = transport
+	return &client
+}
+
+// tlsVersions - допустимые значения PROXY_TLS_MIN_VERSION
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// initUpstreamTLS задаёт минимальную версию TLS (PROXY_TLS_MIN_VERSION, по умолчанию 1.2)
+// и список шифров (PROXY_TLS_CIPHER_SUITES, имена Go, для TLS 1.0-1.2) общего клиента;
+// клиенты провайдеров клонируют его транспорт и получают те же настройки
+func initUpstreamTLS() error {
+	name := strings.TrimSpace(os.Getenv("PROXY_TLS_MIN_VERSION"))
+	if name == "" {
+		name = "1.2"
+	}
+	version, ok := tlsVersions[name]
+	if !ok {
+		return fmt.Errorf("PROXY_TLS_MIN_VERSION: unsupported version %q", name)
+	}
+	cfg := &tls.Config{MinVersion: version}
+
+	if raw := envList("PROXY_TLS_CIPHER_SUITES"); len(raw) > 0 {
+		known := map[string]uint16{}
+		for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			known[s.Name] = s.ID
+		}
+		for _, name := range raw {
+			id, ok := known[name]
+			if !ok {
+				return fmt.Errorf("PROXY_TLS_CIPHER_SUITES: unknown cipher suite %q", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	httpClient.Transport.(*http.Transport).TLSClientConfig = cfg
+	return nil
+}
+
+// upstreamMaxRedirects - сколько редиректов провайдера следовать (PROXY_UPSTREAM_MAX_REDIRECTS);
+// 0 - не следовать, 3xx уходит клиенту как есть
+var upstreamMaxRedirects int
+
+// initUpstreamRedirects задаёт политику редиректов общего клиента; клиенты провайдеров - его копии.
+// Следуем только на тот же хост и без перехода с https на http, иначе отдаём 3xx клиенту.
+func initUpstreamRedirects() {
+	upstreamMaxRedirects = envInt("PROXY_UPSTREAM_MAX_REDIRECTS", 0)
+	httpClient.CheckRedirect = checkUpstreamRedirect
+}
+
+func checkUpstreamRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > upstreamMaxRedirects {
+		return http.ErrUseLastResponse
+	}
+	first := via[0].URL
+	if !strings.EqualFold(req.URL.Host, first.Host) {
+		log.Printf("WARN: upstream redirect from %s to another host %s not followed", first.Host, req.URL.Host)
+		return http.ErrUseLastResponse
+	}
+	if first.Scheme == "https" && req.URL.Scheme != "https" {
+		log.Printf("WARN: upstream redirect from https to %s on %s not followed", req.URL.Scheme, first.Host)
+		return http.ErrUseLastResponse
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// h2Upstream - TLS-сервер с HTTP/2; /stream отдаёт SSE по частям, ожидая release
+func h2Upstream(t *testing.T, release chan struct{}) (*httptest.Server, *http.Client) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+		if r.URL.Path == "/stream" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: first\n\n"))
+			w.(http.Flusher).Flush()
+			<-release
+			w.Write([]byte("data: [DONE]\n\n"))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	// Собственный TLSClientConfig отключает автоматический HTTP/2 - как у клиента прокси
+	base := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	return srv, base
+}
+
+func TestUpstreamHTTP2(t *testing.T) {
+	t.Setenv("OPENAI_HTTP2", "true")
+	t.Setenv("OPENAI_TCP_KEEPALIVE_MS", "15000")
+	release := make(chan struct{})
+	srv, base := h2Upstream(t, release)
+	client := upstreamClient(base, "OPENAI_")
+
+	resp, err := client.Get(srv.URL + "/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 || resp.Header.Get("X-Proto") != "HTTP/2.0" {
+		t.Fatalf("negotiated %s, want HTTP/2", resp.Proto)
+	}
+
+	// Поток по HTTP/2 приходит частями, а не целиком по завершении
+	resp, err = client.Get(srv.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	first := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		first <- line
+	}()
+	select {
+	case line := <-first:
+		if line != "data: first\n" {
+			t.Fatalf("first line = %q", line)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("first event was not delivered before the stream ended")
+	}
+	close(release)
+}
+
+func TestUpstreamHTTP2Disabled(t *testing.T) {
+	t.Setenv("OPENAI_HTTP2", "false")
+	srv, base := h2Upstream(t, nil)
+
+	resp, err := upstreamClient(base, "OPENAI_").Get(srv.URL + "/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 1 {
+		t.Fatalf("negotiated %s with OPENAI_HTTP2=false", resp.Proto)
+	}
+	// Без настроек провайдера - общий клиент
+	if upstreamClient(base, "NEBIUS_") != base {
+		t.Fatal("client without settings is not the shared client")
+	}
+}
+
+// connCountingUpstream - провайдер, считающий новые TCP-соединения от прокси
+func connCountingUpstream(t *testing.T) *atomic.Int32 {
+	conns := &atomic.Int32{}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	t.Setenv("OPENAI_BASE_URL", srv.URL)
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	return conns
+}
+
+func TestDisableKeepAlives(t *testing.T) {
+	t.Setenv("OPENAI_DISABLE_KEEPALIVES", "true")
+	conns := connCountingUpstream(t)
+	p := startProxy(t)
+
+	for range 3 {
+		p.do(t, http.MethodGet, "/openai/v1/models", "")
+	}
+	if n := conns.Load(); n != 3 {
+		t.Fatalf("upstream connections = %d, want a fresh one per request", n)
+	}
+}
+
+func TestKeepAlivesByDefault(t *testing.T) {
+	conns := connCountingUpstream(t)
+	p := startProxy(t)
+
+	for range 3 {
+		p.do(t, http.MethodGet, "/openai/v1/models", "")
+	}
+	if n := conns.Load(); n != 1 {
+		t.Fatalf("upstream connections = %d, want one reused connection", n)
+	}
+}
+
+// legacyTLSUpstream - TLS-сервер, не поддерживающий версии новее TLS 1.1
+func legacyTLSUpstream(t *testing.T) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	srv.TLS = &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS11}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// upstreamTLSClient - клиент с политикой TLS общего клиента провайдеров и доверием к сертификату srv
+func upstreamTLSClient(t *testing.T, srv *httptest.Server) *http.Client {
+	t.Helper()
+	transport := httpClient.Transport.(*http.Transport)
+	prev := transport.TLSClientConfig
+	t.Cleanup(func() { transport.TLSClientConfig = prev })
+	if err := initUpstreamTLS(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := transport.TLSClientConfig.Clone()
+	cfg.RootCAs = x509.NewCertPool()
+	cfg.RootCAs.AddCert(srv.Certificate())
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+}
+
+func TestUpstreamTLSMinVersion(t *testing.T) {
+	srv := legacyTLSUpstream(t)
+
+	// По умолчанию TLS 1.2+: сервер только с TLS 1.1 отклоняется на рукопожатии
+	_, err := upstreamTLSClient(t, srv).Get(srv.URL)
+	if err == nil || !strings.Contains(err.Error(), "protocol version") {
+		t.Fatalf("TLS 1.1 server with the default policy: %v", err)
+	}
+
+	t.Setenv("PROXY_TLS_MIN_VERSION", "1.1")
+	resp, err := upstreamTLSClient(t, srv).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("TLS 1.1 server with PROXY_TLS_MIN_VERSION=1.1: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestUpstreamTLSCipherSuites(t *testing.T) {
+	transport := httpClient.Transport.(*http.Transport)
+	prev := transport.TLSClientConfig
+	t.Cleanup(func() { transport.TLSClientConfig = prev })
+
+	t.Setenv("PROXY_TLS_CIPHER_SUITES", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+	if err := initUpstreamTLS(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := transport.TLSClientConfig
+	want := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+	if cfg.MinVersion != tls.VersionTLS12 || !slices.Equal(cfg.CipherSuites, want) {
+		t.Fatalf("TLS config: min version %x, cipher suites %x", cfg.MinVersion, cfg.CipherSuites)
+	}
+
+	t.Setenv("PROXY_TLS_CIPHER_SUITES", "TLS_NO_SUCH_SUITE")
+	if err := initUpstreamTLS(); err == nil {
+		t.Error("unknown cipher suite accepted")
+	}
+	t.Setenv("PROXY_TLS_CIPHER_SUITES", "")
+	t.Setenv("PROXY_TLS_MIN_VERSION", "1.4")
+	if err := initUpstreamTLS(); err == nil {
+		t.Error("unsupported TLS version accepted")
+	}
+}
+
+func TestProviderClientsUseTLSPolicy(t *testing.T) {
+	srv := legacyTLSUpstream(t)
+	t.Setenv("OPENAI_BASE_URL", srv.URL)
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	// Собственный транспорт провайдера - клон общего, с той же политикой
+	t.Setenv("OPENAI_MAX_IDLE_CONNS_PER_HOST", "10")
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodGet, "/openai/v1/models", "")
+	if resp.StatusCode != http.StatusBadGateway || !strings.Contains(body, "protocol version") {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+}
+
+// redirectingUpstream: /v1/hop/N перенаправляет на /v1/hop/N-1, /v1/hop/0 отвечает 200;
+// /v1/away - на адрес other. Пройденные пути и ключи - в hits
+func redirectingUpstream(t *testing.T, other string, hits *[]string) {
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		*hits = append(*hits, r.URL.Path+" "+r.Header.Get("Authorization"))
+		switch {
+		case r.URL.Path == "/v1/away":
+			http.Redirect(w, r, other+"/v1/models", http.StatusFound)
+		case r.URL.Path == "/v1/hop/0":
+			w.Write([]byte(`{"ok":true}`))
+		case strings.HasPrefix(r.URL.Path, "/v1/hop/"):
+			n := r.URL.Path[len("/v1/hop/"):]
+			next := map[string]string{"1": "0", "2": "1", "3": "2"}[n]
+			http.Redirect(w, r, "/v1/hop/"+next, http.StatusTemporaryRedirect)
+		}
+	})
+}
+
+// doNoRedirect - запрос к прокси клиентом, который сам редиректы не выполняет
+func (p *testProxy) doNoRedirect(t *testing.T, method, path, body string) *http.Response {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(p.newRequest(t, method, path, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestUpstreamRedirectNotFollowedByDefault(t *testing.T) {
+	var hits []string
+	redirectingUpstream(t, "", &hits)
+	p := startProxy(t)
+
+	resp := p.doNoRedirect(t, http.MethodPost, "/openai/v1/hop/1", `{"model":"gpt-4o"}`)
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != "/v1/hop/0" {
+		t.Fatalf("status %d, Location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if len(hits) != 1 {
+		t.Fatalf("upstream hits = %v, want the redirect not followed", hits)
+	}
+}
+
+func TestUpstreamRedirectSameHostCap(t *testing.T) {
+	t.Setenv("PROXY_UPSTREAM_MAX_REDIRECTS", "2")
+	var hits []string
+	redirectingUpstream(t, "", &hits)
+	p := startProxy(t)
+
+	// Два перехода - в пределах лимита; ключ провайдера сохраняется на том же хосте
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/hop/2", `{"model":"gpt-4o"}`)
+	if resp.StatusCode != http.StatusOK || body != `{"ok":true}` {
+		t.Fatalf("2 redirects: status %d: %s", resp.StatusCode, body)
+	}
+	if want := "/v1/hop/2 Bearer sk-openai-test,/v1/hop/1 Bearer sk-openai-test,/v1/hop/0 Bearer sk-openai-test"; strings.Join(hits, ",") != want {
+		t.Fatalf("upstream hits = %v", hits)
+	}
+
+	// Третий переход сверх лимита - клиент получает последний 3xx
+	hits = nil
+	resp = p.doNoRedirect(t, http.MethodPost, "/openai/v1/hop/3", `{"model":"gpt-4o"}`)
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != "/v1/hop/0" || len(hits) != 3 {
+		t.Fatalf("3 redirects: status %d, Location %q, hits %v", resp.StatusCode, resp.Header.Get("Location"), hits)
+	}
+}
+
+func TestUpstreamRedirectOtherHostNotFollowed(t *testing.T) {
+	t.Setenv("PROXY_UPSTREAM_MAX_REDIRECTS", "5")
+	otherHits := 0
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { otherHits++ }))
+	t.Cleanup(other.Close)
+	var hits []string
+	redirectingUpstream(t, other.URL, &hits)
+	p := startProxy(t)
+	logs := captureLog(t)
+
+	resp := p.doNoRedirect(t, http.MethodGet, "/openai/v1/away", "")
+	if resp.StatusCode != http.StatusFound || otherHits != 0 {
+		t.Fatalf("status %d, other host hits %d", resp.StatusCode, otherHits)
+	}
+	if !strings.Contains(logs.String(), "to another host "+strings.TrimPrefix(other.URL, "http://")+" not followed") {
+		t.Fatalf("no warning for the cross-host redirect:\n%s", logs)
+	}
+}
+
+func TestCheckUpstreamRedirectDowngrade(t *testing.T) {
+	prev := upstreamMaxRedirects
+	upstreamMaxRedirects = 5
+	t.Cleanup(func() { upstreamMaxRedirects = prev })
+
+	first, _ := http.NewRequest(http.MethodGet, "https://api.example.com/v1/a", nil)
+	for target, follow := range map[string]bool{
+		"https://api.example.com/v1/b": true,
+		"https://API.example.com/v1/b": true,
+		"http://api.example.com/v1/b":  false,
+		"https://evil.example.com/v1":  false,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		if err := checkUpstreamRedirect(req, []*http.Request{first}); (err == nil) != follow {
+			t.Errorf("%s: err = %v, want follow %v", target, err, follow)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// bodyLimit - максимальный размер тела запроса (PROXY_BODY_LIMIT_MB)
+	bodyLimit = 100 * 1024 * 1024
+	// streamRequestBodies - передавать любые тела потоком (без валидации и преобразований JSON)
+	streamRequestBodies bool
+)
+
+var errBodyTooLarge = errors.New("request body exceeds limit")
+
+// bodyTooLarge - ответ 413 в общей схеме ошибок прокси с действующим лимитом. Непрочитанный
+// остаток тела остаётся в соединении, поэтому оно закрывается после ответа (lingerClose).
+func bodyTooLarge(c *fiber.Ctx) error {
+	c.Context().SetConnectionClose()
+	lingerClose(c)
+	return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+		"error": fmt.Sprintf("Request body too large: limit is %d bytes (%d MB)", bodyLimit, bodyLimit/(1024*1024)),
+		"limit": bodyLimit,
+	})
+}
+
+// lingerTimeout - сколько после ответа 413 вычитывается остаток тела
+const lingerTimeout = 500 * time.Millisecond
+
+// lingerClose перед закрытием соединения вычитывает остаток тела, пока клиент не закроет его сам,
+// но не дольше lingerTimeout. Закрытие с непрочитанными данными сбрасывает соединение (RST),
+// и клиент, ещё отправляющий тело, теряет уже отправленный ему ответ.
+// fasthttp не передаёт соединение hijack-обработчику после ответа с Connection: close,
+// поэтому ответ (с заголовками и телом после всех middleware) пишется здесь же.
+func lingerClose(c *fiber.Ctx) {
+	ctx := c.Context()
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(conn net.Conn) {
+		if _, err := ctx.Response.WriteTo(conn); err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+		io.Copy(io.Discard, conn)
+	})
+}
+
+// bodyLimitMiddleware отклоняет тела длиннее bodyLimit по Content-Length. При StreamRequestBody
+// fasthttp не отвергает такие тела сам, c.Body() прочитал бы их целиком.
+func bodyLimitMiddleware(c *fiber.Ctx) error {
+	if c.Request().Header.ContentLength() > bodyLimit {
+		return bodyTooLarge(c)
+	}
+	return c.Next()
+}
+
+// isMultipart - загрузки файлов передаются провайдеру потоком, без чтения тела в память
+func isMultipart(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "multipart/form-data" || mediaType == "multipart/mixed")
+}
+
+// isJSON - тело JSON: его проверяем (схема, модель) и преобразуем, поэтому читаем целиком
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// shouldStreamRequestBody - тело идёт провайдеру потоком: multipart, chunked от клиента
+// (Content-Length неизвестен, кроме JSON) или включён PROXY_STREAM_REQUEST_BODY
+func shouldStreamRequestBody(c *fiber.Ctx) bool {
+	contentType := c.Get("Content-Type")
+	if c.Request().Header.ContentLength() == -1 && !isJSON(contentType) {
+		return true
+	}
+	return streamRequestBodies || isMultipart(contentType)
+}
+
+// readChunkedBody читает chunked-тело в память, но не больше bodyLimit:
+// c.Body() при StreamRequestBody прочитал бы его целиком без лимита
+func readChunkedBody(c *fiber.Ctx) error {
+	stream := c.Request().BodyStream()
+	if stream == nil || c.Request().Header.ContentLength() != -1 {
+		return nil
+	}
+	body, err := io.ReadAll(&limitedBody{r: stream, limit: int64(bodyLimit)})
+	if err != nil {
+		return err
+	}
+	c.Request().SetBody(body)
+	return nil
+}
+
+// multipartModel - поле model формы загрузки (allowlist моделей токена). Прочитанное до него
+// начало тела возвращается в head: провайдеру оно уходит перед остатком потока.
+func multipartModel(c *fiber.Ctx) (model string, head []byte, err error) {
+	_, params, err := mime.ParseMediaType(c.Get("Content-Type"))
+	if err != nil {
+		return "", nil, err
+	}
+	var buf bytes.Buffer
+	var body io.Reader
+	if stream := c.Request().BodyStream(); stream != nil {
+		body = io.TeeReader(&limitedBody{r: stream, limit: int64(bodyLimit)}, &buf)
+	} else {
+		body = bytes.NewReader(c.Body())
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, errBodyTooLarge) {
+			return "", nil, err
+		}
+		if err != nil {
+			break
+		}
+		if part.FormName() == "model" {
+			value, _ := io.ReadAll(io.LimitReader(part, 256))
+			model = string(value)
+			break
+		}
+	}
+	return model, buf.Bytes(), nil
+}
+
+// limitedBody обрывает поток ошибкой, если он длиннее limit.
+// При StreamRequestBody fasthttp не применяет BodyLimit к потоковому телу.
+type limitedBody struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.read >= l.limit {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > l.limit-l.read+1 {
+		p = p[:l.limit-l.read+1]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
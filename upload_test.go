@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// batchFile - JSONL батча на ~3MB, больше буфера чтения fasthttp
+func batchFile() []byte {
+	line := `{"custom_id":"req-1","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hi"}]}}` + "\n"
+	return bytes.Repeat([]byte(line), 3*1024*1024/len(line))
+}
+
+// multipartUpload - тело загрузки /v1/files с полем purpose и файлом
+func multipartUpload(t *testing.T, file []byte) (contentType string, body []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("purpose", "batch")
+	fw, err := mw.CreateFormFile("file", "batch.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(file)
+	mw.Close()
+	return mw.FormDataContentType(), buf.Bytes()
+}
+
+func TestMultipartFileUpload(t *testing.T) {
+	file := batchFile()
+	contentType, upload := multipartUpload(t, file)
+	var gotType string
+	var gotLength int64
+	var gotPurpose string
+	var gotFile []byte
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotType = r.Header.Get("Content-Type")
+		gotLength = r.ContentLength
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		gotPurpose = r.FormValue("purpose")
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		gotFile, _ = io.ReadAll(f)
+		w.Write([]byte(`{"id":"file-abc","object":"file","purpose":"batch"}`))
+	})
+	p := startProxy(t)
+
+	req := p.newRequest(t, http.MethodPost, "/openai/v1/files", string(upload), "Content-Type", contentType)
+	resp, body := send(t, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	// boundary клиента сохранён, длина известна провайдеру
+	if gotType != contentType || gotLength != int64(len(upload)) {
+		t.Fatalf("upstream Content-Type %q length %d, want %q %d", gotType, gotLength, contentType, len(upload))
+	}
+	if gotPurpose != "batch" || sha256.Sum256(gotFile) != sha256.Sum256(file) {
+		t.Fatalf("file forwarded damaged: purpose %q, %d of %d bytes", gotPurpose, len(gotFile), len(file))
+	}
+}
+
+func TestMultipartUploadOverLimit(t *testing.T) {
+	t.Setenv("PROXY_BODY_LIMIT_MB", "1")
+	calls := 0
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	contentType, upload := multipartUpload(t, batchFile())
+	resp, body := send(t, p.newRequest(t, http.MethodPost, "/openai/v1/files", string(upload), "Content-Type", contentType))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge || !strings.Contains(body, `"limit":1048576`) {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if calls != 0 {
+		t.Fatalf("upload over the limit reached the provider")
+	}
+}
+
+func TestBatchEndpoints(t *testing.T) {
+	var gotBody string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/batches":
+			b, _ := io.ReadAll(r.Body)
+			gotBody = string(b)
+			w.Write([]byte(`{"id":"batch_1","object":"batch","status":"validating"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/batches/batch_1":
+			w.Write([]byte(`{"id":"batch_1","object":"batch","status":"completed"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	p := startProxy(t)
+
+	create := `{"input_file_id":"file-abc","endpoint":"/v1/chat/completions","completion_window":"24h"}`
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/batches", create)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `"validating"`) || gotBody != create {
+		t.Fatalf("create: status %d %s, upstream got %s", resp.StatusCode, body, gotBody)
+	}
+	resp, body = p.do(t, http.MethodGet, "/openai/v1/batches/batch_1", "")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `"completed"`) {
+		t.Fatalf("retrieve: status %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestChunkedUploadIsStreamed(t *testing.T) {
+	firstChunk := make(chan string, 1)
+	var gotLength int64
+	var gotTE []string
+	var gotBody []byte
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotLength, gotTE = r.ContentLength, r.TransferEncoding
+		buf := make([]byte, 5)
+		io.ReadFull(r.Body, buf)
+		firstChunk <- string(buf)
+		rest, _ := io.ReadAll(r.Body)
+		gotBody = append(buf, rest...)
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	pr, pw := io.Pipe()
+	req := p.newRequest(t, http.MethodPost, "/openai/v1/uploads/part", "", "Content-Type", "application/octet-stream")
+	req.Body = pr
+	req.ContentLength = -1
+	done := make(chan *http.Response)
+	go func() {
+		resp, _ := send(t, req)
+		done <- resp
+	}()
+
+	// Первая часть доходит до провайдера, пока клиент ещё не закончил тело
+	pw.Write([]byte("part1"))
+	select {
+	case got := <-firstChunk:
+		if got != "part1" {
+			t.Fatalf("first chunk = %q", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("upstream got nothing before the client finished the body")
+	}
+	pw.Write([]byte("-part2"))
+	pw.Close()
+
+	if resp := <-done; resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if gotLength != -1 || len(gotTE) != 1 || gotTE[0] != "chunked" {
+		t.Fatalf("upstream Content-Length %d, Transfer-Encoding %v, want chunked", gotLength, gotTE)
+	}
+	if string(gotBody) != "part1-part2" {
+		t.Fatalf("upstream body = %q", gotBody)
+	}
+}
+
+func TestStreamRequestBodyKeepsContentLength(t *testing.T) {
+	t.Setenv("PROXY_STREAM_REQUEST_BODY", "true")
+	var gotLength int64
+	var gotBody string
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		gotLength = r.ContentLength
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	body := strings.Repeat("a", 200*1024)
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/uploads/part", body, "Content-Type", "application/octet-stream"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if gotLength != int64(len(body)) || gotBody != body {
+		t.Fatalf("upstream got %d bytes with Content-Length %d", len(gotBody), gotLength)
+	}
+}
+
+// tooLarge разбирает ответ 413 в общей схеме ошибок прокси
+func tooLarge(t *testing.T, resp *http.Response, body string) (msg string, limit int) {
+	t.Helper()
+	var out struct {
+		Error string
+		Limit int
+	}
+	if err := json.Unmarshal([]byte(body), &out); err != nil || resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	return out.Error, out.Limit
+}
+
+func TestJSONBodyOverLimit(t *testing.T) {
+	t.Setenv("PROXY_BODY_LIMIT_MB", "1")
+	calls := 0
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	big := `{"model":"gpt-4o","input":"` + strings.Repeat("x", 1<<20) + `"}`
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/embeddings", big)
+	msg, limit := tooLarge(t, resp, body)
+	if msg != "Request body too large: limit is 1048576 bytes (1 MB)" || limit != 1<<20 {
+		t.Fatalf("error %q, limit %d", msg, limit)
+	}
+	// Остаток тела не читался: соединение закрывается, а не разбирается как следующий запрос
+	if !resp.Close {
+		t.Fatal("connection kept alive after an unread over-limit body")
+	}
+	// Тело в пределах лимита проходит
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/embeddings", `{"input":"x"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("small body: status %d", resp.StatusCode)
+	}
+	if calls != 1 {
+		t.Fatalf("upstream calls = %d, want 1", calls)
+	}
+}
+
+func TestChunkedBodyOverLimit(t *testing.T) {
+	t.Setenv("PROXY_BODY_LIMIT_MB", "1")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	// Длина заранее неизвестна: лимит срабатывает посреди передачи, ответ - тот же 413, не 502
+	req := p.newRequest(t, http.MethodPost, "/openai/v1/files", "", "Content-Type", "application/octet-stream")
+	req.Body = io.NopCloser(io.LimitReader(zeroReader{}, 2<<20))
+	req.ContentLength = -1
+	resp, body := send(t, req)
+	if _, limit := tooLarge(t, resp, body); limit != 1<<20 {
+		t.Fatalf("limit = %d", limit)
+	}
+}
+
+func TestBodyOverLimitOpenAIFormat(t *testing.T) {
+	t.Setenv("PROXY_BODY_LIMIT_MB", "1")
+	t.Setenv("PROXY_ERROR_FORMAT", "openai")
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) })
+	p := startProxy(t)
+
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/embeddings", strings.Repeat("x", 2<<20))
+	if e := openAIError(t, body); resp.StatusCode != http.StatusRequestEntityTooLarge || !strings.Contains(e["message"].(string), "limit is 1048576 bytes") {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+}
+
+// zeroReader - бесконечный поток нулевых байт
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// tokenUsage - нормализованный usage независимо от формата провайдера
+type tokenUsage struct {
+	Model            string `json:"model,omitempty"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens"`
+	ReasoningTokens  int64  `json:"reasoning_tokens"`
+	CachedTokens     int64  `json:"cached_tokens"`
+}
+
+// rawUsage объединяет поля usage всех поддерживаемых провайдеров
+type rawUsage struct {
+	// OpenAI-совместимые
+	PromptTokens        int64 `json:"prompt_tokens"`
+	CompletionTokens    int64 `json:"completion_tokens"`
+	TotalTokens         int64 `json:"total_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int64 `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	CompletionTokensDetails struct {
+		ReasoningTokens int64 `json:"reasoning_tokens"`
+	} `json:"completion_tokens_details"`
+
+	// DeepSeek
+	ReasoningTokens      int64 `json:"reasoning_tokens"`
+	PromptCacheHitTokens int64 `json:"prompt_cache_hit_tokens"`
+
+	// Anthropic
+	InputTokens          int64 `json:"input_tokens"`
+	OutputTokens         int64 `json:"output_tokens"`
+	CacheReadInputTokens int64 `json:"cache_read_input_tokens"`
+}
+
+// normalizeUsage приводит usage провайдера к tokenUsage; неизвестные форматы дают общие поля
+func normalizeUsage(provider, model string, raw rawUsage) tokenUsage {
+	u := tokenUsage{
+		Model:            model,
+		PromptTokens:     raw.PromptTokens,
+		CompletionTokens: raw.CompletionTokens,
+		TotalTokens:      raw.TotalTokens,
+	}
+
+	switch provider {
+	case "anthropic":
+		u.PromptTokens = raw.InputTokens
+		u.CompletionTokens = raw.OutputTokens
+		u.CachedTokens = raw.CacheReadInputTokens
+	case "deepseek":
+		u.ReasoningTokens = raw.CompletionTokensDetails.ReasoningTokens
+		if raw.ReasoningTokens > 0 {
+			u.ReasoningTokens = raw.ReasoningTokens
+		}
+		u.CachedTokens = raw.PromptCacheHitTokens
+	default:
+		u.ReasoningTokens = raw.CompletionTokensDetails.ReasoningTokens
+		u.CachedTokens = raw.PromptTokensDetails.CachedTokens
+	}
+
+	if u.TotalTokens == 0 {
+		u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	}
+	return u
+}
+
+// decodeBody распаковывает тело ответа с Content-Encoding: gzip, остальные возвращает как есть
+func decodeBody(body []byte, contentEncoding string) ([]byte, error) {
+	if !strings.EqualFold(strings.TrimSpace(contentEncoding), "gzip") {
+		return body, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// extractUsage достаёт usage из JSON-ответа; при Content-Encoding: gzip тело сначала распаковывается
+func extractUsage(provider string, body []byte, contentEncoding string) (tokenUsage, bool) {
+	body, err := decodeBody(body, contentEncoding)
+	if err != nil {
+		return tokenUsage{}, false
+	}
+
+	var payload struct {
+		Model string    `json:"model"`
+		Usage *rawUsage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Usage == nil {
+		return tokenUsage{}, false
+	}
+	return normalizeUsage(provider, payload.Model, *payload.Usage), true
+}
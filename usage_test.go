@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// usageSamples - ответы провайдеров в их собственном формате usage
+var usageSamples = []struct{ name, provider, body string }{
+	{"openai", "openai", `{"model":"o3-mini","usage":{"prompt_tokens":100,"completion_tokens":250,"total_tokens":350,
+		"prompt_tokens_details":{"cached_tokens":40},"completion_tokens_details":{"reasoning_tokens":200}}}`},
+	{"deepseek", "deepseek", `{"model":"deepseek-reasoner","usage":{"prompt_tokens":80,"completion_tokens":300,"total_tokens":380,
+		"prompt_cache_hit_tokens":64,"prompt_cache_miss_tokens":16,"completion_tokens_details":{"reasoning_tokens":220}}}`},
+	{"deepseek_flat_reasoning", "deepseek", `{"model":"deepseek-reasoner","usage":{"prompt_tokens":10,"completion_tokens":30,
+		"reasoning_tokens":25}}`},
+	{"anthropic", "anthropic", `{"model":"claude-3-5-sonnet","usage":{"input_tokens":50,"output_tokens":20,"cache_read_input_tokens":30}}`},
+	{"unknown_shape", "nebius", `{"model":"llama","usage":{"prompt_tokens":5,"completion_tokens":7,"unknown_field":1}}`},
+}
+
+func TestExtractUsageNormalized(t *testing.T) {
+	got := map[string]tokenUsage{}
+	for _, s := range usageSamples {
+		u, ok := extractUsage(s.provider, []byte(s.body), "")
+		if !ok {
+			t.Fatalf("%s: usage not found", s.name)
+		}
+		got[s.name] = u
+	}
+	data, _ := json.MarshalIndent(got, "", "  ")
+	golden(t, "usage", append(data, '\n'))
+}
+
+func TestExtractUsageGzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(usageSamples[1].body))
+	zw.Close()
+
+	u, ok := extractUsage("deepseek", buf.Bytes(), "gzip")
+	if !ok || u.ReasoningTokens != 220 || u.CachedTokens != 64 {
+		t.Fatalf("gzip usage = %+v, %v", u, ok)
+	}
+	if _, ok := extractUsage("deepseek", buf.Bytes(), ""); ok {
+		t.Fatal("compressed body parsed without Content-Encoding")
+	}
+	if _, ok := extractUsage("openai", []byte(`{"id":"x"}`), ""); ok {
+		t.Fatal("usage found in a response without usage")
+	}
+}
+
+func TestUsageStatsFromCompressedResponse(t *testing.T) {
+	upstream(t, "deepseek", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(usageSamples[1].body))
+		zw.Close()
+	})
+	p := startProxy(t)
+
+	req := p.newRequest(t, http.MethodPost, "/deepseek/v1/chat/completions", `{"model":"deepseek-reasoner"}`)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if resp, _ := send(t, req); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	usage := p.providerStat(t, "deepseek", "usage").(map[string]any)
+	if usage["reasoning_tokens"] != float64(220) || usage["cached_tokens"] != float64(64) || usage["prompt_tokens"] != float64(80) {
+		t.Fatalf("deepseek usage stats = %v", usage)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// requestSchemas - JSON Schema для тела запроса по пути клиента (например /openai/v1/chat/completions)
+var requestSchemas = map[string]*jsonschema.Schema{}
+
+// loadRequestSchemas разбирает PROXY_REQUEST_SCHEMAS вида "path=file.json,path2=file2.json"
+func loadRequestSchemas(spec string) error {
+	requestSchemas = map[string]*jsonschema.Schema{}
+	compiler := jsonschema.NewCompiler()
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		path, file, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid schema mapping %q, expected path=file", pair)
+		}
+		schema, err := compiler.Compile(strings.TrimSpace(file))
+		if err != nil {
+			return fmt.Errorf("compile schema for %s: %w", path, err)
+		}
+		requestSchemas[strings.TrimSpace(path)] = schema
+	}
+	return nil
+}
+
+// maxMessages - лимит сообщений в chat-запросе (0 - без ограничения)
+var maxMessages int
+
+// validateRequestBody - этап проверки запроса до обращения к провайдеру:
+// JSON Schema пути и лимиты chat-запросов. Не-JSON тела пропускаются.
+func validateRequestBody(path string, body []byte) error {
+	if err := validateSchema(path, body); err != nil {
+		return err
+	}
+	return checkRequestLimits(path, body)
+}
+
+// validateSchema проверяет тело по схеме пути. Без схемы или для не-JSON тела проверка пропускается.
+func validateSchema(path string, body []byte) error {
+	schema, ok := requestSchemas[path]
+	if !ok {
+		return nil
+	}
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	return schema.Validate(inst)
+}
+
+// isChatCompletionsPath - эндпоинт chat completions (/openai/v1/chat/completions, /v1/chat/completions)
+func isChatCompletionsPath(path string) bool {
+	return strings.HasSuffix(strings.TrimRight(path, "/"), "/chat/completions")
+}
+
+// checkRequestLimits проверяет лимиты chat-запросов; другие эндпоинты и тела
+// без массива messages пропускаются
+func checkRequestLimits(path string, body []byte) error {
+	if maxMessages <= 0 || !isChatCompletionsPath(path) {
+		return nil
+	}
+	var req struct {
+		Messages []json.RawMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+	if len(req.Messages) > maxMessages {
+		return fmt.Errorf("too many messages: %d (max %d)", len(req.Messages), maxMessages)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const chatSchema = `{
+  "type": "object",
+  "required": ["model", "messages"],
+  "properties": {"messages": {"type": "array", "minItems": 1}}
+}`
+
+func TestRequestSchemaValidation(t *testing.T) {
+	schema := writeFile(t, "chat.json", chatSchema)
+	t.Setenv("PROXY_REQUEST_SCHEMAS", "/openai/v1/chat/completions="+schema)
+	calls := 0
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	// Валидное тело уходит провайдеру
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions",
+		`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if resp.StatusCode != http.StatusOK || calls != 1 {
+		t.Fatalf("valid body: status %d %s, upstream calls %d", resp.StatusCode, body, calls)
+	}
+
+	// Невалидное - 400 с подробностями, провайдер не вызывается
+	resp, body = p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o"}`)
+	if resp.StatusCode != http.StatusBadRequest || calls != 1 {
+		t.Fatalf("invalid body: status %d, upstream calls %d", resp.StatusCode, calls)
+	}
+	var out struct{ Error, Details string }
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Error != "Request validation failed" || !strings.Contains(out.Details, "messages") {
+		t.Fatalf("invalid body: response %s", body)
+	}
+
+	// Путь без схемы не проверяется
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/embeddings", `{"input":"x"}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unconfigured path: status %d", resp.StatusCode)
+	}
+	// Не-JSON тело на пути со схемой пропускается
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", `not json`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("non-JSON body: status %d", resp.StatusCode)
+	}
+	if calls != 3 {
+		t.Fatalf("upstream calls = %d, want 3", calls)
+	}
+}
+
+func TestLoadRequestSchemasErrors(t *testing.T) {
+	if err := loadRequestSchemas("/v1/chat/completions"); err == nil {
+		t.Error("mapping without a file accepted")
+	}
+	if err := loadRequestSchemas("/v1/chat/completions=" + writeFile(t, "bad.json", `{"type": 5}`)); err == nil {
+		t.Error("invalid schema accepted")
+	}
+}
+
+// chatWithMessages - chat-запрос с n сообщениями
+func chatWithMessages(n int) string {
+	msgs := make([]string, n)
+	for i := range msgs {
+		msgs[i] = `{"role":"user","content":"hi"}`
+	}
+	return `{"model":"gpt-4o","messages":[` + strings.Join(msgs, ",") + `]}`
+}
+
+func TestMaxMessages(t *testing.T) {
+	t.Setenv("PROXY_MAX_MESSAGES", "3")
+	calls := 0
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	if resp, _ := p.do(t, http.MethodPost, "/openai/v1/chat/completions", chatWithMessages(3)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("3 messages: status %d", resp.StatusCode)
+	}
+	resp, body := p.do(t, http.MethodPost, "/openai/v1/chat/completions", chatWithMessages(4))
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, "too many messages: 4 (max 3)") {
+		t.Fatalf("4 messages: status %d: %s", resp.StatusCode, body)
+	}
+	// Единый маршрут - тоже chat completions
+	if resp, _ := p.do(t, http.MethodPost, "/v1/chat/completions", chatWithMessages(4)); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("4 messages via /v1: status %d", resp.StatusCode)
+	}
+	if calls != 1 {
+		t.Fatalf("upstream calls = %d, want 1", calls)
+	}
+}
+
+func TestMaxMessagesSkipsOtherBodies(t *testing.T) {
+	t.Setenv("PROXY_MAX_MESSAGES", "1")
+	calls := 0
+	upstream(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{}`))
+	})
+	p := startProxy(t)
+
+	// Эндпоинт не chat completions, хотя в теле есть messages (threads, evals)
+	p.do(t, http.MethodPost, "/openai/v1/threads", chatWithMessages(5))
+	// chat completions без массива messages и не-JSON
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `{"model":"gpt-4o","prompt":"hi"}`)
+	p.do(t, http.MethodPost, "/openai/v1/chat/completions", `not json`)
+	if calls != 3 {
+		t.Fatalf("upstream calls = %d, want all 3 requests forwarded", calls)
+	}
+}